@@ -49,6 +49,8 @@ type AeLoop struct {
 	fileEventFd     int
 	timeEventNextId int
 	stop            bool
+	// 每次等待事件前调用
+	BeforeSleep func(loop *AeLoop)
 }
 
 // 返回fileEvent的key，可读为正，可写为负
@@ -77,6 +79,9 @@ func AeLoopCreate() (*AeLoop, error) {
 // 主循环
 func (loop *AeLoop) AeMain() {
 	for !loop.stop {
+		if loop.BeforeSleep != nil {
+			loop.BeforeSleep(loop)
+		}
 		tes, fes := loop.AeWait()
 		loop.AeProcess(tes, fes)
 	}
@@ -135,7 +140,7 @@ func (loop *AeLoop) AeWait() (tes []*AeTimeEvent, fes []*AeFileEvent) {
 		}
 		p = p.next
 	}
-	return nil, nil
+	return tes, fes
 }
 
 // 处理事件
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/dict"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"akt-redis/zset"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AOF 中以RESP格式记录所有写命令，启动时按顺序重放恢复数据
+// 相对时间的命令 (EXPIRE/SET EX 等) 传播前改写为绝对时间，随机命令 (SPOP) 改写为确定的结果，保证重放结果一致
+
+const (
+	DEFAULT_AOF_FILENAME      = "appendonly.aof"
+	AOF_REWRITE_ITEMS_PER_CMD = 64   // 重写时每条命令最多包含的元素数
+	AOF_FSYNC_INTERVAL        = 1000 // everysec 的fsync间隔，毫秒
+	FAKE_CLIENT_FD            = -1   // 加载AOF时使用的client，不回复
+)
+
+// appendfsync
+const (
+	AOF_FSYNC_NO = iota
+	AOF_FSYNC_ALWAYS
+	AOF_FSYNC_EVERYSEC
+)
+
+var (
+	AOF_FSYNC_ERR     = errors.New("invalid appendfsync value, must be always, everysec or no")
+	AOF_FORMAT_ERR    = errors.New("bad file format reading the append only file")
+	AOF_TRUNCATED_ERR = errors.New("unexpected end of file reading the append only file")
+	AOF_UNKNOWN_ERR   = errors.New("unknown command reading the append only file")
+	AOF_REWRITE_ERR   = errors.New("background append only file rewriting already in progress")
+)
+
+func parseAofFsync(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return AOF_FSYNC_ALWAYS, nil
+	case "everysec", "":
+		return AOF_FSYNC_EVERYSEC, nil
+	case "no":
+		return AOF_FSYNC_NO, nil
+	}
+	return 0, AOF_FSYNC_ERR
+}
+
+func initAppendOnly(config *conf.Config) error {
+	closeAppendOnlyFile()
+	fsync, err := parseAofFsync(config.AppendFsync)
+	if err != nil {
+		return err
+	}
+	server.aofEnabled = config.AppendOnly
+	server.aofFilename = config.AppendFilename
+	if server.aofFilename == "" {
+		server.aofFilename = DEFAULT_AOF_FILENAME
+	}
+	server.aofFsync = fsync
+	server.aofLoadTruncated = config.AofLoadTruncated
+	server.aofBuf = nil
+	server.aofSelectedDb = -1
+	server.aofLastWriteOk = true
+	server.aofRewriteBuf = nil
+	server.aofRewriteSnapshot = nil
+	server.aofRewriteDone = nil
+	server.aofLastRewriteOk = true
+	return nil
+}
+
+func aofFilename() string {
+	return filepath.Join(server.dir, server.aofFilename)
+}
+
+// 命令追加到aofBuf，db变化时先写入SELECT；重写期间同时记录到aofRewriteBuf
+func feedAppendOnlyFile(dbid int, args []string) {
+	var buf []byte
+	if dbid != server.aofSelectedDb {
+		buf = resp.AppendCommand(buf, []string{"select", strconv.Itoa(dbid)})
+		server.aofSelectedDb = dbid
+	}
+	buf = resp.AppendCommand(buf, args)
+	if server.aofEnabled {
+		server.aofBuf = append(server.aofBuf, buf...)
+	}
+	if server.aofRewriteDone != nil {
+		server.aofRewriteBuf = append(server.aofRewriteBuf, buf...)
+	}
+}
+
+// 传播执行过的写命令到AOF、replica及Raft日志
+func propagate(db *GodisDB, args []string) {
+	if server.aofEnabled || server.aofRewriteDone != nil {
+		feedAppendOnlyFile(db.id, args)
+	}
+	replicationFeedReplicas(db.id, args)
+	raftPropagate(db.id, args)
+}
+
+// 替换client的命令参数，ProcessCommand 会传播替换后的命令
+func rewriteClientCommandArgs(c *GodisClient, args ...string) {
+	freeArgs(c)
+	c.args = createArgs(args)
+}
+
+// 替换client的第i个命令参数
+func replaceClientCommandArg(c *GodisClient, i int, arg string) {
+	c.args[i].DecrRefCount()
+	c.args[i] = obj.CreateObject(obj.GSTR, arg)
+}
+
+func createArgs(args []string) []*obj.Gobj {
+	objs := make([]*obj.Gobj, len(args))
+	for i, arg := range args {
+		objs[i] = obj.CreateObject(obj.GSTR, arg)
+	}
+	return objs
+}
+
+// 当前命令不需要传播，如阻塞的BLPOP
+func preventCommandPropagation(c *GodisClient) {
+	c.preventPropagate = true
+}
+
+// 将aofBuf写入文件，always 时立即fsync，everysec 在 aofCron 中后台fsync
+func flushAppendOnlyFile() {
+	if len(server.aofBuf) == 0 || server.aofFile == nil {
+		return
+	}
+	n, err := server.aofFile.Write(server.aofBuf)
+	if err != nil {
+		// 未写入的部分保留，下次重试
+		log.Printf("error writing to the AOF file: %v\n", err)
+		server.aofBuf = server.aofBuf[n:]
+		server.aofLastWriteOk = false
+		return
+	}
+	server.aofBuf = server.aofBuf[:0]
+	server.aofLastWriteOk = true
+	if server.aofFsync == AOF_FSYNC_ALWAYS {
+		if err := server.aofFile.Sync(); err != nil {
+			log.Printf("fsync error on the AOF file: %v\n", err)
+		}
+		server.aofLastFsync = utils.GetMsTime()
+	}
+}
+
+// 等待后台fsync结束
+func waitAofFsync() {
+	if server.aofFsyncDone == nil {
+		return
+	}
+	if err := <-server.aofFsyncDone; err != nil {
+		log.Printf("background fsync error on the AOF file: %v\n", err)
+	}
+	server.aofFsyncDone = nil
+}
+
+func aofCron() {
+	checkRewriteDone(false)
+	flushAppendOnlyFile()
+	if server.aofFsyncDone != nil {
+		select {
+		case err := <-server.aofFsyncDone:
+			if err != nil {
+				log.Printf("background fsync error on the AOF file: %v\n", err)
+			}
+			server.aofFsyncDone = nil
+		default:
+			return
+		}
+	}
+	now := utils.GetMsTime()
+	if server.aofFile == nil || server.aofFsync != AOF_FSYNC_EVERYSEC || now-server.aofLastFsync < AOF_FSYNC_INTERVAL {
+		return
+	}
+	// fsync 可能很慢，放到goroutine中，不阻塞ae循环
+	done := make(chan error, 1)
+	f := server.aofFile
+	go func() {
+		done <- f.Sync()
+	}()
+	server.aofFsyncDone = done
+	server.aofLastFsync = now
+}
+
+// 打开AOF用于追加，已打开时先关闭
+func openAppendOnlyFile() error {
+	closeAppendOnlyFile()
+	f, err := os.OpenFile(aofFilename(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	server.aofFile = f
+	server.aofBuf = nil
+	// 下一条命令前强制写入SELECT
+	server.aofSelectedDb = -1
+	server.aofLastFsync = utils.GetMsTime()
+	return nil
+}
+
+func closeAppendOnlyFile() {
+	if server.aofFile == nil {
+		return
+	}
+	flushAppendOnlyFile()
+	waitAofFsync()
+	server.aofFile.Sync()
+	server.aofFile.Close()
+	server.aofFile = nil
+}
+
+func createFakeClient() *GodisClient {
+	return CreateClient(FAKE_CLIENT_FD)
+}
+
+// 读取AOF格式文件的reader，也用于Raft的meta及日志文件
+func newAofReader(f io.Reader) *resp.Reader {
+	r := resp.NewReader(f)
+	r.MultiBulkOnly = true
+	return r
+}
+
+// 读取一条RESP格式的命令，已读取的字节数见 r.Offset
+// 文件在命令边界结束时返回 io.EOF，命令不完整时返回 io.ErrUnexpectedEOF
+func readAofCommand(r *resp.Reader) ([]string, error) {
+	args, err := r.ReadCommand()
+	if err == resp.FORMAT_ERR || err == resp.TOO_BIG_BULK_ERR || err == resp.TOO_BIG_INLINE_ERR || (err == nil && len(args) == 0) {
+		return nil, AOF_FORMAT_ERR
+	}
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg)
+	}
+	return strs, nil
+}
+
+// 启动时重放AOF，文件不存在时忽略
+// 末尾命令不完整时，aofLoadTruncated 为true则截断到最后一条完整命令并继续启动，否则返回错误
+func loadAppendOnlyFile(filename string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 重放的命令不计入 server.dirty
+	dirty := server.dirty
+	server.loading = true
+	defer func() {
+		server.loading = false
+		server.dirty = dirty
+	}()
+	fake := createFakeClient()
+	r := newAofReader(f)
+	var valid int64
+	loaded := 0
+	for {
+		args, err := readAofCommand(r)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			if !server.aofLoadTruncated {
+				return AOF_TRUNCATED_ERR
+			}
+			log.Printf("AOF %v truncated at %v, loading the last complete command\n", filename, valid)
+			if err := os.Truncate(filename, valid); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		cmd := lookupCommand(args[0])
+		if cmd == nil {
+			return AOF_UNKNOWN_ERR
+		}
+		if !checkArity(cmd, len(args)) {
+			return AOF_FORMAT_ERR
+		}
+		fake.args = createArgs(args)
+		cmd.proc(fake)
+		freeArgs(fake)
+		valid = r.Offset()
+		loaded++
+	}
+	log.Printf("DB loaded from append only file: %v commands\n", loaded)
+	return nil
+}
+
+// 按数据集生成最少的命令，写入w
+func rewriteAppendOnlySnapshot(w *bufio.Writer, snap []dbSnapshot) error {
+	var buf []byte
+	// 元素较多时拆分为多条命令
+	emitItems := func(cmd, key string, items []string, step int) {
+		for len(items) > 0 {
+			count := len(items)
+			if count > AOF_REWRITE_ITEMS_PER_CMD*step {
+				count = AOF_REWRITE_ITEMS_PER_CMD * step
+			}
+			buf = resp.AppendCommand(buf, append([]string{cmd, key}, items[:count]...))
+			items = items[count:]
+		}
+	}
+	for _, ds := range snap {
+		buf = resp.AppendCommand(buf[:0], []string{"select", strconv.Itoa(ds.id)})
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		for _, entry := range ds.entries {
+			buf = buf[:0]
+			key := entry.key.StrVal()
+			var items []string
+			switch entry.val.Type_ {
+			case obj.GSTR:
+				buf = resp.AppendCommand(buf, []string{"set", key, entry.val.StrVal()})
+			case obj.GLIST:
+				l := entry.val.Val_.(*list.List)
+				for n := l.First(); n != nil; n = n.Next() {
+					items = append(items, n.Val.StrVal())
+				}
+				emitItems("rpush", key, items, 1)
+			case obj.GSET:
+				snapshotForEach(entry.val.Val_.(*dict.Dict), func(e *dict.Entry) {
+					items = append(items, e.Key.StrVal())
+				})
+				emitItems("sadd", key, items, 1)
+			case obj.GDICT:
+				snapshotForEach(entry.val.Val_.(*dict.Dict), func(e *dict.Entry) {
+					items = append(items, e.Key.StrVal(), e.Val.StrVal())
+				})
+				emitItems("hset", key, items, 2)
+			case obj.GZSET:
+				for n := entry.val.Val_.(*zset.ZSet).First(); n != nil; n = n.Next() {
+					items = append(items, strconv.FormatFloat(n.Score, 'g', -1, 64), n.Member.StrVal())
+				}
+				emitItems("zadd", key, items, 2)
+			default:
+				return TYPE_ERR
+			}
+			if entry.expire != -1 {
+				buf = resp.AppendCommand(buf, []string{"pexpireat", key, strconv.FormatInt(entry.expire, 10)})
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func rewriteTempFilename() string {
+	return filepath.Join(server.dir, fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+}
+
+func rewriteAppendOnlyFile(tmpfile string, snap []dbSnapshot) error {
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	err = rewriteAppendOnlySnapshot(bufio.NewWriter(f), snap)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+	}
+	return err
+}
+
+// 在主线程中生成快照，由goroutine写入临时文件
+// 期间的写命令记录在aofRewriteBuf中，完成后追加到新文件末尾再替换旧文件
+func rewriteAppendOnlyFileBackground() error {
+	if server.aofRewriteDone != nil {
+		return AOF_REWRITE_ERR
+	}
+	snap := createSnapshot()
+	done := make(chan error, 1)
+	tmpfile := rewriteTempFilename()
+	go func() {
+		done <- rewriteAppendOnlyFile(tmpfile, snap)
+	}()
+	server.aofRewriteSnapshot = snap
+	server.aofRewriteBuf = nil
+	server.aofRewriteDone = done
+	// 重写缓冲中的第一条命令需要带上SELECT
+	server.aofSelectedDb = -1
+	log.Println("background append only file rewriting started")
+	return nil
+}
+
+// 把重写期间的命令追加到临时文件
+func appendRewriteBuf(tmpfile string) error {
+	f, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(server.aofRewriteBuf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// BGREWRITEAOF 完成后的处理，block 为true时等待完成
+func checkRewriteDone(block bool) {
+	if server.aofRewriteDone == nil {
+		return
+	}
+	var err error
+	if block {
+		err = <-server.aofRewriteDone
+	} else {
+		select {
+		case err = <-server.aofRewriteDone:
+		default:
+			return
+		}
+	}
+	releaseSnapshot(server.aofRewriteSnapshot)
+	server.aofRewriteSnapshot = nil
+	server.aofRewriteDone = nil
+	tmpfile := rewriteTempFilename()
+	if err == nil {
+		err = appendRewriteBuf(tmpfile)
+	}
+	server.aofRewriteBuf = nil
+	if err == nil {
+		// aofBuf中的命令都已在重写缓冲中，不再写入旧文件
+		waitAofFsync()
+		err = os.Rename(tmpfile, aofFilename())
+	}
+	if err != nil {
+		log.Printf("background AOF rewrite error: %v\n", err)
+		os.Remove(tmpfile)
+		server.aofLastRewriteOk = false
+		return
+	}
+	server.aofLastRewriteOk = true
+	if server.aofEnabled {
+		server.aofFile.Close()
+		server.aofFile = nil
+		if err := openAppendOnlyFile(); err != nil {
+			log.Printf("can't reopen the append only file: %v\n", err)
+			server.aofLastWriteOk = false
+		}
+	}
+	log.Println("background AOF rewrite terminated with success")
+}
+
+// BGREWRITEAOF
+func bgrewriteaofCommand(c *GodisClient) {
+	if err := rewriteAppendOnlyFileBackground(); err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	c.AddReplyStatus("Background append only file rewriting started")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/resp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAofLoad(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	config.AppendFsync = "always"
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "set str v\r\n")
+	ExecQuery(client, "set tmp v ex 100\r\n")
+	ExecQuery(client, "expire str 1000\r\n")
+	ExecQuery(client, "rpush list a b c\r\n")
+	ExecQuery(client, "sadd set m1 m2 m3\r\n")
+	ExecQuery(client, "spop set\r\n")
+	ExecQuery(client, "blpop list 0\r\n")
+	ExecQuery(client, "get str\r\n")
+	ExecQuery(client, "del missing\r\n")
+	ExecQuery(CreateClient(server.fd), "blpop empty 0\r\n")
+	ExecQuery(client, "select 3\r\n")
+	ExecQuery(client, "hset hash f1 v1\r\n")
+
+	data, _ := os.ReadFile(filepath.Join(config.Dir, DEFAULT_AOF_FILENAME))
+	aof := string(data)
+	// 相对时间、随机及阻塞命令以确定的形式记录
+	assert.NotContains(t, aof, "expire\r\n")
+	assert.Contains(t, aof, "$9\r\npexpireat\r\n")
+	assert.Contains(t, aof, "$4\r\npxat\r\n")
+	assert.Contains(t, aof, "$4\r\nsrem\r\n")
+	assert.NotContains(t, aof, "spop")
+	assert.NotContains(t, aof, "blpop")
+	assert.NotContains(t, aof, "get\r\n")
+	// 没有修改数据的写命令不记录
+	assert.NotContains(t, aof, "missing")
+	assert.Equal(t, 2, strings.Count(aof, "$6\r\nselect\r\n"))
+
+	ExecQuery(client, "select 0\r\n")
+	ttl := ExecQuery(client, "pttl tmp\r\n")
+	members := ExecQuery(client, "smembers set\r\n")
+	initServer(&config)
+	client = CreateClient(server.fd)
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(client, "get str\r\n"))
+	before, _ := strconv.Atoi(strings.Trim(ttl, ":\r\n"))
+	after, _ := strconv.Atoi(strings.Trim(ExecQuery(client, "pttl tmp\r\n"), ":\r\n"))
+	assert.InDelta(t, before, after, 1000)
+	assert.Equal(t, members, ExecQuery(client, "smembers set\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\nc\r\n", ExecQuery(client, "lrange list 1 -1\r\n"))
+	ExecQuery(client, "select 3\r\n")
+	assert.Equal(t, "$2\r\nv1\r\n", ExecQuery(client, "hget hash f1\r\n"))
+}
+
+func TestAofServedBlockedClient(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	initServer(&config)
+	c1 := CreateClient(server.fd)
+	c2 := CreateClient(server.fd)
+
+	ExecQuery(c1, "blmove src dst left right 0\r\n")
+	ExecQuery(c2, "rpush src a b\r\n")
+	assert.Equal(t, "$1\r\na\r\n", ExecQuery(c1, ""))
+	flushAppendOnlyFile()
+	data, _ := os.ReadFile(filepath.Join(config.Dir, DEFAULT_AOF_FILENAME))
+	assert.Contains(t, string(data), "$5\r\nlmove\r\n$3\r\nsrc\r\n$3\r\ndst\r\n$4\r\nleft\r\n$5\r\nright\r\n")
+
+	// 目标类型错误时不传播
+	ExecQuery(c2, "set str v\r\n")
+	ExecQuery(c1, "blmove other str left right 0\r\n")
+	dirty := server.dirty
+	ExecQuery(c2, "rpush other x\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(c1, ""))
+	assert.Equal(t, dirty+1, server.dirty)
+	flushAppendOnlyFile()
+	data, _ = os.ReadFile(filepath.Join(config.Dir, DEFAULT_AOF_FILENAME))
+	assert.NotContains(t, string(data), "$3\r\nstr\r\n$4\r\nleft")
+
+	initServer(&config)
+	client := CreateClient(server.fd)
+	assert.Equal(t, "*1\r\n$1\r\nb\r\n", ExecQuery(client, "lrange src 0 -1\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", ExecQuery(client, "lrange dst 0 -1\r\n"))
+}
+
+func TestAofExpire(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	config.AppendFsync = "always"
+	filename := filepath.Join(config.Dir, DEFAULT_AOF_FILENAME)
+	// 加载期间不删除过期key，之后的命令执行时key尚未过期
+	var buf []byte
+	buf = resp.AppendCommand(buf, []string{"rpush", "l", "a"})
+	buf = resp.AppendCommand(buf, []string{"pexpireat", "l", "1"})
+	buf = resp.AppendCommand(buf, []string{"rpush", "l", "b"})
+	os.WriteFile(filename, buf, 0644)
+	assert.Nil(t, initServer(&config))
+	assert.Equal(t, int64(2), server.dbs[0].data.Size()+server.dbs[0].expire.Size())
+	client := CreateClient(server.fd)
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists l\r\n"))
+
+	// 过期删除以DEL记录
+	ExecQuery(client, "set k1 v px 1\r\n")
+	ExecQuery(client, "set k2 v px 1\r\n")
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get k1\r\n"))
+	activeExpireCycle()
+	flushAppendOnlyFile()
+	data, _ := os.ReadFile(filename)
+	aof := string(data)
+	assert.Contains(t, aof, "*2\r\n$3\r\ndel\r\n$1\r\nl\r\n")
+	assert.Contains(t, aof, "*2\r\n$3\r\ndel\r\n$2\r\nk1\r\n")
+	assert.Contains(t, aof, "*2\r\n$3\r\ndel\r\n$2\r\nk2\r\n")
+
+	// 回复错误的写命令不记录
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "lpush l2 a\r\nsadd l2 b\r\n")[len(":1\r\n"):])
+	data, _ = os.ReadFile(filename)
+	assert.NotContains(t, string(data), "sadd")
+}
+
+func TestAofTruncated(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	initServer(&config)
+	client := CreateClient(server.fd)
+	ExecQuery(client, "set k1 v1\r\n")
+	ExecQuery(client, "set k2 v2\r\n")
+	closeAppendOnlyFile()
+
+	filename := filepath.Join(config.Dir, DEFAULT_AOF_FILENAME)
+	data, _ := os.ReadFile(filename)
+	complete := len(data)
+	os.WriteFile(filename, append(data, "*3\r\n$3\r\nset\r\n$2\r\nk3"...), 0644)
+
+	assert.Equal(t, AOF_TRUNCATED_ERR, initServer(&config))
+
+	config.AofLoadTruncated = true
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "dbsize\r\n"))
+	// 截断后可以继续追加
+	ExecQuery(client, "set k3 v3\r\n")
+	data, _ = os.ReadFile(filename)
+	assert.Equal(t, "*2\r\n$6\r\nselect", string(data[complete:complete+14]))
+
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "dbsize\r\n"))
+
+	// 格式错误时拒绝加载
+	os.WriteFile(filename, []byte("*1\r\n?3\r\nset\r\n"), 0644)
+	assert.Equal(t, AOF_FORMAT_ERR, initServer(&config))
+	// 参数个数错误的命令同样拒绝
+	os.WriteFile(filename, []byte("*1\r\n$3\r\nset\r\n"), 0644)
+	assert.Equal(t, AOF_FORMAT_ERR, initServer(&config))
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	for i := 0; i < 100; i++ {
+		ExecQuery(client, "incr counter\r\n")
+		ExecQuery(client, "rpush list x\r\n")
+	}
+	ExecQuery(client, "zadd zset 1.5 a -inf b\r\n")
+	ExecQuery(client, "hset hash f v\r\n")
+	ExecQuery(client, "pexpire hash 100000\r\n")
+	filename := filepath.Join(config.Dir, DEFAULT_AOF_FILENAME)
+	before, _ := os.Stat(filename)
+
+	assert.Equal(t, "+Background append only file rewriting started\r\n", ExecQuery(client, "bgrewriteaof\r\n"))
+	assert.Equal(t, "-ERR: background append only file rewriting already in progress\r\n", ExecQuery(client, "bgrewriteaof\r\n"))
+	// 重写期间的修改追加到新文件
+	ExecQuery(client, "select 1\r\n")
+	ExecQuery(client, "set during v\r\n")
+	checkRewriteDone(true)
+	ExecQuery(client, "set after v\r\n")
+	after, _ := os.Stat(filename)
+	assert.Less(t, after.Size(), before.Size())
+
+	initServer(&config)
+	client = CreateClient(server.fd)
+	assert.Equal(t, "$3\r\n100\r\n", ExecQuery(client, "get counter\r\n"))
+	assert.Equal(t, ":100\r\n", ExecQuery(client, "llen list\r\n"))
+	assert.Equal(t, "$4\r\n-inf\r\n", ExecQuery(client, "zscore zset b\r\n"))
+	assert.NotEqual(t, ":-1\r\n", ExecQuery(client, "pttl hash\r\n"))
+	ExecQuery(client, "select 1\r\n")
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "dbsize\r\n"))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"log"
+)
+
+// 阻塞命令 (BLPOP/BRPOP/BLMOVE) 的状态
+// client 阻塞时不会回复，直到key可用时由 handleClientsBlockedOnKeys 处理，或超时后由ae时间事件处理
+type blockingState struct {
+	keys      []*obj.Gobj
+	timeoutId int       // ae 时间事件id，0表示永不超时
+	target    *obj.Gobj // BLMOVE 的目标key，BLPOP/BRPOP 为nil
+	from      int
+	to        int
+}
+
+// 变为可用的阻塞key
+type readyKey struct {
+	db  *GodisDB
+	key *obj.Gobj
+}
+
+// 阻塞client，timeout 为毫秒，0表示永不超时
+func blockForKeys(c *GodisClient, keys []*obj.Gobj, timeout int64, target *obj.Gobj, from, to int) {
+	c.blocked = true
+	c.bpop.from = from
+	c.bpop.to = to
+	if target != nil {
+		target.IncrRefCount()
+		c.bpop.target = target
+	}
+	for _, key := range keys {
+		key.IncrRefCount()
+		c.bpop.keys = append(c.bpop.keys, key)
+		k := key.StrVal()
+		c.db.blockingKeys[k] = append(c.db.blockingKeys[k], c)
+	}
+	if timeout > 0 {
+		c.bpop.timeoutId = server.aeLoop.AddTimeEvent(ae.AE_ONCE, timeout, blockedClientTimeout, c)
+	}
+}
+
+func unblockClient(c *GodisClient) {
+	for _, key := range c.bpop.keys {
+		k := key.StrVal()
+		clients := c.db.blockingKeys[k]
+		for i, bc := range clients {
+			if bc == c {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(c.db.blockingKeys, k)
+		} else {
+			c.db.blockingKeys[k] = clients
+		}
+		key.DecrRefCount()
+	}
+	if c.bpop.target != nil {
+		c.bpop.target.DecrRefCount()
+	}
+	if c.bpop.timeoutId != 0 {
+		server.aeLoop.RemoveTimeEvent(c.bpop.timeoutId)
+	}
+	c.bpop = blockingState{}
+	c.blocked = false
+	// 阻塞期间收到的后续命令由 processUnblockedClients 继续处理
+	server.unblockedClients = append(server.unblockedClients, c)
+}
+
+// 继续处理解除阻塞的client中未执行的命令
+// 在 beforeSleep 中调用，避免在命令执行过程中重入
+func processUnblockedClients() {
+	for len(server.unblockedClients) > 0 {
+		c := server.unblockedClients[0]
+		server.unblockedClients = server.unblockedClients[1:]
+		if c.blocked || len(c.query.Buffered()) == 0 {
+			continue
+		}
+		if err := ProcessQueryBuf(c); err != nil {
+			log.Printf("process query buf err: %v\n", err)
+			freeClient(c)
+		}
+	}
+}
+
+// client 释放时从待处理列表中移除
+func removeUnblockedClient(c *GodisClient) {
+	for i, uc := range server.unblockedClients {
+		if uc == c {
+			server.unblockedClients = append(server.unblockedClients[:i], server.unblockedClients[i+1:]...)
+			return
+		}
+	}
+}
+
+// 超时回复空值
+func blockedClientTimeout(loop *ae.AeLoop, id int, extra interface{}) {
+	c := extra.(*GodisClient)
+	// 超时事件已被执行，无需再移除
+	c.bpop.timeoutId = 0
+	if c.bpop.target != nil {
+		c.AddReplyNull()
+	} else {
+		c.AddReplyNullArray()
+	}
+	unblockClient(c)
+}
+
+// 有client阻塞在key上时，标记key为可用
+func signalKeyAsReady(db *GodisDB, key *obj.Gobj) {
+	k := key.StrVal()
+	if _, ok := db.blockingKeys[k]; !ok {
+		return
+	}
+	if db.readyKeys[k] {
+		return
+	}
+	key.IncrRefCount()
+	db.readyKeys[k] = true
+	server.readyKeys = append(server.readyKeys, readyKey{db: db, key: key})
+}
+
+// 处理可用的key，按阻塞顺序服务client，直到list为空
+func handleClientsBlockedOnKeys() {
+	for len(server.readyKeys) > 0 {
+		// 服务过程中 BLMOVE 可能产生新的可用key
+		readyKeys := server.readyKeys
+		server.readyKeys = nil
+		for _, rk := range readyKeys {
+			db, key := rk.db, rk.key
+			k := key.StrVal()
+			delete(db.readyKeys, k)
+			for len(db.blockingKeys[k]) > 0 {
+				lobj := findKeyWrite(db, key)
+				if lobj == nil || lobj.Type_ != obj.GLIST {
+					break
+				}
+				c := db.blockingKeys[k][0]
+				serveClientBlockedOnList(c, key, lobj.Val_.(*list.List))
+			}
+			key.DecrRefCount()
+		}
+	}
+}
+
+func serveClientBlockedOnList(c *GodisClient, key *obj.Gobj, l *list.List) {
+	target, from, to := c.bpop.target, c.bpop.from, c.bpop.to
+	if target != nil {
+		target.IncrRefCount()
+	}
+	unblockClient(c)
+	if target != nil {
+		// 目标类型错误时没有移动元素，不传播
+		if lmoveGenericCommand(c, key, target, from, to) {
+			propagate(c.db, []string{"lmove", key.StrVal(), target.StrVal(), listPositionName(from), listPositionName(to)})
+		}
+		target.DecrRefCount()
+		return
+	}
+	server.dirty++
+	propagate(c.db, []string{popCommandName(from), key.StrVal()})
+	val := listPop(l, from)
+	c.AddReplyArrayLen(2)
+	c.AddReplyBulk(key.StrVal())
+	c.AddReplyBulk(val.StrVal())
+	val.DecrRefCount()
+	if l.Length() == 0 {
+		dbDelete(c.db, key)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// 连接相关的命令: HELLO AUTH CLIENT
+// HELLO 3 之后该连接使用RESP3回复，map/set/double等类型通过 AddReplyMapLen 等方法按连接的协议版本输出
+
+const GODIS_VERSION = "7.0.0"
+
+var REQUIREPASS_ERR = errors.New("requirepass is not supported in raft or cluster mode")
+
+// 未认证时可以执行的命令
+func commandNoAuth(name string) bool {
+	return name == "auth" || name == "hello"
+}
+
+// 校验密码，失败时回复错误
+func checkPassword(c *GodisClient, username, password string) bool {
+	if server.requirePass == "" {
+		c.AddReplyError("ERR: AUTH called without any password configured for the default user")
+		return false
+	}
+	// 常量时间比较，避免通过耗时猜测密码
+	if username != "default" || subtle.ConstantTimeCompare([]byte(password), []byte(server.requirePass)) != 1 {
+		c.AddReplyError("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	return true
+}
+
+// AUTH [username] password
+func authCommand(c *GodisClient) {
+	if len(c.args) > 3 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	username := "default"
+	if len(c.args) == 3 {
+		username = c.args[1].StrVal()
+	}
+	if !checkPassword(c, username, c.args[len(c.args)-1].StrVal()) {
+		return
+	}
+	c.authenticated = true
+	c.AddReplyStatus("OK")
+}
+
+// 客户端名称不能包含空格及特殊字符
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func helloCommand(c *GodisClient) {
+	ver := c.resp
+	if len(c.args) >= 2 {
+		v, ok := getIntFromArg(c, c.args[1])
+		if !ok {
+			return
+		}
+		if v != 2 && v != 3 {
+			c.AddReplyError("NOPROTO unsupported protocol version")
+			return
+		}
+		ver = int(v)
+	}
+	var username, password, name string
+	auth, setname := false, false
+	for i := 2; i < len(c.args); i++ {
+		opt := strings.ToLower(c.args[i].StrVal())
+		if opt == "auth" && i+2 < len(c.args) {
+			auth = true
+			username, password = c.args[i+1].StrVal(), c.args[i+2].StrVal()
+			i += 2
+		} else if opt == "setname" && i+1 < len(c.args) {
+			setname = true
+			name = c.args[i+1].StrVal()
+			i++
+		} else {
+			c.AddReplyErrorFormat("ERR: Syntax error in HELLO option '%v'", c.args[i].StrVal())
+			return
+		}
+	}
+	if auth {
+		if !checkPassword(c, username, password) {
+			return
+		}
+		c.authenticated = true
+	}
+	if server.requirePass != "" && !c.authenticated {
+		c.AddReplyError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO AUTH option can be used")
+		return
+	}
+	if setname {
+		if !validClientName(name) {
+			c.AddReplyError("ERR: Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.name = name
+	}
+	c.resp = ver
+
+	mode := "standalone"
+	if server.sentinel != nil {
+		mode = "sentinel"
+	} else if server.cluster != nil {
+		mode = "cluster"
+	}
+	role := "master"
+	if server.masterHost != "" {
+		role = "replica"
+	}
+	c.AddReplyMapLen(7)
+	c.AddReplyBulk("server")
+	c.AddReplyBulk("redis")
+	c.AddReplyBulk("version")
+	c.AddReplyBulk(GODIS_VERSION)
+	c.AddReplyBulk("proto")
+	c.AddReplyInt(int64(c.resp))
+	c.AddReplyBulk("id")
+	c.AddReplyInt(c.id)
+	c.AddReplyBulk("mode")
+	c.AddReplyBulk(mode)
+	c.AddReplyBulk("role")
+	c.AddReplyBulk(role)
+	c.AddReplyBulk("modules")
+	c.AddReplyArrayLen(0)
+}
+
+// CLIENT ID | GETNAME | SETNAME name
+func clientCommand(c *GodisClient) {
+	sub := strings.ToLower(c.args[1].StrVal())
+	switch {
+	case sub == "id" && len(c.args) == 2:
+		c.AddReplyInt(c.id)
+	case sub == "getname" && len(c.args) == 2:
+		if c.name == "" {
+			c.AddReplyNull()
+		} else {
+			c.AddReplyBulk(c.name)
+		}
+	case sub == "setname" && len(c.args) == 3:
+		name := c.args[2].StrVal()
+		if !validClientName(name) {
+			c.AddReplyError("ERR: Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.name = name
+		c.AddReplyStatus("OK")
+	default:
+		c.AddReplyError("ERR: unknown subcommand or wrong number of arguments")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/resp"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHello(t *testing.T) {
+	var config conf.Config
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	hello := func(proto int) string {
+		return fmt.Sprintf("$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n%v\r\n$5\r\nproto\r\n:%d\r\n$2\r\nid\r\n:%d\r\n"+
+			"$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n", GODIS_VERSION, proto, client.id)
+	}
+	assert.Equal(t, "*14\r\n"+hello(2), ExecQuery(client, "hello\r\n"))
+	assert.Equal(t, "-NOPROTO unsupported protocol version\r\n", ExecQuery(client, "hello 4\r\n"))
+	assert.Equal(t, "-ERR: Syntax error in HELLO option 'setname'\r\n", ExecQuery(client, "hello 3 setname\r\n"))
+	assert.Equal(t, 2, client.resp)
+	assert.Equal(t, "%7\r\n"+hello(3), ExecQuery(client, "hello 3 setname conn1\r\n"))
+	assert.Equal(t, "$5\r\nconn1\r\n", ExecQuery(client, "client getname\r\n"))
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", client.id), ExecQuery(client, "client id\r\n"))
+	assert.Equal(t, "-ERR: Client names cannot contain spaces, newlines or special characters.\r\n", ExecQuery(client, string(resp.AppendCommand(nil, []string{"client", "setname", "a b"}))))
+
+	// RESP3 回复
+	assert.Equal(t, "_\r\n", ExecQuery(client, "get nokey\r\n"))
+	ExecQuery(client, "hset h f v\r\n")
+	assert.Equal(t, "%1\r\n$1\r\nf\r\n$1\r\nv\r\n", ExecQuery(client, "hgetall h\r\n"))
+	assert.Equal(t, "%0\r\n", ExecQuery(client, "hgetall nokey\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\nf\r\n", ExecQuery(client, "hkeys h\r\n"))
+	ExecQuery(client, "sadd s a\r\n")
+	assert.Equal(t, "~1\r\n$1\r\na\r\n", ExecQuery(client, "smembers s\r\n"))
+	assert.Equal(t, "~1\r\n$1\r\na\r\n", ExecQuery(client, "sunion s nokey\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", ExecQuery(client, "srandmember s 2\r\n"))
+	ExecQuery(client, "zadd z 1.5 a\r\n")
+	assert.Equal(t, ",1.5\r\n", ExecQuery(client, "zscore z a\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n,1.5\r\n", ExecQuery(client, "zrange z 0 -1 withscores\r\n"))
+	assert.Equal(t, "_\r\n", ExecQuery(client, "lpop nokey 1\r\n"))
+	assert.Equal(t, "=", ExecQuery(client, "info server\r\n")[:1])
+
+	// 其他连接仍然使用RESP2
+	other := CreateClient(server.fd)
+	assert.Equal(t, "$-1\r\n", ExecQuery(other, "get nokey\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nf\r\n$1\r\nv\r\n", ExecQuery(other, "hgetall h\r\n"))
+	assert.Equal(t, "$3\r\n1.5\r\n", ExecQuery(other, "zscore z a\r\n"))
+	assert.Equal(t, "*-1\r\n", ExecQuery(other, "lpop nokey 1\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(other, "client getname\r\n"))
+}
+
+func TestAuth(t *testing.T) {
+	var config conf.Config
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	assert.Equal(t, "-ERR: AUTH called without any password configured for the default user\r\n", ExecQuery(client, "auth pass\r\n"))
+
+	config.RequirePass = "pass"
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", ExecQuery(client, "get a\r\n"))
+	assert.Contains(t, ExecQuery(client, "hello 3\r\n"), "-NOAUTH")
+	assert.Equal(t, 2, client.resp)
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", ExecQuery(client, "auth bad\r\n"))
+	assert.Equal(t, "-WRONGPASS invalid username-password pair or user is disabled.\r\n", ExecQuery(client, "auth user pass\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "auth default pass\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get a\r\n"))
+
+	client = CreateClient(server.fd)
+	assert.Equal(t, "%7\r\n", ExecQuery(client, "hello 3 auth default pass\r\n")[:4])
+	assert.Equal(t, "_\r\n", ExecQuery(client, "get a\r\n"))
+
+	config.Raft = true
+	assert.Equal(t, REQUIREPASS_ERR, initServer(&config))
+}
+
+func TestMasterAuth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip process test in short mode")
+	}
+	bin := buildGodis(t)
+	masterPort, replicaPort := freePort(t), freePort(t)
+	startGodis(t, bin, &conf.Config{Port: masterPort, RequirePass: "pass"})
+	startGodis(t, bin, &conf.Config{Port: replicaPort, RequirePass: "pass", ReplicaOf: fmt.Sprintf("127.0.0.1 %d", masterPort), MasterAuth: "pass"})
+	master := dialGodis(t, masterPort)
+	assert.Equal(t, "+OK\r\n", master.do(t, "auth", "pass"))
+	assert.Equal(t, "+OK\r\n", master.do(t, "set", "foo", "bar"))
+	replica := dialGodis(t, replicaPort)
+	assert.Equal(t, "-NOAUTH Authentication required.\r\n", replica.do(t, "get", "foo"))
+	assert.Equal(t, "+OK\r\n", replica.do(t, "auth", "pass"))
+	waitReply(t, replica, "$3\r\nbar\r\n", "get", "foo")
+}
@@ -0,0 +1,1466 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/conf"
+	"akt-redis/net"
+	"akt-redis/obj"
+	"akt-redis/rdb"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 集群模式
+// 共16384个hash slot，key的slot为 CRC16(key) & 16383，key中包含非空的 {tag} 时只计算tag
+// 每个节点负责部分slot，命令的key不属于本节点时回复 -MOVED slot ip:port
+// 迁移中的slot，本节点已不存在的key回复 -ASK slot ip:port，client 先发送 ASKING 再到目标节点执行
+// 节点间通过 cluster.ping 命令交换状态(gossip)，每个节点主动连接其他节点发送ping，回复为pong
+// slot 归属冲突时 configEpoch 大的节点胜出，SETSLOT NODE 接收slot时增加本节点的 configEpoch
+// 节点信息保存在 nodes.conf，格式与 CLUSTER NODES 相同
+// 集群模式只使用 db 0
+
+const (
+	CLUSTER_SLOTS               = 16384
+	DEFAULT_CLUSTER_CONFIG_FILE = "nodes.conf"
+	DEFAULT_CLUSTER_ANNOUNCE_IP = "127.0.0.1"
+	CLUSTER_PING_INTERVAL       = 1000 // ms
+	CLUSTER_NODE_TIMEOUT        = 5000 // ms，握手超时及等待pong的时间
+	CLUSTER_GOSSIP_ENTRIES      = 3    // 每条消息携带的其他节点数
+	DEFAULT_MIGRATE_TIMEOUT     = 1000 // ms
+)
+
+// 节点flags
+const (
+	CLUSTER_NODE_MYSELF    = 1 << iota
+	CLUSTER_NODE_HANDSHAKE // 还不知道对方的id，使用临时id发送meet
+)
+
+var (
+	CLUSTER_RAFT_ERR     = errors.New("cluster mode is not supported in raft mode")
+	CLUSTER_CONFIG_ERR   = errors.New("bad cluster config file")
+	CLUSTER_MSG_ERR      = errors.New("bad cluster message")
+	CLUSTER_DISABLED_ERR = errors.New("This instance has cluster support disabled")
+	CLUSTER_SLOT_ERR     = errors.New("Invalid or out of range slot")
+	DUMP_PAYLOAD_ERR     = errors.New("DUMP payload version or checksum are wrong")
+)
+
+type clusterNode struct {
+	id             string
+	ip             string
+	port           int
+	flags          int
+	configEpoch    int64
+	fd             int // 本节点发起的连接，-1 表示未连接
+	buf            []byte
+	ctime          int64 // 创建时间，用于握手超时
+	pingSent       int64 // 等待pong的ping的发送时间，0表示没有
+	lastPing       int64
+	pongReceived   int64
+	lastConnectTry int64
+}
+
+type clusterState struct {
+	myself        *clusterNode
+	currentEpoch  int64
+	nodes         map[string]*clusterNode // 握手中的节点使用临时id
+	slots         [CLUSTER_SLOTS]*clusterNode
+	migratingTo   [CLUSTER_SLOTS]*clusterNode
+	importingFrom [CLUSTER_SLOTS]*clusterNode
+	configFile    string
+	todoSave      bool // cron 中保存 nodes.conf
+}
+
+// 命令中key的位置，未列出的命令只有第1个参数为key
+type keySpec struct {
+	first, last, step int // last 为负数时从末尾计算，first 为0表示没有固定位置的key
+	numkeys           int // 大于0时该参数为之后key的个数
+}
+
+var commandKeySpecs = map[string]keySpec{
+	"keys":        {},
+	"scan":        {},
+	"randomkey":   {},
+	"dbsize":      {},
+	"flushdb":     {},
+	"flushall":    {},
+	"swapdb":      {},
+	"del":         {1, -1, 1, 0},
+	"unlink":      {1, -1, 1, 0},
+	"exists":      {1, -1, 1, 0},
+	"touch":       {1, -1, 1, 0},
+	"mget":        {1, -1, 1, 0},
+	"sinter":      {1, -1, 1, 0},
+	"sinterstore": {1, -1, 1, 0},
+	"sunion":      {1, -1, 1, 0},
+	"sunionstore": {1, -1, 1, 0},
+	"sdiff":       {1, -1, 1, 0},
+	"sdiffstore":  {1, -1, 1, 0},
+	"mset":        {1, -1, 2, 0},
+	"msetnx":      {1, -1, 2, 0},
+	"rename":      {1, 2, 1, 0},
+	"renamenx":    {1, 2, 1, 0},
+	"copy":        {1, 2, 1, 0},
+	"smove":       {1, 2, 1, 0},
+	"lmove":       {1, 2, 1, 0},
+	"blmove":      {1, 2, 1, 0},
+	"lcs":         {1, 2, 1, 0},
+	"blpop":       {1, -2, 1, 0},
+	"brpop":       {1, -2, 1, 0},
+	"zunion":      {0, 0, 0, 1},
+	"zinter":      {0, 0, 0, 1},
+	"zdiff":       {0, 0, 0, 1},
+	"zunionstore": {1, 1, 1, 2},
+	"zinterstore": {1, 1, 1, 2},
+	"zdiffstore":  {1, 1, 1, 2},
+}
+
+func initCluster(config *conf.Config) error {
+	closeCluster()
+	if !config.ClusterEnabled {
+		return nil
+	}
+	if config.Raft {
+		return CLUSTER_RAFT_ERR
+	}
+	cs := &clusterState{
+		nodes:      make(map[string]*clusterNode),
+		configFile: config.ClusterConfigFile,
+	}
+	if cs.configFile == "" {
+		cs.configFile = DEFAULT_CLUSTER_CONFIG_FILE
+	}
+	server.cluster = cs
+	loaded, err := clusterLoadConfig()
+	if err != nil {
+		server.cluster = nil
+		return err
+	}
+	if !loaded {
+		cs.myself = createClusterNode(genReplId(), CLUSTER_NODE_MYSELF)
+		cs.nodes[cs.myself.id] = cs.myself
+	}
+	cs.myself.ip = config.ClusterAnnounceIp
+	if cs.myself.ip == "" {
+		cs.myself.ip = DEFAULT_CLUSTER_ANNOUNCE_IP
+	}
+	cs.myself.port = server.port
+	if err := clusterSaveConfig(); err != nil {
+		server.cluster = nil
+		return err
+	}
+	return nil
+}
+
+func closeCluster() {
+	cs := server.cluster
+	if cs == nil {
+		return
+	}
+	for _, n := range cs.nodes {
+		clusterCloseLink(n)
+	}
+	server.cluster = nil
+}
+
+func clusterFilename() string {
+	return filepath.Join(server.dir, server.cluster.configFile)
+}
+
+func createClusterNode(id string, flags int) *clusterNode {
+	return &clusterNode{id: id, flags: flags, fd: -1, ctime: utils.GetMsTime()}
+}
+
+// 计算key所属的slot
+func keyHashSlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(utils.Crc16([]byte(key)) & (CLUSTER_SLOTS - 1))
+}
+
+// 返回命令参数中key的下标
+func getKeysFromCommand(cmd *GodisCommand, args []*obj.Gobj) []int {
+	if cmd.name == "migrate" {
+		return migrateGetKeys(args)
+	}
+	spec, ok := commandKeySpecs[cmd.name]
+	if !ok {
+		spec = keySpec{1, 1, 1, 0}
+	}
+	var keys []int
+	if spec.first > 0 {
+		last := spec.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := spec.first; i <= last && i < len(args); i += spec.step {
+			keys = append(keys, i)
+		}
+	}
+	if spec.numkeys > 0 && spec.numkeys < len(args) {
+		n, err := args[spec.numkeys].IntVal()
+		if err != nil {
+			return keys
+		}
+		for i := spec.numkeys + 1; i <= spec.numkeys+int(n) && i < len(args); i++ {
+			keys = append(keys, i)
+		}
+	}
+	return keys
+}
+
+// 检查命令的key是否由本节点负责，否则回复重定向错误并返回false
+func clusterCheckCommand(c *GodisClient, cmd *GodisCommand) bool {
+	asking := c.flags&CLIENT_ASKING != 0 || cmd.name == "restore-asking"
+	c.flags &^= CLIENT_ASKING
+	if cmd.flags&(CMD_WRITE|CMD_READONLY) == 0 {
+		return true
+	}
+	cs := server.cluster
+	keys := getKeysFromCommand(cmd, c.args)
+	slot, missing := -1, 0
+	for _, i := range keys {
+		key := c.args[i]
+		s := keyHashSlot(key.StrVal())
+		if slot == -1 {
+			slot = s
+		} else if s != slot {
+			c.AddReplyError("CROSSSLOT Keys in request don't hash to the same slot")
+			return false
+		}
+		if c.db.data.Find(key) == nil {
+			missing++
+		}
+	}
+	if slot == -1 {
+		return true
+	}
+	n := cs.slots[slot]
+	if n == nil {
+		c.AddReplyError("CLUSTERDOWN Hash slot not served")
+		return false
+	}
+	if n == cs.myself {
+		target := cs.migratingTo[slot]
+		if target == nil || missing == 0 {
+			return true
+		}
+		// 部分key已迁移，需等待迁移完成
+		if missing < len(keys) {
+			c.AddReplyError("TRYAGAIN Multiple keys request during rehashing of slot")
+			return false
+		}
+		c.AddReplyErrorFormat("ASK %d %v:%d", slot, target.ip, target.port)
+		return false
+	}
+	if asking && cs.importingFrom[slot] != nil {
+		if len(keys) > 1 && missing > 0 {
+			c.AddReplyError("TRYAGAIN Multiple keys request during rehashing of slot")
+			return false
+		}
+		return true
+	}
+	c.AddReplyErrorFormat("MOVED %d %v:%d", slot, n.ip, n.port)
+	return false
+}
+
+// 节点负责的slot区间
+func clusterNodeSlotRanges(n *clusterNode) [][2]int {
+	var ranges [][2]int
+	cs := server.cluster
+	for s := 0; s < CLUSTER_SLOTS; s++ {
+		if cs.slots[s] != n {
+			continue
+		}
+		start := s
+		for s+1 < CLUSTER_SLOTS && cs.slots[s+1] == n {
+			s++
+		}
+		ranges = append(ranges, [2]int{start, s})
+	}
+	return ranges
+}
+
+func formatSlotRange(r [2]int) string {
+	if r[0] == r[1] {
+		return strconv.Itoa(r[0])
+	}
+	return fmt.Sprintf("%d-%d", r[0], r[1])
+}
+
+// "0-100,200"
+func clusterSlotsString(n *clusterNode) string {
+	var parts []string
+	for _, r := range clusterNodeSlotRanges(n) {
+		parts = append(parts, formatSlotRange(r))
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= CLUSTER_SLOTS {
+		return 0, CLUSTER_SLOT_ERR
+	}
+	return slot, nil
+}
+
+// 解析 "a-b" 或 "a"
+func parseSlotRange(s string) ([2]int, error) {
+	var r [2]int
+	var err error
+	start, end, found := strings.Cut(s, "-")
+	if r[0], err = parseSlot(start); err != nil {
+		return r, err
+	}
+	r[1] = r[0]
+	if found {
+		if r[1], err = parseSlot(end); err != nil || r[1] < r[0] {
+			return r, CLUSTER_SLOT_ERR
+		}
+	}
+	return r, nil
+}
+
+func parseSlotRanges(s string) ([][2]int, error) {
+	var ranges [][2]int
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		r, err := parseSlotRange(part)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func clusterNodeByAddr(ip string, port int) *clusterNode {
+	for _, n := range server.cluster.nodes {
+		if n.ip == ip && n.port == port {
+			return n
+		}
+	}
+	return nil
+}
+
+// 开始与 ip:port 握手，已有该地址的节点时返回false
+func clusterStartHandshake(ip string, port int) bool {
+	if clusterNodeByAddr(ip, port) != nil {
+		return false
+	}
+	n := createClusterNode(genReplId(), CLUSTER_NODE_HANDSHAKE)
+	n.ip = ip
+	n.port = port
+	server.cluster.nodes[n.id] = n
+	return true
+}
+
+func clusterDelNode(n *clusterNode) {
+	cs := server.cluster
+	clusterCloseLink(n)
+	delete(cs.nodes, n.id)
+	for s := 0; s < CLUSTER_SLOTS; s++ {
+		if cs.slots[s] == n {
+			cs.slots[s] = nil
+		}
+		if cs.migratingTo[s] == n {
+			cs.migratingTo[s] = nil
+		}
+		if cs.importingFrom[s] == n {
+			cs.importingFrom[s] = nil
+		}
+	}
+	cs.todoSave = true
+}
+
+func clusterCloseLink(n *clusterNode) {
+	if n.fd >= 0 {
+		server.aeLoop.RemoveFileEvent(n.fd, ae.AE_READABLE)
+		net.Close(n.fd)
+	}
+	n.fd = -1
+	n.buf = nil
+	n.pingSent = 0
+}
+
+func clusterConnectNode(n *clusterNode) bool {
+	now := utils.GetMsTime()
+	if now-n.lastConnectTry < CLUSTER_PING_INTERVAL {
+		return false
+	}
+	n.lastConnectTry = now
+	ip, err := resolveHost(n.ip)
+	if err != nil {
+		log.Printf("can't resolve cluster node %v: %v\n", n.ip, err)
+		return false
+	}
+	fd, err := net.Connect(ip, n.port)
+	if err != nil {
+		return false
+	}
+	n.fd = fd
+	server.aeLoop.AddFileEvent(fd, ae.AE_READABLE, clusterReadHandler, n)
+	return true
+}
+
+// 消息格式：type id ip port currentEpoch configEpoch slots [id ip port ...]
+// 之后为随机选取的其他节点，接收方据此发现新节点
+func clusterBuildMessage(typ string, link *clusterNode) []string {
+	cs := server.cluster
+	m := cs.myself
+	msg := []string{typ, m.id, m.ip, strconv.Itoa(m.port), formatInt(cs.currentEpoch), formatInt(m.configEpoch), clusterSlotsString(m)}
+	gossip := 0
+	for _, n := range cs.nodes {
+		if gossip == CLUSTER_GOSSIP_ENTRIES {
+			break
+		}
+		if n == m || n == link || n.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+			continue
+		}
+		msg = append(msg, n.id, n.ip, strconv.Itoa(n.port))
+		gossip++
+	}
+	return msg
+}
+
+func clusterSendPing(n *clusterNode) {
+	typ := "ping"
+	if n.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		typ = "meet"
+	}
+	args := append([]string{"cluster.ping"}, clusterBuildMessage(typ, n)...)
+	if _, err := net.Write(n.fd, resp.AppendCommand(nil, args)); err != nil {
+		log.Printf("send to cluster node %v:%d err: %v\n", n.ip, n.port, err)
+		clusterCloseLink(n)
+		return
+	}
+	n.pingSent = utils.GetMsTime()
+	n.lastPing = n.pingSent
+}
+
+func clusterReadHandler(loop *ae.AeLoop, fd int, extra interface{}) {
+	n := extra.(*clusterNode)
+	buf := make([]byte, utils.GODIS_IO_BUF)
+	nread, err := net.Read(fd, buf)
+	if err != nil || nread == 0 {
+		clusterCloseLink(n)
+		return
+	}
+	n.buf = append(n.buf, buf[:nread]...)
+	for n.fd == fd && len(n.buf) > 0 {
+		v, consumed, err := resp.Parse(n.buf)
+		if err == nil && consumed == 0 {
+			return
+		}
+		msg, ok := v.Strings()
+		if err == nil && (!ok || len(msg) == 0 || msg[0] != "pong") {
+			err = CLUSTER_MSG_ERR
+		}
+		if err == nil {
+			n.buf = n.buf[consumed:]
+			n.pingSent = 0
+			err = clusterProcessMessage(msg, n)
+		}
+		if err != nil {
+			log.Printf("cluster node %v:%d reply err: %v\n", n.ip, n.port, err)
+			clusterCloseLink(n)
+			return
+		}
+	}
+}
+
+// 处理ping/meet/pong，link 为收到pong的连接对应的节点，收到ping时为nil
+func clusterProcessMessage(msg []string, link *clusterNode) error {
+	if len(msg) < 7 || (len(msg)-7)%3 != 0 {
+		return CLUSTER_MSG_ERR
+	}
+	typ, id, ip := msg[0], msg[1], msg[2]
+	port, err := strconv.Atoi(msg[3])
+	if err != nil {
+		return CLUSTER_MSG_ERR
+	}
+	currentEpoch, err1 := strconv.ParseInt(msg[4], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(msg[5], 10, 64)
+	if err1 != nil || err2 != nil {
+		return CLUSTER_MSG_ERR
+	}
+	ranges, err := parseSlotRanges(msg[6])
+	if err != nil {
+		return err
+	}
+	cs := server.cluster
+	if id == cs.myself.id {
+		// 与自己握手
+		if link != nil && link.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+			clusterDelNode(link)
+		}
+		return nil
+	}
+	if currentEpoch > cs.currentEpoch {
+		cs.currentEpoch = currentEpoch
+		cs.todoSave = true
+	}
+	sender := cs.nodes[id]
+	if link != nil && link.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		// 握手完成，已知该节点时删除握手节点，否则使用对方的id
+		if sender != nil {
+			clusterDelNode(link)
+		} else {
+			delete(cs.nodes, link.id)
+			link.id = id
+			link.flags &^= CLUSTER_NODE_HANDSHAKE
+			cs.nodes[id] = link
+			sender = link
+			log.Printf("cluster handshake with %v:%d completed, id %v\n", link.ip, link.port, id)
+		}
+		cs.todoSave = true
+	} else if sender == nil && typ == "meet" {
+		sender = createClusterNode(id, 0)
+		sender.ip = ip
+		sender.port = port
+		cs.nodes[id] = sender
+		cs.todoSave = true
+	}
+	// 只信任已知的节点
+	if sender == nil {
+		return nil
+	}
+	if link == sender {
+		sender.pongReceived = utils.GetMsTime()
+	}
+	if sender.configEpoch != configEpoch {
+		sender.configEpoch = configEpoch
+		cs.todoSave = true
+	}
+	clusterUpdateSlots(sender, ranges)
+	clusterHandleConfigEpochCollision(sender)
+	for i := 7; i < len(msg); i += 3 {
+		gport, err := strconv.Atoi(msg[i+2])
+		if err != nil {
+			return CLUSTER_MSG_ERR
+		}
+		if cs.nodes[msg[i]] == nil && msg[i] != cs.myself.id && clusterStartHandshake(msg[i+1], gport) {
+			log.Printf("cluster start handshake with %v:%d\n", msg[i+1], gport)
+		}
+	}
+	return nil
+}
+
+// sender 声明负责的slot，没有节点负责或 sender 的 configEpoch 更大时更新
+func clusterUpdateSlots(sender *clusterNode, ranges [][2]int) {
+	cs := server.cluster
+	for _, r := range ranges {
+		for s := r[0]; s <= r[1]; s++ {
+			cur := cs.slots[s]
+			// 导入中的slot由 SETSLOT 管理
+			if cur == sender || cs.importingFrom[s] != nil {
+				continue
+			}
+			if cur != nil && cur.configEpoch >= sender.configEpoch {
+				continue
+			}
+			if cur == cs.myself {
+				if n := delKeysInSlot(s); n > 0 {
+					log.Printf("cluster lost slot %d to %v, deleted %d keys\n", s, sender.id, n)
+				}
+				cs.migratingTo[s] = nil
+			}
+			cs.slots[s] = sender
+			cs.todoSave = true
+		}
+	}
+}
+
+// configEpoch 相同时，id较小的节点使用新的epoch，保证每个节点的 configEpoch 不同
+func clusterHandleConfigEpochCollision(sender *clusterNode) {
+	cs := server.cluster
+	if sender.configEpoch != cs.myself.configEpoch || sender.id <= cs.myself.id {
+		return
+	}
+	cs.currentEpoch++
+	cs.myself.configEpoch = cs.currentEpoch
+	cs.todoSave = true
+}
+
+// 不经过其他节点同意直接增加本节点的 configEpoch
+func clusterBumpConfigEpoch() {
+	cs := server.cluster
+	max := cs.currentEpoch
+	for _, n := range cs.nodes {
+		if n.configEpoch > max {
+			max = n.configEpoch
+		}
+	}
+	if cs.myself.configEpoch == 0 || cs.myself.configEpoch != max {
+		cs.currentEpoch = max + 1
+		cs.myself.configEpoch = cs.currentEpoch
+	}
+}
+
+// 每100ms执行：连接其他节点，定期发送ping，清理超时的握手
+func clusterCron() {
+	cs := server.cluster
+	if cs == nil {
+		return
+	}
+	now := utils.GetMsTime()
+	for _, n := range cs.nodes {
+		if n == cs.myself {
+			continue
+		}
+		if n.flags&CLUSTER_NODE_HANDSHAKE != 0 && now-n.ctime > CLUSTER_NODE_TIMEOUT {
+			log.Printf("cluster handshake with %v:%d timeout\n", n.ip, n.port)
+			clusterDelNode(n)
+			continue
+		}
+		if n.fd >= 0 && n.pingSent != 0 && now-n.pingSent > CLUSTER_NODE_TIMEOUT/2 {
+			clusterCloseLink(n)
+		}
+		if n.fd < 0 && !clusterConnectNode(n) {
+			continue
+		}
+		if n.pingSent == 0 && now-n.lastPing >= CLUSTER_PING_INTERVAL {
+			clusterSendPing(n)
+		}
+	}
+	if cs.todoSave {
+		if err := clusterSaveConfig(); err != nil {
+			log.Printf("save cluster config err: %v\n", err)
+		}
+	}
+}
+
+// CLUSTER NODES 中的一行
+func clusterGenNodeDescription(n *clusterNode) string {
+	cs := server.cluster
+	flags := "master"
+	linkState := "connected"
+	if n == cs.myself {
+		flags = "myself,master"
+	} else {
+		if utils.GetMsTime()-n.pongReceived > CLUSTER_NODE_TIMEOUT {
+			flags += ",fail?"
+		}
+		if n.fd < 0 {
+			linkState = "disconnected"
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v %v:%d@%d %v - %d %d %d %v", n.id, n.ip, n.port, n.port, flags, n.pingSent, n.pongReceived, n.configEpoch, linkState)
+	for _, r := range clusterNodeSlotRanges(n) {
+		sb.WriteString(" " + formatSlotRange(r))
+	}
+	if n == cs.myself {
+		for s := 0; s < CLUSTER_SLOTS; s++ {
+			if cs.migratingTo[s] != nil {
+				fmt.Fprintf(&sb, " [%d->-%v]", s, cs.migratingTo[s].id)
+			} else if cs.importingFrom[s] != nil {
+				fmt.Fprintf(&sb, " [%d-<-%v]", s, cs.importingFrom[s].id)
+			}
+		}
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// 按id排序，不包括握手中的节点
+func clusterSortedNodes() []*clusterNode {
+	var nodes []*clusterNode
+	for _, n := range server.cluster.nodes {
+		if n.flags&CLUSTER_NODE_HANDSHAKE == 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+func clusterGenNodesDescription() string {
+	var sb strings.Builder
+	for _, n := range clusterSortedNodes() {
+		sb.WriteString(clusterGenNodeDescription(n))
+	}
+	return sb.String()
+}
+
+func clusterSaveConfig() error {
+	cs := server.cluster
+	cs.todoSave = false
+	content := clusterGenNodesDescription() + fmt.Sprintf("vars currentEpoch %d\n", cs.currentEpoch)
+	return writeFileAtomic(clusterFilename(), []byte(content))
+}
+
+// 加载 nodes.conf，文件不存在时返回false
+func clusterLoadConfig() (bool, error) {
+	data, err := os.ReadFile(clusterFilename())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	cs := server.cluster
+	var lines [][]string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					if cs.currentEpoch, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+						return false, CLUSTER_CONFIG_ERR
+					}
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return false, CLUSTER_CONFIG_ERR
+		}
+		n := createClusterNode(fields[0], 0)
+		addr, _, _ := strings.Cut(fields[1], "@")
+		host, port, err := gonet.SplitHostPort(addr)
+		if err != nil {
+			return false, CLUSTER_CONFIG_ERR
+		}
+		n.ip = host
+		if n.port, err = strconv.Atoi(port); err != nil {
+			return false, CLUSTER_CONFIG_ERR
+		}
+		if n.configEpoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+			return false, CLUSTER_CONFIG_ERR
+		}
+		for _, flag := range strings.Split(fields[2], ",") {
+			if flag == "myself" {
+				n.flags |= CLUSTER_NODE_MYSELF
+				cs.myself = n
+			}
+		}
+		cs.nodes[n.id] = n
+		lines = append(lines, fields)
+	}
+	if cs.myself == nil {
+		return false, CLUSTER_CONFIG_ERR
+	}
+	// 节点都创建后再加载slot，迁移状态中引用了其他节点
+	for _, fields := range lines {
+		n := cs.nodes[fields[0]]
+		for _, f := range fields[8:] {
+			if !strings.HasPrefix(f, "[") {
+				r, err := parseSlotRange(f)
+				if err != nil {
+					return false, CLUSTER_CONFIG_ERR
+				}
+				for s := r[0]; s <= r[1]; s++ {
+					cs.slots[s] = n
+				}
+				continue
+			}
+			f = strings.Trim(f, "[]")
+			table := &cs.migratingTo
+			slotStr, id, found := strings.Cut(f, "->-")
+			if !found {
+				table = &cs.importingFrom
+				slotStr, id, found = strings.Cut(f, "-<-")
+			}
+			s, err := parseSlot(slotStr)
+			if !found || err != nil || cs.nodes[id] == nil {
+				return false, CLUSTER_CONFIG_ERR
+			}
+			table[s] = cs.nodes[id]
+		}
+	}
+	return true, nil
+}
+
+func clusterSlotsAssigned() int {
+	assigned := 0
+	for _, n := range server.cluster.slots {
+		if n != nil {
+			assigned++
+		}
+	}
+	return assigned
+}
+
+func genClusterInfo(sb *strings.Builder) {
+	cs := server.cluster
+	fmt.Fprintf(sb, "cluster_enabled:%d\r\n", boolToInt(cs != nil))
+	if cs == nil {
+		return
+	}
+	assigned := clusterSlotsAssigned()
+	state := "ok"
+	if assigned != CLUSTER_SLOTS {
+		state = "fail"
+	}
+	size := 0
+	for _, n := range clusterSortedNodes() {
+		if len(clusterNodeSlotRanges(n)) > 0 {
+			size++
+		}
+	}
+	fmt.Fprintf(sb, "cluster_state:%v\r\n", state)
+	fmt.Fprintf(sb, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(sb, "cluster_known_nodes:%d\r\n", len(clusterSortedNodes()))
+	fmt.Fprintf(sb, "cluster_size:%d\r\n", size)
+	fmt.Fprintf(sb, "cluster_current_epoch:%d\r\n", cs.currentEpoch)
+	fmt.Fprintf(sb, "cluster_my_epoch:%d\r\n", cs.myself.configEpoch)
+}
+
+// slot -> key 索引，只在集群模式下维护，由 dbAdd/setKey/dbDelete 更新
+func createSlotKeys() []map[string]*obj.Gobj {
+	return make([]map[string]*obj.Gobj, CLUSTER_SLOTS)
+}
+
+// key 为db中保存的对象
+func slotToKeyAdd(db *GodisDB, key *obj.Gobj) {
+	if db.slotKeys == nil {
+		return
+	}
+	slot := keyHashSlot(key.StrVal())
+	if db.slotKeys[slot] == nil {
+		db.slotKeys[slot] = make(map[string]*obj.Gobj)
+	}
+	db.slotKeys[slot][key.StrVal()] = key
+}
+
+func slotToKeyDel(db *GodisDB, key *obj.Gobj) {
+	if db.slotKeys == nil {
+		return
+	}
+	slot := keyHashSlot(key.StrVal())
+	delete(db.slotKeys[slot], key.StrVal())
+	if len(db.slotKeys[slot]) == 0 {
+		db.slotKeys[slot] = nil
+	}
+}
+
+func countKeysInSlot(slot int) int {
+	return len(server.dbs[0].slotKeys[slot])
+}
+
+func getKeysInSlot(slot int, max int) []*obj.Gobj {
+	var keys []*obj.Gobj
+	for _, key := range server.dbs[0].slotKeys[slot] {
+		if len(keys) >= max {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func delKeysInSlot(slot int) int {
+	keys := getKeysInSlot(slot, countKeysInSlot(slot))
+	for _, key := range keys {
+		key.IncrRefCount()
+		dbDelete(server.dbs[0], key)
+		key.DecrRefCount()
+	}
+	return len(keys)
+}
+
+// 回复节点信息 ip port id
+func addReplyClusterNode(c *GodisClient, n *clusterNode) {
+	c.AddReplyArrayLen(3)
+	c.AddReplyBulk(n.ip)
+	c.AddReplyInt(int64(n.port))
+	c.AddReplyBulk(n.id)
+}
+
+func checkClusterEnabled(c *GodisClient) bool {
+	if server.cluster == nil {
+		c.AddReplyErrorFormat("ERR: %v", CLUSTER_DISABLED_ERR)
+		return false
+	}
+	return true
+}
+
+func getSlotFromArg(c *GodisClient, o *obj.Gobj) (int, bool) {
+	slot, err := parseSlot(o.StrVal())
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return 0, false
+	}
+	return slot, true
+}
+
+// CLUSTER subcommand [args ...]
+func clusterCommand(c *GodisClient) {
+	if !checkClusterEnabled(c) {
+		return
+	}
+	cs := server.cluster
+	sub := strings.ToLower(c.args[1].StrVal())
+	argc := len(c.args)
+	switch {
+	case sub == "myid" && argc == 2:
+		c.AddReplyBulk(cs.myself.id)
+	case sub == "info" && argc == 2:
+		var sb strings.Builder
+		genClusterInfo(&sb)
+		c.AddReplyBulk(sb.String())
+	case sub == "nodes" && argc == 2:
+		c.AddReplyBulk(clusterGenNodesDescription())
+	case sub == "slots" && argc == 2:
+		clusterReplySlots(c)
+	case sub == "shards" && argc == 2:
+		clusterReplyShards(c)
+	case sub == "meet" && argc == 4:
+		clusterMeetCommand(c)
+	case sub == "addslots" && argc >= 3:
+		var ranges [][2]int
+		for _, arg := range c.args[2:] {
+			slot, ok := getSlotFromArg(c, arg)
+			if !ok {
+				return
+			}
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+		clusterAddSlots(c, ranges)
+	case sub == "addslotsrange" && argc >= 4 && argc%2 == 0:
+		var ranges [][2]int
+		for i := 2; i < argc; i += 2 {
+			start, ok := getSlotFromArg(c, c.args[i])
+			if !ok {
+				return
+			}
+			end, ok := getSlotFromArg(c, c.args[i+1])
+			if !ok {
+				return
+			}
+			if start > end {
+				c.AddReplyErrorFormat("ERR: start slot number %d is greater than end slot number %d", start, end)
+				return
+			}
+			ranges = append(ranges, [2]int{start, end})
+		}
+		clusterAddSlots(c, ranges)
+	case sub == "setslot" && argc >= 4:
+		clusterSetSlotCommand(c)
+	case sub == "keyslot" && argc == 3:
+		c.AddReplyInt(int64(keyHashSlot(c.args[2].StrVal())))
+	case sub == "countkeysinslot" && argc == 3:
+		slot, ok := getSlotFromArg(c, c.args[2])
+		if !ok {
+			return
+		}
+		c.AddReplyInt(int64(countKeysInSlot(slot)))
+	case sub == "getkeysinslot" && argc == 4:
+		slot, ok := getSlotFromArg(c, c.args[2])
+		if !ok {
+			return
+		}
+		count, ok := getIntFromArg(c, c.args[3])
+		if !ok {
+			return
+		}
+		if count < 0 {
+			c.AddReplyError("ERR: Invalid number of keys")
+			return
+		}
+		keys := getKeysInSlot(slot, int(count))
+		c.AddReplyArrayLen(len(keys))
+		for _, key := range keys {
+			c.AddReplyBulk(key.StrVal())
+		}
+	default:
+		c.AddReplyError("ERR: unknown subcommand or wrong number of arguments")
+	}
+}
+
+// CLUSTER SLOTS，每个连续区间回复 start end [ip port id]
+func clusterReplySlots(c *GodisClient) {
+	cs := server.cluster
+	type slotRange struct {
+		start, end int
+		node       *clusterNode
+	}
+	var ranges []slotRange
+	for s := 0; s < CLUSTER_SLOTS; s++ {
+		n := cs.slots[s]
+		if n == nil {
+			continue
+		}
+		start := s
+		for s+1 < CLUSTER_SLOTS && cs.slots[s+1] == n {
+			s++
+		}
+		ranges = append(ranges, slotRange{start, s, n})
+	}
+	c.AddReplyArrayLen(len(ranges))
+	for _, r := range ranges {
+		c.AddReplyArrayLen(3)
+		c.AddReplyInt(int64(r.start))
+		c.AddReplyInt(int64(r.end))
+		addReplyClusterNode(c, r.node)
+	}
+}
+
+// CLUSTER SHARDS，每个节点为一个shard
+func clusterReplyShards(c *GodisClient) {
+	nodes := clusterSortedNodes()
+	now := utils.GetMsTime()
+	c.AddReplyArrayLen(len(nodes))
+	for _, n := range nodes {
+		ranges := clusterNodeSlotRanges(n)
+		c.AddReplyMapLen(2)
+		c.AddReplyBulk("slots")
+		c.AddReplyArrayLen(len(ranges) * 2)
+		for _, r := range ranges {
+			c.AddReplyInt(int64(r[0]))
+			c.AddReplyInt(int64(r[1]))
+		}
+		health := "online"
+		if n != server.cluster.myself && now-n.pongReceived > CLUSTER_NODE_TIMEOUT {
+			health = "fail"
+		}
+		c.AddReplyBulk("nodes")
+		c.AddReplyArrayLen(1)
+		c.AddReplyMapLen(6)
+		c.AddReplyBulk("id")
+		c.AddReplyBulk(n.id)
+		c.AddReplyBulk("port")
+		c.AddReplyInt(int64(n.port))
+		c.AddReplyBulk("ip")
+		c.AddReplyBulk(n.ip)
+		c.AddReplyBulk("endpoint")
+		c.AddReplyBulk(n.ip)
+		c.AddReplyBulk("role")
+		c.AddReplyBulk("master")
+		c.AddReplyBulk("health")
+		c.AddReplyBulk(health)
+	}
+}
+
+// CLUSTER MEET ip port
+func clusterMeetCommand(c *GodisClient) {
+	host := c.args[2].StrVal()
+	port, err := strconv.Atoi(c.args[3].StrVal())
+	ip, rerr := resolveHost(host)
+	if err != nil || rerr != nil || port <= 0 || port > 65535 {
+		c.AddReplyErrorFormat("ERR: Invalid node address specified: %v:%v", host, c.args[3].StrVal())
+		return
+	}
+	addr := gonet.IP(ip[:]).String()
+	if clusterStartHandshake(addr, port) {
+		log.Printf("cluster meet %v:%d\n", addr, port)
+	}
+	c.AddReplyStatus("OK")
+}
+
+// CLUSTER ADDSLOTS / ADDSLOTSRANGE
+func clusterAddSlots(c *GodisClient, ranges [][2]int) {
+	cs := server.cluster
+	seen := make(map[int]bool)
+	for _, r := range ranges {
+		for s := r[0]; s <= r[1]; s++ {
+			if cs.slots[s] != nil {
+				c.AddReplyErrorFormat("ERR: Slot %d is already busy", s)
+				return
+			}
+			if seen[s] {
+				c.AddReplyErrorFormat("ERR: Slot %d specified multiple times", s)
+				return
+			}
+			seen[s] = true
+		}
+	}
+	for s := range seen {
+		cs.slots[s] = cs.myself
+		cs.importingFrom[s] = nil
+	}
+	clusterSaveConfigOrLog()
+	c.AddReplyStatus("OK")
+}
+
+func clusterSaveConfigOrLog() {
+	if err := clusterSaveConfig(); err != nil {
+		log.Printf("save cluster config err: %v\n", err)
+	}
+}
+
+// CLUSTER SETSLOT slot IMPORTING node-id | MIGRATING node-id | STABLE | NODE node-id
+func clusterSetSlotCommand(c *GodisClient) {
+	cs := server.cluster
+	slot, ok := getSlotFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	action := strings.ToLower(c.args[3].StrVal())
+	if action == "stable" {
+		if len(c.args) != 4 {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		cs.migratingTo[slot] = nil
+		cs.importingFrom[slot] = nil
+		clusterSaveConfigOrLog()
+		c.AddReplyStatus("OK")
+		return
+	}
+	if len(c.args) != 5 || (action != "migrating" && action != "importing" && action != "node") {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	id := c.args[4].StrVal()
+	n := cs.nodes[id]
+	if n == nil || n.flags&CLUSTER_NODE_HANDSHAKE != 0 {
+		c.AddReplyErrorFormat("ERR: I don't know about node %v", id)
+		return
+	}
+	switch action {
+	case "migrating":
+		if cs.slots[slot] != cs.myself {
+			c.AddReplyErrorFormat("ERR: I'm not the owner of hash slot %d", slot)
+			return
+		}
+		if n == cs.myself {
+			c.AddReplyError("ERR: I can't migrate a slot to myself")
+			return
+		}
+		cs.migratingTo[slot] = n
+	case "importing":
+		if cs.slots[slot] == cs.myself {
+			c.AddReplyErrorFormat("ERR: I'm already the owner of hash slot %d", slot)
+			return
+		}
+		if n == cs.myself {
+			c.AddReplyError("ERR: I can't import a slot from myself")
+			return
+		}
+		cs.importingFrom[slot] = n
+	case "node":
+		if cs.slots[slot] == cs.myself && n != cs.myself && countKeysInSlot(slot) > 0 {
+			c.AddReplyErrorFormat("ERR: Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+			return
+		}
+		if n != cs.myself {
+			cs.migratingTo[slot] = nil
+		}
+		// 导入完成，使用新的 configEpoch 使其他节点接受新的归属
+		if n == cs.myself && cs.importingFrom[slot] != nil {
+			cs.importingFrom[slot] = nil
+			clusterBumpConfigEpoch()
+		}
+		cs.slots[slot] = n
+	}
+	clusterSaveConfigOrLog()
+	c.AddReplyStatus("OK")
+}
+
+// CLUSTER.PING type id ip port currentEpoch configEpoch slots [id ip port ...]
+func clusterPingCommand(c *GodisClient) {
+	if !checkClusterEnabled(c) {
+		return
+	}
+	msg := make([]string, len(c.args)-1)
+	for i := range msg {
+		msg[i] = c.args[i+1].StrVal()
+	}
+	if msg[0] != "ping" && msg[0] != "meet" {
+		c.AddReplyErrorFormat("ERR: %v", CLUSTER_MSG_ERR)
+		return
+	}
+	if err := clusterProcessMessage(msg, nil); err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	c.AddReplyStrings(clusterBuildMessage("pong", server.cluster.nodes[msg[1]]))
+}
+
+// ASKING
+func askingCommand(c *GodisClient) {
+	if !checkClusterEnabled(c) {
+		return
+	}
+	c.flags |= CLIENT_ASKING
+	c.AddReplyStatus("OK")
+}
+
+// DUMP 格式：对象类型 + 对象的RDB编码 + EOF及校验和
+func createDumpPayload(o *obj.Gobj) (string, error) {
+	var buf bytes.Buffer
+	enc := rdb.NewEncoder(&buf)
+	if err := enc.SaveType(byte(o.Type_)); err != nil {
+		return "", err
+	}
+	if err := rdbSaveObject(enc, o); err != nil {
+		return "", err
+	}
+	if err := enc.SaveFooter(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func loadDumpPayload(payload string) (*obj.Gobj, error) {
+	dec := rdb.NewDecoder(strings.NewReader(payload))
+	typ, err := dec.LoadType()
+	if err != nil {
+		return nil, DUMP_PAYLOAD_ERR
+	}
+	o, err := rdbLoadObject(dec, obj.Gtype(typ))
+	if err != nil {
+		return nil, DUMP_PAYLOAD_ERR
+	}
+	if eof, err := dec.LoadType(); err != nil || eof != rdb.RDB_OPCODE_EOF || dec.VerifyChecksum() != nil {
+		o.DecrRefCount()
+		return nil, DUMP_PAYLOAD_ERR
+	}
+	return o, nil
+}
+
+// DUMP key
+func dumpCommand(c *GodisClient) {
+	val := findKeyRead(c.db, c.args[1])
+	if val == nil {
+		c.AddReplyNull()
+		return
+	}
+	payload, err := createDumpPayload(val)
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	c.AddReplyBulk(payload)
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+func restoreCommand(c *GodisClient) {
+	replace, absttl := false, false
+	for _, arg := range c.args[4:] {
+		switch strings.ToLower(arg.StrVal()) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absttl = true
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+	ttl, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	if ttl < 0 {
+		c.AddReplyError("ERR: Invalid TTL value, must be >= 0")
+		return
+	}
+	key := c.args[1]
+	if !replace && findKeyWrite(c.db, key) != nil {
+		c.AddReplyError("BUSYKEY Target key name already exists.")
+		return
+	}
+	val, err := loadDumpPayload(c.args[3].StrVal())
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	now := utils.GetMsTime()
+	var when int64 = -1
+	if ttl > 0 {
+		when = ttl
+		if !absttl {
+			when += now
+		}
+	}
+	deleted := dbDelete(c.db, key)
+	if when != -1 && when <= now {
+		val.DecrRefCount()
+		if deleted {
+			server.dirty++
+			rewriteClientCommandArgs(c, "del", key.StrVal())
+		}
+		c.AddReplyStatus("OK")
+		return
+	}
+	dbAdd(c.db, key, val)
+	val.DecrRefCount()
+	server.dirty++
+	if when != -1 {
+		setExpire(c.db, key, when)
+		// 传播绝对过期时间，保证重放结果一致
+		if !absttl {
+			rewriteClientCommandArgs(c, "restore", key.StrVal(), formatInt(when), c.args[3].StrVal(), "replace", "absttl")
+		}
+	}
+	c.AddReplyStatus("OK")
+}
+
+// MIGRATE 的key，key参数为空字符串时使用 KEYS 之后的参数
+func migrateGetKeys(args []*obj.Gobj) []int {
+	if len(args) < 6 {
+		return nil
+	}
+	if args[3].StrVal() != "" {
+		return []int{3}
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.ToLower(args[i].StrVal()) == "keys" {
+			var keys []int
+			for j := i + 1; j < len(args); j++ {
+				keys = append(keys, j)
+			}
+			return keys
+		}
+	}
+	return nil
+}
+
+// 读取n条回复，错误回复转为error，其他为nil
+func readMigrateReplies(fd int, n int) ([]error, error) {
+	r := resp.NewReader(net.FdReader(fd))
+	replies := make([]error, n)
+	for i := range replies {
+		v, err := r.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v.Err()
+	}
+	return replies, nil
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key ...]
+// 阻塞地将key通过 RESTORE-ASKING 发送到目标节点，成功后删除本地的key
+func migrateCommand(c *GodisClient) {
+	copyKeys, replace := false, false
+	for i := 6; i < len(c.args); i++ {
+		switch strings.ToLower(c.args[i].StrVal()) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			if c.args[3].StrVal() != "" {
+				c.AddReplyError("ERR: When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			i = len(c.args)
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+	vals, ok := getInt64Args(c, 4, 6)
+	if !ok {
+		return
+	}
+	dbid, timeout := vals[0], vals[1]
+	if timeout <= 0 {
+		timeout = DEFAULT_MIGRATE_TIMEOUT
+	}
+	port, err := strconv.Atoi(c.args[2].StrVal())
+	if err != nil {
+		c.AddReplyError("ERR: invalid port")
+		return
+	}
+	// 只迁移存在的key
+	var keys []string
+	var cmds []byte
+	cmds = resp.AppendCommand(cmds, []string{"select", formatInt(dbid)})
+	now := utils.GetMsTime()
+	for _, i := range migrateGetKeys(c.args) {
+		key := c.args[i]
+		val := findKeyRead(c.db, key)
+		if val == nil {
+			continue
+		}
+		payload, err := createDumpPayload(val)
+		if err != nil {
+			c.AddReplyErrorFormat("ERR: %v", err)
+			return
+		}
+		var ttl int64
+		if when := getExpire(c.db, key); when != -1 {
+			if ttl = when - now; ttl < 1 {
+				ttl = 1
+			}
+		}
+		args := []string{"restore-asking", key.StrVal(), formatInt(ttl), payload}
+		if replace {
+			args = append(args, "replace")
+		}
+		cmds = resp.AppendCommand(cmds, args)
+		keys = append(keys, key.StrVal())
+	}
+	c.preventPropagate = true
+	if len(keys) == 0 {
+		c.AddReplyStatus("NOKEY")
+		return
+	}
+	ip, err := resolveHost(c.args[1].StrVal())
+	if err != nil {
+		c.AddReplyError("IOERR error or timeout connecting to the client")
+		return
+	}
+	fd, err := net.Connect(ip, port)
+	if err != nil {
+		c.AddReplyError("IOERR error or timeout connecting to the client")
+		return
+	}
+	defer net.Close(fd)
+	var replies []error
+	err = net.SetTimeout(fd, timeout)
+	for sent := 0; err == nil && sent < len(cmds); {
+		var n int
+		n, err = net.Write(fd, cmds[sent:])
+		sent += n
+	}
+	if err == nil {
+		replies, err = readMigrateReplies(fd, len(keys)+1)
+	}
+	if err != nil {
+		c.AddReplyErrorFormat("IOERR error or timeout writing to target instance: %v", err)
+		return
+	}
+	if replies[0] != nil {
+		c.AddReplyErrorFormat("ERR: Target instance replied with error: %v", replies[0])
+		return
+	}
+	var errReply error
+	var migrated []string
+	for i, reply := range replies[1:] {
+		if reply != nil {
+			errReply = reply
+			continue
+		}
+		migrated = append(migrated, keys[i])
+	}
+	// 已迁移的key从本地删除，并以DEL传播
+	if !copyKeys && len(migrated) > 0 {
+		for _, k := range migrated {
+			key := obj.CreateObject(obj.GSTR, k)
+			dbDelete(c.db, key)
+			key.DecrRefCount()
+		}
+		server.dirty += int64(len(migrated))
+		rewriteClientCommandArgs(c, append([]string{"del"}, migrated...)...)
+		c.preventPropagate = false
+	}
+	if errReply != nil {
+		c.AddReplyErrorFormat("ERR: Target instance replied with error: %v", errReply)
+		return
+	}
+	c.AddReplyStatus("OK")
+}
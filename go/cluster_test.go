@@ -0,0 +1,242 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/resp"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	assert.Equal(t, 12182, keyHashSlot("foo"))
+	assert.Equal(t, keyHashSlot("user1000"), keyHashSlot("{user1000}.following"))
+	assert.Equal(t, keyHashSlot("user1000"), keyHashSlot("foo{user1000}{bar}"))
+	// 空的tag使用整个key
+	assert.Equal(t, int(0x31C3&(CLUSTER_SLOTS-1)), keyHashSlot("123456789"))
+	assert.NotEqual(t, keyHashSlot("{}a"), keyHashSlot("{}b"))
+}
+
+func TestGetKeysFromCommand(t *testing.T) {
+	keys := func(query ...string) []int {
+		return getKeysFromCommand(lookupCommand(query[0]), createArgs(query))
+	}
+	assert.Equal(t, []int{1}, keys("get", "k"))
+	assert.Equal(t, []int{1, 3}, keys("mset", "a", "1", "b", "2"))
+	assert.Equal(t, []int{1, 2}, keys("blpop", "a", "b", "0"))
+	assert.Equal(t, []int{1, 3, 4}, keys("zunionstore", "dst", "2", "a", "b", "weights", "1", "2"))
+	assert.Equal(t, []int{2, 3}, keys("zdiff", "2", "a", "b"))
+	assert.Nil(t, keys("keys", "*"))
+	assert.Equal(t, []int{8, 9}, keys("migrate", "127.0.0.1", "1", "", "0", "10", "copy", "keys", "a", "b"))
+}
+
+func clusterPing(client *GodisClient, args ...string) string {
+	return ExecQuery(client, string(resp.AppendCommand(nil, append([]string{"cluster.ping"}, args...))))
+}
+
+func TestClusterSlotKeys(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.ClusterEnabled = true
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	ExecQuery(client, "cluster addslotsrange 0 16383\r\n")
+	slot := keyHashSlot("t")
+	count := func() int { return countKeysInSlot(slot) }
+
+	ExecQuery(client, "mset {t}a 1 {t}b 2 {t}c 3\r\n")
+	ExecQuery(client, "set {t}a 4\r\n")
+	assert.Equal(t, 3, count())
+	ExecQuery(client, "del {t}b\r\n")
+	ExecQuery(client, "rename {t}c {t}d\r\n")
+	assert.Equal(t, 2, count())
+	assert.Equal(t, 2, len(getKeysInSlot(slot, 10)))
+	assert.Equal(t, 1, len(getKeysInSlot(slot, 1)))
+	ExecQuery(client, "set {t}e v px 1\r\n")
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists {t}e\r\n"))
+	assert.Equal(t, 2, count())
+
+	// 重启后从快照重建索引
+	ExecQuery(client, "save\r\n")
+	assert.Nil(t, initServer(&config))
+	assert.Equal(t, 2, count())
+	assert.Equal(t, 2, delKeysInSlot(slot))
+	assert.Equal(t, 0, count())
+	assert.Equal(t, int64(0), server.dbs[0].data.Size())
+}
+
+func TestClusterCommands(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.ClusterEnabled = true
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	myid := server.cluster.myself.id
+	assert.Equal(t, ":12182\r\n", ExecQuery(client, "cluster keyslot foo\r\n"))
+	assert.Equal(t, "-CLUSTERDOWN Hash slot not served\r\n", ExecQuery(client, "set foo bar\r\n"))
+	assert.Equal(t, "-ERR: only DB 0 is available in cluster mode\r\n", ExecQuery(client, "select 1\r\n"))
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "cluster addslotsrange 0 8000 8001 16383\r\n"))
+	assert.Equal(t, "-ERR: Slot 1 is already busy\r\n", ExecQuery(client, "cluster addslots 1\r\n"))
+	assert.Contains(t, ExecQuery(client, "cluster info\r\n"), "cluster_state:ok")
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set foo bar\r\n"))
+	assert.Equal(t, "-CROSSSLOT Keys in request don't hash to the same slot\r\n", ExecQuery(client, "mset a 1 b 2\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "mset {t}a 1 {t}b 2\r\n"))
+	slot := keyHashSlot("t")
+	assert.Equal(t, ":2\r\n", ExecQuery(client, fmt.Sprintf("cluster countkeysinslot %d\r\n", slot)))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set {t}c 3 keepttl\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, fmt.Sprintf("cluster countkeysinslot %d\r\n", slot)))
+	ExecQuery(client, "del {t}c\r\n")
+	assert.Equal(t, "*1\r\n", ExecQuery(client, fmt.Sprintf("cluster getkeysinslot %d 1\r\n", slot))[:4])
+	assert.Equal(t, fmt.Sprintf("*1\r\n*3\r\n:0\r\n:16383\r\n*3\r\n$9\r\n127.0.0.1\r\n:%d\r\n$40\r\n%v\r\n", config.Port, myid), ExecQuery(client, "cluster slots\r\n"))
+	assert.Contains(t, ExecQuery(client, "cluster nodes\r\n"), fmt.Sprintf("%v 127.0.0.1:%d@%d myself,master - 0 0 0 connected 0-16383\n", myid, config.Port, config.Port))
+
+	// DUMP / RESTORE
+	ExecQuery(client, "rpush list a b c\r\n")
+	ExecQuery(client, "sadd {list}set x\r\n")
+	payload := ExecQuery(client, "dump list\r\n")
+	payload = payload[strings.Index(payload, "\r\n")+2 : len(payload)-2]
+	restore := func(args ...string) string {
+		return ExecQuery(client, string(resp.AppendCommand(nil, args)))
+	}
+	assert.Equal(t, "-BUSYKEY Target key name already exists.\r\n", restore("restore", "list", "0", payload))
+	assert.Equal(t, "+OK\r\n", restore("restore", "{list}copy", "100000", payload))
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "lrange {list}copy 0 -1\r\n"))
+	assert.NotEqual(t, ":-1\r\n", ExecQuery(client, "ttl {list}copy\r\n"))
+	assert.Equal(t, "-ERR: DUMP payload version or checksum are wrong\r\n", restore("restore", "{list}bad", "0", payload[:len(payload)-1]+"x"))
+	assert.Equal(t, "+NOKEY\r\n", ExecQuery(client, "migrate 127.0.0.1 1 nokey 0 100\r\n"))
+
+	// 导入中的slot需要 ASKING
+	other := strings.Repeat("f", 40)
+	clusterPing(client, "meet", other, "127.0.0.1", "1", "0", "0", "")
+	assert.Equal(t, "-ERR: I'm already the owner of hash slot 0\r\n", ExecQuery(client, fmt.Sprintf("cluster setslot 0 importing %v\r\n", other)))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, fmt.Sprintf("cluster setslot %d migrating %v\r\n", slot, other)))
+	assert.Equal(t, "$1\r\n1\r\n", ExecQuery(client, "get {t}a\r\n"))
+	assert.Equal(t, fmt.Sprintf("-ASK %d 127.0.0.1:1\r\n", slot), ExecQuery(client, "get {t}c\r\n"))
+	assert.Equal(t, "-TRYAGAIN Multiple keys request during rehashing of slot\r\n", ExecQuery(client, "mget {t}a {t}c\r\n"))
+	assert.Contains(t, ExecQuery(client, "cluster nodes\r\n"), fmt.Sprintf("[%d->-%v]", slot, other))
+
+	// 重启后从 nodes.conf 恢复
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	assert.Equal(t, myid, server.cluster.myself.id)
+	assert.Equal(t, server.cluster.nodes[other], server.cluster.migratingTo[slot])
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, fmt.Sprintf("cluster setslot %d stable\r\n", slot)))
+	assert.Equal(t, CLUSTER_SLOTS, clusterSlotsAssigned())
+	assert.Contains(t, ExecQuery(client, "info cluster\r\n"), "cluster_known_nodes:2")
+
+	config.Raft = true
+	assert.Equal(t, CLUSTER_RAFT_ERR, initServer(&config))
+}
+
+func TestClusterMessage(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.ClusterEnabled = true
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	cs := server.cluster
+	ExecQuery(client, "cluster addslots 100 101\r\n")
+	key := ""
+	for i := 0; keyHashSlot(key) != 101; i++ {
+		key = fmt.Sprintf("key%d", i)
+	}
+	ExecQuery(client, fmt.Sprintf("set %v v\r\n", key))
+	ping := func(args ...string) string {
+		return clusterPing(client, args...)
+	}
+
+	// 未知节点的ping被忽略，meet加入节点
+	other := strings.Repeat("f", 40)
+	ping("ping", other, "127.0.0.1", "1", "0", "0", "")
+	assert.Nil(t, cs.nodes[other])
+	reply := ping("meet", other, "127.0.0.1", "1", "3", "0", "", strings.Repeat("c", 40), "127.0.0.1", "2")
+	assert.True(t, strings.HasPrefix(reply, "*7\r\n$4\r\npong\r\n"))
+	assert.Contains(t, reply, "100-101")
+	assert.NotNil(t, cs.nodes[other])
+	assert.Equal(t, 3, len(cs.nodes))
+	assert.NotNil(t, clusterNodeByAddr("127.0.0.1", 2))
+
+	// configEpoch 相同时 id 较小的节点使用新的epoch
+	assert.Equal(t, int64(4), cs.myself.configEpoch)
+	assert.Equal(t, int64(4), cs.currentEpoch)
+	// configEpoch 较大的节点获得slot，本地的key被删除
+	ping("ping", other, "127.0.0.1", "1", "5", "5", "101-102")
+	assert.Equal(t, cs.nodes[other], cs.slots[101])
+	assert.Equal(t, cs.nodes[other], cs.slots[102])
+	assert.Equal(t, cs.myself, cs.slots[100])
+	assert.Equal(t, "-MOVED 101 127.0.0.1:1\r\n", ExecQuery(client, fmt.Sprintf("get %v\r\n", key)))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "cluster countkeysinslot 101\r\n"))
+	// configEpoch 较小时不能夺取slot
+	ping("ping", other, "127.0.0.1", "1", "5", "1", "100")
+	assert.Equal(t, cs.myself, cs.slots[100])
+	assert.Equal(t, "-ERR: bad cluster message\r\n", ping("ping", other, "127.0.0.1", "1", "5", "1", "", "x"))
+}
+
+func TestClusterProcesses(t *testing.T) {
+	bin := buildGodis(t)
+	var ports []int
+	var conns []*respConn
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ports = append(ports, freePort(t))
+		startGodis(t, bin, &conf.Config{Port: ports[i], ClusterEnabled: true})
+		conns = append(conns, dialGodis(t, ports[i]))
+		ids = append(ids, conns[i].do(t, "cluster", "myid")[5:45])
+	}
+	ranges := [][2]string{{"0", "5460"}, {"5461", "10922"}, {"10923", "16383"}}
+	for i, c := range conns {
+		assert.Equal(t, "+OK\r\n", c.do(t, "cluster", "addslotsrange", ranges[i][0], ranges[i][1]))
+	}
+	// 只需与一个节点握手，其他节点通过gossip发现
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "cluster", "meet", "127.0.0.1", strconv.Itoa(ports[1])))
+	assert.Equal(t, "+OK\r\n", conns[2].do(t, "cluster", "meet", "127.0.0.1", strconv.Itoa(ports[0])))
+	for _, c := range conns {
+		waitReply(t, c, "cluster_known_nodes:3", "cluster", "info")
+		waitReply(t, c, "cluster_state:ok", "cluster", "info")
+	}
+	assert.Equal(t, fmt.Sprintf("-MOVED 12182 127.0.0.1:%d\r\n", ports[2]), conns[0].do(t, "set", "foo", "bar"))
+	assert.Equal(t, "+OK\r\n", conns[2].do(t, "set", "foo", "bar"))
+	waitReply(t, conns[1], fmt.Sprintf("%v 127.0.0.1:%d@%d master - ", ids[2], ports[2], ports[2]), "cluster", "nodes")
+
+	// 将节点0的一个slot迁移到节点1
+	tag := "tag"
+	for i := 0; keyHashSlot(tag) > 5460; i++ {
+		tag = fmt.Sprintf("tag%d", i)
+	}
+	slot := strconv.Itoa(keyHashSlot(tag))
+	a, b, c := fmt.Sprintf("{%v}a", tag), fmt.Sprintf("{%v}b", tag), fmt.Sprintf("{%v}c", tag)
+	conns[0].do(t, "mset", a, "1", b, "2", c, "3")
+	d := fmt.Sprintf("{%v}d", tag)
+	rpush := []string{"rpush", d}
+	for i := 0; i < 100; i++ {
+		rpush = append(rpush, strings.Repeat("x", 100))
+	}
+	assert.Equal(t, ":100\r\n", conns[0].do(t, rpush...))
+	assert.Equal(t, "+OK\r\n", conns[1].do(t, "cluster", "setslot", slot, "importing", ids[0]))
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "cluster", "setslot", slot, "migrating", ids[1]))
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "migrate", "127.0.0.1", strconv.Itoa(ports[1]), a, "0", "1000"))
+	assert.Equal(t, fmt.Sprintf("-ASK %v 127.0.0.1:%d\r\n", slot, ports[1]), conns[0].do(t, "get", a))
+	assert.Equal(t, "$1\r\n2\r\n", conns[0].do(t, "get", b))
+	assert.Equal(t, fmt.Sprintf("-MOVED %v 127.0.0.1:%d\r\n", slot, ports[0]), conns[1].do(t, "get", a))
+	assert.Equal(t, "+OK\r\n", conns[1].do(t, "asking"))
+	assert.Equal(t, "$1\r\n1\r\n", conns[1].do(t, "get", a))
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "migrate", "127.0.0.1", strconv.Itoa(ports[1]), "", "0", "1000", "keys", b, c))
+	// DUMP 数据超过4KB的key
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "migrate", "127.0.0.1", strconv.Itoa(ports[1]), d, "0", "1000"))
+	assert.Equal(t, ":0\r\n", conns[0].do(t, "cluster", "countkeysinslot", slot))
+	assert.Equal(t, ":4\r\n", conns[1].do(t, "cluster", "countkeysinslot", slot))
+	assert.Equal(t, "+OK\r\n", conns[1].do(t, "cluster", "setslot", slot, "node", ids[1]))
+	assert.Equal(t, "+OK\r\n", conns[0].do(t, "cluster", "setslot", slot, "node", ids[1]))
+
+	// 节点2通过gossip得知新的归属
+	moved := fmt.Sprintf("-MOVED %v 127.0.0.1:%d\r\n", slot, ports[1])
+	waitReply(t, conns[2], moved, "get", a)
+	assert.Equal(t, moved, conns[0].do(t, "get", b))
+	assert.Equal(t, "$1\r\n2\r\n", conns[1].do(t, "get", b))
+}
@@ -0,0 +1,101 @@
+// rdb-import 将Redis 6~7 生成的dump.rdb 离线转换为godis的快照文件
+// 用法: rdb-import <redis dump.rdb> <godis dump.rdb>
+package main
+
+import (
+	"akt-redis/rdb"
+	"fmt"
+	"log"
+	"os"
+)
+
+func saveEntry(enc *rdb.Encoder, e *rdb.RedisEntry) error {
+	if e.Expire != -1 {
+		if err := enc.SaveType(rdb.RDB_OPCODE_EXPIRETIME_MS); err != nil {
+			return err
+		}
+		if err := enc.SaveMillisecondTime(e.Expire); err != nil {
+			return err
+		}
+	}
+	// Redis 的逻辑类型与 obj.Gtype 取值一致
+	if err := enc.SaveType(e.Type); err != nil {
+		return err
+	}
+	if err := enc.SaveString(e.Key); err != nil {
+		return err
+	}
+	if e.Type == rdb.REDIS_RDB_TYPE_STRING {
+		return enc.SaveString(e.Values[0])
+	}
+	n := len(e.Values)
+	if e.Type == rdb.REDIS_RDB_TYPE_HASH {
+		n /= 2
+	}
+	if err := enc.SaveLen(uint64(n)); err != nil {
+		return err
+	}
+	for i, v := range e.Values {
+		if err := enc.SaveString(v); err != nil {
+			return err
+		}
+		if e.Type == rdb.REDIS_RDB_TYPE_ZSET {
+			if err := enc.SaveDouble(e.Scores[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func convert(src, dst string) (int, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	enc := rdb.NewEncoder(out)
+	if err := enc.SaveHeader(); err != nil {
+		return 0, err
+	}
+	db, count := -1, 0
+	err = rdb.NewRedisDecoder(in).Load(func(e *rdb.RedisEntry) error {
+		if e.DB != db {
+			db = e.DB
+			if err := enc.SaveType(rdb.RDB_OPCODE_SELECTDB); err != nil {
+				return err
+			}
+			if err := enc.SaveLen(uint64(db)); err != nil {
+				return err
+			}
+		}
+		count++
+		return saveEntry(enc, e)
+	})
+	if err != nil {
+		return count, err
+	}
+	if err := enc.SaveFooter(); err != nil {
+		return count, err
+	}
+	return count, out.Sync()
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: %v <redis dump.rdb> <godis dump.rdb>\n", os.Args[0])
+		os.Exit(1)
+	}
+	count, err := convert(os.Args[1], os.Args[2])
+	if err != nil {
+		os.Remove(os.Args[2])
+		log.Fatalf("convert error: %v\n", err)
+	}
+	log.Printf("converted %v keys\n", count)
+}
@@ -7,7 +7,52 @@ import (
 )
 
 type Config struct {
-	Port int `json:"port"`
+	Port      int    `json:"port"`
+	HashSeed  string `json:"hash_seed"` // 32位16进制，为空时启动时随机生成
+	Databases int    `json:"databases"` // db数目，默认16
+	// 客户端需要通过 AUTH 或 HELLO AUTH 认证，为空表示不需要，不支持raft及集群模式
+	RequirePass string `json:"requirepass"`
+	// 快照持久化
+	Dir        string     `json:"dir"`
+	DbFilename string     `json:"dbfilename"` // 默认 dump.rdb
+	Save       [][2]int64 `json:"save"`       // [[seconds, changes], ...]
+	// AOF持久化
+	AppendOnly       bool   `json:"appendonly"`
+	AppendFilename   string `json:"appendfilename"`     // 默认 appendonly.aof
+	AppendFsync      string `json:"appendfsync"`        // always/everysec/no，默认 everysec
+	AofLoadTruncated bool   `json:"aof_load_truncated"` // AOF末尾不完整时，加载到最后一条完整命令
+	// 启动时没有数据则导入Redis生成的RDB文件
+	ImportRdb string `json:"import-rdb"`
+	// 主从复制
+	ReplicaOf       string `json:"replicaof"`         // "host port"，为空表示主节点
+	ReplicaReadOnly *bool  `json:"replica_read_only"` // 默认true
+	ReplBacklogSize int    `json:"repl_backlog_size"` // 默认1MB
+	MasterAuth      string `json:"masterauth"`        // 主节点的 requirepass
+	// Raft强一致模式
+	Raft                  bool     `json:"raft"`
+	RaftAddr              string   `json:"raft_addr"`               // 本节点地址 host:port，默认 127.0.0.1:port
+	RaftPeers             []string `json:"raft_peers"`              // 初始集群的全部节点地址，为空时等待加入已有集群
+	RaftSnapshotThreshold int      `json:"raft_snapshot_threshold"` // 快照之后的日志数达到该值时压缩，默认1000
+	// 集群模式
+	ClusterEnabled    bool   `json:"cluster_enabled"`
+	ClusterConfigFile string `json:"cluster_config_file"` // 默认 nodes.conf
+	ClusterAnnounceIp string `json:"cluster_announce_ip"` // 其他节点连接本节点的ip，默认 127.0.0.1
+	// Sentinel 模式，也可以使用 --sentinel 参数启动
+	Sentinel           bool              `json:"sentinel"`
+	SentinelMonitor    []SentinelMonitor `json:"sentinel_monitor"`
+	SentinelPeers      []string          `json:"sentinel_peers"`       // 其他sentinel的地址 host:port
+	SentinelAnnounceIp string            `json:"sentinel_announce_ip"` // 其他sentinel连接本节点的ip，默认 127.0.0.1
+}
+
+// 监控的主节点
+type SentinelMonitor struct {
+	Name            string `json:"name"`
+	Host            string `json:"host"`
+	Port            int    `json:"port"`
+	Quorum          int    `json:"quorum"`                  // 判断客观下线需要的sentinel数
+	DownAfter       int64  `json:"down_after_milliseconds"` // 默认30000
+	FailoverTimeout int64  `json:"failover_timeout"`        // 毫秒，默认180000
+	AuthPass        string `json:"auth_pass"`               // 主节点及replica的 requirepass
 }
 
 func LoadConfig(path string) (*Config, error) {
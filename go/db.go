@@ -0,0 +1,533 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"akt-redis/zset"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 创建以string为key的dict，用于db.data/db.expire
+func createKeyDict() *dict.Dict {
+	return dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+}
+
+func typeName(o *obj.Gobj) string {
+	switch o.Type_ {
+	case obj.GSTR:
+		return "string"
+	case obj.GLIST:
+		return "list"
+	case obj.GSET:
+		return "set"
+	case obj.GZSET:
+		return "zset"
+	case obj.GDICT:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// 深拷贝value，string不可变，直接共享
+// 用于 COPY 及快照的写时复制
+func dupObject(o *obj.Gobj) *obj.Gobj {
+	switch o.Type_ {
+	case obj.GLIST:
+		dobj := createListObject()
+		l := dobj.Val_.(*list.List)
+		for n := o.Val_.(*list.List).First(); n != nil; n = n.Next() {
+			listPush(l, n.Val, LIST_TAIL)
+		}
+		return dobj
+	case obj.GSET:
+		dobj := createSetObject()
+		s := dobj.Val_.(*dict.Dict)
+		o.Val_.(*dict.Dict).ForEach(func(e *dict.Entry) {
+			setAdd(s, e.Key)
+		})
+		return dobj
+	case obj.GDICT:
+		dobj := createHashObject()
+		h := dobj.Val_.(*dict.Dict)
+		o.Val_.(*dict.Dict).ForEach(func(e *dict.Entry) {
+			h.Set(e.Key, e.Val)
+		})
+		return dobj
+	case obj.GZSET:
+		dobj := createZsetObject()
+		zs := dobj.Val_.(*zset.ZSet)
+		for n := o.Val_.(*zset.ZSet).First(); n != nil; n = n.Next() {
+			zs.Add(n.Score, n.Member, 0)
+		}
+		return dobj
+	}
+	o.IncrRefCount()
+	return o
+}
+
+// value 同时被快照引用时，复制一份替换db中的value，快照中的对象保持不变
+func dbUnshareObject(db *GodisDB, key *obj.Gobj, o *obj.Gobj) *obj.Gobj {
+	dup := dupObject(o)
+	db.data.Set(key, dup)
+	dup.DecrRefCount()
+	return dup
+}
+
+// 获取key的过期时间，-1表示不过期
+func getExpire(db *GodisDB, key *obj.Gobj) int64 {
+	entry := db.expire.Find(key)
+	if entry == nil {
+		return -1
+	}
+	when, _ := entry.Val.IntVal()
+	return when
+}
+
+// DEL/UNLINK key [key ...]
+func delCommand(c *GodisClient) {
+	deleted := 0
+	for _, key := range c.args[1:] {
+		if expireIfNeeded(c.db, key) {
+			continue
+		}
+		if dbDelete(c.db, key) {
+			deleted++
+		}
+	}
+	server.dirty += int64(deleted)
+	c.AddReplyInt(int64(deleted))
+}
+
+// EXISTS/TOUCH key [key ...]，重复的key重复计数
+func existsCommand(c *GodisClient) {
+	count := 0
+	for _, key := range c.args[1:] {
+		if findKeyRead(c.db, key) != nil {
+			count++
+		}
+	}
+	c.AddReplyInt(int64(count))
+}
+
+// TYPE key
+func typeCommand(c *GodisClient) {
+	o := findKeyRead(c.db, c.args[1])
+	if o == nil {
+		c.AddReplyStatus("none")
+		return
+	}
+	c.AddReplyStatus(typeName(o))
+}
+
+// RENAME/RENAMENX key newkey
+func renameGenericCommand(c *GodisClient, nx bool) {
+	src, dst := c.args[1], c.args[2]
+	val := findKeyWrite(c.db, src)
+	if val == nil {
+		c.AddReplyError("ERR: no such key")
+		return
+	}
+	if utils.GStrEqual(src, dst) {
+		if nx {
+			c.AddReplyInt(0)
+		} else {
+			c.AddReplyStatus("OK")
+		}
+		return
+	}
+	if findKeyWrite(c.db, dst) != nil {
+		if nx {
+			c.AddReplyInt(0)
+			return
+		}
+		dbDelete(c.db, dst)
+	}
+	when := getExpire(c.db, src)
+	val.IncrRefCount()
+	dbDelete(c.db, src)
+	dbAdd(c.db, dst, val)
+	val.DecrRefCount()
+	if when != -1 {
+		setExpire(c.db, dst, when)
+	}
+	server.dirty++
+	if nx {
+		c.AddReplyInt(1)
+	} else {
+		c.AddReplyStatus("OK")
+	}
+}
+
+func renameCommand(c *GodisClient) {
+	renameGenericCommand(c, false)
+}
+
+func renamenxCommand(c *GodisClient) {
+	renameGenericCommand(c, true)
+}
+
+// KEYS pattern
+func keysCommand(c *GodisClient) {
+	pattern := c.args[1].StrVal()
+	reply := c.AddReplyDeferredLen()
+	count := 0
+	// 安全迭代器允许在遍历时删除过期key
+	it := c.db.data.GetSafeIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		key := e.Key
+		if pattern != "*" && !utils.StringMatch(pattern, key.StrVal(), false) {
+			continue
+		}
+		key.IncrRefCount()
+		if findKeyRead(c.db, key) != nil {
+			c.AddReplyBulk(key.StrVal())
+			count++
+		}
+		key.DecrRefCount()
+	}
+	it.Release()
+	c.SetDeferredArrayLen(reply, count)
+}
+
+// RANDOMKEY
+func randomkeyCommand(c *GodisClient) {
+	for c.db.data.Size() > 0 {
+		// RandomGet在稀疏table中可能返回nil，需重试
+		e := c.db.data.RandomGet()
+		if e == nil {
+			continue
+		}
+		key := e.Key
+		key.IncrRefCount()
+		if findKeyRead(c.db, key) == nil {
+			key.DecrRefCount()
+			continue
+		}
+		c.AddReplyBulk(key.StrVal())
+		key.DecrRefCount()
+		return
+	}
+	c.AddReplyNull()
+}
+
+// DBSIZE
+func dbsizeCommand(c *GodisClient) {
+	c.AddReplyInt(c.db.data.Size())
+}
+
+// 清空db中全部key，返回删除的key数目
+func emptyDb(db *GodisDB) int64 {
+	removed := db.data.Size()
+	db.data = createKeyDict()
+	db.expire = createKeyDict()
+	if db.slotKeys != nil {
+		db.slotKeys = createSlotKeys()
+	}
+	return removed
+}
+
+// FLUSHDB/FLUSHALL [ASYNC|SYNC]
+func parseFlushFlags(c *GodisClient) bool {
+	if len(c.args) > 2 {
+		c.AddReplyError("ERR: syntax error")
+		return false
+	}
+	if len(c.args) == 2 {
+		opt := strings.ToLower(c.args[1].StrVal())
+		if opt != "async" && opt != "sync" {
+			c.AddReplyError("ERR: syntax error")
+			return false
+		}
+	}
+	return true
+}
+
+func flushdbCommand(c *GodisClient) {
+	if !parseFlushFlags(c) {
+		return
+	}
+	server.dirty += emptyDb(c.db)
+	c.AddReplyStatus("OK")
+}
+
+func flushallCommand(c *GodisClient) {
+	if !parseFlushFlags(c) {
+		return
+	}
+	for _, db := range server.dbs {
+		server.dirty += emptyDb(db)
+	}
+	c.AddReplyStatus("OK")
+}
+
+// 解析db编号，失败时回复错误
+func getDbFromArg(c *GodisClient, o *obj.Gobj) (*GodisDB, bool) {
+	id, err := o.IntVal()
+	if err != nil {
+		c.AddReplyError("ERR: invalid DB index")
+		return nil, false
+	}
+	if id < 0 || id >= int64(len(server.dbs)) {
+		c.AddReplyError("ERR: DB index is out of range")
+		return nil, false
+	}
+	if server.cluster != nil && id != 0 {
+		c.AddReplyError("ERR: only DB 0 is available in cluster mode")
+		return nil, false
+	}
+	return server.dbs[id], true
+}
+
+// SELECT index
+func selectCommand(c *GodisClient) {
+	db, ok := getDbFromArg(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.db = db
+	c.AddReplyStatus("OK")
+}
+
+// MOVE key db
+func moveCommand(c *GodisClient) {
+	key := c.args[1]
+	dst, ok := getDbFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	if dst == c.db {
+		c.AddReplyError("ERR: source and destination objects are the same")
+		return
+	}
+	val := findKeyWrite(c.db, key)
+	if val == nil || findKeyWrite(dst, key) != nil {
+		c.AddReplyInt(0)
+		return
+	}
+	when := getExpire(c.db, key)
+	val.IncrRefCount()
+	dbDelete(c.db, key)
+	dbAdd(dst, key, val)
+	val.DecrRefCount()
+	if when != -1 {
+		setExpire(dst, key, when)
+	}
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+// SWAPDB index1 index2
+func swapdbCommand(c *GodisClient) {
+	db1, ok := getDbFromArg(c, c.args[1])
+	if !ok {
+		return
+	}
+	db2, ok := getDbFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	// 只交换数据，阻塞在db上的client保持不变
+	db1.data, db2.data = db2.data, db1.data
+	db1.expire, db2.expire = db2.expire, db1.expire
+	db1.slotKeys, db2.slotKeys = db2.slotKeys, db1.slotKeys
+	scanDatabaseForReadyKeys(db1)
+	scanDatabaseForReadyKeys(db2)
+	server.dirty++
+	c.AddReplyStatus("OK")
+}
+
+// 交换数据后，阻塞的key可能已存在
+func scanDatabaseForReadyKeys(db *GodisDB) {
+	for k := range db.blockingKeys {
+		key := obj.CreateObject(obj.GSTR, k)
+		if val := findKeyRead(db, key); val != nil && val.Type_ == obj.GLIST {
+			signalKeyAsReady(db, key)
+		}
+		key.DecrRefCount()
+	}
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func copyCommand(c *GodisClient) {
+	replace := false
+	dstDb := c.db
+	for i := 3; i < len(c.args); i++ {
+		opt := strings.ToLower(c.args[i].StrVal())
+		if opt == "replace" {
+			replace = true
+		} else if opt == "db" && i+1 < len(c.args) {
+			db, ok := getDbFromArg(c, c.args[i+1])
+			if !ok {
+				return
+			}
+			dstDb = db
+			i++
+		} else {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+	src, dst := c.args[1], c.args[2]
+	if dstDb == c.db && utils.GStrEqual(src, dst) {
+		c.AddReplyError("ERR: source and destination objects are the same")
+		return
+	}
+	val := findKeyRead(c.db, src)
+	if val == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if findKeyWrite(dstDb, dst) != nil {
+		if !replace {
+			c.AddReplyInt(0)
+			return
+		}
+		dbDelete(dstDb, dst)
+	}
+	dup := dupObject(val)
+	dbAdd(dstDb, dst, dup)
+	dup.DecrRefCount()
+	if when := getExpire(c.db, src); when != -1 {
+		setExpire(dstDb, dst, when)
+	}
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+// 解析游标参数，失败时回复错误
+func parseScanCursor(c *GodisClient, o *obj.Gobj) (uint64, bool) {
+	cursor, err := strconv.ParseUint(o.StrVal(), 10, 64)
+	if err != nil {
+		c.AddReplyError("ERR: invalid cursor")
+		return 0, false
+	}
+	return cursor, true
+}
+
+// HSCAN/SSCAN/ZSCAN 获取key，不存在时回复空结果
+func scanLookupRead(c *GodisClient, typ obj.Gtype) *obj.Gobj {
+	o := findKeyRead(c.db, c.args[1])
+	if o == nil {
+		c.AddReplyArrayLen(2)
+		c.AddReplyBulk("0")
+		c.AddReplyArrayLen(0)
+		return nil
+	}
+	if checkType(c, o, typ) {
+		return nil
+	}
+	return o
+}
+
+// SCAN/HSCAN/SSCAN/ZSCAN 通用实现，o 为nil时遍历整个keyspace
+// cursorIdx 为游标参数的位置，其后为可选参数
+func scanGenericCommand(c *GodisClient, o *obj.Gobj, cursor uint64, cursorIdx int) {
+	var count int64 = 10
+	var pattern, typ string
+	for i := cursorIdx + 1; i < len(c.args); i += 2 {
+		if i+1 >= len(c.args) {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		switch strings.ToLower(c.args[i].StrVal()) {
+		case "count":
+			val, ok := getIntFromArg(c, c.args[i+1])
+			if !ok {
+				return
+			}
+			if val < 1 {
+				c.AddReplyError("ERR: syntax error")
+				return
+			}
+			count = val
+		case "match":
+			pattern = c.args[i+1].StrVal()
+		case "type":
+			if o != nil {
+				c.AddReplyError("ERR: syntax error")
+				return
+			}
+			typ = strings.ToLower(c.args[i+1].StrVal())
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+
+	// hash/zset 每个元素带有value
+	var keys, vals []string
+	withVals := o != nil && (o.Type_ == obj.GDICT || o.Type_ == obj.GZSET)
+	var scan func(cursor uint64) uint64
+	switch {
+	case o == nil:
+		scan = func(cursor uint64) uint64 {
+			return c.db.data.Scan(cursor, func(e *dict.Entry) {
+				keys = append(keys, e.Key.StrVal())
+			})
+		}
+	case o.Type_ == obj.GZSET:
+		scan = func(cursor uint64) uint64 {
+			return o.Val_.(*zset.ZSet).Scan(cursor, func(member *obj.Gobj, score float64) {
+				keys = append(keys, member.StrVal())
+				vals = append(vals, zset.FormatScore(score))
+			})
+		}
+	default:
+		scan = func(cursor uint64) uint64 {
+			return o.Val_.(*dict.Dict).Scan(cursor, func(e *dict.Entry) {
+				keys = append(keys, e.Key.StrVal())
+				if withVals {
+					vals = append(vals, e.Val.StrVal())
+				}
+			})
+		}
+	}
+	// 限制单次调用的遍历次数，避免稀疏table下阻塞过久，COUNT很大时避免溢出
+	maxIterations := int64(math.MaxInt64)
+	if count <= math.MaxInt64/10 {
+		maxIterations = count * 10
+	}
+	for {
+		cursor = scan(cursor)
+		maxIterations--
+		if cursor == 0 || int64(len(keys)) >= count || maxIterations <= 0 {
+			break
+		}
+	}
+
+	var items []string
+	for i, key := range keys {
+		if pattern != "" && !utils.StringMatch(pattern, key, false) {
+			continue
+		}
+		if o == nil {
+			val := findKeyRead(c.db, obj.CreateObject(obj.GSTR, key))
+			if val == nil || (typ != "" && typeName(val) != typ) {
+				continue
+			}
+		}
+		items = append(items, key)
+		if withVals {
+			items = append(items, vals[i])
+		}
+	}
+	c.AddReplyArrayLen(2)
+	c.AddReplyBulk(strconv.FormatUint(cursor, 10))
+	c.AddReplyArrayLen(len(items))
+	for _, item := range items {
+		c.AddReplyBulk(item)
+	}
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scanCommand(c *GodisClient) {
+	cursor, ok := parseScanCursor(c, c.args[1])
+	if !ok {
+		return
+	}
+	scanGenericCommand(c, nil, cursor, 1)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/obj"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "mset a 1 b 2 c 3\r\n")
+	ExecQuery(client, "rpush list x y\r\n")
+	ExecQuery(client, "hset hash f v\r\n")
+	assert.Equal(t, ":5\r\n", ExecQuery(client, "dbsize\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "exists a a nope list\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "touch b\r\n"))
+	assert.Equal(t, "+string\r\n", ExecQuery(client, "type a\r\n"))
+	assert.Equal(t, "+list\r\n", ExecQuery(client, "type list\r\n"))
+	assert.Equal(t, "+hash\r\n", ExecQuery(client, "type hash\r\n"))
+	assert.Equal(t, "+none\r\n", ExecQuery(client, "type nope\r\n"))
+	assert.Equal(t, "*1\r\n$4\r\nlist\r\n", ExecQuery(client, "keys l*\r\n"))
+	assert.Equal(t, "*0\r\n", ExecQuery(client, "keys z?\r\n"))
+
+	ExecQuery(client, "expire a 100\r\n")
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "rename a d\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists a\r\n"))
+	assert.NotNil(t, client.db.expire.Find(obj.CreateObject(obj.GSTR, "d")))
+	assert.Equal(t, "-ERR: no such key\r\n", ExecQuery(client, "rename a e\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "renamenx d b\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "renamenx d e\r\n"))
+
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "copy list list2\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "copy list list2\r\n"))
+	ExecQuery(client, "rpush list2 z\r\n")
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "llen list\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "copy hash list2 replace\r\n"))
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(client, "hget list2 f\r\n"))
+	assert.Equal(t, "-ERR: source and destination objects are the same\r\n", ExecQuery(client, "copy b b\r\n"))
+
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "del b c nope\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "unlink e\r\n"))
+	assert.Equal(t, "*3\r\n", ExecQuery(client, "keys *\r\n")[:4])
+	assert.Equal(t, "$", ExecQuery(client, "randomkey\r\n")[:1])
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "flushdb\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "dbsize\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "randomkey\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "flushall now\r\n"))
+}
+
+func TestScanCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	for i := 0; i < 30; i++ {
+		ExecQuery(client, fmt.Sprintf("set key%d v\r\n", i))
+	}
+	ExecQuery(client, "rpush list a\r\n")
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		reply := ExecQuery(client, fmt.Sprintf("scan %v count 5\r\n", cursor))
+		lines := strings.Split(reply, "\r\n")
+		cursor = lines[2]
+		for i := 5; i < len(lines); i += 2 {
+			seen[lines[i]] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, 31, len(seen))
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*1\r\n$4\r\nlist\r\n", ExecQuery(client, "scan 0 count 100 type list\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*1\r\n$5\r\nkey10\r\n", ExecQuery(client, "scan 0 count 100 match key1[0]\r\n"))
+	// COUNT 很大时一次遍历完成
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*1\r\n$5\r\nkey10\r\n", ExecQuery(client, "scan 0 count 9223372036854775807 match key1[0]\r\n"))
+	assert.Equal(t, "-ERR: invalid cursor\r\n", ExecQuery(client, "scan abc\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "scan 0 count\r\n"))
+
+	ExecQuery(client, "hset hash f v\r\n")
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n", ExecQuery(client, "hscan hash 0\r\n"))
+	ExecQuery(client, "sadd set m\r\n")
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nm\r\n", ExecQuery(client, "sscan set 0 match m*\r\n"))
+	ExecQuery(client, "zadd zset 1.5 m\r\n")
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nm\r\n$3\r\n1.5\r\n", ExecQuery(client, "zscan zset 0\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n*0\r\n", ExecQuery(client, "zscan nope 0\r\n"))
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "hscan set 0\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "sscan set 0 type set\r\n"))
+}
+
+func TestMultiDb(t *testing.T) {
+	var config conf.Config
+	config.Databases = 4
+	initServer(&config)
+	c1 := CreateClient(server.fd)
+	c2 := CreateClient(server.fd)
+
+	assert.Equal(t, "-ERR: DB index is out of range\r\n", ExecQuery(c1, "select 4\r\n"))
+	assert.Equal(t, "-ERR: invalid DB index\r\n", ExecQuery(c1, "select a\r\n"))
+	ExecQuery(c1, "set k v0\r\n")
+	assert.Equal(t, "+OK\r\n", ExecQuery(c1, "select 1\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(c1, "get k\r\n"))
+	ExecQuery(c1, "set k v1\r\n")
+	ExecQuery(c1, "set m v1 ex 100\r\n")
+
+	assert.Equal(t, ":0\r\n", ExecQuery(c1, "move k 0\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(c1, "move m 2\r\n"))
+	assert.Equal(t, "-ERR: source and destination objects are the same\r\n", ExecQuery(c1, "move k 1\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(c1, "copy k k db 3\r\n"))
+	info := "# Keyspace\r\ndb0:keys=1,expires=0\r\ndb1:keys=1,expires=0\r\n" +
+		"db2:keys=1,expires=1\r\ndb3:keys=1,expires=0\r\n"
+	assert.Equal(t, fmt.Sprintf("$%d\r\n%v\r\n", len(info), info), ExecQuery(c1, "info keyspace\r\n"))
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(c1, "flushdb\r\n"))
+	assert.Equal(t, "$2\r\nv0\r\n", ExecQuery(c2, "get k\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(c1, "swapdb 0 1\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(c2, "get k\r\n"))
+	assert.Equal(t, "$2\r\nv0\r\n", ExecQuery(c1, "get k\r\n"))
+
+	// 交换后阻塞的key变为可用
+	assert.Equal(t, "", ExecQuery(c2, "blpop list 0\r\n"))
+	ExecQuery(c1, "rpush list a\r\n")
+	assert.Equal(t, "", ExecQuery(c2, ""))
+	ExecQuery(c1, "swapdb 1 0\r\n")
+	assert.Equal(t, "*2\r\n$4\r\nlist\r\n$1\r\na\r\n", ExecQuery(c2, ""))
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(c1, "flushall\r\n"))
+	assert.Equal(t, "$12\r\n# Keyspace\r\n\r\n", ExecQuery(c1, "info keyspace\r\n"))
+}
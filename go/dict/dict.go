@@ -4,20 +4,25 @@ import (
 	"akt-redis/obj"
 	"errors"
 	"math"
+	"math/bits"
 	"math/rand"
+	"time"
 )
 
 const (
 	INIT_SIZE    int64 = 8
-	FORCE_RATIO  int64 = 2 // 强制扩容阈值
-	GROW_RATIO   int64 = 2 // 扩容幅度
+	FORCE_RATIO  int64 = 2  // 强制扩容阈值
+	GROW_RATIO   int64 = 2  // 扩容幅度
+	MIN_FILL     int64 = 10 // 使用率低于该百分比时缩容
 	DEFAULT_STEP int   = 1
+	CRON_STEP    int   = 100 // Rehash 每轮迁移的bucket数
 )
 
 var (
 	EP_ERR = errors.New("expand error")
 	EX_ERR = errors.New("key exists error")
 	NK_ERR = errors.New("key doesnt exist error")
+	FP_ERR = errors.New("dict modified during unsafe iteration")
 )
 
 type Entry struct {
@@ -42,6 +47,21 @@ type Dict struct {
 	DictType
 	hts       [2]*htable
 	rehashidx int64
+	iterators int64 // 正在使用的安全迭代器及 PauseRehash 的数目，大于0时暂停rehash
+}
+
+// 迭代器
+// 安全迭代器遍历期间暂停rehash，允许修改dict；
+// 非安全迭代器不可修改dict，Release 时通过指纹检查dict是否被修改
+type Iterator struct {
+	dict        *Dict
+	table       int
+	index       int64
+	safe        bool
+	started     bool
+	entry       *Entry
+	nextEntry   *Entry
+	fingerprint int64
 }
 
 func DictCreate(distType DictType) *Dict {
@@ -65,7 +85,7 @@ func nextPower(size int64) int64 {
 func (dict *Dict) expand(size int64) error {
 	// 找寻大于等于size的最近2**n
 	num := nextPower(size)
-	if dict.isRehashing() || (dict.hts[0] != nil && dict.hts[0].size >= num) {
+	if dict.isRehashing() || (dict.hts[0] != nil && dict.hts[0].size == num) {
 		return EP_ERR
 	}
 	ht := htable{
@@ -85,6 +105,161 @@ func (dict *Dict) expand(size int64) error {
 	return nil
 }
 
+// 元素总数
+func (dict *Dict) Size() int64 {
+	var size int64
+	for _, ht := range dict.hts {
+		if ht != nil {
+			size += ht.used
+		}
+	}
+	return size
+}
+
+// 遍历所有entry，遍历过程中暂停rehash，可以查找但不可增删dict
+func (dict *Dict) ForEach(fn func(e *Entry)) {
+	it := dict.GetSafeIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		fn(e)
+	}
+	it.Release()
+}
+
+// dict 状态的指纹，非安全迭代期间发生增删或rehash时改变
+func (dict *Dict) fingerprint() int64 {
+	var hash int64 = dict.rehashidx
+	for _, ht := range dict.hts {
+		var size, used int64
+		if ht != nil {
+			size, used = ht.size, ht.used
+		}
+		for _, v := range []int64{size, used} {
+			hash = hash*31 + v
+		}
+	}
+	return hash
+}
+
+func (dict *Dict) GetIterator() *Iterator {
+	return &Iterator{
+		dict:  dict,
+		table: 0,
+		index: -1,
+	}
+}
+
+func (dict *Dict) GetSafeIterator() *Iterator {
+	it := dict.GetIterator()
+	it.safe = true
+	return it
+}
+
+// 返回下一个entry，遍历结束返回nil
+func (it *Iterator) Next() *Entry {
+	for {
+		if it.entry == nil {
+			// 第一次调用
+			if !it.started {
+				it.started = true
+				if it.safe {
+					it.dict.iterators++
+				} else {
+					it.fingerprint = it.dict.fingerprint()
+				}
+			}
+			ht := it.dict.hts[it.table]
+			if ht == nil {
+				return nil
+			}
+			it.index++
+			if it.index >= ht.size {
+				if it.dict.isRehashing() && it.table == 0 {
+					it.table++
+					it.index = 0
+					ht = it.dict.hts[1]
+				} else {
+					return nil
+				}
+			}
+			it.entry = ht.table[it.index]
+		} else {
+			it.entry = it.nextEntry
+		}
+		if it.entry != nil {
+			// 保存next，安全迭代时可删除当前entry
+			it.nextEntry = it.entry.next
+			return it.entry
+		}
+	}
+}
+
+// 释放迭代器，非安全迭代器在dict被修改时返回FP_ERR
+func (it *Iterator) Release() error {
+	if !it.started {
+		return nil
+	}
+	if it.safe {
+		it.dict.iterators--
+		return nil
+	}
+	if it.fingerprint != it.dict.fingerprint() {
+		return FP_ERR
+	}
+	return nil
+}
+
+func scanBucket(ht *htable, idx uint64, fn func(e *Entry)) {
+	for e := ht.table[idx&uint64(ht.mask)]; e != nil; e = e.next {
+		fn(e)
+	}
+}
+
+// 高位加一：cursor反转后加一再反转回来
+func nextCursor(v uint64, mask int64) uint64 {
+	v |= ^uint64(mask)
+	v = bits.Reverse64(v)
+	v++
+	return bits.Reverse64(v)
+}
+
+// 游标遍历，返回下一次的游标，返回0时遍历结束
+// 游标按二进制高位递增，table扩容或缩容前已遍历过的bucket不会再被访问，
+// 因此在两次调用之间发生rehash也能保证遍历开始时存在的元素都会被返回(可能重复)
+func (dict *Dict) Scan(cursor uint64, fn func(e *Entry)) uint64 {
+	if dict.Size() == 0 {
+		return 0
+	}
+	if !dict.isRehashing() {
+		t0 := dict.hts[0]
+		scanBucket(t0, cursor, fn)
+		return nextCursor(cursor, t0.mask)
+	}
+	// rehash 中，先遍历小表的bucket，再遍历大表中由该bucket扩展出的所有bucket
+	t0, t1 := dict.hts[0], dict.hts[1]
+	if t0.size > t1.size {
+		t0, t1 = t1, t0
+	}
+	m0, m1 := uint64(t0.mask), uint64(t1.mask)
+	scanBucket(t0, cursor, fn)
+	for {
+		scanBucket(t1, cursor, fn)
+		cursor = nextCursor(cursor, t1.mask)
+		if cursor&(m0^m1) == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+// 暂停rehash，期间只查找不增删时dict的结构保持不变，可供其他goroutine只读遍历
+func (dict *Dict) PauseRehash() {
+	dict.iterators++
+}
+
+func (dict *Dict) ResumeRehash() {
+	dict.iterators--
+}
+
 func (dict *Dict) isRehashing() bool {
 	return dict.rehashidx != -1
 }
@@ -106,20 +281,30 @@ func (dict *Dict) expandIfNeeded() error {
 	return nil
 }
 
+// 使用率低于 MIN_FILL 时缩容至能容纳全部元素的最小大小
+func (dict *Dict) shrinkIfNeeded() error {
+	if dict.isRehashing() || dict.iterators > 0 || dict.hts[0] == nil {
+		return nil
+	}
+	hashTable := dict.hts[0]
+	if hashTable.size > INIT_SIZE && hashTable.used*100/hashTable.size < MIN_FILL {
+		return dict.expand(hashTable.used)
+	}
+	return nil
+}
+
 func (dict *Dict) rehashStep() {
-	dict.rehash(DEFAULT_STEP)
+	// 存在安全迭代器时暂停rehash，避免entry在table间移动导致重复或遗漏
+	if dict.iterators == 0 {
+		dict.rehash(DEFAULT_STEP)
+	}
 }
 
-func (dict *Dict) rehash(step int) {
+// 迁移最多step个非空bucket，返回迁移的bucket数及是否仍在rehash
+func (dict *Dict) rehash(step int) (int, bool) {
+	moved := 0
 	// 由于单线程，redis将rehash拆分成为小步骤进行rehash，以保证不会因为table过大而引发block线程
-	for step > 0 {
-		// 已经rehash结束时
-		if dict.hts[0].used == 0 {
-			dict.hts[0] = dict.hts[1]
-			dict.hts[1] = nil
-			dict.rehashidx = -1
-			return
-		}
+	for step > 0 && dict.hts[0].used > 0 {
 		// 寻找第一个非空值
 		for dict.hts[0].table[dict.rehashidx] == nil {
 			dict.rehashidx++
@@ -139,12 +324,41 @@ func (dict *Dict) rehash(step int) {
 		dict.hts[0].table[dict.rehashidx] = nil
 		dict.rehashidx += 1
 		step -= 1
+		moved++
+	}
+	// 已经rehash结束时
+	if dict.hts[0].used == 0 {
+		dict.hts[0] = dict.hts[1]
+		dict.hts[1] = nil
+		dict.rehashidx = -1
+		return moved, false
+	}
+	return moved, true
+}
+
+// 在ms毫秒内持续rehash，返回迁移的bucket数，供定时任务调用
+func (dict *Dict) Rehash(ms int64) int {
+	if !dict.isRehashing() || dict.iterators > 0 {
+		return 0
+	}
+	start := time.Now()
+	deadline := time.Duration(ms) * time.Millisecond
+	rehashes := 0
+	for {
+		moved, more := dict.rehash(CRON_STEP)
+		rehashes += moved
+		if !more || time.Since(start) > deadline {
+			return rehashes
+		}
 	}
 }
 
 func freeEntry(e *Entry) {
 	e.Key.DecrRefCount()
-	e.Val.DecrRefCount()
+	// set 只使用key，val为nil
+	if e.Val != nil {
+		e.Val.DecrRefCount()
+	}
 }
 
 func (dict *Dict) Delete(key *obj.Gobj) error {
@@ -167,7 +381,9 @@ func (dict *Dict) Delete(key *obj.Gobj) error {
 				} else {
 					prev.next = e.next
 				}
+				dict.hts[i].used -= 1
 				freeEntry(e)
+				dict.shrinkIfNeeded()
 				return nil
 			}
 			prev = e
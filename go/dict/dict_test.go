@@ -80,3 +80,160 @@ func TestRehash(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf("v%v", i), entry.Val.StrVal())
 	}
 }
+
+func TestForEach(t *testing.T) {
+	dict := DictCreate(DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+	total := int(INIT_SIZE * (FORCE_RATIO + 2))
+	for i := 0; i < total; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		val := obj.CreateObject(obj.GSTR, fmt.Sprintf("v%v", i))
+		dict.Add(key, val)
+	}
+	assert.Equal(t, true, dict.isRehashing())
+	assert.Equal(t, int64(total), dict.Size())
+
+	seen := make(map[string]bool)
+	dict.ForEach(func(e *Entry) {
+		seen[e.Key.StrVal()] = true
+	})
+	assert.Equal(t, total, len(seen))
+
+	dict.Delete(obj.CreateObject(obj.GSTR, "k0"))
+	assert.Equal(t, int64(total-1), dict.Size())
+}
+
+func TestScan(t *testing.T) {
+	dict := DictCreate(DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+	assert.Equal(t, uint64(0), dict.Scan(0, func(e *Entry) {}))
+
+	total := 100
+	for i := 0; i < total; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		dict.Add(key, key)
+	}
+	// 遍历过程中持续插入，触发扩容及rehash
+	seen := make(map[string]bool)
+	var cursor uint64
+	added := total
+	rehashed := false
+	for {
+		cursor = dict.Scan(cursor, func(e *Entry) {
+			seen[e.Key.StrVal()] = true
+		})
+		if cursor == 0 {
+			break
+		}
+		for i := 0; i < 20; i++ {
+			key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", added))
+			dict.Add(key, key)
+			added++
+		}
+		rehashed = rehashed || dict.isRehashing()
+	}
+	assert.True(t, rehashed)
+	for i := 0; i < total; i++ {
+		assert.True(t, seen[fmt.Sprintf("k%v", i)])
+	}
+}
+
+func TestIterator(t *testing.T) {
+	dict := DictCreate(DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+	it := dict.GetIterator()
+	assert.Nil(t, it.Next())
+	assert.Nil(t, it.Release())
+
+	total := 100
+	for i := 0; i < total; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		dict.Add(key, key)
+	}
+	seen := make(map[string]bool)
+	it = dict.GetIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		seen[e.Key.StrVal()] = true
+	}
+	assert.Nil(t, it.Release())
+	assert.Equal(t, total, len(seen))
+
+	// 非安全迭代器期间修改dict
+	it = dict.GetIterator()
+	it.Next()
+	dict.Delete(obj.CreateObject(obj.GSTR, "k0"))
+	assert.Equal(t, FP_ERR, it.Release())
+
+	// 安全迭代器期间暂停rehash，可删除当前entry
+	for i := total; dict.isRehashing() == false; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		dict.Add(key, key)
+	}
+	size := dict.Size()
+	idx := dict.rehashidx
+	count := int64(0)
+	it = dict.GetSafeIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		count++
+		assert.Nil(t, dict.Delete(e.Key))
+		assert.Equal(t, idx, dict.rehashidx)
+	}
+	assert.Nil(t, it.Release())
+	assert.Equal(t, size, count)
+	assert.Equal(t, int64(0), dict.Size())
+	assert.Equal(t, int64(0), dict.iterators)
+}
+
+func TestPauseRehash(t *testing.T) {
+	dict := DictCreate(DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+	for i := 0; dict.isRehashing() == false; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		dict.Add(key, key)
+	}
+	idx := dict.rehashidx
+	dict.PauseRehash()
+	// 查找及定时rehash都不再迁移bucket
+	assert.NotNil(t, dict.Find(obj.CreateObject(obj.GSTR, "k0")))
+	assert.NotNil(t, dict.RandomGet())
+	assert.Equal(t, 0, dict.Rehash(1))
+	assert.Equal(t, idx, dict.rehashidx)
+	dict.ResumeRehash()
+	dict.Find(obj.CreateObject(obj.GSTR, "k0"))
+	assert.NotEqual(t, idx, dict.rehashidx)
+}
+
+func TestShrinkAndRehash(t *testing.T) {
+	dict := DictCreate(DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+	assert.Equal(t, 0, dict.Rehash(1))
+
+	total := 1000
+	for i := 0; i < total; i++ {
+		key := obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))
+		dict.Add(key, key)
+	}
+	// 后台rehash完成全部迁移，返回值为实际迁移的非空bucket数
+	buckets := 0
+	for i := dict.rehashidx; i < dict.hts[0].size; i++ {
+		if dict.hts[0].table[i] != nil {
+			buckets++
+		}
+	}
+	moved := 0
+	for dict.isRehashing() {
+		n := dict.Rehash(1)
+		assert.Greater(t, n, 0)
+		moved += n
+	}
+	assert.Equal(t, buckets, moved)
+	assert.Equal(t, 0, dict.Rehash(1))
+	size := dict.hts[0].size
+
+	// 大量删除后缩容
+	for i := 0; i < total-10; i++ {
+		dict.Delete(obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i)))
+	}
+	dict.Rehash(1)
+	assert.False(t, dict.isRehashing())
+	assert.Less(t, dict.hts[0].size, size)
+	assert.Equal(t, int64(10), dict.Size())
+	for i := total - 10; i < total; i++ {
+		assert.NotNil(t, dict.Find(obj.CreateObject(obj.GSTR, fmt.Sprintf("k%v", i))))
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"akt-redis/utils"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 过期时间统一使用毫秒时间戳，保存在 db.expire 中
+
+const (
+	EXPIRE_NX = 1 << iota
+	EXPIRE_XX
+	EXPIRE_GT
+	EXPIRE_LT
+)
+
+const EXPIRE_CHECK_COUNT int = 100
+
+// 每个db随机取数据判断是否过期
+// 从节点及Raft follower不主动删除，等待主节点或leader传播的DEL
+func activeExpireCycle() {
+	if server.masterHost != "" || (server.raft != nil && server.raft.role != RAFT_LEADER) {
+		return
+	}
+	now := utils.GetMsTime()
+	for _, db := range server.dbs {
+		for i := 0; i < EXPIRE_CHECK_COUNT; i++ {
+			entry := db.expire.RandomGet()
+			if entry == nil {
+				break
+			}
+			if when, _ := entry.Val.IntVal(); when <= now {
+				deleteExpiredKey(db, entry.Key)
+			}
+		}
+	}
+}
+
+// 解析 NX/XX/GT/LT，失败时回复错误
+func parseExpireFlags(c *GodisClient) (int, bool) {
+	flags := 0
+	for _, arg := range c.args[3:] {
+		switch strings.ToLower(arg.StrVal()) {
+		case "nx":
+			flags |= EXPIRE_NX
+		case "xx":
+			flags |= EXPIRE_XX
+		case "gt":
+			flags |= EXPIRE_GT
+		case "lt":
+			flags |= EXPIRE_LT
+		default:
+			c.AddReplyErrorFormat("ERR: unsupported option %v", arg.StrVal())
+			return 0, false
+		}
+	}
+	if flags&EXPIRE_NX != 0 && flags&(EXPIRE_XX|EXPIRE_GT|EXPIRE_LT) != 0 {
+		c.AddReplyError("ERR: NX and XX, GT or LT options at the same time are not compatible")
+		return 0, false
+	}
+	if flags&EXPIRE_GT != 0 && flags&EXPIRE_LT != 0 {
+		c.AddReplyError("ERR: GT and LT options at the same time are not compatible")
+		return 0, false
+	}
+	return flags, true
+}
+
+// EXPIRE/PEXPIRE/EXPIREAT/PEXPIREAT key time [NX|XX|GT|LT]
+// basetime 为相对时间的起点，unit 为时间参数的毫秒倍数
+func expireGenericCommand(c *GodisClient, basetime int64, unit int64) {
+	key := c.args[1]
+	when, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	flags, ok := parseExpireFlags(c)
+	if !ok {
+		return
+	}
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		c.AddReplyErrorFormat("ERR: invalid expire time in '%v' command", c.args[0].StrVal())
+		return
+	}
+	when *= unit
+	if when > math.MaxInt64-basetime {
+		c.AddReplyErrorFormat("ERR: invalid expire time in '%v' command", c.args[0].StrVal())
+		return
+	}
+	when += basetime
+	// 相对时间在重放时会变化，统一以PEXPIREAT传播
+	if basetime != 0 {
+		replaceClientCommandArg(c, 0, "pexpireat")
+		replaceClientCommandArg(c, 2, strconv.FormatInt(when, 10))
+	}
+
+	if findKeyWrite(c.db, key) == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if flags != 0 {
+		cur := getExpire(c.db, key)
+		// 没有过期时间视为无限大
+		if (flags&EXPIRE_NX != 0 && cur != -1) ||
+			(flags&EXPIRE_XX != 0 && cur == -1) ||
+			(flags&EXPIRE_GT != 0 && (cur == -1 || when <= cur)) ||
+			(flags&EXPIRE_LT != 0 && cur != -1 && when >= cur) {
+			c.AddReplyInt(0)
+			return
+		}
+	}
+	// 已过期时直接删除并以DEL传播，加载期间及从节点只设置过期时间
+	if when <= utils.GetMsTime() && !server.loading && server.masterHost == "" {
+		k := key.StrVal()
+		dbDelete(c.db, key)
+		rewriteClientCommandArgs(c, "del", k)
+	} else {
+		setExpire(c.db, key, when)
+	}
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+func expireCommand(c *GodisClient) {
+	expireGenericCommand(c, utils.GetMsTime(), 1000)
+}
+
+func pexpireCommand(c *GodisClient) {
+	expireGenericCommand(c, utils.GetMsTime(), 1)
+}
+
+func expireatCommand(c *GodisClient) {
+	expireGenericCommand(c, 0, 1000)
+}
+
+func pexpireatCommand(c *GodisClient) {
+	expireGenericCommand(c, 0, 1)
+}
+
+// TTL/PTTL/EXPIRETIME/PEXPIRETIME key
+// 不存在时返回-2，没有过期时间时返回-1
+func ttlGenericCommand(c *GodisClient, outputMs bool, outputAbs bool) {
+	if findKeyRead(c.db, c.args[1]) == nil {
+		c.AddReplyInt(-2)
+		return
+	}
+	when := getExpire(c.db, c.args[1])
+	if when == -1 {
+		c.AddReplyInt(-1)
+		return
+	}
+	if !outputAbs {
+		when -= utils.GetMsTime()
+		if when < 0 {
+			when = 0
+		}
+	}
+	if !outputMs {
+		when = (when + 500) / 1000
+	}
+	c.AddReplyInt(when)
+}
+
+func ttlCommand(c *GodisClient) {
+	ttlGenericCommand(c, false, false)
+}
+
+func pttlCommand(c *GodisClient) {
+	ttlGenericCommand(c, true, false)
+}
+
+func expiretimeCommand(c *GodisClient) {
+	ttlGenericCommand(c, false, true)
+}
+
+func pexpiretimeCommand(c *GodisClient) {
+	ttlGenericCommand(c, true, true)
+}
+
+// PERSIST key
+func persistCommand(c *GodisClient) {
+	if findKeyWrite(c.db, c.args[1]) == nil || c.db.expire.Delete(c.args[1]) != nil {
+		c.AddReplyInt(0)
+		return
+	}
+	server.dirty++
+	c.AddReplyInt(1)
+}
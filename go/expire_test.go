@@ -0,0 +1,51 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "expire nope 100\r\n"))
+	assert.Equal(t, ":-2\r\n", ExecQuery(client, "ttl nope\r\n"))
+	ExecQuery(client, "set k v\r\n")
+	assert.Equal(t, ":-1\r\n", ExecQuery(client, "ttl k\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "expire k 100 xx\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "expire k 100 gt\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "expire k 100 nx\r\n"))
+	assert.Equal(t, ":100\r\n", ExecQuery(client, "ttl k\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "expire k 200 lt\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "pexpire k 50000 lt\r\n"))
+	assert.Equal(t, ":50\r\n", ExecQuery(client, "ttl k\r\n"))
+	assert.Equal(t, "-ERR: NX and XX, GT or LT options at the same time are not compatible\r\n", ExecQuery(client, "expire k 1 nx gt\r\n"))
+	assert.Equal(t, "-ERR: GT and LT options at the same time are not compatible\r\n", ExecQuery(client, "expire k 1 gt lt\r\n"))
+
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "pexpireat k 99999999999999\r\n"))
+	assert.Equal(t, ":99999999999999\r\n", ExecQuery(client, "pexpiretime k\r\n"))
+	assert.Equal(t, ":100000000000\r\n", ExecQuery(client, "expiretime k\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "persist k\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "persist k\r\n"))
+	assert.Equal(t, ":-1\r\n", ExecQuery(client, "pttl k\r\n"))
+
+	// 过去的时间直接删除key
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "expireat k 1\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists k\r\n"))
+
+	// 主动过期
+	ExecQuery(client, "set k v\r\n")
+	when := utils.GetMsTime() - 1
+	ExecQuery(client, fmt.Sprintf("pexpireat k %d\r\n", when+1000))
+	setExpire(client.db, obj.CreateObject(obj.GSTR, "k"), when)
+	activeExpireCycle()
+	assert.Equal(t, int64(0), client.db.data.Size())
+	assert.Equal(t, int64(0), client.db.expire.Size())
+}
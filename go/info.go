@@ -0,0 +1,179 @@
+package main
+
+import (
+	"akt-redis/net"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// INFO 中的一个section，gen 返回section内容
+type infoSection struct {
+	name string
+	gen  func(sb *strings.Builder)
+}
+
+var infoSections = []infoSection{
+	{"server", genServerInfo},
+	{"clients", genClientsInfo},
+	{"persistence", genPersistenceInfo},
+	{"replication", genReplicationInfo},
+	{"raft", genRaftInfo},
+	{"cluster", genClusterInfo},
+	{"keyspace", genKeyspaceInfo},
+}
+
+func genServerInfo(sb *strings.Builder) {
+	fmt.Fprintf(sb, "redis_version:%v\r\n", GODIS_VERSION)
+	fmt.Fprintf(sb, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(sb, "tcp_port:%d\r\n", server.port)
+	fmt.Fprintf(sb, "databases:%d\r\n", len(server.dbs))
+}
+
+func genClientsInfo(sb *strings.Builder) {
+	blocked := 0
+	for _, c := range server.clients {
+		if c.blocked {
+			blocked++
+		}
+	}
+	fmt.Fprintf(sb, "connected_clients:%d\r\n", len(server.clients))
+	fmt.Fprintf(sb, "blocked_clients:%d\r\n", blocked)
+}
+
+func genPersistenceInfo(sb *strings.Builder) {
+	fmt.Fprintf(sb, "rdb_changes_since_last_save:%d\r\n", server.dirty)
+	fmt.Fprintf(sb, "rdb_bgsave_in_progress:%d\r\n", boolToInt(server.rdbBgsaveDone != nil))
+	fmt.Fprintf(sb, "rdb_last_save_time:%d\r\n", server.lastSave)
+	fmt.Fprintf(sb, "rdb_last_bgsave_status:%v\r\n", statusString(server.lastBgsaveOk))
+	fmt.Fprintf(sb, "aof_enabled:%d\r\n", boolToInt(server.aofEnabled))
+	fmt.Fprintf(sb, "aof_rewrite_in_progress:%d\r\n", boolToInt(server.aofRewriteDone != nil))
+	fmt.Fprintf(sb, "aof_last_bgrewrite_status:%v\r\n", statusString(server.aofLastRewriteOk))
+	fmt.Fprintf(sb, "aof_last_write_status:%v\r\n", statusString(server.aofLastWriteOk))
+}
+
+func genReplicationInfo(sb *strings.Builder) {
+	if server.masterHost == "" {
+		sb.WriteString("role:master\r\n")
+	} else {
+		sb.WriteString("role:slave\r\n")
+		fmt.Fprintf(sb, "master_host:%v\r\n", server.masterHost)
+		fmt.Fprintf(sb, "master_port:%d\r\n", server.masterPort)
+		linkStatus := "down"
+		if server.replState == REPL_STATE_CONNECTED {
+			linkStatus = "up"
+		}
+		fmt.Fprintf(sb, "master_link_status:%v\r\n", linkStatus)
+		fmt.Fprintf(sb, "master_sync_in_progress:%d\r\n", boolToInt(server.replState == REPL_STATE_TRANSFER))
+		fmt.Fprintf(sb, "slave_read_only:%d\r\n", boolToInt(server.replicaReadOnly))
+		fmt.Fprintf(sb, "slave_repl_offset:%d\r\n", server.replOffset)
+	}
+	fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(server.replicas))
+	for i, r := range server.replicas {
+		state := "wait_bgsave"
+		if r.repl.state == REPLICA_STATE_ONLINE {
+			state = "online"
+		}
+		fmt.Fprintf(sb, "slave%d:ip=%v,port=%d,state=%v,offset=%d\r\n", i, net.PeerIp(r.fd), r.repl.listeningPort, state, r.repl.ackOffset)
+	}
+	fmt.Fprintf(sb, "master_replid:%v\r\n", server.replId)
+	fmt.Fprintf(sb, "master_repl_offset:%d\r\n", server.replOffset)
+	backlogActive := server.replBacklog != nil
+	fmt.Fprintf(sb, "repl_backlog_active:%d\r\n", boolToInt(backlogActive))
+	fmt.Fprintf(sb, "repl_backlog_size:%d\r\n", server.replBacklogSize)
+	if backlogActive {
+		fmt.Fprintf(sb, "repl_backlog_histlen:%d\r\n", server.replBacklog.histlen)
+	}
+}
+
+func genRaftInfo(sb *strings.Builder) {
+	r := server.raft
+	fmt.Fprintf(sb, "raft_enabled:%d\r\n", boolToInt(r != nil))
+	if r == nil {
+		return
+	}
+	role := "follower"
+	switch r.role {
+	case RAFT_CANDIDATE:
+		role = "candidate"
+	case RAFT_LEADER:
+		role = "leader"
+	}
+	fmt.Fprintf(sb, "raft_role:%v\r\n", role)
+	fmt.Fprintf(sb, "raft_node:%v\r\n", r.self)
+	fmt.Fprintf(sb, "raft_leader:%v\r\n", r.leader)
+	fmt.Fprintf(sb, "raft_term:%d\r\n", r.term)
+	fmt.Fprintf(sb, "raft_members:%v\r\n", strings.Join(r.members, ","))
+	fmt.Fprintf(sb, "raft_last_index:%d\r\n", raftLastIndex())
+	fmt.Fprintf(sb, "raft_commit_index:%d\r\n", r.commitIndex)
+	fmt.Fprintf(sb, "raft_last_applied:%d\r\n", r.lastApplied)
+	fmt.Fprintf(sb, "raft_snapshot_index:%d\r\n", r.snapIndex)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func statusString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+// 只输出非空的db
+func genKeyspaceInfo(sb *strings.Builder) {
+	for _, db := range server.dbs {
+		keys := db.data.Size()
+		if keys == 0 {
+			continue
+		}
+		fmt.Fprintf(sb, "db%d:keys=%d,expires=%d\r\n", db.id, keys, db.expire.Size())
+	}
+}
+
+// section 为 all/default 时输出全部section
+func genInfoString(section string) string {
+	all := section == "all" || section == "default" || section == "everything"
+	var sb strings.Builder
+	for _, s := range infoSections {
+		if !all && s.name != section {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		fmt.Fprintf(&sb, "# %v\r\n", strings.ToUpper(s.name[:1])+s.name[1:])
+		s.gen(&sb)
+	}
+	return sb.String()
+}
+
+// INFO [section]
+func infoCommand(c *GodisClient) {
+	if len(c.args) > 2 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	section := "default"
+	if len(c.args) == 2 {
+		section = strings.ToLower(c.args[1].StrVal())
+	}
+	c.AddReplyVerbatim(genInfoString(section), "txt")
+}
+
+// PING [message]
+func pingCommand(c *GodisClient) {
+	if len(c.args) > 2 {
+		c.AddReplyError("ERR: wrong number of args")
+		return
+	}
+	if len(c.args) == 2 {
+		c.AddReplyBulk(c.args[1].StrVal())
+		return
+	}
+	c.AddReplyStatus("PONG")
+}
@@ -50,6 +50,10 @@ func (list *List) DelNode(n *Node) {
 		if n.next != nil {
 			n.next.prev = nil
 		}
+		// 唯一节点时同时清空尾节点
+		if n == list.Tail {
+			list.Tail = nil
+		}
 		list.Head = n.next
 		n.next = nil
 	} else if n == list.Tail {
@@ -114,3 +118,63 @@ func (list *List) LPush(val *obj.Gobj) {
 	list.Head = &node
 	list.length += 1
 }
+
+func (n *Node) Next() *Node {
+	return n.next
+}
+
+func (n *Node) Prev() *Node {
+	return n.prev
+}
+
+// 获取下标对应节点，负数从尾部开始计算，-1为最后一个
+func (list *List) Index(index int) *Node {
+	var n *Node
+	if index < 0 {
+		index = -index - 1
+		n = list.Tail
+		for n != nil && index > 0 {
+			n = n.prev
+			index--
+		}
+	} else {
+		n = list.Head
+		for n != nil && index > 0 {
+			n = n.next
+			index--
+		}
+	}
+	return n
+}
+
+// 在n之前插入
+func (list *List) InsertBefore(n *Node, val *obj.Gobj) {
+	if n == list.Head {
+		list.LPush(val)
+		return
+	}
+	node := Node{
+		Val:  val,
+		next: n,
+		prev: n.prev,
+	}
+	n.prev.next = &node
+	n.prev = &node
+	list.length += 1
+}
+
+// 在n之后插入
+func (list *List) InsertAfter(n *Node, val *obj.Gobj) {
+	if n == list.Tail {
+		list.Append(val)
+		return
+	}
+	node := Node{
+		Val:  val,
+		next: n.next,
+		prev: n,
+	}
+	n.next.prev = &node
+	n.next = &node
+	list.length += 1
+}
@@ -3,6 +3,7 @@ package list
 import (
 	"akt-redis/obj"
 	"akt-redis/utils"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,3 +46,29 @@ func TestList(t *testing.T) {
 	assert.Equal(t, list.Length(), 2)
 	assert.Equal(t, list.Last().Val.Val_.(string), "2")
 }
+
+func TestListIndexInsert(t *testing.T) {
+	list := ListCreate(ListType{EqualFunc: utils.GStrEqual})
+	assert.Nil(t, list.Index(0))
+
+	list.Append(obj.CreateObject(obj.GSTR, "1"))
+	list.Append(obj.CreateObject(obj.GSTR, "3"))
+	list.InsertAfter(list.First(), obj.CreateObject(obj.GSTR, "2"))
+	list.InsertBefore(list.First(), obj.CreateObject(obj.GSTR, "0"))
+	list.InsertAfter(list.Last(), obj.CreateObject(obj.GSTR, "4"))
+	assert.Equal(t, 5, list.Length())
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("%v", i), list.Index(i).Val.StrVal())
+		assert.Equal(t, fmt.Sprintf("%v", i), list.Index(i-5).Val.StrVal())
+	}
+	assert.Nil(t, list.Index(5))
+	assert.Nil(t, list.Index(-6))
+	assert.Equal(t, "1", list.Index(2).Prev().Val.StrVal())
+	assert.Equal(t, "3", list.Index(2).Next().Val.StrVal())
+
+	for list.Length() > 0 {
+		list.DelNode(list.First())
+	}
+	assert.Nil(t, list.First())
+	assert.Nil(t, list.Last())
+}
@@ -7,11 +7,8 @@ import (
 	"akt-redis/list"
 	"akt-redis/net"
 	"akt-redis/obj"
+	"akt-redis/resp"
 	"akt-redis/utils"
-	"errors"
-	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"log"
@@ -19,60 +16,284 @@ import (
 )
 
 type GodisDB struct {
-	data   *dict.Dict
-	expire *dict.Dict
+	id           int
+	data         *dict.Dict
+	expire       *dict.Dict
+	blockingKeys map[string][]*GodisClient // 阻塞在key上的client，按阻塞顺序排列
+	readyKeys    map[string]bool           // 已加入 server.readyKeys 的key，用于去重
+	slotKeys     []map[string]*obj.Gobj    // 集群模式下 slot -> key，否则为nil
 }
 
 type GodisServer struct {
 	fd      int
 	port    int
-	db      *GodisDB
+	dbs     []*GodisDB
 	clients map[int]*GodisClient
 	aeLoop  *ae.AeLoop
+	// 本次命令中变为可用的阻塞key
+	readyKeys []readyKey
+	// 已解除阻塞，等待继续处理后续命令的client
+	unblockedClients []*GodisClient
+	// 快照持久化
+	dir               string
+	dbFilename        string
+	saveParams        []saveParam
+	dirty             int64 // 上次保存后的修改次数
+	dirtyBeforeBgsave int64
+	lastSave          int64 // 上次成功保存的时间，unix秒
+	lastBgsaveTry     int64
+	lastBgsaveOk      bool
+	rdbSnapshot       []dbSnapshot
+	rdbBgsaveDone     chan error // 进行中的BGSAVE，nil表示没有
+	// AOF持久化
+	aofEnabled         bool
+	aofFilename        string
+	aofFsync           int
+	aofLoadTruncated   bool
+	loading            bool // 正在加载AOF或应用Raft日志，期间不删除过期key
+	aofFile            *os.File
+	aofBuf             []byte // 等待写入文件的命令
+	aofSelectedDb      int    // AOF中最后一次SELECT的db，-1表示需要重新SELECT
+	aofLastFsync       int64  // 毫秒
+	aofLastWriteOk     bool
+	aofFsyncDone       chan error // 进行中的后台fsync
+	aofRewriteBuf      []byte     // 重写期间的写命令
+	aofRewriteSnapshot []dbSnapshot
+	aofRewriteDone     chan error // 进行中的BGREWRITEAOF，nil表示没有
+	aofLastRewriteOk   bool
+	// 主从复制
+	replId             string // 复制ID，从节点使用主节点的ID
+	replOffset         int64  // 主节点为写入复制流的字节数，从节点为已执行的字节数
+	replBacklog        *replBacklog
+	replBacklogSize    int
+	replSelectedDb     int // 复制流中最后一次SELECT的db，-1表示需要重新SELECT
+	replicas           []*GodisClient
+	replicaReadOnly    bool
+	masterHost         string // 为空表示不是从节点
+	masterPort         int
+	masterAuth         string
+	master             *GodisClient
+	replState          int
+	replLastConnectTry int64 // 毫秒
+	replLastAck        int64 // 毫秒
+	replCachedDb       int   // 与主节点断开时master client的db，续传时恢复
+	replTransferFd     int   // 握手阶段与主节点的连接
+	replTransferBuf    []byte
+	replTransferSize   int // 快照长度，-1表示未读取
+	replTransferId     string
+	replTransferOffset int64
+	// Raft强一致模式，nil表示未开启
+	raft *raftState
+	// 集群模式，nil表示未开启
+	cluster *clusterState
+	// sentinel模式，nil表示未开启
+	sentinel *sentinelState
+	// 客户端
+	currentClient *GodisClient // 正在执行命令的client
+	nextClientId  int64
+	requirePass   string // 为空表示不需要认证
 }
 
 type GodisClient struct {
-	fd       int
-	db       *GodisDB
-	args     []*obj.Gobj
-	reply    *list.List
-	queryBuf []byte
-	queryLen int // client.queryLen: 未处理的长度
-	cmdType  utils.CmdType
-	bulkNum  int
-	bulkLen  int
-	sentLen  int
+	fd      int
+	db      *GodisDB
+	args    []*obj.Gobj
+	reply   *list.List
+	query   *resp.Reader // 未处理的数据，命令不完整时保留解析进度
+	cmdType utils.CmdType
+	sentLen int
+	blocked bool
+	bpop    blockingState
+	// 为true时当前命令不写入AOF
+	preventPropagate bool
+	flags            int
+	repl             replicaState
+	readReplOffset   int64 // master client 已读取的复制偏移量
+	// Raft模式下等待日志提交的回复
+	raftReplies   []*obj.Gobj
+	raftWaitIndex int64
+	raftWaiting   bool
+	// HELLO 协商的协议版本，2或3
+	resp          int
+	id            int64
+	name          string
+	authenticated bool
 }
 
 type CommandProc func(client *GodisClient)
 
+// 命令flags
+const (
+	CMD_WRITE    = 1 << iota // 可能修改数据
+	CMD_READONLY             // 只读取数据
+)
+
+// client flags
+const (
+	CLIENT_MASTER  = 1 << iota // 从节点中与主节点的连接
+	CLIENT_REPLICA             // 主节点中与从节点的连接
+	CLIENT_ASKING              // 下一条命令可以访问正在导入的slot
+)
+
 type GodisCommand struct {
 	name  string
 	proc  CommandProc
-	arity int // 参数个数
-}
-
-func (client *GodisClient) findLineInQuery() (int, error) {
-	index := strings.Index(string(client.queryBuf[:client.queryLen]), "\r\n")
-	if index < 0 && client.queryLen > utils.GODIS_MAX_INLINE {
-		return index, errors.New("too big inline cmd")
-	}
-	return index, nil
-}
-
-func (client *GodisClient) getNumInQuery(s, e int) (int, error) {
-	num, err := strconv.Atoi(string(client.queryBuf[s:e]))
-	client.queryBuf = client.queryBuf[e+2:]
-	client.queryLen -= e + 2
-	return num, err
+	arity int // 参数个数，负数表示至少需要 -arity 个参数
+	flags int
 }
 
 var server GodisServer
 
+const DEFAULT_DBNUM int = 16
+
 var cmdTable []GodisCommand = []GodisCommand{
-	{"get", getCommand, 2},
-	{"set", setCommand, 3},
-	{"expire", expireCommand, 3},
+	{"get", getCommand, 2, CMD_READONLY},
+	{"set", setCommand, -3, CMD_WRITE},
+	// expire
+	{"expire", expireCommand, -3, CMD_WRITE},
+	{"pexpire", pexpireCommand, -3, CMD_WRITE},
+	{"expireat", expireatCommand, -3, CMD_WRITE},
+	{"pexpireat", pexpireatCommand, -3, CMD_WRITE},
+	{"ttl", ttlCommand, 2, CMD_READONLY},
+	{"pttl", pttlCommand, 2, CMD_READONLY},
+	{"expiretime", expiretimeCommand, 2, CMD_READONLY},
+	{"pexpiretime", pexpiretimeCommand, 2, CMD_READONLY},
+	{"persist", persistCommand, 2, CMD_WRITE},
+	// keyspace
+	{"del", delCommand, -2, CMD_WRITE},
+	{"unlink", delCommand, -2, CMD_WRITE},
+	{"exists", existsCommand, -2, CMD_READONLY},
+	{"touch", existsCommand, -2, CMD_READONLY},
+	{"type", typeCommand, 2, CMD_READONLY},
+	{"rename", renameCommand, 3, CMD_WRITE},
+	{"renamenx", renamenxCommand, 3, CMD_WRITE},
+	{"keys", keysCommand, 2, CMD_READONLY},
+	{"randomkey", randomkeyCommand, 1, CMD_READONLY},
+	{"dbsize", dbsizeCommand, 1, CMD_READONLY},
+	{"flushdb", flushdbCommand, -1, CMD_WRITE},
+	{"flushall", flushallCommand, -1, CMD_WRITE},
+	{"copy", copyCommand, -3, CMD_WRITE},
+	{"scan", scanCommand, -2, CMD_READONLY},
+	{"select", selectCommand, 2, 0},
+	{"move", moveCommand, 3, CMD_WRITE},
+	{"swapdb", swapdbCommand, 3, CMD_WRITE},
+	{"info", infoCommand, -1, 0},
+	{"ping", pingCommand, -1, 0},
+	// connection
+	{"hello", helloCommand, -1, 0},
+	{"auth", authCommand, -2, 0},
+	{"client", clientCommand, -2, 0},
+	// persistence
+	{"save", saveCommand, 1, 0},
+	{"bgsave", bgsaveCommand, 1, 0},
+	{"lastsave", lastsaveCommand, 1, 0},
+	{"bgrewriteaof", bgrewriteaofCommand, 1, 0},
+	// replication
+	{"replicaof", replicaofCommand, 3, 0},
+	{"slaveof", replicaofCommand, 3, 0},
+	{"psync", psyncCommand, 3, 0},
+	{"replconf", replconfCommand, -3, 0},
+	// raft
+	{"raft.requestvote", raftRequestVoteCommand, 5, 0},
+	{"raft.appendentries", raftAppendEntriesCommand, -6, 0},
+	{"raft.installsnapshot", raftInstallSnapshotCommand, -6, 0},
+	{"raft.node", raftNodeCommand, 3, 0},
+	// cluster
+	{"cluster", clusterCommand, -2, 0},
+	{"cluster.ping", clusterPingCommand, -8, 0},
+	{"asking", askingCommand, 1, 0},
+	{"dump", dumpCommand, 2, CMD_READONLY},
+	{"restore", restoreCommand, -4, CMD_WRITE},
+	{"restore-asking", restoreCommand, -4, CMD_WRITE},
+	{"migrate", migrateCommand, -6, CMD_WRITE},
+	// string
+	{"setnx", setnxCommand, 3, CMD_WRITE},
+	{"getset", getsetCommand, 3, CMD_WRITE},
+	{"getdel", getdelCommand, 2, CMD_WRITE},
+	{"getex", getexCommand, -2, CMD_WRITE},
+	{"mget", mgetCommand, -2, CMD_READONLY},
+	{"mset", msetCommand, -3, CMD_WRITE},
+	{"msetnx", msetnxCommand, -3, CMD_WRITE},
+	{"incr", incrCommand, 2, CMD_WRITE},
+	{"decr", decrCommand, 2, CMD_WRITE},
+	{"incrby", incrbyCommand, 3, CMD_WRITE},
+	{"decrby", decrbyCommand, 3, CMD_WRITE},
+	{"incrbyfloat", incrbyfloatCommand, 3, CMD_WRITE},
+	{"append", appendCommand, 3, CMD_WRITE},
+	{"strlen", strlenCommand, 2, CMD_READONLY},
+	{"getrange", getrangeCommand, 4, CMD_READONLY},
+	{"setrange", setrangeCommand, 4, CMD_WRITE},
+	{"lcs", lcsCommand, -3, CMD_READONLY},
+	// list
+	{"lpush", lpushCommand, -3, CMD_WRITE},
+	{"rpush", rpushCommand, -3, CMD_WRITE},
+	{"lpop", lpopCommand, -2, CMD_WRITE},
+	{"rpop", rpopCommand, -2, CMD_WRITE},
+	{"llen", llenCommand, 2, CMD_READONLY},
+	{"lrange", lrangeCommand, 4, CMD_READONLY},
+	{"lindex", lindexCommand, 3, CMD_READONLY},
+	{"lset", lsetCommand, 4, CMD_WRITE},
+	{"lrem", lremCommand, 4, CMD_WRITE},
+	{"linsert", linsertCommand, 5, CMD_WRITE},
+	{"ltrim", ltrimCommand, 4, CMD_WRITE},
+	{"lpos", lposCommand, -3, CMD_READONLY},
+	{"lmove", lmoveCommand, 5, CMD_WRITE},
+	{"blpop", blpopCommand, -3, CMD_WRITE},
+	{"brpop", brpopCommand, -3, CMD_WRITE},
+	{"blmove", blmoveCommand, 6, CMD_WRITE},
+	// hash
+	{"hset", hsetCommand, -4, CMD_WRITE},
+	{"hsetnx", hsetnxCommand, 4, CMD_WRITE},
+	{"hget", hgetCommand, 3, CMD_READONLY},
+	{"hmget", hmgetCommand, -3, CMD_READONLY},
+	{"hdel", hdelCommand, -3, CMD_WRITE},
+	{"hexists", hexistsCommand, 3, CMD_READONLY},
+	{"hlen", hlenCommand, 2, CMD_READONLY},
+	{"hstrlen", hstrlenCommand, 3, CMD_READONLY},
+	{"hkeys", hkeysCommand, 2, CMD_READONLY},
+	{"hvals", hvalsCommand, 2, CMD_READONLY},
+	{"hgetall", hgetallCommand, 2, CMD_READONLY},
+	{"hincrby", hincrbyCommand, 4, CMD_WRITE},
+	{"hincrbyfloat", hincrbyfloatCommand, 4, CMD_WRITE},
+	{"hscan", hscanCommand, -3, CMD_READONLY},
+	// set
+	{"sadd", saddCommand, -3, CMD_WRITE},
+	{"srem", sremCommand, -3, CMD_WRITE},
+	{"sismember", sismemberCommand, 3, CMD_READONLY},
+	{"smismember", smismemberCommand, -3, CMD_READONLY},
+	{"smembers", smembersCommand, 2, CMD_READONLY},
+	{"scard", scardCommand, 2, CMD_READONLY},
+	{"spop", spopCommand, -2, CMD_WRITE},
+	{"srandmember", srandmemberCommand, -2, CMD_READONLY},
+	{"smove", smoveCommand, 4, CMD_WRITE},
+	{"sinter", sinterCommand, -2, CMD_READONLY},
+	{"sinterstore", sinterstoreCommand, -3, CMD_WRITE},
+	{"sunion", sunionCommand, -2, CMD_READONLY},
+	{"sunionstore", sunionstoreCommand, -3, CMD_WRITE},
+	{"sdiff", sdiffCommand, -2, CMD_READONLY},
+	{"sdiffstore", sdiffstoreCommand, -3, CMD_WRITE},
+	{"sscan", sscanCommand, -3, CMD_READONLY},
+	// zset
+	{"zadd", zaddCommand, -4, CMD_WRITE},
+	{"zincrby", zincrbyCommand, 4, CMD_WRITE},
+	{"zrem", zremCommand, -3, CMD_WRITE},
+	{"zscore", zscoreCommand, 3, CMD_READONLY},
+	{"zcard", zcardCommand, 2, CMD_READONLY},
+	{"zrank", zrankCommand, 3, CMD_READONLY},
+	{"zrevrank", zrevrankCommand, 3, CMD_READONLY},
+	{"zrange", zrangeCommand, -4, CMD_READONLY},
+	{"zcount", zcountCommand, 4, CMD_READONLY},
+	{"zremrangebyscore", zremrangebyscoreCommand, 4, CMD_WRITE},
+	{"zremrangebyrank", zremrangebyrankCommand, 4, CMD_WRITE},
+	{"zpopmin", zpopminCommand, -2, CMD_WRITE},
+	{"zpopmax", zpopmaxCommand, -2, CMD_WRITE},
+	{"zunion", zunionCommand, -3, CMD_READONLY},
+	{"zunionstore", zunionstoreCommand, -4, CMD_WRITE},
+	{"zinter", zinterCommand, -3, CMD_READONLY},
+	{"zinterstore", zinterstoreCommand, -4, CMD_WRITE},
+	{"zdiff", zdiffCommand, -3, CMD_READONLY},
+	{"zdiffstore", zdiffstoreCommand, -4, CMD_WRITE},
+	{"zscan", zscanCommand, -3, CMD_READONLY},
 }
 
 // resp 协议返回值，返回给client
@@ -83,84 +304,150 @@ var cmdTable []GodisCommand = []GodisCommand{
 // $: string，"$4\r\ntest\r\n"后接数字，告诉string长度，\r\n后为string文本，可以包含换行符之类的字符
 // *: array 后接数字，表示数组长度
 
-func expireIfNeeded(key *obj.Gobj) {
-	entry := server.db.expire.Find(key)
+// 返回key是否已过期，过期的key对调用者不可见
+// 加载期间不删除过期key：AOF中的命令执行时key都未过期，过期的删除已记录为DEL
+// 从节点不删除过期key，只对普通client隐藏，等待主节点传播的DEL；主节点的命令流仍可访问这些key
+func expireIfNeeded(db *GodisDB, key *obj.Gobj) bool {
+	if server.loading {
+		return false
+	}
+	entry := db.expire.Find(key)
 	if entry == nil {
-		return
+		return false
 	}
-	when := entry.Val.IntVal()
+	when, _ := entry.Val.IntVal()
 	if when > utils.GetMsTime() {
-		return
+		return false
+	}
+	if server.masterHost != "" {
+		return server.currentClient == nil || server.currentClient.flags&CLIENT_MASTER == 0
 	}
-	server.db.expire.Delete(key)
-	server.db.data.Delete(key)
+	// Raft follower 等待leader日志中的DEL
+	if server.raft != nil && server.raft.role != RAFT_LEADER {
+		return true
+	}
+	deleteExpiredKey(db, key)
+	return true
 }
 
-func findKeyRead(key *obj.Gobj) *obj.Gobj {
-	expireIfNeeded(key)
-	return server.db.data.Get(key)
+// 删除过期key并传播DEL，AOF及从节点不依赖各自的时钟判断过期
+func deleteExpiredKey(db *GodisDB, key *obj.Gobj) {
+	key.IncrRefCount()
+	dbDelete(db, key)
+	propagate(db, []string{"del", key.StrVal()})
+	key.DecrRefCount()
 }
 
-func getCommand(c *GodisClient) {
-	key := c.args[1]
-	val := findKeyRead(key)
-	if val == nil {
-		c.AddReplyStr("$-1\r\n")
-	} else if val.Type_ != obj.GSTR {
-		c.AddReplyStr("-ERR: wrong type\r\n")
+func findKeyRead(db *GodisDB, key *obj.Gobj) *obj.Gobj {
+	if expireIfNeeded(db, key) {
+		return nil
+	}
+	return db.data.Get(key)
+}
+
+// 返回的value可以直接修改，修改value的命令必须通过此函数查找
+func findKeyWrite(db *GodisDB, key *obj.Gobj) *obj.Gobj {
+	if expireIfNeeded(db, key) {
+		return nil
+	}
+	o := db.data.Get(key)
+	if o != nil && o.Type_ != obj.GSTR && o.RefCount > 1 {
+		o = dbUnshareObject(db, key, o)
+	}
+	return o
+}
+
+// 添加新key，从节点上可能替换对client隐藏的过期key，同时清除其过期时间
+func dbAdd(db *GodisDB, key *obj.Gobj, val *obj.Gobj) {
+	setKey(db, key, val)
+	if val.Type_ == obj.GLIST {
+		signalKeyAsReady(db, key)
+	}
+}
+
+// 设置key的值并清除过期时间
+func setKey(db *GodisDB, key *obj.Gobj, val *obj.Gobj) {
+	if err := db.data.Add(key, val); err != nil {
+		db.data.Set(key, val)
 	} else {
-		str := val.StrVal()
-		c.AddReplyStr(fmt.Sprintf("$%d%v\r\n", len(str), str))
+		slotToKeyAdd(db, key)
 	}
+	db.expire.Delete(key)
 }
 
-func setCommand(client *GodisClient) {
-	key := client.args[1]
-	val := client.args[2]
+// 设置过期时间，when 为毫秒时间戳
+func setExpire(db *GodisDB, key *obj.Gobj, when int64) {
+	expObj := obj.CreateFromInt(when)
+	db.expire.Set(key, expObj)
+	expObj.DecrRefCount()
+}
 
-	if val.Type_ != obj.GSTR {
-		client.AddReplyStr("-ERR: wrong type\r\n")
+// 删除key及其过期时间
+func dbDelete(db *GodisDB, key *obj.Gobj) bool {
+	db.expire.Delete(key)
+	if db.data.Delete(key) != nil {
+		return false
 	}
+	slotToKeyDel(db, key)
+	return true
+}
 
-	server.db.data.Set(key, val)
-	server.db.expire.Delete(key)
-	client.AddReplyStr("+OK\r\n")
+// 类型不符时返回错误，返回true表示已回复
+func checkType(c *GodisClient, o *obj.Gobj, typ obj.Gtype) bool {
+	if o.Type_ != typ {
+		c.AddReplyError("ERR: wrong type")
+		return true
+	}
+	return false
 }
 
-func expireCommand(c *GodisClient) {
-	key := c.args[1]
-	val := c.args[2]
-	if val.Type_ != obj.GSTR {
-		c.AddReplyStr("-ERR: wrong type\r\n")
+// 解析整数参数，失败时回复错误
+func getIntFromArg(c *GodisClient, o *obj.Gobj) (int64, bool) {
+	val, err := o.IntVal()
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return 0, false
 	}
-	expire := utils.GetMsTime() + (val.IntVal() * 1000)
-	expObj := obj.CreateFromInt(expire)
-	server.db.expire.Set(key, expObj)
-	expObj.DecrRefCount()
-	c.AddReplyStr("+OK\r\n")
+	return val, true
+}
+
+// 解析浮点数参数，失败时回复错误
+func getFloatFromArg(c *GodisClient, o *obj.Gobj) (float64, bool) {
+	val, err := o.FloatVal()
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return 0, false
+	}
+	return val, true
 }
 
 func CreateClient(fd int) *GodisClient {
 	var client GodisClient
 	client.fd = fd
-	client.db = server.db
-	client.queryBuf = make([]byte, utils.GODIS_IO_BUF)
+	client.db = server.dbs[0]
+	client.query = resp.NewReader(net.FdReader(fd))
+	client.query.MaxInline = utils.GODIS_MAX_INLINE
+	client.query.MaxBulk = utils.GODIS_MAX_BULK
 	client.reply = list.ListCreate(list.ListType{EqualFunc: utils.GStrEqual})
+	client.resp = 2
+	server.nextClientId++
+	client.id = server.nextClientId
 	return &client
 }
 
 func resetClient(client *GodisClient) {
 	freeArgs(client)
 	client.cmdType = utils.COMMAND_UNKNOWN
-	client.bulkLen = 0
-	client.bulkNum = 0
+	client.preventPropagate = false
 }
 
 // 释放client.args中的gobj
+// 释放后置为nil，之后 freeClient 再次调用时不会重复释放
 func freeArgs(client *GodisClient) {
 	for _, v := range client.args {
 		v.DecrRefCount()
 	}
+	client.args = nil
 }
 
 func freeReplyList(client *GodisClient) {
@@ -173,7 +460,18 @@ func freeReplyList(client *GodisClient) {
 
 // 释放client
 func freeClient(client *GodisClient) {
+	if client.blocked {
+		unblockClient(client)
+	}
+	removeUnblockedClient(client)
+	if client.flags&CLIENT_REPLICA != 0 {
+		removeReplica(client)
+	}
+	if client.flags&CLIENT_MASTER != 0 {
+		replicationHandleMasterDisconnection(client)
+	}
 	freeArgs(client)
+	freeRaftReplies(client)
 	// 从map表中删除
 	delete(server.clients, client.fd)
 	server.aeLoop.RemoveFileEvent(client.fd, ae.AE_READABLE)
@@ -199,14 +497,14 @@ func SendReplyToClient(loop *ae.AeLoop, fd int, extra interface{}) {
 			}
 			client.sentLen += n
 			log.Printf("send %v bytes to client:%v\n", n, client.fd)
-			if client.sentLen == bufLen {
-				client.reply.DelNode(rep)
-				rep.Val.DecrRefCount()
-				client.sentLen = 0
-			} else {
-				break
-			}
 		}
+		if client.sentLen < bufLen {
+			break
+		}
+		// 长度为0的回复直接移除
+		client.reply.DelNode(rep)
+		rep.Val.DecrRefCount()
+		client.sentLen = 0
 	}
 	if client.reply.Length() == 0 {
 		client.sentLen = 0
@@ -214,18 +512,6 @@ func SendReplyToClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	}
 }
 
-func (c *GodisClient) AddReply(o *obj.Gobj) {
-	c.reply.Append(o)
-	o.IncrRefCount()
-	server.aeLoop.AddFileEvent(c.fd, ae.AE_WRITABLE, SendReplyToClient, c)
-}
-
-func (c *GodisClient) AddReplyStr(str string) {
-	o := obj.CreateObject(obj.GSTR, str)
-	c.AddReply(o)
-	o.DecrRefCount()
-}
-
 // 寻找对应的cmd
 func lookupCommand(cmdStr string) *GodisCommand {
 	for _, c := range cmdTable {
@@ -236,6 +522,11 @@ func lookupCommand(cmdStr string) *GodisCommand {
 	return nil
 }
 
+// 检查参数个数是否符合命令的 arity
+func checkArity(cmd *GodisCommand, argc int) bool {
+	return (cmd.arity <= 0 || cmd.arity == argc) && argc >= -cmd.arity
+}
+
 // 执行cmd
 func ProcessCommand(client *GodisClient) {
 	cmdStr := client.args[0].StrVal()
@@ -244,93 +535,80 @@ func ProcessCommand(client *GodisClient) {
 		freeClient(client)
 		return
 	}
-	cmd := lookupCommand(cmdStr)
+	var cmd *GodisCommand
+	if server.sentinel != nil {
+		cmd = lookupSentinelCommand(cmdStr)
+	} else {
+		cmd = lookupCommand(cmdStr)
+	}
 	if cmd == nil {
-		client.AddReplyStr("-ERR: unknow command")
+		client.AddReplyError("ERR: unknow command")
+		resetClient(client)
+		return
+	} else if !checkArity(cmd, len(client.args)) {
+		client.AddReplyError("ERR: wrong number of args")
+		resetClient(client)
+		return
+	}
+	if server.requirePass != "" && !client.authenticated && client.fd != FAKE_CLIENT_FD && client.flags&CLIENT_MASTER == 0 && !commandNoAuth(cmd.name) {
+		client.AddReplyError("NOAUTH Authentication required.")
+		resetClient(client)
+		return
+	}
+	if server.cluster != nil && client.fd != FAKE_CLIENT_FD && client.flags&CLIENT_MASTER == 0 && !clusterCheckCommand(client, cmd) {
+		resetClient(client)
+		return
+	}
+	if cmd.flags&CMD_WRITE != 0 && server.masterHost != "" && server.replicaReadOnly && client.flags&CLIENT_MASTER == 0 {
+		client.AddReplyError("ERR: you can't write against a read only replica")
 		resetClient(client)
 		return
-	} else if cmd.arity != len(client.args) {
-		client.AddReplyStr("-ERR: wrong number of args")
+	}
+	if server.raft != nil && cmd.flags&(CMD_WRITE|CMD_READONLY) != 0 && client.fd != FAKE_CLIENT_FD && !raftCheckLeader(client) {
 		resetClient(client)
 		return
 	}
+	server.currentClient = client
+	// 写命令修改数据时增加 server.dirty，没有修改的命令不传播
+	dirty := server.dirty
 	cmd.proc(client)
+	if cmd.flags&CMD_WRITE != 0 && server.dirty > dirty && !client.preventPropagate {
+		args := make([]string, len(client.args))
+		for i, arg := range client.args {
+			args[i] = arg.StrVal()
+		}
+		propagate(client.db, args)
+	}
 	resetClient(client)
-}
-
-func handleInlineBuf(client *GodisClient) (bool, error) {
-	index, err := client.findLineInQuery()
-	if index < 0 {
-		return false, err
+	if len(server.readyKeys) > 0 {
+		handleClientsBlockedOnKeys()
 	}
-
-	subs := strings.Split(string(client.queryBuf[:index]), " ")
-	client.queryBuf = client.queryBuf[index+2:]
-	client.queryLen -= index + 2
-	client.args = make([]*obj.Gobj, len(subs))
-
-	for i, v := range subs {
-		client.args[i] = obj.CreateObject(obj.GSTR, v)
+	server.currentClient = nil
+	flushAppendOnlyFile()
+	if server.raft != nil {
+		raftHoldReplies()
+		if err := raftFlush(); err != nil {
+			log.Printf("raft flush err: %v\n", err)
+		}
 	}
-	return true, nil
 }
 
-func handleBulkBuf(client *GodisClient) (bool, error) {
-	// init bulkNum
-	if client.bulkNum == 0 {
-		index, err := client.findLineInQuery()
-		if index < 0 {
-			return false, err
-		}
-
-		bnum, err := client.getNumInQuery(1, index)
-		if err != nil {
-			return false, err
-		}
-		if bnum == 0 {
-			return true, nil
-		}
-		client.bulkNum = bnum
-		client.args = make([]*obj.Gobj, bnum)
-	}
-	// 读取所有 bulk string
-	for client.bulkNum > 0 {
-		// init bulkLen
-		if client.bulkLen == 0 {
-			index, err := client.findLineInQuery()
-			if index < 0 {
-				return false, err
-			}
-
-			if client.queryBuf[0] != '$' {
-				return false, errors.New("expect $ for bulk length")
-			}
-
-			blen, err := client.getNumInQuery(1, index)
-			if err != nil || blen == 0 {
-				return false, err
-			}
-			if blen > utils.GODIS_MAX_BULK {
-				return false, errors.New("too big bulk")
-			}
-			client.bulkLen = blen
-		}
-		// read bulk string
-		if client.queryLen < client.bulkLen+2 {
-			return false, nil
-		}
-		index := client.bulkLen
-		// 判断结尾是否为\r\n
-		if client.queryBuf[index] != '\r' || client.queryBuf[index+1] != '\n' {
-			return false, errors.New("expect CRLF for bulk end")
-		}
-		client.args[len(client.args)-client.bulkNum] = obj.CreateObject(obj.GSTR, string(client.queryBuf[:index]))
-		client.queryBuf = client.queryBuf[index+2:]
-		client.queryLen -= index + 2
-		client.bulkLen = 0
-		client.bulkNum -= 1
+// 解析出一条完整的命令，创建参数对象并设置cmdType
+// 命令不完整时 query 保留已解析的参数，下次数据到达时继续解析
+func handleQueryBuf(client *GodisClient) (bool, error) {
+	cmdType := utils.COMMAND_INLINE
+	if buf := client.query.Buffered(); len(buf) > 0 && buf[0] == '*' {
+		cmdType = utils.COMMAND_BULK
 	}
-
+	args, ok, err := client.query.NextCommand()
+	if !ok {
+		return false, err
+	}
+	client.args = make([]*obj.Gobj, len(args))
+	for i, v := range args {
+		client.args[i] = obj.CreateObject(obj.GSTR, string(v))
+	}
+	client.cmdType = cmdType
 	return true, nil
 }
 
@@ -340,62 +618,45 @@ func handleBulkBuf(client *GodisClient) (bool, error) {
 
 // 处理client query
 func ProcessQueryBuf(client *GodisClient) error {
-	// 不断取值
-	for len(client.queryBuf) > 0 {
-		if client.cmdType == utils.COMMAND_UNKNOWN {
-			if client.queryBuf[0] == '*' {
-				client.cmdType = utils.COMMAND_BULK
-			} else {
-				client.cmdType = utils.COMMAND_INLINE
-			}
-		}
-
-		// 读取内容转化为cmd args
-		var ok bool
-		var err error
-		if client.cmdType == utils.COMMAND_INLINE {
-			ok, err = handleInlineBuf(client)
-		} else if client.cmdType == utils.COMMAND_BULK {
-			ok, err = handleBulkBuf(client)
-		} else {
-			return errors.New("unknow Godis Command Type")
-		}
+	// 不断取值，阻塞中的client暂不处理后续命令
+	for len(client.query.Buffered()) > 0 && !client.blocked {
+		// 读取内容转化为cmd args，命令完整后才创建参数
+		ok, err := handleQueryBuf(client)
 		if err != nil {
 			return err
 		}
-
-		// 执行cmd
-		if ok {
-			if len(client.args) == 0 {
-				resetClient(client)
-			} else {
-				ProcessCommand(client)
-			}
-		} else {
+		if !ok {
 			// 未读取完，下次再处理
 			break
 		}
+		// 执行cmd
+		if len(client.args) == 0 {
+			resetClient(client)
+		} else {
+			ProcessCommand(client)
+		}
+		// 已执行的命令流偏移量
+		if client.flags&CLIENT_MASTER != 0 {
+			server.replOffset = client.readReplOffset - int64(len(client.query.Buffered()))
+		}
 	}
 	return nil
 }
 
 func ReadQueryFromClient(loop *ae.AeLoop, fd int, extra interface{}) {
 	client := extra.(*GodisClient)
-	// 如果剩余大小不足GODIS_MAX_BULK，则扩容
-	if len(client.queryBuf)-client.queryLen < utils.GODIS_MAX_BULK {
-		client.queryBuf = append(client.queryBuf, make([]byte, utils.GODIS_MAX_BULK)...)
-	}
-	n, err := net.Read(fd, client.queryBuf[client.queryLen:])
+	n, err := client.query.ReadMore()
 
-	if err != nil {
+	if err != nil || n == 0 {
 		log.Printf("client %v read err: %v\n", fd, err)
 		freeClient(client)
 		return
 	}
 
-	client.queryLen += n
+	if client.flags&CLIENT_MASTER != 0 {
+		client.readReplOffset += int64(n)
+	}
 	log.Printf("read %v bytes from client:%v\n", n, client.fd)
-	log.Printf("ReadQueryFromClient, queryBuf : %v\n", string(client.queryBuf))
 	// 处理query
 	err = ProcessQueryBuf(client)
 	if err != nil {
@@ -420,41 +681,138 @@ func AcceptHandler(loop *ae.AeLoop, fd int, extra interface{}) {
 	log.Printf("accept client, fd: %v\n", cfd)
 }
 
-const EXPIRE_CHECK_COUNT int = 100
+// 每次进入事件等待前执行
+func beforeSleep(loop *ae.AeLoop) {
+	if len(server.unblockedClients) > 0 {
+		processUnblockedClients()
+	}
+}
 
-// 随机取数据判断是否过期
+// 定时任务，每100ms执行一次
 func ServerCron(loop *ae.AeLoop, id int, extra interface{}) {
-	for i := 0; i < EXPIRE_CHECK_COUNT; i++ {
-		entry := server.db.expire.RandomGet()
-		if entry == nil {
-			break
-		}
-		if entry.Val.IntVal() < time.Now().Unix() {
-			server.db.data.Delete(entry.Key)
-			server.db.expire.Delete(entry.Key)
+	if server.sentinel != nil {
+		sentinelCron()
+		return
+	}
+	activeExpireCycle()
+	databasesCron()
+	rdbCron()
+	aofCron()
+	replicationCron()
+	raftCron()
+	clusterCron()
+}
+
+// 推进db中未完成的rehash，每个dict最多使用1ms，完成一个后本轮即返回
+func databasesCron() {
+	for _, db := range server.dbs {
+		if db.data.Rehash(1) > 0 || db.expire.Rehash(1) > 0 {
+			return
 		}
 	}
 }
 
 // 初始化godis server
 func initServer(config *conf.Config) error {
+	closeSentinel()
 	server.port = config.Port
+	server.requirePass = config.RequirePass
+	if server.requirePass != "" && (config.Raft || config.ClusterEnabled) {
+		return REQUIREPASS_ERR
+	}
+	if config.HashSeed != "" {
+		if err := utils.SetHashSeedHex(config.HashSeed); err != nil {
+			return err
+		}
+	}
 	server.clients = make(map[int]*GodisClient)
-	server.db = &GodisDB{
-		data:   dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual}),
-		expire: dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual}),
+	server.readyKeys = nil
+	server.unblockedClients = nil
+	databases := config.Databases
+	if databases <= 0 {
+		databases = DEFAULT_DBNUM
+	}
+	server.dbs = make([]*GodisDB, databases)
+	for i := range server.dbs {
+		server.dbs[i] = &GodisDB{
+			id:           i,
+			data:         createKeyDict(),
+			expire:       createKeyDict(),
+			blockingKeys: make(map[string][]*GodisClient),
+			readyKeys:    make(map[string]bool),
+		}
+		if config.ClusterEnabled {
+			server.dbs[i].slotKeys = createSlotKeys()
+		}
+	}
+	server.dir = config.Dir
+	server.dbFilename = config.DbFilename
+	if server.dbFilename == "" {
+		server.dbFilename = DEFAULT_DBFILENAME
+	}
+	server.saveParams = nil
+	for _, sp := range config.Save {
+		server.saveParams = append(server.saveParams, saveParam{seconds: sp[0], changes: sp[1]})
+	}
+	server.dirty = 0
+	server.lastSave = time.Now().Unix()
+	server.lastBgsaveOk = true
+	server.rdbSnapshot = nil
+	server.rdbBgsaveDone = nil
+	if err := initAppendOnly(config); err != nil {
+		return err
+	}
+	// sentinel模式不加载数据
+	if config.Sentinel {
+		return initSentinel(config)
+	}
+	// Raft模式只从Raft快照及日志恢复，开启AOF时只从AOF恢复数据
+	if config.Raft {
+		if server.aofEnabled || config.ImportRdb != "" {
+			return RAFT_PERSISTENCE_ERR
+		}
+	} else if server.aofEnabled {
+		if err := loadAppendOnlyFile(aofFilename()); err != nil {
+			return err
+		}
+		if err := openAppendOnlyFile(); err != nil {
+			return err
+		}
+	} else if err := rdbLoad(rdbFilename()); err != nil {
+		return err
+	}
+	if err := importRdbOnStartup(config.ImportRdb); err != nil {
+		return err
 	}
 	var err error
 	if server.aeLoop, err = ae.AeLoopCreate(); err != nil {
 		return err
 	}
+	if err := initReplication(config); err != nil {
+		return err
+	}
+	if err := initRaft(config); err != nil {
+		return err
+	}
+	if err := initCluster(config); err != nil {
+		return err
+	}
 	server.fd, err = net.TcpServer(server.port)
 	return err
 }
 
 func main() {
-	path := os.Args[1]
+	// godis [--sentinel] config.json
+	args := os.Args[1:]
+	sentinel := len(args) > 0 && args[0] == "--sentinel"
+	if sentinel {
+		args = args[1:]
+	}
+	path := args[0]
 	config, err := conf.LoadConfig(path)
+	if sentinel && config != nil {
+		config.Sentinel = true
+	}
 
 	if err != nil {
 		log.Printf("config error: %v\n", err)
@@ -464,6 +822,7 @@ func main() {
 		log.Printf("init server error: %v\n", err)
 	}
 
+	server.aeLoop.BeforeSleep = beforeSleep
 	server.aeLoop.AddFileEvent(server.fd, ae.AE_READABLE, AcceptHandler, nil)
 	server.aeLoop.AddTimeEvent(ae.AE_NORMAL, 100, ServerCron, nil)
 	log.Println("godis server is up.")
@@ -3,37 +3,38 @@ package main
 import (
 	"akt-redis/conf"
 	"akt-redis/obj"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func ReadQuery(client *GodisClient, query string) {
-	for _, v := range []byte(query) {
-		client.queryBuf[client.queryLen] = v
-		client.queryLen += 1
-	}
+	client.query.Feed([]byte(query))
 }
 
 func TestInlineBuf(t *testing.T) {
 	client := CreateClient(0)
 	ReadQuery(client, "set key val\r\n")
-	ok, err := handleInlineBuf(client)
+	ok, err := handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, true, ok)
 
 	ReadQuery(client, "set ")
-	ok, err = handleInlineBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, false, ok)
 
 	ReadQuery(client, "key ")
-	ok, err = handleInlineBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, false, ok)
 
 	ReadQuery(client, "val\r\n")
-	ok, err = handleInlineBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 3, len(client.args))
@@ -43,31 +44,38 @@ func TestBulkBuf(t *testing.T) {
 	client := CreateClient(0)
 
 	ReadQuery(client, "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$3\r\nval\r\n")
-	ok, err := handleBulkBuf(client)
+	ok, err := handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 3, len(client.args))
 
 	ReadQuery(client, "*3\r")
-	ok, err = handleBulkBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, false, ok)
 
 	ReadQuery(client, "\n$3\r\nset\r\n$3")
-	ok, err = handleBulkBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, false, ok)
 
 	ReadQuery(client, "\r\nkey\r")
-	ok, err = handleBulkBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, false, ok)
 
 	ReadQuery(client, "\n$3\r\nval\r\n")
-	ok, err = handleBulkBuf(client)
+	ok, err = handleQueryBuf(client)
 	assert.Nil(t, err)
 	assert.Equal(t, true, ok)
 	assert.Equal(t, 3, len(client.args))
+
+	// 空字符串参数
+	ReadQuery(client, "*2\r\n$3\r\nget\r\n$0\r\n\r\n")
+	ok, err = handleQueryBuf(client)
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "", client.args[1].StrVal())
 }
 
 func TestProcessQueryBuf(t *testing.T) {
@@ -77,15 +85,77 @@ func TestProcessQueryBuf(t *testing.T) {
 	ReadQuery(client, "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$3\r\nval\r\n")
 	err := ProcessQueryBuf(client)
 	assert.Nil(t, err)
-	assert.Equal(t, 3, len(client.args))
+	// 执行后参数已释放
+	assert.Nil(t, client.args)
 	key := obj.CreateObject(obj.GSTR, "key")
-	val := server.db.data.Get(key)
+	val := client.db.data.Get(key)
 	assert.Equal(t, "val", val.StrVal())
 
 	ReadQuery(client, "set key val2\r\n")
 	err = ProcessQueryBuf(client)
 	assert.Nil(t, err)
-	assert.Equal(t, 3, len(client.args))
-	val2 := server.db.data.Get(key)
+	assert.Nil(t, client.args)
+	val2 := client.db.data.Get(key)
 	assert.Equal(t, "val2", val2.StrVal())
+
+	// 空命令忽略，命令不完整时不创建参数
+	ExecQuery(client, "")
+	ReadQuery(client, "*0\r\n*2\r\n$3\r\nget\r\n$3\r\nke")
+	assert.Nil(t, ProcessQueryBuf(client))
+	assert.Equal(t, utils.COMMAND_UNKNOWN, client.cmdType)
+	assert.Equal(t, "$4\r\nval2\r\n", ExecQuery(client, "y\r\n"))
+	ReadQuery(client, "*1\r\n:1\r\n")
+	assert.Equal(t, resp.FORMAT_ERR, ProcessQueryBuf(client))
+}
+
+// 命令分多次到达，完整后才执行
+func TestQueryInChunks(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer unix.Close(fds[1])
+	client := CreateClient(fds[0])
+	server.clients[fds[0]] = client
+	key := obj.CreateObject(obj.GSTR, "key")
+	val := strings.Repeat("v", 3000)
+	data := resp.AppendCommand(nil, []string{"set", "key", val})
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := unix.Write(fds[1], data[i:end])
+		assert.Nil(t, err)
+		ReadQueryFromClient(server.aeLoop, fds[0], client)
+		if end < len(data) {
+			assert.Nil(t, client.db.data.Get(key))
+			assert.Equal(t, end, len(client.query.Buffered()))
+		}
+	}
+	assert.Equal(t, val, client.db.data.Get(key).StrVal())
+	assert.Equal(t, 0, len(client.query.Buffered()))
+	freeClient(client)
+}
+
+// 执行query并取出全部回复
+func ExecQuery(client *GodisClient, query string) string {
+	ReadQuery(client, query)
+	ProcessQueryBuf(client)
+	var sb strings.Builder
+	for client.reply.Length() > 0 {
+		n := client.reply.First()
+		sb.WriteString(n.Val.StrVal())
+		client.reply.DelNode(n)
+		n.Val.DecrRefCount()
+	}
+	return sb.String()
+}
+
+func TestArity(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+	assert.Equal(t, "-ERR: wrong number of args\r\n", ExecQuery(client, "lpush list\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "lpush list a b c\r\n"))
 }
@@ -1,6 +1,8 @@
 package net
 
 import (
+	"fmt"
+	"io"
 	"log"
 
 	"golang.org/x/sys/unix"
@@ -8,6 +10,9 @@ import (
 
 const BACKLOG int = 64
 
+// 连接的超时时间，在ae loop中连接时对端无响应不会长时间阻塞
+const CONNECT_TIMEOUT int64 = 200 // ms
+
 func Accept(fd int) (int, error) {
 	nfd, _, err := unix.Accept(fd)
 	return nfd, err
@@ -28,10 +33,18 @@ func Connect(host [4]byte, port int) (int, error) {
 	var addr unix.SockaddrInet4
 	addr.Addr = host
 	addr.Port = port
-	// 链接
-	err = unix.Connect(s, &addr)
+	// 链接，Linux 的 SO_SNDTIMEO 同样限制 connect 的时间，连接后恢复为不超时
+	tv := unix.NsecToTimeval(CONNECT_TIMEOUT * 1e6)
+	err = unix.SetsockoptTimeval(s, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
+	if err == nil {
+		err = unix.Connect(s, &addr)
+	}
+	if err == nil {
+		err = unix.SetsockoptTimeval(s, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &unix.Timeval{})
+	}
 	if err != nil {
 		log.Printf("connect err: %v\n", err)
+		unix.Close(s)
 		return -1, err
 	}
 	return s, nil
@@ -73,6 +86,41 @@ func Read(fd int, buf []byte) (int, error) {
 	return unix.Read(fd, buf)
 }
 
+// 以 io.Reader 读取fd，对端关闭时返回 io.EOF
+type FdReader int
+
+func (fd FdReader) Read(buf []byte) (int, error) {
+	n, err := Read(int(fd), buf)
+	if n < 0 {
+		n = 0
+	}
+	if n == 0 && err == nil && len(buf) > 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
 func Write(fd int, buf []byte) (int, error) {
 	return unix.Write(fd, buf)
 }
+
+// 设置阻塞socket的读写超时
+func SetTimeout(fd int, ms int64) error {
+	tv := unix.NsecToTimeval(ms * 1e6)
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+	return unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
+}
+
+// 对端的ip，获取失败时返回空字符串
+func PeerIp(fd int) string {
+	sa, err := unix.Getpeername(fd)
+	if err != nil {
+		return ""
+	}
+	if in4, ok := sa.(*unix.SockaddrInet4); ok {
+		return fmt.Sprintf("%d.%d.%d.%d", in4.Addr[0], in4.Addr[1], in4.Addr[2], in4.Addr[3])
+	}
+	return ""
+}
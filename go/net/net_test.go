@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func EchoServer(s, c, e chan struct{}) {
@@ -59,3 +60,26 @@ func TestAeInstead(t *testing.T) {
 	assert.Equal(t, 10, n)
 	assert.Equal(t, msg, string(buf))
 }
+
+// 对端不响应时在超时后返回
+// backlog为0且不accept的监听socket，队列满后丢弃SYN，模拟无响应的对端
+func TestConnectTimeout(t *testing.T) {
+	s, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	assert.Nil(t, err)
+	defer unix.Close(s)
+	assert.Nil(t, unix.Bind(s, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	assert.Nil(t, unix.Listen(s, 0))
+	sa, _ := unix.Getsockname(s)
+	port := sa.(*unix.SockaddrInet4).Port
+	host := [4]byte{127, 0, 0, 1}
+	for i := 0; i < 16; i++ {
+		start := time.Now()
+		fd, err := Connect(host, port)
+		if err != nil {
+			assert.Less(t, time.Since(start), time.Second)
+			return
+		}
+		defer Close(fd)
+	}
+	t.Fatal("connect never blocked")
+}
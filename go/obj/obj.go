@@ -1,6 +1,10 @@
 package obj
 
-import "strconv"
+import (
+	"errors"
+	"math"
+	"strconv"
+)
 
 type Gtype uint8
 
@@ -19,18 +23,37 @@ const (
 	GDICT Gtype = 0x04
 )
 
+var (
+	NOT_INT_ERR   = errors.New("value is not an integer or out of range")
+	NOT_FLOAT_ERR = errors.New("value is not a valid float")
+)
+
 type Gobj struct {
 	Type_    Gtype
 	Val_     Gval
 	RefCount int
 }
 
-func (obj *Gobj) IntVal() int64 {
+func (obj *Gobj) IntVal() (int64, error) {
 	if obj.Type_ != GSTR {
-		return 0
+		return 0, NOT_INT_ERR
+	}
+	val, err := strconv.ParseInt(obj.Val_.(string), 10, 64)
+	if err != nil {
+		return 0, NOT_INT_ERR
+	}
+	return val, nil
+}
+
+func (obj *Gobj) FloatVal() (float64, error) {
+	if obj.Type_ != GSTR {
+		return 0, NOT_FLOAT_ERR
+	}
+	val, err := strconv.ParseFloat(obj.Val_.(string), 64)
+	if err != nil || math.IsNaN(val) {
+		return 0, NOT_FLOAT_ERR
 	}
-	val, _ := strconv.ParseInt(obj.Val_.(string), 10, 64)
-	return val
+	return val, nil
 }
 
 func (o *Gobj) StrVal() string {
@@ -0,0 +1,1266 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/conf"
+	"akt-redis/net"
+	"akt-redis/obj"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	gonet "net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Raft 强一致模式
+// leader 执行写命令后，将传播后的确定形式追加到Raft日志，过半节点持久化后提交，之后才回复client
+// follower 按顺序应用已提交的日志，数据命令都重定向到leader (-REDIRECT host:port)
+// leader 的数据包含全部日志，失去leadership且有未提交的日志时，从快照和已提交的日志重建
+// 节点间通过 raft.* 命令通信，由 leader/candidate 使用 net.Connect 连接其他节点，回复在ae loop中读取
+// 持久化：raft.meta 保存term、投票及快照信息，raft.log 保存快照之后的日志，raft-<index>.rdb 为快照
+// 成员变更每次增删一个节点，配置日志追加后即生效
+
+const (
+	RAFT_META_FILENAME              = "raft.meta"
+	RAFT_LOG_FILENAME               = "raft.log"
+	RAFT_ELECTION_TIMEOUT           = 1000 // ms，实际为1-2倍
+	RAFT_RPC_TIMEOUT                = 1000 // ms
+	RAFT_MAX_ENTRIES_PER_RPC        = 64
+	RAFT_SNAPSHOT_RETRY_MAX         = 30000 // ms，安装快照失败后重发间隔的上限
+	DEFAULT_RAFT_SNAPSHOT_THRESHOLD = 1000
+)
+
+// 节点角色
+const (
+	RAFT_FOLLOWER = iota
+	RAFT_CANDIDATE
+	RAFT_LEADER
+)
+
+// 日志类型
+const (
+	RAFT_ENTRY_NOOP   = iota // 新leader提交之前任期的日志
+	RAFT_ENTRY_CMD           // 写命令
+	RAFT_ENTRY_CONFIG        // 成员变更，args 为全部成员
+)
+
+// 等待回复的RPC
+const (
+	RAFT_RPC_NONE = iota
+	RAFT_RPC_VOTE
+	RAFT_RPC_APPEND
+	RAFT_RPC_SNAPSHOT
+)
+
+var (
+	RAFT_LOG_ERR         = errors.New("bad raft log")
+	RAFT_PERSISTENCE_ERR = errors.New("appendonly and import-rdb are not supported in raft mode")
+	RAFT_DISABLED_ERR    = errors.New("raft mode is not enabled")
+	RAFT_NO_LEADER_ERR   = errors.New("no raft leader elected")
+	RAFT_CONFIG_ERR      = errors.New("a membership change is in progress")
+	RAFT_MEMBER_ERR      = errors.New("no such member")
+	RAFT_DUP_MEMBER_ERR  = errors.New("already a member")
+	RAFT_REPLY_ERR       = errors.New("bad raft reply")
+)
+
+type raftEntry struct {
+	term int64
+	typ  int
+	dbid int
+	args []string
+}
+
+type raftPeer struct {
+	addr           string
+	fd             int // -1 表示未连接
+	buf            []byte
+	inflight       int // 等待回复的RPC
+	inflightAt     int64
+	lastConnectTry int64
+	lastContact    int64 // 上次收到回复的时间
+	voteTerm       int64 // 已收到投票回复的term
+	snapBackoff    int64 // 快照重发间隔，安装成功后清零
+	snapRetryAt    int64
+	nextIndex      int64
+	matchIndex     int64
+}
+
+type raftState struct {
+	self              string
+	role              int
+	term              int64
+	votedFor          string
+	leader            string
+	members           []string
+	entries           []raftEntry // 快照之后的日志，第i条的index为 snapIndex+1+i
+	snapIndex         int64
+	snapTerm          int64
+	snapMembers       []string
+	commitIndex       int64
+	lastApplied       int64
+	persistedIndex    int64 // 已写入日志文件的index
+	logFile           *os.File
+	logBuf            []byte
+	peers             map[string]*raftPeer
+	votes             map[string]bool
+	electionDeadline  int64
+	lastLeaderContact int64
+	snapshotThreshold int64
+	fake              *GodisClient
+	lookupCommand     func(string) *GodisCommand // 避免 cmdTable 初始化循环
+	holding           bool                       // 为true时回复暂不发送，等待日志提交
+	held              []*GodisClient             // 本次命令中有回复的client
+	waiting           []*GodisClient             // 回复等待提交的client
+	compactIndex      int64                      // 进行中的快照对应的index
+	compactSnapshot   []dbSnapshot
+	compactDone       chan error // 进行中的快照，nil表示没有
+}
+
+func initRaft(config *conf.Config) error {
+	closeRaft()
+	if !config.Raft {
+		return nil
+	}
+	r := &raftState{
+		self:              config.RaftAddr,
+		peers:             make(map[string]*raftPeer),
+		snapMembers:       config.RaftPeers,
+		snapshotThreshold: int64(config.RaftSnapshotThreshold),
+		fake:              createFakeClient(),
+		lookupCommand:     lookupCommand,
+	}
+	if r.self == "" {
+		r.self = fmt.Sprintf("127.0.0.1:%d", server.port)
+	}
+	if r.snapshotThreshold <= 0 {
+		r.snapshotThreshold = DEFAULT_RAFT_SNAPSHOT_THRESHOLD
+	}
+	server.raft = r
+	if err := raftLoad(); err != nil {
+		server.raft = nil
+		return err
+	}
+	raftUpdateMembers()
+	raftResetElectionTimer()
+	return nil
+}
+
+func closeRaft() {
+	r := server.raft
+	if r == nil {
+		return
+	}
+	for _, p := range r.peers {
+		raftClosePeer(p)
+	}
+	if err := checkRaftCompactDone(true); err != nil {
+		log.Printf("raft compact err: %v\n", err)
+	}
+	if r.logFile != nil {
+		r.logFile.Close()
+	}
+	server.raft = nil
+}
+
+func raftMetaFilename() string {
+	return filepath.Join(server.dir, RAFT_META_FILENAME)
+}
+
+func raftLogFilename() string {
+	return filepath.Join(server.dir, RAFT_LOG_FILENAME)
+}
+
+func raftSnapshotFilename(index int64) string {
+	return filepath.Join(server.dir, fmt.Sprintf("raft-%d.rdb", index))
+}
+
+func formatInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func raftLastIndex() int64 {
+	r := server.raft
+	return r.snapIndex + int64(len(r.entries))
+}
+
+func raftEntryAt(index int64) *raftEntry {
+	return &server.raft.entries[index-server.raft.snapIndex-1]
+}
+
+// index 已在快照中时返回-1
+func raftTermAt(index int64) int64 {
+	r := server.raft
+	if index == r.snapIndex {
+		return r.snapTerm
+	}
+	if index < r.snapIndex || index > raftLastIndex() {
+		return -1
+	}
+	return raftEntryAt(index).term
+}
+
+// 截止到index的成员
+func raftMembersAt(index int64) []string {
+	r := server.raft
+	for i := index; i > r.snapIndex; i-- {
+		if e := raftEntryAt(i); e.typ == RAFT_ENTRY_CONFIG {
+			return e.args
+		}
+	}
+	return r.snapMembers
+}
+
+// 最后一条配置日志的index，没有时返回快照index
+func raftLastConfigIndex() int64 {
+	r := server.raft
+	for i := raftLastIndex(); i > r.snapIndex; i-- {
+		if raftEntryAt(i).typ == RAFT_ENTRY_CONFIG {
+			return i
+		}
+	}
+	return r.snapIndex
+}
+
+func raftIsMember(addr string) bool {
+	for _, m := range server.raft.members {
+		if m == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func raftQuorum() int {
+	return len(server.raft.members)/2 + 1
+}
+
+func raftResetElectionTimer() {
+	server.raft.electionDeadline = utils.GetMsTime() + RAFT_ELECTION_TIMEOUT + rand.Int63n(RAFT_ELECTION_TIMEOUT)
+}
+
+// 日志变化后更新成员，并建立/关闭与其他节点的连接
+func raftUpdateMembers() {
+	r := server.raft
+	r.members = raftMembersAt(raftLastIndex())
+	for _, m := range r.members {
+		if _, ok := r.peers[m]; !ok && m != r.self {
+			r.peers[m] = &raftPeer{addr: m, fd: -1, nextIndex: raftLastIndex() + 1}
+		}
+	}
+	for addr, p := range r.peers {
+		if !raftIsMember(addr) {
+			raftClosePeer(p)
+			delete(r.peers, addr)
+		}
+	}
+}
+
+func raftSaveMeta() error {
+	r := server.raft
+	args := []string{"raftmeta", formatInt(r.term), r.votedFor, formatInt(r.snapIndex), formatInt(r.snapTerm)}
+	args = append(args, r.snapMembers...)
+	return writeFileAtomic(raftMetaFilename(), resp.AppendCommand(nil, args))
+}
+
+func catRaftEntry(buf []byte, e *raftEntry) []byte {
+	args := []string{formatInt(e.term), strconv.Itoa(e.typ), strconv.Itoa(e.dbid)}
+	return resp.AppendCommand(buf, append(args, e.args...))
+}
+
+// 以快照index开头重写日志文件
+func raftRewriteLog() error {
+	r := server.raft
+	if r.logFile != nil {
+		r.logFile.Close()
+		r.logFile = nil
+	}
+	buf := resp.AppendCommand(nil, []string{"raftlog", formatInt(r.snapIndex)})
+	for i := range r.entries {
+		buf = catRaftEntry(buf, &r.entries[i])
+	}
+	r.logBuf = nil
+	if err := writeFileAtomic(raftLogFilename(), buf); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(raftLogFilename(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.logFile = f
+	r.persistedIndex = raftLastIndex()
+	return nil
+}
+
+// 启动时加载快照及日志，已提交的日志在选出leader后应用
+func raftLoad() error {
+	r := server.raft
+	f, err := os.Open(raftMetaFilename())
+	if err == nil {
+		args, err := readAofCommand(newAofReader(f))
+		f.Close()
+		if err != nil || len(args) < 5 || args[0] != "raftmeta" {
+			return RAFT_LOG_ERR
+		}
+		r.term, _ = strconv.ParseInt(args[1], 10, 64)
+		r.votedFor = args[2]
+		r.snapIndex, _ = strconv.ParseInt(args[3], 10, 64)
+		r.snapTerm, _ = strconv.ParseInt(args[4], 10, 64)
+		r.snapMembers = args[5:]
+	} else if !os.IsNotExist(err) {
+		return err
+	} else if err := raftSaveMeta(); err != nil {
+		// 保存初始成员，之后不再使用配置中的 raft_peers
+		return err
+	}
+	if r.snapIndex > 0 {
+		if err := raftLoadSnapshotFile(); err != nil {
+			return err
+		}
+	}
+	r.commitIndex = r.snapIndex
+	r.lastApplied = r.snapIndex
+
+	f, err = os.Open(raftLogFilename())
+	if os.IsNotExist(err) {
+		return raftRewriteLog()
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd := newAofReader(f)
+	header, err := readAofCommand(rd)
+	if err != nil || len(header) != 2 || header[0] != "raftlog" {
+		return RAFT_LOG_ERR
+	}
+	start, err := strconv.ParseInt(header[1], 10, 64)
+	if err != nil || start > r.snapIndex {
+		return RAFT_LOG_ERR
+	}
+	for index := start + 1; ; index++ {
+		args, err := readAofCommand(rd)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			// 写入日志时宕机，未确认的日志可以丢弃
+			log.Printf("raft log truncated at index %v\n", index)
+			break
+		}
+		if err != nil || len(args) < 3 {
+			return RAFT_LOG_ERR
+		}
+		if index <= r.snapIndex {
+			continue
+		}
+		var e raftEntry
+		e.term, _ = strconv.ParseInt(args[0], 10, 64)
+		e.typ, _ = strconv.Atoi(args[1])
+		e.dbid, _ = strconv.Atoi(args[2])
+		e.args = args[3:]
+		r.entries = append(r.entries, e)
+	}
+	log.Printf("raft log loaded: snapshot index %v, last index %v\n", r.snapIndex, raftLastIndex())
+	return raftRewriteLog()
+}
+
+func raftLoadSnapshotFile() error {
+	data, err := os.ReadFile(raftSnapshotFilename(server.raft.snapIndex))
+	if err != nil {
+		return err
+	}
+	return rdbLoadFrom(bytes.NewReader(data))
+}
+
+// 追加日志，写入文件由 raftFlush 完成
+func raftAppendEntry(e raftEntry) {
+	r := server.raft
+	r.entries = append(r.entries, e)
+	r.logBuf = catRaftEntry(r.logBuf, &e)
+	if e.typ == RAFT_ENTRY_CONFIG {
+		raftUpdateMembers()
+	}
+	// leader 已执行该命令
+	if r.role == RAFT_LEADER {
+		r.lastApplied = raftLastIndex()
+	}
+}
+
+// 删除 index 及之后的日志
+func raftTruncate(index int64) error {
+	r := server.raft
+	r.entries = r.entries[:index-r.snapIndex-1]
+	raftUpdateMembers()
+	return raftRewriteLog()
+}
+
+// 持久化日志，leader 随后发送给其他节点
+func raftFlush() error {
+	r := server.raft
+	if len(r.logBuf) > 0 {
+		if _, err := r.logFile.Write(r.logBuf); err != nil {
+			return err
+		}
+		if err := r.logFile.Sync(); err != nil {
+			return err
+		}
+		r.logBuf = nil
+		r.persistedIndex = raftLastIndex()
+	}
+	if r.role == RAFT_LEADER {
+		raftAdvanceCommit()
+		for _, p := range r.peers {
+			if p.inflight == RAFT_RPC_NONE && p.nextIndex <= raftLastIndex() {
+				raftSendAppend(p)
+			}
+		}
+	}
+	return nil
+}
+
+// 写命令追加到日志
+func raftPropagate(dbid int, args []string) {
+	if server.raft == nil || server.raft.role != RAFT_LEADER {
+		return
+	}
+	raftAppendEntry(raftEntry{term: server.raft.term, typ: RAFT_ENTRY_CMD, dbid: dbid, args: args})
+}
+
+// 应用日志到 index
+// 应用期间不删除过期key，过期由leader决定并以DEL记录在日志中
+func raftApplyTo(index int64) {
+	r := server.raft
+	server.loading = true
+	defer func() { server.loading = false }()
+	for r.lastApplied < index {
+		r.lastApplied++
+		e := raftEntryAt(r.lastApplied)
+		if e.typ != RAFT_ENTRY_CMD {
+			continue
+		}
+		cmd := raftEntryCommand(e)
+		if cmd == nil {
+			log.Printf("invalid command in raft log: %v\n", e.args)
+			continue
+		}
+		r.fake.db = server.dbs[e.dbid]
+		r.fake.args = createArgs(e.args)
+		cmd.proc(r.fake)
+		freeArgs(r.fake)
+		r.fake.preventPropagate = false
+	}
+}
+
+// 返回命令日志对应的命令，db或参数个数不合法时返回nil
+func raftEntryCommand(e *raftEntry) *GodisCommand {
+	if e.dbid < 0 || e.dbid >= len(server.dbs) || len(e.args) == 0 {
+		return nil
+	}
+	cmd := server.raft.lookupCommand(e.args[0])
+	if cmd == nil || !checkArity(cmd, len(e.args)) {
+		return nil
+	}
+	return cmd
+}
+
+// 清空数据后从快照及已提交的日志重建
+func raftRebuildState() {
+	r := server.raft
+	for _, db := range server.dbs {
+		emptyDb(db)
+	}
+	r.lastApplied = r.snapIndex
+	if r.snapIndex > 0 {
+		if err := raftLoadSnapshotFile(); err != nil {
+			log.Fatalf("raft load snapshot err: %v\n", err)
+		}
+	}
+	raftApplyTo(r.commitIndex)
+	log.Printf("raft state rebuilt at index %v\n", r.commitIndex)
+}
+
+// 提交index变化后应用日志并发送等待的回复
+func raftCommitted() {
+	r := server.raft
+	if r.role != RAFT_LEADER {
+		raftApplyTo(r.commitIndex)
+	}
+	waiting := r.waiting[:0]
+	for _, c := range r.waiting {
+		if c.raftWaitIndex > r.commitIndex {
+			waiting = append(waiting, c)
+			continue
+		}
+		c.raftWaiting = false
+		for _, o := range c.raftReplies {
+			c.reply.Append(o)
+		}
+		c.raftReplies = nil
+		if server.clients[c.fd] == c && c.reply.Length() > 0 {
+			server.aeLoop.AddFileEvent(c.fd, ae.AE_WRITABLE, SendReplyToClient, c)
+		}
+	}
+	r.waiting = waiting
+	// 移除自身的配置提交后退出
+	if r.role == RAFT_LEADER && !raftIsMember(r.self) && raftLastConfigIndex() <= r.commitIndex {
+		log.Println("raft leader removed from the cluster, stepping down")
+		raftBecomeFollower(r.term, "")
+	}
+}
+
+// 只有当前任期的日志通过计数提交
+func raftAdvanceCommit() {
+	r := server.raft
+	for n := raftLastIndex(); n > r.commitIndex && raftTermAt(n) == r.term; n-- {
+		count := 0
+		if raftIsMember(r.self) && r.persistedIndex >= n {
+			count++
+		}
+		for _, p := range r.peers {
+			if p.matchIndex >= n {
+				count++
+			}
+		}
+		if count >= raftQuorum() {
+			r.commitIndex = n
+			raftCommitted()
+			return
+		}
+	}
+}
+
+// AddReply 时调用，回复暂存在 client.raftReplies 中，在 raftHoldReplies 中关联到日志index
+func raftHoldReply(c *GodisClient, o *obj.Gobj) bool {
+	r := server.raft
+	if r == nil || !r.holding || c.fd == FAKE_CLIENT_FD {
+		return false
+	}
+	o.IncrRefCount()
+	c.raftReplies = append(c.raftReplies, o)
+	r.held = append(r.held, c)
+	return true
+}
+
+func freeRaftReplies(c *GodisClient) {
+	for _, o := range c.raftReplies {
+		o.DecrRefCount()
+	}
+	c.raftReplies = nil
+}
+
+// 命令结束时调用，回复在当前最后一条日志提交后发送
+func raftHoldReplies() {
+	r := server.raft
+	r.holding = false
+	for _, c := range r.held {
+		c.raftWaitIndex = raftLastIndex()
+		if !c.raftWaiting {
+			c.raftWaiting = true
+			r.waiting = append(r.waiting, c)
+		}
+	}
+	r.held = nil
+	raftCommitted()
+}
+
+// 数据命令只由leader处理，返回false表示已重定向
+func raftCheckLeader(c *GodisClient) bool {
+	r := server.raft
+	if r.role == RAFT_LEADER {
+		r.holding = true
+		return true
+	}
+	if r.leader == "" {
+		c.AddReplyErrorFormat("ERR: %v", RAFT_NO_LEADER_ERR)
+	} else {
+		c.AddReplyErrorFormat("REDIRECT %v", r.leader)
+	}
+	return false
+}
+
+func raftBecomeFollower(term int64, leader string) {
+	r := server.raft
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		if err := raftSaveMeta(); err != nil {
+			log.Printf("raft save meta err: %v\n", err)
+		}
+	}
+	wasLeader := r.role == RAFT_LEADER
+	r.role = RAFT_FOLLOWER
+	r.leader = leader
+	if wasLeader {
+		raftStepDown()
+	}
+}
+
+// 不再是leader，等待提交的client结果未知，断开连接
+func raftStepDown() {
+	r := server.raft
+	for _, c := range r.waiting {
+		if server.clients[c.fd] == c {
+			freeClient(c)
+		}
+	}
+	r.waiting = nil
+	for _, c := range server.clients {
+		if c.blocked {
+			freeClient(c)
+		}
+	}
+	for _, p := range r.peers {
+		raftClosePeer(p)
+	}
+	if r.lastApplied > r.commitIndex {
+		raftRebuildState()
+	}
+	log.Printf("raft step down at term %v\n", r.term)
+}
+
+func raftStartElection() {
+	r := server.raft
+	r.term++
+	r.role = RAFT_CANDIDATE
+	r.votedFor = r.self
+	r.leader = ""
+	r.votes = map[string]bool{r.self: true}
+	if err := raftSaveMeta(); err != nil {
+		log.Printf("raft save meta err: %v\n", err)
+	}
+	raftResetElectionTimer()
+	log.Printf("raft start election at term %v\n", r.term)
+	if len(r.votes) >= raftQuorum() {
+		raftBecomeLeader()
+		return
+	}
+	for _, p := range r.peers {
+		raftClosePeer(p)
+		raftSendVote(p)
+	}
+}
+
+func raftBecomeLeader() {
+	r := server.raft
+	r.role = RAFT_LEADER
+	r.leader = r.self
+	now := utils.GetMsTime()
+	r.lastLeaderContact = now
+	for _, p := range r.peers {
+		p.nextIndex = raftLastIndex() + 1
+		p.matchIndex = 0
+		p.lastContact = now
+	}
+	// 之前任期的日志将由本leader提交，先应用以保证顺序
+	raftApplyTo(raftLastIndex())
+	raftAppendEntry(raftEntry{term: r.term, typ: RAFT_ENTRY_NOOP})
+	log.Printf("raft become leader at term %v\n", r.term)
+	if err := raftFlush(); err != nil {
+		log.Printf("raft flush err: %v\n", err)
+	}
+}
+
+func raftClosePeer(p *raftPeer) {
+	if p.fd >= 0 {
+		server.aeLoop.RemoveFileEvent(p.fd, ae.AE_READABLE)
+		net.Close(p.fd)
+	}
+	p.fd = -1
+	p.buf = nil
+	p.inflight = RAFT_RPC_NONE
+}
+
+func raftConnectPeer(p *raftPeer) bool {
+	now := utils.GetMsTime()
+	if now-p.lastConnectTry < RAFT_RPC_TIMEOUT {
+		return false
+	}
+	p.lastConnectTry = now
+	host, portStr, err := gonet.SplitHostPort(p.addr)
+	if err != nil {
+		log.Printf("bad raft peer addr %v: %v\n", p.addr, err)
+		return false
+	}
+	port, _ := strconv.Atoi(portStr)
+	ip, err := resolveHost(host)
+	if err != nil {
+		log.Printf("can't resolve raft peer %v: %v\n", p.addr, err)
+		return false
+	}
+	fd, err := net.Connect(ip, port)
+	if err != nil {
+		return false
+	}
+	p.fd = fd
+	server.aeLoop.AddFileEvent(fd, ae.AE_READABLE, raftPeerReadHandler, p)
+	return true
+}
+
+func raftSendRpc(p *raftPeer, typ int, args []string) {
+	if p.fd < 0 && !raftConnectPeer(p) {
+		return
+	}
+	if _, err := net.Write(p.fd, resp.AppendCommand(nil, args)); err != nil {
+		log.Printf("send to raft peer %v err: %v\n", p.addr, err)
+		raftClosePeer(p)
+		return
+	}
+	p.inflight = typ
+	p.inflightAt = utils.GetMsTime()
+}
+
+func raftSendVote(p *raftPeer) {
+	r := server.raft
+	last := raftLastIndex()
+	raftSendRpc(p, RAFT_RPC_VOTE, []string{"raft.requestvote", formatInt(r.term), r.self, formatInt(last), formatInt(raftTermAt(last))})
+}
+
+func raftSendAppend(p *raftPeer) {
+	r := server.raft
+	if p.nextIndex <= r.snapIndex {
+		raftSendSnapshot(p)
+		return
+	}
+	prev := p.nextIndex - 1
+	last := raftLastIndex()
+	if last > prev+RAFT_MAX_ENTRIES_PER_RPC {
+		last = prev + RAFT_MAX_ENTRIES_PER_RPC
+	}
+	args := []string{"raft.appendentries", formatInt(r.term), r.self, formatInt(prev), formatInt(raftTermAt(prev)), formatInt(r.commitIndex)}
+	for i := prev + 1; i <= last; i++ {
+		e := raftEntryAt(i)
+		args = append(args, formatInt(e.term), strconv.Itoa(e.typ), strconv.Itoa(e.dbid), strconv.Itoa(len(e.args)))
+		args = append(args, e.args...)
+	}
+	raftSendRpc(p, RAFT_RPC_APPEND, args)
+}
+
+func raftSendSnapshot(p *raftPeer) {
+	r := server.raft
+	// 安装失败后逐次加倍重发间隔，避免反复读取并发送快照
+	now := utils.GetMsTime()
+	if now < p.snapRetryAt {
+		return
+	}
+	if p.snapBackoff == 0 {
+		p.snapBackoff = RAFT_RPC_TIMEOUT
+	} else if p.snapBackoff < RAFT_SNAPSHOT_RETRY_MAX {
+		p.snapBackoff *= 2
+	}
+	p.snapRetryAt = now + p.snapBackoff
+	data, err := os.ReadFile(raftSnapshotFilename(r.snapIndex))
+	if err != nil {
+		log.Printf("read raft snapshot err: %v\n", err)
+		return
+	}
+	args := []string{"raft.installsnapshot", formatInt(r.term), r.self, formatInt(r.snapIndex), formatInt(r.snapTerm), string(data)}
+	raftSendRpc(p, RAFT_RPC_SNAPSHOT, append(args, r.snapMembers...))
+	log.Printf("send raft snapshot %v to %v\n", r.snapIndex, p.addr)
+}
+
+// 解析整数数组回复，不完整时返回的长度为0
+func parseRaftReply(buf []byte) ([]int64, int, error) {
+	v, n, err := resp.Parse(buf)
+	if err != nil {
+		return nil, 0, RAFT_REPLY_ERR
+	}
+	if n == 0 {
+		return nil, 0, nil
+	}
+	if err := v.Err(); err != nil {
+		return nil, n, err
+	}
+	vals, ok := v.Ints()
+	if !ok {
+		return nil, 0, RAFT_REPLY_ERR
+	}
+	return vals, n, nil
+}
+
+func raftPeerReadHandler(loop *ae.AeLoop, fd int, extra interface{}) {
+	p := extra.(*raftPeer)
+	buf := make([]byte, utils.GODIS_IO_BUF)
+	n, err := net.Read(fd, buf)
+	if err != nil || n == 0 {
+		raftClosePeer(p)
+		return
+	}
+	p.buf = append(p.buf, buf[:n]...)
+	for p.fd == fd && len(p.buf) > 0 {
+		vals, consumed, err := parseRaftReply(p.buf)
+		if err != nil {
+			log.Printf("raft peer %v reply err: %v\n", p.addr, err)
+			raftClosePeer(p)
+			return
+		}
+		if consumed == 0 {
+			return
+		}
+		p.buf = p.buf[consumed:]
+		if len(vals) == 0 {
+			raftClosePeer(p)
+			return
+		}
+		typ := p.inflight
+		p.inflight = RAFT_RPC_NONE
+		p.lastContact = utils.GetMsTime()
+		raftHandleReply(p, typ, vals)
+	}
+}
+
+func raftHandleReply(p *raftPeer, typ int, vals []int64) {
+	r := server.raft
+	if vals[0] > r.term {
+		raftBecomeFollower(vals[0], "")
+		return
+	}
+	switch typ {
+	case RAFT_RPC_VOTE:
+		if len(vals) != 2 || r.role != RAFT_CANDIDATE || vals[0] != r.term {
+			return
+		}
+		p.voteTerm = r.term
+		if vals[1] == 1 && raftIsMember(p.addr) {
+			r.votes[p.addr] = true
+		}
+		if len(r.votes) >= raftQuorum() {
+			raftBecomeLeader()
+		}
+	case RAFT_RPC_APPEND:
+		if len(vals) != 3 || r.role != RAFT_LEADER {
+			return
+		}
+		if vals[1] == 1 {
+			p.matchIndex = vals[2]
+			p.nextIndex = vals[2] + 1
+			raftAdvanceCommit()
+		} else if vals[2]+1 < p.nextIndex {
+			p.nextIndex = vals[2] + 1
+		} else {
+			p.nextIndex--
+		}
+		if r.role == RAFT_LEADER && p.nextIndex <= raftLastIndex() {
+			raftSendAppend(p)
+		}
+	case RAFT_RPC_SNAPSHOT:
+		if len(vals) != 2 || r.role != RAFT_LEADER {
+			return
+		}
+		p.matchIndex = vals[1]
+		p.nextIndex = vals[1] + 1
+		p.snapBackoff, p.snapRetryAt = 0, 0
+		raftAdvanceCommit()
+	}
+}
+
+// 日志数超过阈值时，将已应用的数据保存为快照并删除之前的日志
+// 在主线程生成快照后，由goroutine完成序列化及写盘，完成后由 checkRaftCompactDone 删除之前的日志
+func raftCompactBackground() {
+	r := server.raft
+	index := r.commitIndex
+	snap := createSnapshot()
+	done := make(chan error, 1)
+	filename := raftSnapshotFilename(index)
+	go func() {
+		var buf bytes.Buffer
+		err := rdbWriteSnapshot(&buf, snap)
+		if err == nil {
+			err = writeFileAtomic(filename, buf.Bytes())
+		}
+		done <- err
+	}()
+	r.compactIndex = index
+	r.compactSnapshot = snap
+	r.compactDone = done
+}
+
+// 快照写入后的处理，block 为true时等待完成
+func checkRaftCompactDone(block bool) error {
+	r := server.raft
+	if r.compactDone == nil {
+		return nil
+	}
+	var err error
+	if block {
+		err = <-r.compactDone
+	} else {
+		select {
+		case err = <-r.compactDone:
+		default:
+			return nil
+		}
+	}
+	releaseSnapshot(r.compactSnapshot)
+	r.compactSnapshot = nil
+	r.compactDone = nil
+	if err != nil {
+		return err
+	}
+	index := r.compactIndex
+	// 期间已安装了leader发送的更新的快照
+	if index <= r.snapIndex {
+		if index < r.snapIndex {
+			os.Remove(raftSnapshotFilename(index))
+		}
+		return nil
+	}
+	old := r.snapIndex
+	r.snapMembers = raftMembersAt(index)
+	r.snapTerm = raftTermAt(index)
+	r.entries = append([]raftEntry{}, r.entries[index-old:]...)
+	r.snapIndex = index
+	// 先更新meta，之后的日志文件中index不大于快照的日志加载时忽略
+	if err := raftSaveMeta(); err != nil {
+		return err
+	}
+	if err := raftRewriteLog(); err != nil {
+		return err
+	}
+	if old > 0 {
+		os.Remove(raftSnapshotFilename(old))
+	}
+	log.Printf("raft log compacted at index %v\n", index)
+	return nil
+}
+
+func raftCron() {
+	r := server.raft
+	if r == nil {
+		return
+	}
+	now := utils.GetMsTime()
+	for _, p := range r.peers {
+		if p.inflight != RAFT_RPC_NONE && now-p.inflightAt > RAFT_RPC_TIMEOUT {
+			log.Printf("raft peer %v timeout\n", p.addr)
+			raftClosePeer(p)
+		}
+	}
+	switch r.role {
+	case RAFT_LEADER:
+		// 无法联系过半节点时退出，避免旧leader继续服务
+		contacted := 0
+		if raftIsMember(r.self) {
+			contacted++
+		}
+		for _, p := range r.peers {
+			if now-p.lastContact < RAFT_ELECTION_TIMEOUT {
+				contacted++
+			}
+		}
+		if contacted < raftQuorum() {
+			log.Println("raft leader lost contact with the majority")
+			raftBecomeFollower(r.term, "")
+			break
+		}
+		r.lastLeaderContact = now
+		// 定时任务中过期删除的DEL
+		if err := raftFlush(); err != nil {
+			log.Printf("raft flush err: %v\n", err)
+		}
+		for _, p := range r.peers {
+			if p.inflight == RAFT_RPC_NONE {
+				raftSendAppend(p)
+			}
+		}
+	case RAFT_CANDIDATE:
+		for _, p := range r.peers {
+			if p.inflight == RAFT_RPC_NONE && p.voteTerm != r.term {
+				raftSendVote(p)
+			}
+		}
+		fallthrough
+	case RAFT_FOLLOWER:
+		if now >= r.electionDeadline && raftIsMember(r.self) {
+			raftStartElection()
+		}
+	}
+	if r.compactDone != nil {
+		if err := checkRaftCompactDone(false); err != nil {
+			log.Printf("raft compact err: %v\n", err)
+		}
+	} else if r.lastApplied == r.commitIndex && r.commitIndex-r.snapIndex >= r.snapshotThreshold {
+		raftCompactBackground()
+	}
+}
+
+func raftReplyInts(c *GodisClient, vals ...int64) {
+	c.AddReplyArrayLen(len(vals))
+	for _, v := range vals {
+		c.AddReplyInt(v)
+	}
+}
+
+// 解析整数参数
+func getInt64Args(c *GodisClient, start, end int) ([]int64, bool) {
+	vals := make([]int64, end-start)
+	for i := range vals {
+		v, ok := getIntFromArg(c, c.args[start+i])
+		if !ok {
+			return nil, false
+		}
+		vals[i] = v
+	}
+	return vals, true
+}
+
+func checkRaftEnabled(c *GodisClient) bool {
+	if server.raft == nil {
+		c.AddReplyErrorFormat("ERR: %v", RAFT_DISABLED_ERR)
+		return false
+	}
+	return true
+}
+
+// 收到当前leader的消息
+func raftLeaderContact(term int64, leader string) {
+	r := server.raft
+	if term > r.term || r.role != RAFT_FOLLOWER {
+		raftBecomeFollower(term, leader)
+	}
+	r.leader = leader
+	r.lastLeaderContact = utils.GetMsTime()
+	raftResetElectionTimer()
+}
+
+// RAFT.REQUESTVOTE term candidate lastLogIndex lastLogTerm
+func raftRequestVoteCommand(c *GodisClient) {
+	if !checkRaftEnabled(c) {
+		return
+	}
+	vals, ok := getInt64Args(c, 1, 2)
+	if !ok {
+		return
+	}
+	last, ok := getInt64Args(c, 3, 5)
+	if !ok {
+		return
+	}
+	r := server.raft
+	term, candidate := vals[0], c.args[2].StrVal()
+	// 近期收到过leader的消息时拒绝，避免被移除的节点干扰集群
+	if term > r.term && r.leader != "" && utils.GetMsTime()-r.lastLeaderContact < RAFT_ELECTION_TIMEOUT {
+		raftReplyInts(c, r.term, 0)
+		return
+	}
+	if term > r.term {
+		raftBecomeFollower(term, "")
+	}
+	lastIndex := raftLastIndex()
+	lastTerm := raftTermAt(lastIndex)
+	upToDate := last[1] > lastTerm || (last[1] == lastTerm && last[0] >= lastIndex)
+	granted := term == r.term && (r.votedFor == "" || r.votedFor == candidate) && upToDate
+	if granted {
+		r.votedFor = candidate
+		if err := raftSaveMeta(); err != nil {
+			log.Printf("raft save meta err: %v\n", err)
+			granted = false
+		}
+		raftResetElectionTimer()
+	}
+	raftReplyInts(c, r.term, int64(boolToInt(granted)))
+}
+
+// RAFT.APPENDENTRIES term leader prevIndex prevTerm leaderCommit [term type dbid argc arg ...] ...
+func raftAppendEntriesCommand(c *GodisClient) {
+	if !checkRaftEnabled(c) {
+		return
+	}
+	vals, ok := getInt64Args(c, 1, 2)
+	if !ok {
+		return
+	}
+	head, ok := getInt64Args(c, 3, 6)
+	if !ok {
+		return
+	}
+	var entries []raftEntry
+	for i := 6; i < len(c.args); {
+		if i+4 > len(c.args) {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		ev, ok := getInt64Args(c, i, i+4)
+		if !ok {
+			return
+		}
+		i += 4
+		if ev[3] < 0 || i+int(ev[3]) > len(c.args) {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		e := raftEntry{term: ev[0], typ: int(ev[1]), dbid: int(ev[2])}
+		for _, arg := range c.args[i : i+int(ev[3])] {
+			e.args = append(e.args, arg.StrVal())
+		}
+		i += int(ev[3])
+		if e.typ == RAFT_ENTRY_CMD && raftEntryCommand(&e) == nil {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	r := server.raft
+	term, prevIndex, prevTerm, leaderCommit := vals[0], head[0], head[1], head[2]
+	if term < r.term {
+		raftReplyInts(c, r.term, 0, raftLastIndex())
+		return
+	}
+	raftLeaderContact(term, c.args[2].StrVal())
+	if prevIndex > raftLastIndex() {
+		raftReplyInts(c, r.term, 0, raftLastIndex())
+		return
+	}
+	if prevIndex >= r.snapIndex && raftTermAt(prevIndex) != prevTerm {
+		// 已提交的日志一定一致
+		raftReplyInts(c, r.term, 0, r.commitIndex)
+		return
+	}
+	index := prevIndex
+	for _, e := range entries {
+		index++
+		if index <= r.snapIndex {
+			continue
+		}
+		if index <= raftLastIndex() {
+			if raftTermAt(index) == e.term {
+				continue
+			}
+			if err := raftTruncate(index); err != nil {
+				log.Printf("raft truncate err: %v\n", err)
+				raftReplyInts(c, r.term, 0, r.commitIndex)
+				return
+			}
+		}
+		raftAppendEntry(e)
+	}
+	// 持久化后才能确认
+	if err := raftFlush(); err != nil {
+		log.Printf("raft flush err: %v\n", err)
+		raftReplyInts(c, r.term, 0, r.commitIndex)
+		return
+	}
+	if leaderCommit > r.commitIndex {
+		r.commitIndex = leaderCommit
+		if r.commitIndex > index {
+			r.commitIndex = index
+		}
+		raftCommitted()
+	}
+	raftReplyInts(c, r.term, 1, index)
+}
+
+// RAFT.INSTALLSNAPSHOT term leader lastIndex lastTerm payload [member ...]
+func raftInstallSnapshotCommand(c *GodisClient) {
+	if !checkRaftEnabled(c) {
+		return
+	}
+	vals, ok := getInt64Args(c, 1, 2)
+	if !ok {
+		return
+	}
+	snap, ok := getInt64Args(c, 3, 5)
+	if !ok {
+		return
+	}
+	r := server.raft
+	term, index, snapTerm := vals[0], snap[0], snap[1]
+	if term < r.term {
+		raftReplyInts(c, r.term, 0)
+		return
+	}
+	raftLeaderContact(term, c.args[2].StrVal())
+	if index <= r.commitIndex {
+		raftReplyInts(c, r.term, r.commitIndex)
+		return
+	}
+	// 先在内存中加载，校验通过后才写盘并替换数据
+	payload := c.args[5].StrVal()
+	dbs, err := rdbDecode(strings.NewReader(payload))
+	if err != nil {
+		log.Printf("raft load snapshot err: %v\n", err)
+		c.AddReplyError("ERR: invalid snapshot payload")
+		return
+	}
+	if err := writeFileAtomic(raftSnapshotFilename(index), []byte(payload)); err != nil {
+		log.Printf("raft save snapshot err: %v\n", err)
+		raftReplyInts(c, r.term, r.commitIndex)
+		return
+	}
+	rdbReplaceDbs(dbs)
+	// 快照之后一致的日志保留
+	if raftTermAt(index) == snapTerm {
+		r.entries = append([]raftEntry{}, r.entries[index-r.snapIndex:]...)
+	} else {
+		r.entries = nil
+	}
+	old := r.snapIndex
+	r.snapIndex = index
+	r.snapTerm = snapTerm
+	r.snapMembers = nil
+	for _, m := range c.args[6:] {
+		r.snapMembers = append(r.snapMembers, m.StrVal())
+	}
+	r.commitIndex = index
+	r.lastApplied = index
+	if err := raftSaveMeta(); err != nil {
+		log.Fatalf("raft save meta err: %v\n", err)
+	}
+	if err := raftRewriteLog(); err != nil {
+		log.Fatalf("raft rewrite log err: %v\n", err)
+	}
+	if old > 0 {
+		os.Remove(raftSnapshotFilename(old))
+	}
+	raftUpdateMembers()
+	server.dirty++
+	log.Printf("raft snapshot installed at index %v\n", index)
+	raftReplyInts(c, r.term, index)
+}
+
+// RAFT.NODE ADD|REMOVE host:port
+func raftNodeCommand(c *GodisClient) {
+	if !checkRaftEnabled(c) || !raftCheckLeader(c) {
+		return
+	}
+	r := server.raft
+	if raftLastConfigIndex() > r.commitIndex {
+		c.AddReplyErrorFormat("ERR: %v", RAFT_CONFIG_ERR)
+		return
+	}
+	addr := c.args[2].StrVal()
+	members := append([]string{}, r.members...)
+	switch strings.ToLower(c.args[1].StrVal()) {
+	case "add":
+		if raftIsMember(addr) {
+			c.AddReplyErrorFormat("ERR: %v", RAFT_DUP_MEMBER_ERR)
+			return
+		}
+		members = append(members, addr)
+	case "remove":
+		if !raftIsMember(addr) {
+			c.AddReplyErrorFormat("ERR: %v", RAFT_MEMBER_ERR)
+			return
+		}
+		for i, m := range members {
+			if m == addr {
+				members = append(members[:i], members[i+1:]...)
+				break
+			}
+		}
+	default:
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	raftAppendEntry(raftEntry{term: r.term, typ: RAFT_ENTRY_CONFIG, args: members})
+	log.Printf("raft membership change: %v\n", members)
+	c.AddReplyStatus("OK")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/obj"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRaftSingleNode(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Raft = true
+	config.RaftAddr = "127.0.0.1:7000"
+	config.RaftPeers = []string{config.RaftAddr}
+	config.RaftSnapshotThreshold = 10
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	assert.Equal(t, "-ERR: no raft leader elected\r\n", ExecQuery(client, "set k v\r\n"))
+	assert.Contains(t, ExecQuery(client, "info raft\r\n"), "raft_role:follower")
+	server.raft.electionDeadline = 0
+	raftCron()
+	assert.Equal(t, RAFT_LEADER, server.raft.role)
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set k v ex 100\r\n"))
+	for i := 0; i < 20; i++ {
+		ExecQuery(client, "incr counter\r\n")
+	}
+	ExecQuery(client, "select 2\r\n")
+	ExecQuery(client, "rpush list a b\r\n")
+	raftCron()
+	assert.Nil(t, checkRaftCompactDone(true))
+	assert.Equal(t, server.raft.commitIndex, server.raft.snapIndex)
+	assert.Equal(t, "$1\r\na\r\n", ExecQuery(client, "lpop list\r\n"))
+	assert.Equal(t, int64(1), raftLastIndex()-server.raft.snapIndex)
+
+	// 从快照及日志恢复
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	server.raft.electionDeadline = 0
+	raftCron()
+	assert.Equal(t, "$2\r\n20\r\n", ExecQuery(client, "get counter\r\n"))
+	assert.NotEqual(t, ":-1\r\n", ExecQuery(client, "ttl k\r\n"))
+	ExecQuery(client, "select 2\r\n")
+	assert.Equal(t, "*1\r\n$1\r\nb\r\n", ExecQuery(client, "lrange list 0 -1\r\n"))
+
+	// 新成员无法联系时配置不能提交，之后的回复都等待提交
+	assert.Equal(t, "", ExecQuery(client, "raft.node add 127.0.0.1:1\r\n"))
+	assert.Equal(t, "", ExecQuery(client, "rpush list c\r\n"))
+	assert.Equal(t, 1, len(server.raft.waiting))
+	// 无法联系过半节点，退出leader并回滚未提交的命令
+	raftCron()
+	assert.Equal(t, RAFT_FOLLOWER, server.raft.role)
+	assert.Nil(t, server.raft.waiting)
+	client = CreateClient(server.fd)
+	assert.Equal(t, "-ERR: no raft leader elected\r\n", ExecQuery(client, "get counter\r\n"))
+	assert.Contains(t, ExecQuery(client, "info keyspace\r\n"), "db2:keys=1,")
+	assert.Equal(t, []string{config.RaftAddr, "127.0.0.1:1"}, server.raft.members)
+}
+
+func TestRaftExpire(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Raft = true
+	config.RaftAddr = "127.0.0.1:7000"
+	config.RaftPeers = []string{config.RaftAddr}
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	server.raft.electionDeadline = 0
+	raftCron()
+	assert.Equal(t, RAFT_LEADER, server.raft.role)
+
+	// leader 记录绝对过期时间，过期时记录DEL
+	ExecQuery(client, "set k v px 1\r\n")
+	assert.Equal(t, "pxat", raftEntryAt(raftLastIndex()).args[3])
+	time.Sleep(2 * time.Millisecond)
+	activeExpireCycle()
+	assert.Equal(t, []string{"del", "k"}, raftEntryAt(raftLastIndex()).args)
+
+	// follower 应用日志时不删除过期key，等待leader的DEL
+	raftBecomeFollower(server.raft.term, "")
+	raftAppendEntry(raftEntry{term: server.raft.term, typ: RAFT_ENTRY_CMD, args: []string{"set", "k2", "v", "pxat", "1"}})
+	raftApplyTo(raftLastIndex())
+	activeExpireCycle()
+	key := obj.CreateObject(obj.GSTR, "k2")
+	assert.NotNil(t, server.dbs[0].data.Get(key))
+	assert.Nil(t, findKeyRead(server.dbs[0], key))
+	assert.NotNil(t, server.dbs[0].data.Get(key))
+	raftAppendEntry(raftEntry{term: server.raft.term, typ: RAFT_ENTRY_CMD, args: []string{"del", "k2"}})
+	raftApplyTo(raftLastIndex())
+	assert.Nil(t, server.dbs[0].data.Get(key))
+}
+
+func TestParseRaftReply(t *testing.T) {
+	vals, n, err := parseRaftReply([]byte("*3\r\n:1\r\n:-1\r\n:20\r\n*2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 18, n)
+	assert.Equal(t, []int64{1, -1, 20}, vals)
+	_, n, _ = parseRaftReply([]byte("*2\r\n:1\r\n"))
+	assert.Equal(t, 0, n)
+	_, _, err = parseRaftReply([]byte("-ERR: raft mode is not enabled\r\n"))
+	assert.NotNil(t, err)
+}
+
+// 等待出现leader，返回其下标
+func waitRaftLeader(t *testing.T, conns []*respConn) int {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for i, c := range conns {
+			if c != nil && strings.Contains(c.do(t, "info", "raft"), "raft_role:leader") {
+				return i
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("no raft leader elected")
+	return -1
+}
+
+func TestRaftProcesses(t *testing.T) {
+	bin := buildGodis(t)
+	var addrs []string
+	var ports []int
+	for i := 0; i < 4; i++ {
+		ports = append(ports, freePort(t))
+		addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", ports[i]))
+	}
+	var cmds []*exec.Cmd
+	var conns []*respConn
+	for i := 0; i < 3; i++ {
+		cmds = append(cmds, startGodis(t, bin, &conf.Config{Port: ports[i], Raft: true, RaftPeers: addrs[:3], RaftSnapshotThreshold: 20}))
+		conns = append(conns, dialGodis(t, ports[i]))
+	}
+	leader := waitRaftLeader(t, conns)
+	follower := (leader + 1) % 3
+	assert.Equal(t, "+OK\r\n", conns[leader].do(t, "set", "k", "v"))
+	waitReply(t, conns[follower], fmt.Sprintf("-REDIRECT %v\r\n", addrs[leader]), "set", "k", "v2")
+	for i := 0; i < 30; i++ {
+		conns[leader].do(t, "incr", "counter")
+	}
+	// 快照超过4KB
+	for i := 0; i < 60; i++ {
+		conns[leader].do(t, "set", fmt.Sprintf("key%d", i), strings.Repeat("v", 200))
+	}
+
+	// leader 退出后重新选举，已提交的数据不丢失
+	cmds[leader].Process.Kill()
+	conns[leader] = nil
+	leader = waitRaftLeader(t, conns)
+	assert.Equal(t, "$2\r\n30\r\n", conns[leader].do(t, "get", "counter"))
+	assert.Equal(t, "$1\r\nv\r\n", conns[leader].do(t, "get", "k"))
+
+	// 加入新节点，日志已压缩，通过快照同步
+	startGodis(t, bin, &conf.Config{Port: ports[3], Raft: true})
+	node := dialGodis(t, ports[3])
+	assert.Equal(t, "+OK\r\n", conns[leader].do(t, "raft.node", "add", addrs[3]))
+	assert.Equal(t, ":31\r\n", conns[leader].do(t, "incr", "counter"))
+	waitReply(t, node, "db0:keys=62,", "info", "keyspace")
+	assert.Contains(t, node.do(t, "info", "raft"), "raft_members:"+strings.Join(addrs, ","))
+	waitReply(t, node, fmt.Sprintf("-REDIRECT %v\r\n", addrs[leader]), "get", "counter")
+}
+
+func TestRaftInstallBadSnapshot(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Raft = true
+	config.RaftAddr = "127.0.0.1:7000"
+	config.RaftPeers = []string{config.RaftAddr}
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+
+	// 无效的快照不写盘也不修改状态
+	assert.Equal(t, "-ERR: invalid snapshot payload\r\n", ExecQuery(client, "raft.installsnapshot 1 127.0.0.1:7001 5 1 garbage\r\n"))
+	assert.Equal(t, int64(0), server.raft.snapIndex)
+	_, err := os.Stat(raftSnapshotFilename(5))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRaftRejectBadEntries(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Raft = true
+	config.RaftAddr = "127.0.0.1:7000"
+	config.RaftPeers = []string{config.RaftAddr}
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+
+	// db越界、空参数及参数个数错误的日志被拒绝
+	for _, entry := range []string{"1 1 99 2 get k", "1 1 0 0", "1 1 0 1 set"} {
+		assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "raft.appendentries 1 127.0.0.1:7001 0 0 0 "+entry+"\r\n"))
+	}
+	assert.Equal(t, int64(0), raftLastIndex())
+	assert.Equal(t, "*3\r\n:1\r\n:1\r\n:1\r\n", ExecQuery(client, "raft.appendentries 1 127.0.0.1:7001 0 0 1 1 1 0 3 set k v\r\n"))
+	assert.NotNil(t, server.dbs[0].data.Get(obj.CreateObject(obj.GSTR, "k")))
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/rdb"
+	"akt-redis/utils"
+	"akt-redis/zset"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	DEFAULT_DBFILENAME        = "dump.rdb"
+	CONFIG_BGSAVE_RETRY_DELAY = 5 // bgsave 失败后重试的间隔秒数
+)
+
+var (
+	TYPE_ERR   = errors.New("unknown object type in RDB file")
+	DBID_ERR   = errors.New("DB index out of range in RDB file")
+	BGSAVE_ERR = errors.New("background save already in progress")
+)
+
+// "save <seconds> <changes>"：seconds 秒内至少有 changes 次修改时触发BGSAVE
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+type snapshotEntry struct {
+	key    *obj.Gobj
+	val    *obj.Gobj
+	expire int64
+}
+
+type dbSnapshot struct {
+	id      int
+	entries []snapshotEntry
+}
+
+func rdbFilename() string {
+	return filepath.Join(server.dir, server.dbFilename)
+}
+
+// 在主线程中生成快照，供后台序列化使用
+// 快照只持有key及value的引用，不复制数据：string 不可变；其余类型在被修改前由 findKeyWrite 复制(写时复制)，
+// 快照期间暂停这些value内部dict的rehash，主线程的查找不会改变后台goroutine正在读取的结构
+// 已过期的key同样保存，由加载方决定是否丢弃，Raft快照与日志保持一致
+func createSnapshot() []dbSnapshot {
+	var snap []dbSnapshot
+	for _, db := range server.dbs {
+		if db.data.Size() == 0 {
+			continue
+		}
+		ds := dbSnapshot{id: db.id}
+		db.data.ForEach(func(e *dict.Entry) {
+			expire := getExpire(db, e.Key)
+			e.Key.IncrRefCount()
+			e.Val.IncrRefCount()
+			pauseObjectRehash(e.Val, true)
+			ds.entries = append(ds.entries, snapshotEntry{key: e.Key, val: e.Val, expire: expire})
+		})
+		snap = append(snap, ds)
+	}
+	return snap
+}
+
+// 只能在主线程调用，后台goroutine结束后才可释放
+func releaseSnapshot(snap []dbSnapshot) {
+	for _, ds := range snap {
+		for _, entry := range ds.entries {
+			pauseObjectRehash(entry.val, false)
+			entry.key.DecrRefCount()
+			entry.val.DecrRefCount()
+		}
+	}
+}
+
+func pauseObjectRehash(o *obj.Gobj, pause bool) {
+	switch o.Type_ {
+	case obj.GSET, obj.GDICT:
+		if pause {
+			o.Val_.(*dict.Dict).PauseRehash()
+		} else {
+			o.Val_.(*dict.Dict).ResumeRehash()
+		}
+	case obj.GZSET:
+		if pause {
+			o.Val_.(*zset.ZSet).PauseRehash()
+		} else {
+			o.Val_.(*zset.ZSet).ResumeRehash()
+		}
+	}
+}
+
+// 遍历快照中的dict，非安全迭代器不修改dict，可在后台goroutine中使用
+func snapshotForEach(d *dict.Dict, fn func(e *dict.Entry)) {
+	it := d.GetIterator()
+	for e := it.Next(); e != nil; e = it.Next() {
+		fn(e)
+	}
+	it.Release()
+}
+
+func rdbSaveObject(enc *rdb.Encoder, o *obj.Gobj) error {
+	switch o.Type_ {
+	case obj.GSTR:
+		return enc.SaveString(o.StrVal())
+	case obj.GLIST:
+		l := o.Val_.(*list.List)
+		if err := enc.SaveLen(uint64(l.Length())); err != nil {
+			return err
+		}
+		for n := l.First(); n != nil; n = n.Next() {
+			if err := enc.SaveString(n.Val.StrVal()); err != nil {
+				return err
+			}
+		}
+	case obj.GSET, obj.GDICT:
+		d := o.Val_.(*dict.Dict)
+		if err := enc.SaveLen(uint64(d.Size())); err != nil {
+			return err
+		}
+		var err error
+		snapshotForEach(d, func(e *dict.Entry) {
+			if err != nil {
+				return
+			}
+			if err = enc.SaveString(e.Key.StrVal()); err == nil && o.Type_ == obj.GDICT {
+				err = enc.SaveString(e.Val.StrVal())
+			}
+		})
+		return err
+	case obj.GZSET:
+		zs := o.Val_.(*zset.ZSet)
+		if err := enc.SaveLen(uint64(zs.Len())); err != nil {
+			return err
+		}
+		for n := zs.First(); n != nil; n = n.Next() {
+			if err := enc.SaveString(n.Member.StrVal()); err != nil {
+				return err
+			}
+			if err := enc.SaveDouble(n.Score); err != nil {
+				return err
+			}
+		}
+	default:
+		return TYPE_ERR
+	}
+	return nil
+}
+
+// 先写入临时文件，完成后rename，保证文件始终完整
+func rdbSaveSnapshot(filename string, snap []dbSnapshot) error {
+	tmpfile := filepath.Join(filepath.Dir(filename), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	err = rdbWriteSnapshot(f, snap)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	return os.Rename(tmpfile, filename)
+}
+
+// 写入临时文件后rename，保证文件完整
+func writeFileAtomic(filename string, data []byte) error {
+	tmpfile := filename + ".tmp"
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+	return os.Rename(tmpfile, filename)
+}
+
+func rdbWriteSnapshot(w io.Writer, snap []dbSnapshot) error {
+	enc := rdb.NewEncoder(w)
+	if err := enc.SaveHeader(); err != nil {
+		return err
+	}
+	for _, ds := range snap {
+		if err := enc.SaveType(rdb.RDB_OPCODE_SELECTDB); err != nil {
+			return err
+		}
+		if err := enc.SaveLen(uint64(ds.id)); err != nil {
+			return err
+		}
+		for _, entry := range ds.entries {
+			if entry.expire != -1 {
+				if err := enc.SaveType(rdb.RDB_OPCODE_EXPIRETIME_MS); err != nil {
+					return err
+				}
+				if err := enc.SaveMillisecondTime(entry.expire); err != nil {
+					return err
+				}
+			}
+			if err := enc.SaveType(byte(entry.val.Type_)); err != nil {
+				return err
+			}
+			if err := enc.SaveString(entry.key.StrVal()); err != nil {
+				return err
+			}
+			if err := rdbSaveObject(enc, entry.val); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.SaveFooter()
+}
+
+func rdbLoadObject(dec *rdb.Decoder, typ obj.Gtype) (*obj.Gobj, error) {
+	if typ == obj.GSTR {
+		s, err := dec.LoadString()
+		if err != nil {
+			return nil, err
+		}
+		return obj.CreateObject(obj.GSTR, s), nil
+	}
+	var o *obj.Gobj
+	switch typ {
+	case obj.GLIST:
+		o = createListObject()
+	case obj.GSET:
+		o = createSetObject()
+	case obj.GDICT:
+		o = createHashObject()
+	case obj.GZSET:
+		o = createZsetObject()
+	default:
+		return nil, TYPE_ERR
+	}
+	n, err := dec.LoadLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		s, err := dec.LoadString()
+		if err != nil {
+			return nil, err
+		}
+		ele := obj.CreateObject(obj.GSTR, s)
+		switch typ {
+		case obj.GLIST:
+			listPush(o.Val_.(*list.List), ele, LIST_TAIL)
+		case obj.GSET:
+			setAdd(o.Val_.(*dict.Dict), ele)
+		case obj.GDICT:
+			v, err := dec.LoadString()
+			if err != nil {
+				return nil, err
+			}
+			val := obj.CreateObject(obj.GSTR, v)
+			o.Val_.(*dict.Dict).Set(ele, val)
+			val.DecrRefCount()
+		case obj.GZSET:
+			score, err := dec.LoadDouble()
+			if err != nil {
+				return nil, err
+			}
+			o.Val_.(*zset.ZSet).Add(score, ele, 0)
+		}
+		ele.DecrRefCount()
+	}
+	return o, nil
+}
+
+// 启动时加载快照，文件不存在时忽略
+func rdbLoad(filename string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rdbLoadFrom(f)
+}
+
+// 从r加载快照，也用于从节点加载主节点发送的快照
+// 先加载到临时db，校验和通过后才替换全部db的数据，失败时原数据不变
+func rdbLoadFrom(r io.Reader) error {
+	dbs, err := rdbDecode(r)
+	if err != nil {
+		return err
+	}
+	rdbReplaceDbs(dbs)
+	return nil
+}
+
+// 用加载好的临时db替换全部db的数据
+func rdbReplaceDbs(dbs []*GodisDB) {
+	loaded := 0
+	for i, db := range server.dbs {
+		db.data, db.expire, db.slotKeys = dbs[i].data, dbs[i].expire, dbs[i].slotKeys
+		loaded += int(db.data.Size())
+		scanDatabaseForReadyKeys(db)
+	}
+	log.Printf("DB loaded: %v keys\n", loaded)
+}
+
+// 将快照解码到临时db，不修改当前数据
+func rdbDecode(r io.Reader) ([]*GodisDB, error) {
+	dec := rdb.NewDecoder(r)
+	if _, err := dec.LoadHeader(); err != nil {
+		return nil, err
+	}
+	dbs := make([]*GodisDB, len(server.dbs))
+	for i := range dbs {
+		dbs[i] = &GodisDB{id: i, data: createKeyDict(), expire: createKeyDict()}
+		if server.dbs[i].slotKeys != nil {
+			dbs[i].slotKeys = createSlotKeys()
+		}
+	}
+	db := dbs[0]
+	now := utils.GetMsTime()
+	var expire int64 = -1
+	for {
+		typ, err := dec.LoadType()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case rdb.RDB_OPCODE_EXPIRETIME_MS:
+			if expire, err = dec.LoadMillisecondTime(); err != nil {
+				return nil, err
+			}
+			continue
+		case rdb.RDB_OPCODE_SELECTDB:
+			id, err := dec.LoadLen()
+			if err != nil {
+				return nil, err
+			}
+			if id >= uint64(len(dbs)) {
+				return nil, DBID_ERR
+			}
+			db = dbs[id]
+			continue
+		case rdb.RDB_OPCODE_EOF:
+			if err := dec.VerifyChecksum(); err != nil {
+				return nil, err
+			}
+			return dbs, nil
+		}
+		k, err := dec.LoadString()
+		if err != nil {
+			return nil, err
+		}
+		val, err := rdbLoadObject(dec, obj.Gtype(typ))
+		if err != nil {
+			return nil, err
+		}
+		// 已过期的key不再加载，从节点及Raft模式仍然加载，等待主节点或leader传播的DEL
+		if expire == -1 || expire >= now || server.masterHost != "" || server.raft != nil {
+			key := obj.CreateObject(obj.GSTR, k)
+			setKey(db, key, val)
+			if expire != -1 {
+				setExpire(db, key, expire)
+			}
+			key.DecrRefCount()
+		}
+		val.DecrRefCount()
+		expire = -1
+	}
+}
+
+// 同步保存
+func rdbSave() error {
+	if server.rdbBgsaveDone != nil {
+		return BGSAVE_ERR
+	}
+	snap := createSnapshot()
+	err := rdbSaveSnapshot(rdbFilename(), snap)
+	releaseSnapshot(snap)
+	if err != nil {
+		log.Printf("failed saving the DB: %v\n", err)
+		return err
+	}
+	log.Println("DB saved on disk")
+	server.dirty = 0
+	server.lastSave = time.Now().Unix()
+	server.lastBgsaveOk = true
+	return nil
+}
+
+// 在主线程中生成快照后，由goroutine完成序列化及写盘，不阻塞ae循环
+func rdbSaveBackground() error {
+	if server.rdbBgsaveDone != nil {
+		return BGSAVE_ERR
+	}
+	server.dirtyBeforeBgsave = server.dirty
+	server.lastBgsaveTry = time.Now().Unix()
+	snap := createSnapshot()
+	done := make(chan error, 1)
+	filename := rdbFilename()
+	go func() {
+		done <- rdbSaveSnapshot(filename, snap)
+	}()
+	server.rdbSnapshot = snap
+	server.rdbBgsaveDone = done
+	log.Println("background saving started")
+	return nil
+}
+
+// BGSAVE 完成后的处理，block 为true时等待完成
+func checkBgsaveDone(block bool) {
+	if server.rdbBgsaveDone == nil {
+		return
+	}
+	var err error
+	if block {
+		err = <-server.rdbBgsaveDone
+	} else {
+		select {
+		case err = <-server.rdbBgsaveDone:
+		default:
+			return
+		}
+	}
+	releaseSnapshot(server.rdbSnapshot)
+	server.rdbSnapshot = nil
+	server.rdbBgsaveDone = nil
+	if err != nil {
+		log.Printf("background saving error: %v\n", err)
+		server.lastBgsaveOk = false
+		return
+	}
+	log.Println("background saving terminated with success")
+	server.dirty -= server.dirtyBeforeBgsave
+	server.lastSave = time.Now().Unix()
+	server.lastBgsaveOk = true
+}
+
+// 满足任意save规则时触发BGSAVE，上次失败时等待 CONFIG_BGSAVE_RETRY_DELAY 秒后再重试
+func rdbCron() {
+	checkBgsaveDone(false)
+	if server.rdbBgsaveDone != nil {
+		return
+	}
+	now := time.Now().Unix()
+	for _, sp := range server.saveParams {
+		if server.dirty >= sp.changes && now-server.lastSave > sp.seconds &&
+			(server.lastBgsaveOk || now-server.lastBgsaveTry > CONFIG_BGSAVE_RETRY_DELAY) {
+			log.Printf("%v changes in %v seconds. Saving...\n", sp.changes, sp.seconds)
+			rdbSaveBackground()
+			return
+		}
+	}
+}
+
+// SAVE
+func saveCommand(c *GodisClient) {
+	if err := rdbSave(); err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	c.AddReplyStatus("OK")
+}
+
+// BGSAVE
+func bgsaveCommand(c *GodisClient) {
+	if err := rdbSaveBackground(); err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	c.AddReplyStatus("Background saving started")
+}
+
+// LASTSAVE
+func lastsaveCommand(c *GodisClient) {
+	c.AddReplyInt(server.lastSave)
+}
@@ -0,0 +1,49 @@
+package rdb
+
+import "errors"
+
+var LZF_ERR = errors.New("invalid LZF compressed string")
+
+// 解压 LZF 数据，Redis 对较长的string使用LZF压缩
+// 控制字节 < 32 时后接 ctrl+1 字节的字面量，否则为对已解压数据的回溯引用
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			ctrl++
+			if i+ctrl > len(in) || len(out)+ctrl > outLen {
+				return nil, LZF_ERR
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, LZF_ERR
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, LZF_ERR
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - 1 - int(in[i])
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, LZF_ERR
+		}
+		// 引用区间可能与输出重叠，逐字节复制
+		for k := 0; k < length; k++ {
+			out = append(out, out[ref+k])
+		}
+	}
+	if len(out) != outLen {
+		return nil, LZF_ERR
+	}
+	return out, nil
+}
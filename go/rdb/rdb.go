@@ -0,0 +1,205 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+)
+
+// 快照文件格式：
+// "GODIS" + 4位版本号
+// [SELECTDB dbid] [EXPIRETIME_MS ms] type key value ...
+// EOF + 8字节 CRC64 (小端序，覆盖之前全部内容)
+// 长度使用 uvarint 编码，string 为 长度+内容，double 为8字节小端序
+
+const (
+	RDB_MAGIC   = "GODIS"
+	RDB_VERSION = 1
+)
+
+const (
+	RDB_OPCODE_EXPIRETIME_MS byte = 0xFC
+	RDB_OPCODE_SELECTDB      byte = 0xFE
+	RDB_OPCODE_EOF           byte = 0xFF
+)
+
+var (
+	MAGIC_ERR    = errors.New("wrong signature trying to load DB from file")
+	VERSION_ERR  = errors.New("can't handle RDB format version")
+	CHECKSUM_ERR = errors.New("wrong RDB checksum")
+	LEN_ERR      = errors.New("invalid length in RDB file")
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+type Encoder struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	buf [binary.MaxVarintLen64]byte
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:   bufio.NewWriter(w),
+		crc: crc64.New(crcTable),
+	}
+}
+
+func (e *Encoder) write(p []byte) error {
+	e.crc.Write(p)
+	_, err := e.w.Write(p)
+	return err
+}
+
+func (e *Encoder) SaveHeader() error {
+	return e.write([]byte(fmt.Sprintf("%v%04d", RDB_MAGIC, RDB_VERSION)))
+}
+
+func (e *Encoder) SaveType(t byte) error {
+	return e.write([]byte{t})
+}
+
+func (e *Encoder) SaveLen(n uint64) error {
+	size := binary.PutUvarint(e.buf[:], n)
+	return e.write(e.buf[:size])
+}
+
+func (e *Encoder) SaveString(s string) error {
+	if err := e.SaveLen(uint64(len(s))); err != nil {
+		return err
+	}
+	return e.write([]byte(s))
+}
+
+func (e *Encoder) SaveDouble(f float64) error {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(f))
+	return e.write(e.buf[:8])
+}
+
+func (e *Encoder) SaveMillisecondTime(ms int64) error {
+	binary.LittleEndian.PutUint64(e.buf[:8], uint64(ms))
+	return e.write(e.buf[:8])
+}
+
+// 写入EOF及校验和，并flush
+func (e *Encoder) SaveFooter() error {
+	if err := e.SaveType(RDB_OPCODE_EOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc.Sum64())
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+type Decoder struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:   bufio.NewReader(r),
+		crc: crc64.New(crcTable),
+	}
+}
+
+func (d *Decoder) read(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return err
+	}
+	d.crc.Write(p)
+	return nil
+}
+
+// 读取并校验文件头，返回版本号
+func (d *Decoder) LoadHeader() (int, error) {
+	buf := make([]byte, len(RDB_MAGIC)+4)
+	if err := d.read(buf); err != nil {
+		return 0, err
+	}
+	if string(buf[:len(RDB_MAGIC)]) != RDB_MAGIC {
+		return 0, MAGIC_ERR
+	}
+	var version int
+	if _, err := fmt.Sscanf(string(buf[len(RDB_MAGIC):]), "%04d", &version); err != nil {
+		return 0, MAGIC_ERR
+	}
+	if version < 1 || version > RDB_VERSION {
+		return 0, VERSION_ERR
+	}
+	return version, nil
+}
+
+func (d *Decoder) LoadType() (byte, error) {
+	var buf [1]byte
+	err := d.read(buf[:])
+	return buf[0], err
+}
+
+func (d *Decoder) LoadLen() (uint64, error) {
+	var n uint64
+	var shift uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := d.LoadType()
+		if err != nil {
+			return 0, err
+		}
+		n |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return n, nil
+		}
+		shift += 7
+	}
+	return 0, LEN_ERR
+}
+
+func (d *Decoder) LoadString() (string, error) {
+	n, err := d.LoadLen()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt32 {
+		return "", LEN_ERR
+	}
+	buf := make([]byte, n)
+	if err := d.read(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (d *Decoder) LoadDouble() (float64, error) {
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func (d *Decoder) LoadMillisecondTime() (int64, error) {
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+// 读取EOF后的校验和并与已读取内容比较
+func (d *Decoder) VerifyChecksum() error {
+	expected := d.crc.Sum64()
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(buf[:]) != expected {
+		return CHECKSUM_ERR
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package rdb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	assert.Nil(t, enc.SaveHeader())
+	assert.Nil(t, enc.SaveType(RDB_OPCODE_SELECTDB))
+	assert.Nil(t, enc.SaveLen(300))
+	assert.Nil(t, enc.SaveString("hello"))
+	assert.Nil(t, enc.SaveDouble(-1.5))
+	assert.Nil(t, enc.SaveMillisecondTime(1700000000000))
+	assert.Nil(t, enc.SaveFooter())
+
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	version, err := dec.LoadHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, RDB_VERSION, version)
+	typ, _ := dec.LoadType()
+	assert.Equal(t, RDB_OPCODE_SELECTDB, typ)
+	n, _ := dec.LoadLen()
+	assert.Equal(t, uint64(300), n)
+	s, _ := dec.LoadString()
+	assert.Equal(t, "hello", s)
+	f, _ := dec.LoadDouble()
+	assert.Equal(t, -1.5, f)
+	ms, _ := dec.LoadMillisecondTime()
+	assert.Equal(t, int64(1700000000000), ms)
+	typ, _ = dec.LoadType()
+	assert.Equal(t, RDB_OPCODE_EOF, typ)
+	assert.Nil(t, dec.VerifyChecksum())
+
+	// 内容被修改时校验失败
+	data := buf.Bytes()
+	data[14] ^= 0xff
+	dec = NewDecoder(bytes.NewReader(data))
+	dec.LoadHeader()
+	dec.LoadType()
+	dec.LoadLen()
+	dec.LoadString()
+	dec.LoadDouble()
+	dec.LoadMillisecondTime()
+	dec.LoadType()
+	assert.Equal(t, CHECKSUM_ERR, dec.VerifyChecksum())
+
+	dec = NewDecoder(bytes.NewReader([]byte("REDIS0009")))
+	_, err = dec.LoadHeader()
+	assert.Equal(t, MAGIC_ERR, err)
+}
@@ -0,0 +1,742 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Redis 6~7 生成的 dump.rdb (RDB 版本 <= 12)：
+// "REDIS" + 4位版本号，之后为 AUX 等opcode及 type key value，EOF 后为8字节 CRC64 (Jones多项式，小端序，0表示未计算)
+// 长度的前2位表示编码方式，ziplist/listpack/intset 等紧凑编码以string形式保存，需要再次解析
+
+const (
+	REDIS_RDB_MAGIC       = "REDIS"
+	REDIS_RDB_VERSION_MAX = 12
+	REDIS_MAX_BULK_LEN    = 512 * 1024 * 1024
+)
+
+// 逻辑类型，与 obj.Gtype 的取值一致
+const (
+	REDIS_RDB_TYPE_STRING byte = 0
+	REDIS_RDB_TYPE_LIST   byte = 1
+	REDIS_RDB_TYPE_SET    byte = 2
+	REDIS_RDB_TYPE_ZSET   byte = 3
+	REDIS_RDB_TYPE_HASH   byte = 4
+)
+
+// 编码类型
+const (
+	redisTypeZset2          byte = 5
+	redisTypeHashZipmap     byte = 9
+	redisTypeListZiplist    byte = 10
+	redisTypeSetIntset      byte = 11
+	redisTypeZsetZiplist    byte = 12
+	redisTypeHashZiplist    byte = 13
+	redisTypeListQuicklist  byte = 14
+	redisTypeHashListpack   byte = 16
+	redisTypeZsetListpack   byte = 17
+	redisTypeListQuicklist2 byte = 18
+	redisTypeSetListpack    byte = 20
+)
+
+const (
+	redisOpcodeSlotInfo     byte = 244
+	redisOpcodeFunction2    byte = 245
+	redisOpcodeIdle         byte = 248
+	redisOpcodeFreq         byte = 249
+	redisOpcodeAux          byte = 250
+	redisOpcodeResizeDB     byte = 251
+	redisOpcodeExpireTimeMs byte = 252
+	redisOpcodeExpireTime   byte = 253
+	redisOpcodeSelectDB     byte = 254
+	redisOpcodeEOF          byte = 255
+)
+
+// 长度编码
+const (
+	redisLen6Bit  = 0
+	redisLen14Bit = 1
+	redisLen32Bit = 0x80
+	redisLen64Bit = 0x81
+	redisEncVal   = 3
+	redisEncInt8  = 0
+	redisEncInt16 = 1
+	redisEncInt32 = 2
+	redisEncLzf   = 3
+)
+
+// quicklist 2 中的节点类型
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+var (
+	REDIS_MAGIC_ERR = errors.New("wrong signature trying to load Redis RDB file")
+	REDIS_TYPE_ERR  = errors.New("unsupported object type in Redis RDB file")
+	REDIS_OP_ERR    = errors.New("unsupported opcode in Redis RDB file")
+	ENCODING_ERR    = errors.New("invalid encoding in Redis RDB file")
+)
+
+// Redis 使用的 crc64 (Jones 多项式，反射输入输出，初值及结果均不取反)
+var redisCrcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func redisCrc64(crc uint64, p []byte) uint64 {
+	// crc64.Update 在开始和结束时各取反一次，这里抵消掉
+	return ^crc64.Update(^crc, redisCrcTable, p)
+}
+
+// Redis RDB 中的一个key
+type RedisEntry struct {
+	DB     int
+	Key    string
+	Expire int64 // 毫秒时间戳，-1 表示没有过期时间
+	Type   byte  // REDIS_RDB_TYPE_*
+	// string 为1个元素；list/set 为全部元素；hash 为 field value 交替；zset 为成员
+	Values []string
+	Scores []float64 // zset 成员对应的分数
+}
+
+type RedisDecoder struct {
+	r   *bufio.Reader
+	crc uint64
+}
+
+func NewRedisDecoder(r io.Reader) *RedisDecoder {
+	return &RedisDecoder{r: bufio.NewReader(r)}
+}
+
+func (d *RedisDecoder) read(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.crc = redisCrc64(d.crc, p)
+	return nil
+}
+
+func (d *RedisDecoder) readByte() (byte, error) {
+	var buf [1]byte
+	err := d.read(buf[:])
+	return buf[0], err
+}
+
+// 返回长度，encoded 为true时表示特殊编码的string，n 为编码类型
+func (d *RedisDecoder) loadLen() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case redisLen6Bit:
+		return uint64(b & 0x3f), false, nil
+	case redisLen14Bit:
+		b2, err := d.readByte()
+		return uint64(b&0x3f)<<8 | uint64(b2), false, err
+	case redisEncVal:
+		return uint64(b & 0x3f), true, nil
+	}
+	var buf [8]byte
+	switch b {
+	case redisLen32Bit:
+		err = d.read(buf[:4])
+		return uint64(binary.BigEndian.Uint32(buf[:4])), false, err
+	case redisLen64Bit:
+		err = d.read(buf[:])
+		return binary.BigEndian.Uint64(buf[:]), false, err
+	}
+	return 0, false, ENCODING_ERR
+}
+
+func (d *RedisDecoder) loadLength() (uint64, error) {
+	n, encoded, err := d.loadLen()
+	if err == nil && encoded {
+		err = ENCODING_ERR
+	}
+	return n, err
+}
+
+func (d *RedisDecoder) loadBytes(n uint64) ([]byte, error) {
+	if n > REDIS_MAX_BULK_LEN {
+		return nil, LEN_ERR
+	}
+	buf := make([]byte, n)
+	err := d.read(buf)
+	return buf, err
+}
+
+func (d *RedisDecoder) loadString() (string, error) {
+	n, encoded, err := d.loadLen()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.loadBytes(n)
+		return string(buf), err
+	}
+	var buf [4]byte
+	switch n {
+	case redisEncInt8:
+		err = d.read(buf[:1])
+		return strconv.FormatInt(int64(int8(buf[0])), 10), err
+	case redisEncInt16:
+		err = d.read(buf[:2])
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(buf[:2]))), 10), err
+	case redisEncInt32:
+		err = d.read(buf[:4])
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(buf[:4]))), 10), err
+	case redisEncLzf:
+		clen, err := d.loadLength()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := d.loadLength()
+		if err != nil {
+			return "", err
+		}
+		if ulen > REDIS_MAX_BULK_LEN {
+			return "", LEN_ERR
+		}
+		in, err := d.loadBytes(clen)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(in, int(ulen))
+		return string(out), err
+	}
+	return "", ENCODING_ERR
+}
+
+// ZSET 中以字符串保存的分数，253/254/255 分别表示 nan/+inf/-inf
+func (d *RedisDecoder) loadDoubleValue() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.loadBytes(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// ZSET_2 中的8字节二进制分数
+func (d *RedisDecoder) loadBinaryDouble() (float64, error) {
+	var buf [8]byte
+	if err := d.read(buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func (d *RedisDecoder) loadStrings(n uint64) ([]string, error) {
+	var vals []string
+	for i := uint64(0); i < n; i++ {
+		s, err := d.loadString()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, s)
+	}
+	return vals, nil
+}
+
+// 从 ziplist/listpack 解析出的 member score 交替序列中分离分数
+func splitScores(e *RedisEntry, items []string) error {
+	if len(items)%2 != 0 {
+		return ENCODING_ERR
+	}
+	for i := 0; i < len(items); i += 2 {
+		score, err := strconv.ParseFloat(items[i+1], 64)
+		if err != nil {
+			return ENCODING_ERR
+		}
+		e.Values = append(e.Values, items[i])
+		e.Scores = append(e.Scores, score)
+	}
+	return nil
+}
+
+// 读取 typ 类型的值，填充到 e
+func (d *RedisDecoder) loadObject(e *RedisEntry, typ byte) error {
+	var err error
+	switch typ {
+	case REDIS_RDB_TYPE_STRING:
+		var s string
+		s, err = d.loadString()
+		e.Values = []string{s}
+	case REDIS_RDB_TYPE_LIST, REDIS_RDB_TYPE_SET, REDIS_RDB_TYPE_HASH:
+		var n uint64
+		if n, err = d.loadLength(); err != nil {
+			return err
+		}
+		if typ == REDIS_RDB_TYPE_HASH {
+			n *= 2
+		}
+		e.Values, err = d.loadStrings(n)
+	case REDIS_RDB_TYPE_ZSET, redisTypeZset2:
+		var n uint64
+		if n, err = d.loadLength(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n && err == nil; i++ {
+			var member string
+			var score float64
+			if member, err = d.loadString(); err != nil {
+				return err
+			}
+			if typ == redisTypeZset2 {
+				score, err = d.loadBinaryDouble()
+			} else {
+				score, err = d.loadDoubleValue()
+			}
+			e.Values = append(e.Values, member)
+			e.Scores = append(e.Scores, score)
+		}
+		typ = REDIS_RDB_TYPE_ZSET
+	case redisTypeListQuicklist, redisTypeListQuicklist2:
+		var n uint64
+		if n, err = d.loadLength(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			container := uint64(quicklistNodePacked)
+			if typ == redisTypeListQuicklist2 {
+				if container, err = d.loadLength(); err != nil {
+					return err
+				}
+			}
+			blob, err := d.loadString()
+			if err != nil {
+				return err
+			}
+			var items []string
+			switch {
+			case container == quicklistNodePlain:
+				items = []string{blob}
+			case container != quicklistNodePacked:
+				return ENCODING_ERR
+			case typ == redisTypeListQuicklist:
+				items, err = parseZiplist([]byte(blob))
+			default:
+				items, err = parseListpack([]byte(blob))
+			}
+			if err != nil {
+				return err
+			}
+			e.Values = append(e.Values, items...)
+		}
+		typ = REDIS_RDB_TYPE_LIST
+	case redisTypeListZiplist, redisTypeSetIntset, redisTypeSetListpack,
+		redisTypeHashZiplist, redisTypeHashListpack, redisTypeHashZipmap,
+		redisTypeZsetZiplist, redisTypeZsetListpack:
+		var blob string
+		if blob, err = d.loadString(); err != nil {
+			return err
+		}
+		var items []string
+		switch typ {
+		case redisTypeListZiplist, redisTypeHashZiplist, redisTypeZsetZiplist:
+			items, err = parseZiplist([]byte(blob))
+		case redisTypeSetListpack, redisTypeHashListpack, redisTypeZsetListpack:
+			items, err = parseListpack([]byte(blob))
+		case redisTypeSetIntset:
+			items, err = parseIntset([]byte(blob))
+		case redisTypeHashZipmap:
+			items, err = parseZipmap([]byte(blob))
+		}
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case redisTypeListZiplist:
+			typ = REDIS_RDB_TYPE_LIST
+		case redisTypeSetIntset, redisTypeSetListpack:
+			typ = REDIS_RDB_TYPE_SET
+		case redisTypeZsetZiplist, redisTypeZsetListpack:
+			e.Type = REDIS_RDB_TYPE_ZSET
+			return splitScores(e, items)
+		default:
+			typ = REDIS_RDB_TYPE_HASH
+			if len(items)%2 != 0 {
+				return ENCODING_ERR
+			}
+		}
+		e.Values = items
+	default:
+		// stream 及 module 类型
+		return REDIS_TYPE_ERR
+	}
+	e.Type = typ
+	return err
+}
+
+// 读取并校验文件头，返回版本号
+func (d *RedisDecoder) LoadHeader() (int, error) {
+	buf := make([]byte, len(REDIS_RDB_MAGIC)+4)
+	if err := d.read(buf); err != nil {
+		return 0, err
+	}
+	if string(buf[:len(REDIS_RDB_MAGIC)]) != REDIS_RDB_MAGIC {
+		return 0, REDIS_MAGIC_ERR
+	}
+	version, err := strconv.Atoi(string(buf[len(REDIS_RDB_MAGIC):]))
+	if err != nil {
+		return 0, REDIS_MAGIC_ERR
+	}
+	if version < 1 || version > REDIS_RDB_VERSION_MAX {
+		return 0, VERSION_ERR
+	}
+	return version, nil
+}
+
+// 依次读取全部key并交给fn处理，fn 返回错误时停止
+func (d *RedisDecoder) Load(fn func(e *RedisEntry) error) error {
+	version, err := d.LoadHeader()
+	if err != nil {
+		return err
+	}
+	db := 0
+	var expire int64 = -1
+	for {
+		typ, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		var buf [8]byte
+		switch typ {
+		case redisOpcodeExpireTimeMs:
+			if err := d.read(buf[:]); err != nil {
+				return err
+			}
+			expire = int64(binary.LittleEndian.Uint64(buf[:]))
+			continue
+		case redisOpcodeExpireTime:
+			if err := d.read(buf[:4]); err != nil {
+				return err
+			}
+			expire = int64(binary.LittleEndian.Uint32(buf[:4])) * 1000
+			continue
+		case redisOpcodeSelectDB:
+			id, err := d.loadLength()
+			if err != nil {
+				return err
+			}
+			db = int(id)
+			continue
+		case redisOpcodeResizeDB:
+			if _, err := d.loadLength(); err != nil {
+				return err
+			}
+			if _, err := d.loadLength(); err != nil {
+				return err
+			}
+			continue
+		case redisOpcodeSlotInfo:
+			// slot id, slot size, expires slot size
+			for i := 0; i < 3; i++ {
+				if _, err := d.loadLength(); err != nil {
+					return err
+				}
+			}
+			continue
+		case redisOpcodeAux:
+			if _, err := d.loadString(); err != nil {
+				return err
+			}
+			if _, err := d.loadString(); err != nil {
+				return err
+			}
+			continue
+		case redisOpcodeFunction2:
+			// 函数库代码，不支持，跳过
+			if _, err := d.loadString(); err != nil {
+				return err
+			}
+			continue
+		case redisOpcodeIdle:
+			if _, err := d.loadLength(); err != nil {
+				return err
+			}
+			continue
+		case redisOpcodeFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+			continue
+		case redisOpcodeEOF:
+			if version < 5 {
+				return nil
+			}
+			return d.verifyChecksum()
+		}
+		if typ >= redisOpcodeSlotInfo {
+			return REDIS_OP_ERR
+		}
+		key, err := d.loadString()
+		if err != nil {
+			return err
+		}
+		e := &RedisEntry{DB: db, Key: key, Expire: expire}
+		if err := d.loadObject(e, typ); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		expire = -1
+	}
+}
+
+func (d *RedisDecoder) verifyChecksum() error {
+	expected := d.crc
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[:]); err != nil {
+		return err
+	}
+	// 关闭 rdbchecksum 时校验和为0
+	if sum := binary.LittleEndian.Uint64(buf[:]); sum != 0 && sum != expected {
+		return CHECKSUM_ERR
+	}
+	return nil
+}
+
+// ziplist: zlbytes(4) zltail(4) zllen(2) entries 0xFF
+// entry: prevlen(1或5字节) encoding data
+func parseZiplist(b []byte) ([]string, error) {
+	if len(b) < 11 {
+		return nil, ENCODING_ERR
+	}
+	var items []string
+	pos := 10
+	for {
+		if pos >= len(b) {
+			return nil, ENCODING_ERR
+		}
+		if b[pos] == 0xff {
+			return items, nil
+		}
+		if b[pos] < 254 {
+			pos++
+		} else {
+			pos += 5
+		}
+		if pos >= len(b) {
+			return nil, ENCODING_ERR
+		}
+		enc := b[pos]
+		var strLen, intLen int
+		switch enc >> 6 {
+		case 0:
+			strLen, pos = int(enc&0x3f), pos+1
+		case 1:
+			if pos+2 > len(b) {
+				return nil, ENCODING_ERR
+			}
+			strLen, pos = int(enc&0x3f)<<8|int(b[pos+1]), pos+2
+		case 2:
+			if pos+5 > len(b) {
+				return nil, ENCODING_ERR
+			}
+			strLen, pos = int(binary.BigEndian.Uint32(b[pos+1:pos+5])), pos+5
+		default:
+			pos++
+			switch enc {
+			case 0xc0:
+				intLen = 2
+			case 0xd0:
+				intLen = 4
+			case 0xe0:
+				intLen = 8
+			case 0xf0:
+				intLen = 3
+			case 0xfe:
+				intLen = 1
+			default:
+				// 1111xxxx: 直接保存 0~12
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, ENCODING_ERR
+				}
+				items = append(items, strconv.Itoa(int(enc&0x0f)-1))
+				continue
+			}
+		}
+		if intLen > 0 {
+			if pos+intLen > len(b) {
+				return nil, ENCODING_ERR
+			}
+			items = append(items, strconv.FormatInt(littleEndianInt(b[pos:pos+intLen]), 10))
+			pos += intLen
+			continue
+		}
+		if strLen < 0 || pos+strLen > len(b) {
+			return nil, ENCODING_ERR
+		}
+		items = append(items, string(b[pos:pos+strLen]))
+		pos += strLen
+	}
+}
+
+// listpack: total-bytes(4) num-elements(2) entries 0xFF
+// entry: encoding data backlen，backlen 为 encoding+data 的长度，占1~5字节
+func parseListpack(b []byte) ([]string, error) {
+	if len(b) < 7 {
+		return nil, ENCODING_ERR
+	}
+	var items []string
+	pos := 6
+	for {
+		if pos >= len(b) {
+			return nil, ENCODING_ERR
+		}
+		enc := b[pos]
+		if enc == 0xff {
+			return items, nil
+		}
+		var hdr, strLen, intLen int
+		isStr := false
+		switch {
+		case enc&0x80 == 0:
+			items = append(items, strconv.Itoa(int(enc)))
+			hdr = 1
+		case enc&0xc0 == 0x80:
+			hdr, strLen, isStr = 1, int(enc&0x3f), true
+		case enc&0xe0 == 0xc0:
+			if pos+2 > len(b) {
+				return nil, ENCODING_ERR
+			}
+			// 13位有符号整数
+			v := int(enc&0x1f)<<8 | int(b[pos+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			items = append(items, strconv.Itoa(v))
+			hdr = 2
+		case enc&0xf0 == 0xe0:
+			if pos+2 > len(b) {
+				return nil, ENCODING_ERR
+			}
+			hdr, strLen, isStr = 2, int(enc&0x0f)<<8|int(b[pos+1]), true
+		case enc == 0xf0:
+			if pos+5 > len(b) {
+				return nil, ENCODING_ERR
+			}
+			hdr, strLen, isStr = 5, int(binary.LittleEndian.Uint32(b[pos+1:pos+5])), true
+		case enc >= 0xf1 && enc <= 0xf4:
+			hdr, intLen = 1, [...]int{2, 3, 4, 8}[enc-0xf1]
+		default:
+			return nil, ENCODING_ERR
+		}
+		start := pos + hdr
+		entryLen := hdr + strLen + intLen
+		if strLen < 0 || pos+entryLen > len(b) {
+			return nil, ENCODING_ERR
+		}
+		if isStr {
+			items = append(items, string(b[start:start+strLen]))
+		} else if intLen > 0 {
+			items = append(items, strconv.FormatInt(littleEndianInt(b[start:start+intLen]), 10))
+		}
+		pos += entryLen + listpackBacklenSize(entryLen)
+	}
+}
+
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// intset: encoding(4) length(4) 按encoding字节数保存的有序整数，均为小端序
+func parseIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, ENCODING_ERR
+	}
+	enc := int(binary.LittleEndian.Uint32(b[:4]))
+	n := int(binary.LittleEndian.Uint32(b[4:8]))
+	if (enc != 2 && enc != 4 && enc != 8) || n < 0 || len(b) != 8+n*enc {
+		return nil, ENCODING_ERR
+	}
+	items := make([]string, n)
+	for i := range items {
+		items[i] = strconv.FormatInt(littleEndianInt(b[8+i*enc:8+(i+1)*enc]), 10)
+	}
+	return items, nil
+}
+
+// zipmap: zmlen(1) (len key len free value [free bytes])... 0xFF
+// len 小于254时占1字节，否则为 254 + 4字节小端序长度
+func parseZipmap(b []byte) ([]string, error) {
+	var items []string
+	pos := 1
+	readLen := func() (int, bool) {
+		if pos >= len(b) {
+			return 0, false
+		}
+		if b[pos] < 254 {
+			pos++
+			return int(b[pos-1]), true
+		}
+		if b[pos] == 254 && pos+5 <= len(b) {
+			pos += 5
+			return int(binary.LittleEndian.Uint32(b[pos-4 : pos])), true
+		}
+		return 0, false
+	}
+	for pos < len(b) && b[pos] != 0xff {
+		klen, ok := readLen()
+		if !ok || pos+klen > len(b) {
+			return nil, ENCODING_ERR
+		}
+		key := string(b[pos : pos+klen])
+		pos += klen
+		vlen, ok := readLen()
+		if !ok || pos >= len(b) {
+			return nil, ENCODING_ERR
+		}
+		free := int(b[pos])
+		pos++
+		if pos+vlen+free > len(b) {
+			return nil, ENCODING_ERR
+		}
+		items = append(items, key, string(b[pos:pos+vlen]))
+		pos += vlen + free
+	}
+	if pos >= len(b) {
+		return nil, ENCODING_ERR
+	}
+	return items, nil
+}
+
+// 解析1~8字节的小端序有符号整数
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(v<<shift) >> shift
+}
@@ -0,0 +1,274 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 按Redis格式拼装测试用的RDB文件
+type redisRdbBuilder struct {
+	bytes.Buffer
+}
+
+func (b *redisRdbBuilder) len(n int) {
+	switch {
+	case n < 1<<6:
+		b.WriteByte(byte(n))
+	case n < 1<<14:
+		b.WriteByte(byte(n>>8) | 0x40)
+		b.WriteByte(byte(n))
+	default:
+		b.WriteByte(redisLen32Bit)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func (b *redisRdbBuilder) str(s string) {
+	b.len(len(s))
+	b.WriteString(s)
+}
+
+func (b *redisRdbBuilder) key(typ byte, key string) {
+	b.WriteByte(typ)
+	b.str(key)
+}
+
+func (b *redisRdbBuilder) footer() []byte {
+	b.WriteByte(redisOpcodeEOF)
+	binary.Write(b, binary.LittleEndian, redisCrc64(0, b.Bytes()))
+	return b.Bytes()
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v)), uint32(v>>32))
+}
+
+// 元素为 string 或 int64
+func ziplist(items ...interface{}) string {
+	buf := make([]byte, 10)
+	for _, item := range items {
+		buf = append(buf, 0)
+		switch v := item.(type) {
+		case string:
+			buf = append(buf, byte(len(v)))
+			buf = append(buf, v...)
+		case int64:
+			switch {
+			case v >= 0 && v <= 12:
+				buf = append(buf, 0xf1+byte(v))
+			case v >= math.MinInt16 && v <= math.MaxInt16:
+				buf = append(buf, 0xc0)
+				buf = appendUint16(buf, uint16(v))
+			case v >= -1<<23 && v < 1<<23:
+				buf = append(buf, 0xf0, byte(v), byte(v>>8), byte(v>>16))
+			default:
+				buf = append(buf, 0xe0)
+				buf = appendUint64(buf, uint64(v))
+			}
+		}
+	}
+	return string(append(buf, 0xff))
+}
+
+func listpack(items ...interface{}) string {
+	buf := make([]byte, 6)
+	for _, item := range items {
+		var entry []byte
+		switch v := item.(type) {
+		case string:
+			if len(v) < 64 {
+				entry = append(entry, 0x80|byte(len(v)))
+			} else {
+				entry = append(entry, 0xe0|byte(len(v)>>8), byte(len(v)))
+			}
+			entry = append(entry, v...)
+		case int64:
+			switch {
+			case v >= 0 && v <= 127:
+				entry = append(entry, byte(v))
+			case v >= -4096 && v < 4096:
+				u := uint16(v) & 0x1fff
+				entry = append(entry, 0xc0|byte(u>>8), byte(u))
+			default:
+				entry = append(entry, 0xf4)
+				entry = appendUint64(entry, uint64(v))
+			}
+		}
+		buf = append(buf, entry...)
+		buf = append(buf, byte(len(entry)))
+	}
+	return string(append(buf, 0xff))
+}
+
+func loadRedisEntries(t *testing.T, data []byte) map[string]*RedisEntry {
+	entries := make(map[string]*RedisEntry)
+	err := NewRedisDecoder(bytes.NewReader(data)).Load(func(e *RedisEntry) error {
+		entries[e.Key] = e
+		return nil
+	})
+	assert.Nil(t, err)
+	return entries
+}
+
+func TestRedisCrc64(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), redisCrc64(0, []byte("123456789")))
+	assert.Equal(t, redisCrc64(0, []byte("123456789")), redisCrc64(redisCrc64(0, []byte("1234")), []byte("56789")))
+}
+
+func TestLzfDecompress(t *testing.T) {
+	// 字面量 "abc" + 回溯3字节复制6字节
+	out, err := lzfDecompress([]byte{2, 'a', 'b', 'c', 0x80, 2}, 9)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcabcabc", string(out))
+	_, err = lzfDecompress([]byte{2, 'a', 'b', 'c', 0x80, 9}, 9)
+	assert.Equal(t, LZF_ERR, err)
+}
+
+func TestLoadRedisRdb(t *testing.T) {
+	var b redisRdbBuilder
+	b.WriteString("REDIS0011")
+	b.WriteByte(redisOpcodeAux)
+	b.str("redis-ver")
+	b.str("7.2.4")
+	b.WriteByte(redisOpcodeAux)
+	b.str("ctime")
+	b.Write([]byte{0xc0 | redisEncInt32, 0x00, 0x5e, 0x10, 0x65})
+	b.WriteByte(redisOpcodeSelectDB)
+	b.len(0)
+	b.WriteByte(redisOpcodeResizeDB)
+	b.len(10)
+	b.len(1)
+
+	b.key(REDIS_RDB_TYPE_STRING, "str")
+	b.str("hello")
+	b.key(REDIS_RDB_TYPE_STRING, "num")
+	b.Write([]byte{0xc0 | redisEncInt16, 0xe8, 0x03})
+	b.key(REDIS_RDB_TYPE_STRING, "lzf")
+	b.Write([]byte{0xc0 | redisEncLzf, 6, 9, 2, 'a', 'b', 'c', 0x80, 2})
+	b.WriteByte(redisOpcodeExpireTimeMs)
+	binary.Write(&b, binary.LittleEndian, uint64(1700000000000))
+	b.WriteByte(redisOpcodeIdle)
+	b.len(100)
+	b.key(REDIS_RDB_TYPE_STRING, "tmp")
+	b.str("v")
+
+	long := strings.Repeat("x", 70)
+	b.key(redisTypeListQuicklist2, "list")
+	b.len(2)
+	b.len(quicklistNodePacked)
+	b.str(listpack("a", int64(5), int64(-100), long, int64(1)<<40))
+	b.len(quicklistNodePlain)
+	b.str("plain")
+	b.key(redisTypeSetIntset, "intset")
+	intset := appendUint32(nil, 4)
+	intset = appendUint32(intset, 3)
+	for _, v := range []int32{-1, 2, 70000} {
+		intset = appendUint32(intset, uint32(v))
+	}
+	b.str(string(intset))
+	b.key(redisTypeSetListpack, "set")
+	b.str(listpack("x", "y"))
+	b.key(redisTypeHashListpack, "hash")
+	b.str(listpack("f1", "v1", "f2", int64(2)))
+	b.key(redisTypeZsetListpack, "zset")
+	b.str(listpack("a", int64(1), "b", "2.5"))
+	b.key(redisTypeZset2, "zset2")
+	b.len(1)
+	b.str("c")
+	binary.Write(&b, binary.LittleEndian, math.Float64bits(math.Inf(-1)))
+
+	b.WriteByte(redisOpcodeSelectDB)
+	b.len(2)
+	b.key(redisTypeListQuicklist, "oldlist")
+	b.len(1)
+	b.str(ziplist("z", int64(12), int64(300), int64(-70000), int64(1)<<40))
+	b.key(redisTypeHashZiplist, "oldhash")
+	b.str(ziplist("f", "v"))
+	b.WriteByte(redisOpcodeFreq)
+	b.WriteByte(5)
+	b.WriteByte(redisOpcodeExpireTime)
+	binary.Write(&b, binary.LittleEndian, uint32(1700000000))
+	b.key(REDIS_RDB_TYPE_ZSET, "oldzset")
+	b.len(2)
+	b.str("m1")
+	b.WriteByte(254)
+	b.str("m2")
+	b.str("3.5")
+	b.key(REDIS_RDB_TYPE_LIST, "rawlist")
+	b.len(2)
+	b.str("r1")
+	b.str("r2")
+	data := b.footer()
+
+	entries := loadRedisEntries(t, data)
+	assert.Equal(t, 14, len(entries))
+	assert.Equal(t, &RedisEntry{Key: "str", Expire: -1, Type: REDIS_RDB_TYPE_STRING, Values: []string{"hello"}}, entries["str"])
+	assert.Equal(t, []string{"1000"}, entries["num"].Values)
+	assert.Equal(t, []string{"abcabcabc"}, entries["lzf"].Values)
+	assert.Equal(t, int64(1700000000000), entries["tmp"].Expire)
+	assert.Equal(t, int64(-1), entries["list"].Expire)
+	assert.Equal(t, REDIS_RDB_TYPE_LIST, entries["list"].Type)
+	assert.Equal(t, []string{"a", "5", "-100", long, "1099511627776", "plain"}, entries["list"].Values)
+	assert.Equal(t, REDIS_RDB_TYPE_SET, entries["intset"].Type)
+	assert.Equal(t, []string{"-1", "2", "70000"}, entries["intset"].Values)
+	assert.Equal(t, []string{"x", "y"}, entries["set"].Values)
+	assert.Equal(t, REDIS_RDB_TYPE_HASH, entries["hash"].Type)
+	assert.Equal(t, []string{"f1", "v1", "f2", "2"}, entries["hash"].Values)
+	assert.Equal(t, REDIS_RDB_TYPE_ZSET, entries["zset"].Type)
+	assert.Equal(t, []string{"a", "b"}, entries["zset"].Values)
+	assert.Equal(t, []float64{1, 2.5}, entries["zset"].Scores)
+	assert.Equal(t, []float64{math.Inf(-1)}, entries["zset2"].Scores)
+
+	assert.Equal(t, 2, entries["oldlist"].DB)
+	assert.Equal(t, []string{"z", "12", "300", "-70000", "1099511627776"}, entries["oldlist"].Values)
+	assert.Equal(t, []string{"f", "v"}, entries["oldhash"].Values)
+	assert.Equal(t, int64(1700000000000), entries["oldzset"].Expire)
+	assert.Equal(t, []string{"m1", "m2"}, entries["oldzset"].Values)
+	assert.Equal(t, []float64{math.Inf(1), 3.5}, entries["oldzset"].Scores)
+	assert.Equal(t, []string{"r1", "r2"}, entries["rawlist"].Values)
+
+	// 校验和为0时不校验
+	binary.LittleEndian.PutUint64(data[len(data)-8:], 0)
+	loadRedisEntries(t, data)
+	data[len(data)-8] = 1
+	assert.Equal(t, CHECKSUM_ERR, NewRedisDecoder(bytes.NewReader(data)).Load(func(e *RedisEntry) error { return nil }))
+}
+
+func TestLoadRedisRdbErrors(t *testing.T) {
+	load := func(data []byte) error {
+		return NewRedisDecoder(bytes.NewReader(data)).Load(func(e *RedisEntry) error { return nil })
+	}
+	assert.Equal(t, REDIS_MAGIC_ERR, load([]byte("GODIS0001")))
+	assert.Equal(t, VERSION_ERR, load([]byte("REDIS0099")))
+
+	// stream
+	var b redisRdbBuilder
+	b.WriteString("REDIS0010")
+	b.key(15, "stream")
+	assert.Equal(t, REDIS_TYPE_ERR, load(b.footer()))
+
+	b.Reset()
+	b.WriteString("REDIS0010")
+	b.key(redisTypeHashListpack, "hash")
+	b.str(listpack("f1"))
+	assert.Equal(t, ENCODING_ERR, load(b.footer()))
+
+	b.Reset()
+	b.WriteString("REDIS0010")
+	b.key(REDIS_RDB_TYPE_STRING, "str")
+	b.str("v")
+	assert.NotNil(t, load(b.Bytes()))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/rdb"
+	"akt-redis/utils"
+	"akt-redis/zset"
+	"log"
+	"os"
+)
+
+func createObjectFromRedisEntry(e *rdb.RedisEntry) *obj.Gobj {
+	if e.Type == rdb.REDIS_RDB_TYPE_STRING {
+		return obj.CreateObject(obj.GSTR, e.Values[0])
+	}
+	var o *obj.Gobj
+	switch e.Type {
+	case rdb.REDIS_RDB_TYPE_LIST:
+		o = createListObject()
+	case rdb.REDIS_RDB_TYPE_SET:
+		o = createSetObject()
+	case rdb.REDIS_RDB_TYPE_HASH:
+		o = createHashObject()
+	case rdb.REDIS_RDB_TYPE_ZSET:
+		o = createZsetObject()
+	}
+	for i := 0; i < len(e.Values); i++ {
+		ele := obj.CreateObject(obj.GSTR, e.Values[i])
+		switch e.Type {
+		case rdb.REDIS_RDB_TYPE_LIST:
+			listPush(o.Val_.(*list.List), ele, LIST_TAIL)
+		case rdb.REDIS_RDB_TYPE_SET:
+			setAdd(o.Val_.(*dict.Dict), ele)
+		case rdb.REDIS_RDB_TYPE_HASH:
+			i++
+			val := obj.CreateObject(obj.GSTR, e.Values[i])
+			o.Val_.(*dict.Dict).Set(ele, val)
+			val.DecrRefCount()
+		case rdb.REDIS_RDB_TYPE_ZSET:
+			o.Val_.(*zset.ZSet).Add(e.Scores[i], ele, 0)
+		}
+		ele.DecrRefCount()
+	}
+	return o
+}
+
+// 导入Redis生成的dump.rdb，已存在的key会被覆盖，返回导入的key数
+func importRedisRdb(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	now := utils.GetMsTime()
+	imported := 0
+	err = rdb.NewRedisDecoder(f).Load(func(e *rdb.RedisEntry) error {
+		if e.DB >= len(server.dbs) {
+			return DBID_ERR
+		}
+		// 已过期的key不再导入
+		if e.Expire != -1 && e.Expire < now {
+			return nil
+		}
+		db := server.dbs[e.DB]
+		key := obj.CreateObject(obj.GSTR, e.Key)
+		val := createObjectFromRedisEntry(e)
+		dbDelete(db, key)
+		dbAdd(db, key, val)
+		if e.Expire != -1 {
+			setExpire(db, key, e.Expire)
+		}
+		key.DecrRefCount()
+		val.DecrRefCount()
+		imported++
+		return nil
+	})
+	if err != nil {
+		return imported, err
+	}
+	log.Printf("imported %v keys from Redis RDB file %v\n", imported, filename)
+	return imported, nil
+}
+
+func datasetIsEmpty() bool {
+	for _, db := range server.dbs {
+		if db.data.Size() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 启动时导入 import-rdb 指定的文件，只在没有已持久化的数据时进行，避免重启后覆盖新数据
+// 导入的数据随后由BGSAVE或BGREWRITEAOF持久化
+func importRdbOnStartup(filename string) error {
+	if filename == "" || !datasetIsEmpty() {
+		return nil
+	}
+	imported, err := importRedisRdb(filename)
+	if err != nil || imported == 0 {
+		return err
+	}
+	server.dirty += int64(imported)
+	if server.aofEnabled {
+		return rewriteAppendOnlyFileBackground()
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/dict"
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"akt-redis/zset"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "set str v\r\n")
+	ExecQuery(client, "set tmp v px 100000\r\n")
+	ExecQuery(client, "rpush list a b c\r\n")
+	ExecQuery(client, "hset hash f1 v1 f2 v2\r\n")
+	ExecQuery(client, "sadd set m1 m2\r\n")
+	ExecQuery(client, "zadd zset 1 a 2.5 b\r\n")
+	ExecQuery(client, "select 2\r\n")
+	ExecQuery(client, "set other v2\r\n")
+	// 按修改的元素计数
+	assert.Equal(t, int64(12), server.dirty)
+	// 回复错误及没有修改数据的写命令不计入修改次数
+	ExecQuery(client, "lpush other a\r\n")
+	ExecQuery(client, "del missing\r\n")
+	ExecQuery(client, "expire missing 100\r\n")
+	ExecQuery(client, "setnx other v3\r\n")
+	assert.Equal(t, int64(12), server.dirty)
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "save\r\n"))
+	assert.Equal(t, int64(0), server.dirty)
+
+	initServer(&config)
+	client = CreateClient(server.fd)
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(client, "get str\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "exists tmp\r\n"))
+	assert.NotEqual(t, ":-1\r\n", ExecQuery(client, "pttl tmp\r\n"))
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "lrange list 0 -1\r\n"))
+	assert.Equal(t, "$2\r\nv2\r\n", ExecQuery(client, "hget hash f2\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "scard set\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zscore zset b\r\n"))
+	assert.Equal(t, ":6\r\n", ExecQuery(client, "dbsize\r\n"))
+	ExecQuery(client, "select 2\r\n")
+	assert.Equal(t, "$2\r\nv2\r\n", ExecQuery(client, "get other\r\n"))
+
+	// 文件损坏时拒绝加载，已有数据不变
+	filename := filepath.Join(config.Dir, DEFAULT_DBFILENAME)
+	data, _ := os.ReadFile(filename)
+	data[len(data)-20] ^= 0xff
+	ExecQuery(client, "set other v3\r\n")
+	assert.NotNil(t, rdbLoadFrom(bytes.NewReader(data)))
+	assert.Equal(t, "$2\r\nv3\r\n", ExecQuery(client, "get other\r\n"))
+	os.WriteFile(filename, data, 0644)
+	assert.NotNil(t, initServer(&config))
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "rpush list a b\r\n")
+	ExecQuery(client, "hset hash f v\r\n")
+	ExecQuery(client, "zadd zset 1 a\r\n")
+	snap := createSnapshot()
+	vals := make(map[string]*obj.Gobj)
+	for _, entry := range snap[0].entries {
+		vals[entry.key.StrVal()] = entry.val
+	}
+	// 快照只持有引用，修改时才复制
+	assert.Equal(t, vals["list"], server.dbs[0].data.Get(obj.CreateObject(obj.GSTR, "list")))
+	ExecQuery(client, "lpush list c\r\n")
+	ExecQuery(client, "hdel hash f\r\n")
+	ExecQuery(client, "zincrby zset 1 a\r\n")
+	assert.Equal(t, "*3\r\n$1\r\nc\r\n$1\r\na\r\n$1\r\nb\r\n", ExecQuery(client, "lrange list 0 -1\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists hash\r\n"))
+	assert.Equal(t, "$1\r\n2\r\n", ExecQuery(client, "zscore zset a\r\n"))
+	assert.Equal(t, 2, vals["list"].Val_.(*list.List).Length())
+	assert.Equal(t, int64(1), vals["hash"].Val_.(*dict.Dict).Size())
+	score, _ := vals["zset"].Val_.(*zset.ZSet).Score(obj.CreateObject(obj.GSTR, "a"))
+	assert.Equal(t, float64(1), score)
+
+	// 已复制的value不再复制
+	l := server.dbs[0].data.Get(obj.CreateObject(obj.GSTR, "list"))
+	ExecQuery(client, "rpush list d\r\n")
+	assert.Equal(t, l, server.dbs[0].data.Get(obj.CreateObject(obj.GSTR, "list")))
+	releaseSnapshot(snap)
+	assert.Equal(t, 0, vals["list"].RefCount)
+}
+
+func TestBgsave(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Save = [][2]int64{{60, 2}}
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "set k1 v\r\n")
+	assert.Equal(t, "+Background saving started\r\n", ExecQuery(client, "bgsave\r\n"))
+	assert.Equal(t, "-ERR: background save already in progress\r\n", ExecQuery(client, "bgsave\r\n"))
+	// 保存期间的修改不影响快照
+	ExecQuery(client, "set k2 v\r\n")
+	checkBgsaveDone(true)
+	assert.Equal(t, int64(1), server.dirty)
+
+	// 满足save规则时自动触发
+	ExecQuery(client, "set k3 v\r\n")
+	server.lastSave -= 61
+	rdbCron()
+	assert.NotNil(t, server.rdbBgsaveDone)
+	checkBgsaveDone(true)
+	assert.Equal(t, int64(0), server.dirty)
+
+	initServer(&config)
+	client = CreateClient(server.fd)
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "dbsize\r\n"))
+}
+
+func TestImportRedisRdb(t *testing.T) {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.ImportRdb = filepath.Join(config.Dir, "redis.rdb")
+
+	le := func(v uint64) string {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], v)
+		return string(buf[:])
+	}
+	future := uint64(utils.GetMsTime() + 100000)
+	data := "REDIS0009" +
+		"\xfe\x00" +
+		"\x00\x03str\x05hello" +
+		"\x01\x04list\x02\x01a\x01b" +
+		"\x02\x03set\x01\x01m" +
+		"\x04\x04hash\x01\x01f\x01v" +
+		"\xfc" + le(future) + "\x05\x04zset\x01\x01z" + le(math.Float64bits(1.5)) +
+		"\xfc" + le(1000) + "\x00\x03old\x01v" +
+		"\xfe\x01\x00\x02k1\x01v" +
+		"\xff" + le(0)
+	os.WriteFile(config.ImportRdb, []byte(data), 0644)
+
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	assert.Equal(t, ":5\r\n", ExecQuery(client, "dbsize\r\n"))
+	assert.Equal(t, "$5\r\nhello\r\n", ExecQuery(client, "get str\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", ExecQuery(client, "lrange list 0 -1\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "sismember set m\r\n"))
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(client, "hget hash f\r\n"))
+	assert.Equal(t, "$3\r\n1.5\r\n", ExecQuery(client, "zscore zset z\r\n"))
+	assert.Equal(t, fmt.Sprintf(":%d\r\n", future), ExecQuery(client, "pexpiretime zset\r\n"))
+	ExecQuery(client, "select 1\r\n")
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(client, "get k1\r\n"))
+
+	// 已有持久化数据时不再导入
+	ExecQuery(client, "set k1 new\r\n")
+	ExecQuery(client, "save\r\n")
+	assert.Nil(t, initServer(&config))
+	client = CreateClient(server.fd)
+	ExecQuery(client, "select 1\r\n")
+	assert.Equal(t, "$3\r\nnew\r\n", ExecQuery(client, "get k1\r\n"))
+
+	// 文件损坏时拒绝启动
+	os.Remove(filepath.Join(config.Dir, DEFAULT_DBFILENAME))
+	os.WriteFile(config.ImportRdb, []byte(data[:30]), 0644)
+	assert.NotNil(t, initServer(&config))
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/conf"
+	"akt-redis/net"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	gonet "net"
+	"strconv"
+	"strings"
+)
+
+// 主从异步复制
+// 主节点：replica 发送 PSYNC replid offset，replid 相同且 offset 仍在 backlog 中时从backlog续传 (+CONTINUE)，
+// 否则全量同步 (+FULLRESYNC replid offset)：主线程生成快照，goroutine 序列化后以 "$len\r\n<payload>" 发送，
+// 期间的写命令暂存在 replica 的 pending 中，快照发送后再发送。之后每条写命令以RESP格式写入backlog并发送给所有replica
+// 从节点：ServerCron 中连接主节点并握手，全量同步时清空数据并加载快照，之后以 master client 执行命令流，每秒发送 REPLCONF ACK
+// 过期时间均以绝对时间传播，从节点自行删除过期key
+
+const (
+	DEFAULT_REPL_BACKLOG_SIZE = 1024 * 1024
+	REPL_ACK_INTERVAL         = 1000 // ms
+	REPL_CONNECT_INTERVAL     = 1000 // ms
+	CONFIG_RUN_ID_SIZE        = 40
+)
+
+// 从节点与主节点的连接状态
+const (
+	REPL_STATE_NONE          = iota // 不是从节点
+	REPL_STATE_CONNECT              // 等待连接
+	REPL_STATE_RECEIVE_PSYNC        // 等待 PSYNC 的回复
+	REPL_STATE_TRANSFER             // 接收快照
+	REPL_STATE_CONNECTED            // 接收命令流
+)
+
+// 主节点中 replica client 的状态
+const (
+	REPLICA_STATE_WAIT_BGSAVE = iota + 1 // 等待快照生成
+	REPLICA_STATE_ONLINE
+)
+
+var (
+	REPLICA_PSYNC_ERR = errors.New("can't serve PSYNC while replicating")
+	SYNC_FORMAT_ERR   = errors.New("bad protocol from master")
+	REPLICAOF_ERR     = errors.New("replicaof must be \"host port\"")
+)
+
+// 主节点中 replica client 的复制状态
+type replicaState struct {
+	state         int
+	pending       []byte // 快照生成期间的命令流
+	snapshot      []dbSnapshot
+	syncDone      chan syncResult
+	listeningPort int
+	ackOffset     int64
+	ackTime       int64 // ms
+}
+
+type syncResult struct {
+	payload []byte
+	err     error
+}
+
+// 环形缓冲区，保存最近写入复制流的数据
+type replBacklog struct {
+	buf     []byte
+	idx     int   // 下一个写入位置
+	histlen int   // 有效数据长度
+	end     int64 // 最后一个字节之后的复制偏移量
+}
+
+func createReplBacklog(size int, offset int64) *replBacklog {
+	return &replBacklog{buf: make([]byte, size), end: offset}
+}
+
+func (b *replBacklog) feed(p []byte) {
+	b.end += int64(len(p))
+	for len(p) > 0 {
+		n := copy(b.buf[b.idx:], p)
+		p = p[n:]
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen += n
+	}
+	if b.histlen > len(b.buf) {
+		b.histlen = len(b.buf)
+	}
+}
+
+// 返回从 offset 开始的数据，offset 不在backlog中时返回false
+func (b *replBacklog) rangeFrom(offset int64) ([]byte, bool) {
+	if offset < b.end-int64(b.histlen) || offset > b.end {
+		return nil, false
+	}
+	n := int(b.end - offset)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	data := make([]byte, 0, n)
+	if start+n <= len(b.buf) {
+		return append(data, b.buf[start:start+n]...), true
+	}
+	data = append(data, b.buf[start:]...)
+	return append(data, b.buf[:n-len(data)]...), true
+}
+
+func initReplication(config *conf.Config) error {
+	server.replId = genReplId()
+	server.replOffset = 0
+	server.replBacklog = nil
+	server.replBacklogSize = config.ReplBacklogSize
+	if server.replBacklogSize <= 0 {
+		server.replBacklogSize = DEFAULT_REPL_BACKLOG_SIZE
+	}
+	server.replSelectedDb = -1
+	server.replicas = nil
+	server.replicaReadOnly = config.ReplicaReadOnly == nil || *config.ReplicaReadOnly
+	server.masterHost = ""
+	server.masterPort = 0
+	server.masterAuth = config.MasterAuth
+	server.master = nil
+	server.replState = REPL_STATE_NONE
+	server.replCachedDb = 0
+	server.replTransferFd = -1
+	if config.ReplicaOf == "" {
+		return nil
+	}
+	fields := strings.Fields(config.ReplicaOf)
+	if len(fields) != 2 {
+		return REPLICAOF_ERR
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return REPLICAOF_ERR
+	}
+	replicationSetMaster(fields[0], port)
+	return nil
+}
+
+func genReplId() string {
+	buf := make([]byte, CONFIG_RUN_ID_SIZE/2)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 写命令写入backlog并发送给replica，db变化时先发送SELECT
+func replicationFeedReplicas(dbid int, args []string) {
+	if server.replBacklog == nil {
+		return
+	}
+	var buf []byte
+	if dbid != server.replSelectedDb {
+		buf = resp.AppendCommand(buf, []string{"select", strconv.Itoa(dbid)})
+		server.replSelectedDb = dbid
+	}
+	buf = resp.AppendCommand(buf, args)
+	server.replBacklog.feed(buf)
+	server.replOffset += int64(len(buf))
+	for _, r := range server.replicas {
+		if r.repl.state == REPLICA_STATE_WAIT_BGSAVE {
+			r.repl.pending = append(r.repl.pending, buf...)
+		} else {
+			r.addReplyProto(string(buf))
+		}
+	}
+}
+
+// offset 仍在backlog中时续传，返回false表示需要全量同步
+func tryPartialResync(c *GodisClient, replId string, offset int64) bool {
+	if replId != server.replId {
+		return false
+	}
+	data, ok := server.replBacklog.rangeFrom(offset)
+	if !ok {
+		return false
+	}
+	c.repl.state = REPLICA_STATE_ONLINE
+	c.AddReplyStatus("CONTINUE " + server.replId)
+	if len(data) > 0 {
+		c.addReplyProto(string(data))
+	}
+	log.Printf("partial resync accepted for replica %v, sending %v bytes of backlog\n", c.fd, len(data))
+	return true
+}
+
+// 在主线程生成快照，由goroutine序列化，完成后在 replicationCron 中发送
+func fullResync(c *GodisClient) {
+	snap := createSnapshot()
+	done := make(chan syncResult, 1)
+	go func() {
+		var buf bytes.Buffer
+		err := rdbWriteSnapshot(&buf, snap)
+		done <- syncResult{payload: buf.Bytes(), err: err}
+	}()
+	c.repl.state = REPLICA_STATE_WAIT_BGSAVE
+	c.repl.snapshot = snap
+	c.repl.syncDone = done
+	// 快照之后的命令流需要从SELECT开始
+	server.replSelectedDb = -1
+	c.AddReplyStatus(fmt.Sprintf("FULLRESYNC %v %d", server.replId, server.replOffset))
+	log.Printf("full resync requested by replica %v\n", c.fd)
+}
+
+// 快照生成后发送给replica
+func checkReplicaSyncDone(c *GodisClient) {
+	var res syncResult
+	select {
+	case res = <-c.repl.syncDone:
+	default:
+		return
+	}
+	releaseSnapshot(c.repl.snapshot)
+	c.repl.snapshot = nil
+	c.repl.syncDone = nil
+	if res.err != nil {
+		log.Printf("snapshot for replica %v error: %v\n", c.fd, res.err)
+		freeClient(c)
+		return
+	}
+	// 快照结尾没有CRLF，不是bulk回复
+	c.addReplyBulkLen(len(res.payload))
+	c.addReplyBytes(res.payload)
+	if len(c.repl.pending) > 0 {
+		c.addReplyBytes(c.repl.pending)
+	}
+	c.repl.pending = nil
+	c.repl.state = REPLICA_STATE_ONLINE
+	log.Printf("synchronization with replica %v succeeded\n", c.fd)
+}
+
+// freeClient 时调用
+func removeReplica(c *GodisClient) {
+	if c.repl.syncDone != nil {
+		<-c.repl.syncDone
+		releaseSnapshot(c.repl.snapshot)
+		c.repl.snapshot = nil
+		c.repl.syncDone = nil
+	}
+	for i, r := range server.replicas {
+		if r == c {
+			server.replicas = append(server.replicas[:i], server.replicas[i+1:]...)
+			break
+		}
+	}
+	log.Printf("connection with replica %v lost\n", c.fd)
+}
+
+// PSYNC replid offset
+func psyncCommand(c *GodisClient) {
+	if c.flags&CLIENT_REPLICA != 0 {
+		return
+	}
+	if server.masterHost != "" {
+		c.AddReplyErrorFormat("ERR: %v", REPLICA_PSYNC_ERR)
+		return
+	}
+	offset, err := strconv.ParseInt(c.args[2].StrVal(), 10, 64)
+	if err != nil {
+		offset = -1
+	}
+	if server.replBacklog == nil {
+		server.replBacklog = createReplBacklog(server.replBacklogSize, server.replOffset)
+		server.replSelectedDb = -1
+	}
+	c.flags |= CLIENT_REPLICA
+	server.replicas = append(server.replicas, c)
+	if !tryPartialResync(c, c.args[1].StrVal(), offset) {
+		fullResync(c)
+	}
+}
+
+// REPLCONF listening-port port | REPLCONF ACK offset
+func replconfCommand(c *GodisClient) {
+	if len(c.args)%2 == 0 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	for i := 1; i < len(c.args); i += 2 {
+		val, ok := getIntFromArg(c, c.args[i+1])
+		if !ok {
+			return
+		}
+		switch strings.ToLower(c.args[i].StrVal()) {
+		case "listening-port":
+			c.repl.listeningPort = int(val)
+		case "ack":
+			// ACK 不回复
+			c.repl.ackOffset = val
+			c.repl.ackTime = utils.GetMsTime()
+			return
+		default:
+			c.AddReplyErrorFormat("ERR: unrecognized REPLCONF option: %v", c.args[i].StrVal())
+			return
+		}
+	}
+	c.AddReplyStatus("OK")
+}
+
+func resolveHost(host string) ([4]byte, error) {
+	var ip [4]byte
+	addr, err := gonet.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return ip, err
+	}
+	copy(ip[:], addr.IP.To4())
+	return ip, nil
+}
+
+// 连接主节点并发送握手命令，回复由 readSyncHandler 处理
+func connectWithMaster() {
+	server.replLastConnectTry = utils.GetMsTime()
+	ip, err := resolveHost(server.masterHost)
+	if err != nil {
+		log.Printf("can't resolve master host %v: %v\n", server.masterHost, err)
+		return
+	}
+	fd, err := net.Connect(ip, server.masterPort)
+	if err != nil {
+		log.Printf("error connecting to master %v:%v: %v\n", server.masterHost, server.masterPort, err)
+		return
+	}
+	var handshake []byte
+	if server.masterAuth != "" {
+		handshake = resp.AppendCommand(handshake, []string{"auth", server.masterAuth})
+	}
+	handshake = resp.AppendCommand(handshake, []string{"replconf", "listening-port", strconv.Itoa(server.port)})
+	handshake = resp.AppendCommand(handshake, []string{"psync", server.replId, strconv.FormatInt(server.replOffset, 10)})
+	if _, err := net.Write(fd, handshake); err != nil {
+		log.Printf("error sending handshake to master: %v\n", err)
+		net.Close(fd)
+		return
+	}
+	server.replTransferFd = fd
+	server.replTransferBuf = nil
+	server.replTransferSize = -1
+	server.replState = REPL_STATE_RECEIVE_PSYNC
+	server.aeLoop.AddFileEvent(fd, ae.AE_READABLE, readSyncHandler, nil)
+	log.Printf("connecting to master %v:%v\n", server.masterHost, server.masterPort)
+}
+
+// 握手或传输失败，关闭连接等待重连
+func cancelReplicationHandshake() {
+	if server.replState != REPL_STATE_RECEIVE_PSYNC && server.replState != REPL_STATE_TRANSFER {
+		return
+	}
+	server.aeLoop.RemoveFileEvent(server.replTransferFd, ae.AE_READABLE)
+	net.Close(server.replTransferFd)
+	server.replTransferFd = -1
+	server.replTransferBuf = nil
+	server.replState = REPL_STATE_CONNECT
+}
+
+// 读取一条单行回复，不完整时返回false，错误回复转为error
+func readSyncReply() (string, bool, error) {
+	v, n, err := resp.Parse(server.replTransferBuf)
+	if err != nil || (n > 0 && v.Type != resp.SimpleString && v.Type != resp.Error) {
+		return "", false, SYNC_FORMAT_ERR
+	}
+	if n == 0 {
+		return "", false, nil
+	}
+	server.replTransferBuf = server.replTransferBuf[n:]
+	if err := v.Err(); err != nil {
+		return "", false, err
+	}
+	return v.Text(), true, nil
+}
+
+// 处理 PSYNC 的回复及全量同步的快照
+func readSyncHandler(loop *ae.AeLoop, fd int, extra interface{}) {
+	buf := make([]byte, utils.GODIS_IO_BUF)
+	n, err := net.Read(fd, buf)
+	if err != nil || n == 0 {
+		log.Printf("error reading from master: %v\n", err)
+		cancelReplicationHandshake()
+		return
+	}
+	server.replTransferBuf = append(server.replTransferBuf, buf[:n]...)
+	if err := processSyncBuf(fd); err != nil {
+		log.Printf("replication handshake error: %v\n", err)
+		cancelReplicationHandshake()
+	}
+}
+
+func processSyncBuf(fd int) error {
+	for server.replState == REPL_STATE_RECEIVE_PSYNC {
+		line, ok, err := readSyncReply()
+		if !ok {
+			return err
+		}
+		fields := strings.Fields(line)
+		switch {
+		case line == "OK":
+			// AUTH 及 REPLCONF 的回复
+		case len(fields) == 3 && fields[0] == "FULLRESYNC":
+			offset, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return SYNC_FORMAT_ERR
+			}
+			server.replTransferId = fields[1]
+			server.replTransferOffset = offset
+			server.replState = REPL_STATE_TRANSFER
+			log.Printf("full resync from master: %v:%v\n", fields[1], offset)
+		case len(fields) == 2 && fields[0] == "CONTINUE":
+			log.Println("successful partial resynchronization with master")
+			server.replId = fields[1]
+			return replicationCreateMasterClient(fd)
+		default:
+			return errors.New(line)
+		}
+	}
+	if server.replTransferSize < 0 {
+		size, n, err := resp.ParseBulkLen(server.replTransferBuf)
+		if err != nil {
+			return SYNC_FORMAT_ERR
+		}
+		if n == 0 {
+			return nil
+		}
+		server.replTransferBuf = server.replTransferBuf[n:]
+		server.replTransferSize = int(size)
+	}
+	if len(server.replTransferBuf) < server.replTransferSize {
+		return nil
+	}
+	payload := server.replTransferBuf[:server.replTransferSize]
+	server.replTransferBuf = server.replTransferBuf[server.replTransferSize:]
+	if err := rdbLoadFrom(bytes.NewReader(payload)); err != nil {
+		return err
+	}
+	log.Printf("MASTER <-> REPLICA sync: loaded %v bytes\n", len(payload))
+	server.dirty++
+	server.replId = server.replTransferId
+	server.replOffset = server.replTransferOffset
+	server.replCachedDb = 0
+	if server.aofEnabled {
+		if err := rewriteAppendOnlyFileBackground(); err != nil {
+			log.Printf("can't rewrite the AOF after sync: %v\n", err)
+		}
+	}
+	return replicationCreateMasterClient(fd)
+}
+
+// 握手完成，之后的数据作为命令流由 master client 执行
+func replicationCreateMasterClient(fd int) error {
+	server.aeLoop.RemoveFileEvent(fd, ae.AE_READABLE)
+	c := CreateClient(fd)
+	c.flags |= CLIENT_MASTER
+	c.db = server.dbs[server.replCachedDb]
+	rest := server.replTransferBuf
+	c.query.Feed(rest)
+	c.readReplOffset = server.replOffset + int64(len(rest))
+	server.replTransferFd = -1
+	server.replTransferBuf = nil
+	server.replState = REPL_STATE_CONNECTED
+	server.master = c
+	server.replLastAck = 0
+	server.clients[fd] = c
+	server.aeLoop.AddFileEvent(fd, ae.AE_READABLE, ReadQueryFromClient, c)
+	if err := ProcessQueryBuf(c); err != nil {
+		log.Printf("process master stream err: %v\n", err)
+		freeClient(c)
+	}
+	return nil
+}
+
+// freeClient 时调用，之后在 replicationCron 中重连
+func replicationHandleMasterDisconnection(c *GodisClient) {
+	server.master = nil
+	server.replCachedDb = c.db.id
+	if server.masterHost != "" {
+		server.replState = REPL_STATE_CONNECT
+	}
+	log.Println("connection with master lost")
+}
+
+// 成为 host:port 的从节点，断开现有的replica并释放backlog
+func replicationSetMaster(host string, port int) {
+	replicationDiscardMaster()
+	for len(server.replicas) > 0 {
+		freeClient(server.replicas[0])
+	}
+	server.replBacklog = nil
+	server.masterHost = host
+	server.masterPort = port
+	server.replState = REPL_STATE_CONNECT
+	server.replLastConnectTry = 0
+}
+
+// 不再作为从节点，使用新的复制ID，避免与原主节点的其他replica混淆
+func replicationUnsetMaster() {
+	server.masterHost = ""
+	replicationDiscardMaster()
+	server.replState = REPL_STATE_NONE
+	server.replId = genReplId()
+}
+
+func replicationDiscardMaster() {
+	cancelReplicationHandshake()
+	if server.master != nil {
+		freeClient(server.master)
+	}
+}
+
+func replicationCron() {
+	for _, r := range append([]*GodisClient{}, server.replicas...) {
+		if r.repl.state == REPLICA_STATE_WAIT_BGSAVE {
+			checkReplicaSyncDone(r)
+		}
+	}
+	now := utils.GetMsTime()
+	if server.replState == REPL_STATE_CONNECT && now-server.replLastConnectTry >= REPL_CONNECT_INTERVAL {
+		connectWithMaster()
+	}
+	if server.replState == REPL_STATE_CONNECTED && now-server.replLastAck >= REPL_ACK_INTERVAL {
+		ack := resp.AppendCommand(nil, []string{"replconf", "ack", strconv.FormatInt(server.replOffset, 10)})
+		if _, err := net.Write(server.master.fd, ack); err != nil {
+			log.Printf("send ACK to master err: %v\n", err)
+		}
+		server.replLastAck = now
+	}
+}
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func replicaofCommand(c *GodisClient) {
+	host := c.args[1].StrVal()
+	if strings.ToLower(host) == "no" && strings.ToLower(c.args[2].StrVal()) == "one" {
+		if server.masterHost != "" {
+			replicationUnsetMaster()
+			log.Println("MASTER MODE enabled")
+		}
+		c.AddReplyStatus("OK")
+		return
+	}
+	port, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	if port <= 0 || port > 65535 {
+		c.AddReplyError("ERR: invalid master port")
+		return
+	}
+	if server.masterHost == host && server.masterPort == int(port) {
+		c.AddReplyStatus("OK Already connected to specified master")
+		return
+	}
+	replicationSetMaster(host, int(port))
+	log.Printf("REPLICAOF %v:%v enabled\n", host, port)
+	c.AddReplyStatus("OK")
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/obj"
+	"akt-redis/resp"
+	"encoding/json"
+	"fmt"
+	gonet "net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplBacklog(t *testing.T) {
+	b := createReplBacklog(8, 100)
+	b.feed([]byte("abcde"))
+	data, ok := b.rangeFrom(102)
+	assert.True(t, ok)
+	assert.Equal(t, "cde", string(data))
+	data, ok = b.rangeFrom(105)
+	assert.True(t, ok)
+	assert.Equal(t, "", string(data))
+	_, ok = b.rangeFrom(106)
+	assert.False(t, ok)
+
+	// 写满后覆盖最早的数据
+	b.feed([]byte("fghij"))
+	assert.Equal(t, 8, b.histlen)
+	_, ok = b.rangeFrom(101)
+	assert.False(t, ok)
+	data, ok = b.rangeFrom(102)
+	assert.True(t, ok)
+	assert.Equal(t, "cdefghij", string(data))
+	b.feed([]byte("0123456789abc"))
+	data, _ = b.rangeFrom(118)
+	assert.Equal(t, "89abc", string(data))
+}
+
+func TestPsync(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+	ExecQuery(client, "set k v\r\n")
+	// 没有replica时不记录命令流
+	assert.Equal(t, int64(0), server.replOffset)
+
+	replica := CreateClient(server.fd)
+	assert.Equal(t, "+OK\r\n", ExecQuery(replica, "replconf listening-port 7000\r\n"))
+	assert.Equal(t, fmt.Sprintf("+FULLRESYNC %v 0\r\n", server.replId), ExecQuery(replica, "psync ? -1\r\n"))
+	// 快照生成期间的命令在快照之后发送
+	ExecQuery(client, "set k2 v2\r\n")
+	for replica.repl.state != REPLICA_STATE_ONLINE {
+		replicationCron()
+	}
+	reply := ExecQuery(replica, "")
+	assert.True(t, strings.HasPrefix(reply, "$"))
+	assert.True(t, strings.HasSuffix(reply, "*2\r\n$6\r\nselect\r\n$1\r\n0\r\n*3\r\n$3\r\nset\r\n$2\r\nk2\r\n$2\r\nv2\r\n"))
+	assert.Equal(t, "", ExecQuery(replica, "replconf ack 10\r\n"))
+	assert.Equal(t, int64(10), replica.repl.ackOffset)
+
+	offset := server.replOffset
+	ExecQuery(client, "expire k2 100\r\n")
+	ExecQuery(client, "get k\r\n")
+	stream := ExecQuery(replica, "")
+	assert.Contains(t, stream, "pexpireat")
+	assert.Equal(t, server.replOffset-offset, int64(len(stream)))
+
+	// 从backlog续传
+	other := CreateClient(server.fd)
+	query := fmt.Sprintf("psync %v %d\r\n", server.replId, offset)
+	assert.Equal(t, fmt.Sprintf("+CONTINUE %v\r\n", server.replId)+stream, ExecQuery(other, query))
+	assert.Equal(t, 2, len(server.replicas))
+	query = fmt.Sprintf("psync %v %d\r\n", server.replId, server.replOffset+1)
+	assert.True(t, strings.HasPrefix(ExecQuery(CreateClient(server.fd), query), "+FULLRESYNC"))
+	assert.Equal(t, 3, len(server.replicas))
+	removeReplica(other)
+	assert.Equal(t, 2, len(server.replicas))
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	var config conf.Config
+	config.ReplicaOf = "127.0.0.1 1"
+	assert.Nil(t, initServer(&config))
+	client := CreateClient(server.fd)
+	assert.Equal(t, "-ERR: you can't write against a read only replica\r\n", ExecQuery(client, "set k v\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get k\r\n"))
+	assert.True(t, strings.HasPrefix(ExecQuery(client, "psync ? -1\r\n"), "-ERR"))
+	assert.Contains(t, ExecQuery(client, "info replication\r\n"), "master_link_status:down")
+
+	replId := server.replId
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "replicaof no one\r\n"))
+	assert.NotEqual(t, replId, server.replId)
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set k v\r\n"))
+
+	config.ReplicaOf = "127.0.0.1"
+	assert.Equal(t, REPLICAOF_ERR, initServer(&config))
+}
+
+func TestReplicaExpire(t *testing.T) {
+	var config conf.Config
+	config.ReplicaOf = "127.0.0.1 1"
+	assert.Nil(t, initServer(&config))
+	master := CreateClient(server.fd)
+	master.flags |= CLIENT_MASTER
+	client := CreateClient(server.fd)
+
+	ExecQuery(master, "set k v px 1\r\n")
+	ExecQuery(master, "set k2 v px 1\r\n")
+	time.Sleep(2 * time.Millisecond)
+	// 过期的key只对普通client隐藏，不删除
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get k\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "exists k2\r\n"))
+	activeExpireCycle()
+	assert.Equal(t, int64(2), server.dbs[0].data.Size())
+	// 主节点的命令流仍可访问，直到收到DEL
+	ExecQuery(master, "rpush k v\r\n")
+	assert.Equal(t, obj.GSTR, server.dbs[0].data.Get(obj.CreateObject(obj.GSTR, "k")).Type_)
+	ExecQuery(master, "del k\r\n")
+	assert.Equal(t, int64(1), server.dbs[0].data.Size())
+	// 可写的从节点写入隐藏的key时视为新key
+	server.replicaReadOnly = false
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "del k2\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "rpush k2 a\r\n"))
+	assert.Equal(t, ":-1\r\n", ExecQuery(client, "pttl k2\r\n"))
+}
+
+func buildGodis(t *testing.T) string {
+	bin := filepath.Join(t.TempDir(), "godis")
+	out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("build godis: %v\n%s", err, out)
+	}
+	return bin
+}
+
+// 启动独立的godis进程
+func startGodis(t *testing.T, bin string, config *conf.Config) *exec.Cmd {
+	config.Dir = t.TempDir()
+	data, _ := json.Marshal(config)
+	path := filepath.Join(config.Dir, "config.json")
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+	cmd := exec.Command(bin, path)
+	assert.Nil(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func freePort(t *testing.T) int {
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	return l.Addr().(*gonet.TCPAddr).Port
+}
+
+type respConn struct {
+	conn gonet.Conn
+	r    *resp.Reader
+}
+
+func dialGodis(t *testing.T, port int) *respConn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := gonet.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+			return &respConn{conn: conn, r: resp.NewReader(conn)}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connect to %v: %v", port, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// 读取一个回复，重新编码为RESP文本便于比较
+func (c *respConn) readReply() (string, error) {
+	v, err := c.r.ReadValue()
+	if err != nil {
+		return "", err
+	}
+	return string(resp.AppendValue(nil, v)), nil
+}
+
+func (c *respConn) do(t *testing.T, args ...string) string {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(resp.AppendCommand(nil, args))
+	assert.Nil(t, err)
+	reply, err := c.readReply()
+	assert.Nil(t, err)
+	return reply
+}
+
+// 等待回复包含 expect
+func waitReply(t *testing.T, c *respConn, expect string, args ...string) {
+	waitReplyFor(t, c, expect, 5*time.Second, args...)
+}
+
+func waitReplyFor(t *testing.T, c *respConn, expect string, timeout time.Duration, args ...string) {
+	deadline := time.Now().Add(timeout)
+	for {
+		reply := c.do(t, args...)
+		if strings.Contains(reply, expect) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v: expect %q, got %q", args, expect, reply)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReplicationProcesses(t *testing.T) {
+	bin := buildGodis(t)
+	primaryPort, replicaPort := freePort(t), freePort(t)
+	startGodis(t, bin, &conf.Config{Port: primaryPort})
+	primary := dialGodis(t, primaryPort)
+	assert.Equal(t, "+OK\r\n", primary.do(t, "set", "k1", "v1"))
+	primary.do(t, "rpush", "list", "a", "b")
+	primary.do(t, "select", "2")
+	primary.do(t, "hset", "hash", "f", "v")
+
+	// 全量同步
+	startGodis(t, bin, &conf.Config{Port: replicaPort, ReplicaOf: fmt.Sprintf("127.0.0.1 %d", primaryPort)})
+	replica := dialGodis(t, replicaPort)
+	waitReply(t, replica, "$2\r\nv1\r\n", "get", "k1")
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", replica.do(t, "lrange", "list", "0", "-1"))
+	assert.Equal(t, "-ERR: you can't write against a read only replica\r\n", replica.do(t, "set", "k1", "v2"))
+
+	// 命令流
+	primary.do(t, "set", "k2", "v2", "ex", "100")
+	primary.do(t, "select", "0")
+	primary.do(t, "lpop", "list")
+	waitReply(t, replica, "*1\r\n$1\r\nb\r\n", "lrange", "list", "0", "-1")
+	replica.do(t, "select", "2")
+	assert.Equal(t, "$2\r\nv2\r\n", replica.do(t, "get", "k2"))
+	assert.Equal(t, "$1\r\nv\r\n", replica.do(t, "hget", "hash", "f"))
+	assert.Contains(t, replica.do(t, "info", "replication"), "master_link_status:up")
+	assert.Contains(t, primary.do(t, "info", "replication"), "connected_slaves:1")
+
+	// 提升为主节点
+	assert.Equal(t, "+OK\r\n", replica.do(t, "replicaof", "no", "one"))
+	assert.Equal(t, "+OK\r\n", replica.do(t, "set", "k1", "v2"))
+	assert.Contains(t, replica.do(t, "info", "replication"), "role:master")
+	waitReply(t, primary, "connected_slaves:0", "info", "replication")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/obj"
+	"akt-redis/resp"
+	"fmt"
+)
+
+// 回复client
+// 命令通过以下 AddReplyXXX 方法回复，RESP编码由 resp 包完成，map/set/double等类型按client协商的协议版本输出
+
+func (c *GodisClient) AddReply(o *obj.Gobj) {
+	// 不回复主节点
+	if c.fd == FAKE_CLIENT_FD || c.flags&CLIENT_MASTER != 0 {
+		return
+	}
+	if raftHoldReply(c, o) {
+		return
+	}
+	c.reply.Append(o)
+	o.IncrRefCount()
+	server.aeLoop.AddFileEvent(c.fd, ae.AE_WRITABLE, SendReplyToClient, c)
+}
+
+// 回复已编码的RESP数据
+func (c *GodisClient) addReplyProto(proto string) {
+	o := obj.CreateObject(obj.GSTR, proto)
+	c.AddReply(o)
+	o.DecrRefCount()
+}
+
+func (c *GodisClient) addReplyBytes(b []byte) {
+	c.addReplyProto(string(b))
+}
+
+// 只回复bulk的长度，之后的数据通过 addReplyBytes 写入
+func (c *GodisClient) addReplyBulkLen(length int) {
+	c.addReplyBytes(resp.AppendBulkLen(nil, length))
+}
+
+// +OK，换行会被替换为空格
+func (c *GodisClient) AddReplyStatus(status string) {
+	c.addReplyBytes(resp.AppendSimpleString(nil, status))
+}
+
+// msg 包含错误码，如 "ERR: syntax error"
+func (c *GodisClient) AddReplyError(msg string) {
+	c.addReplyBytes(resp.AppendError(nil, msg))
+}
+
+func (c *GodisClient) AddReplyErrorFormat(format string, args ...interface{}) {
+	c.AddReplyError(fmt.Sprintf(format, args...))
+}
+
+func (c *GodisClient) AddReplyInt(n int64) {
+	c.addReplyBytes(resp.AppendInt(nil, n))
+}
+
+func (c *GodisClient) AddReplyBulk(s string) {
+	c.addReplyBytes(resp.AppendBulkString(nil, s))
+}
+
+// 对象为nil时回复null
+func (c *GodisClient) AddReplyBulkObj(o *obj.Gobj) {
+	if o == nil {
+		c.AddReplyNull()
+		return
+	}
+	c.AddReplyBulk(o.StrVal())
+}
+
+// 之后为 length 个回复
+func (c *GodisClient) AddReplyArrayLen(length int) {
+	c.addReplyBytes(resp.AppendArrayLen(nil, length))
+}
+
+// 字符串数组
+func (c *GodisClient) AddReplyStrings(strs []string) {
+	c.AddReplyArrayLen(len(strs))
+	for _, s := range strs {
+		c.AddReplyBulk(s)
+	}
+}
+
+func (c *GodisClient) AddReplyNull() {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendNull(nil))
+	} else {
+		c.addReplyBytes(resp.AppendNullBulk(nil))
+	}
+}
+
+// RESP2 中部分命令使用空数组表示nil
+func (c *GodisClient) AddReplyNullArray() {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendNull(nil))
+	} else {
+		c.addReplyBytes(resp.AppendNullArray(nil))
+	}
+}
+
+// 之后为 length 个 key value 对
+func (c *GodisClient) AddReplyMapLen(length int) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendMapLen(nil, length))
+	} else {
+		c.AddReplyArrayLen(length * 2)
+	}
+}
+
+func (c *GodisClient) AddReplySetLen(length int) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendSetLen(nil, length))
+	} else {
+		c.AddReplyArrayLen(length)
+	}
+}
+
+// 服务端主动推送的数据
+// 暂无命令使用（如 pubsub），保留为回复API，下同 AddReplyBool AddReplyBigNum
+func (c *GodisClient) AddReplyPushLen(length int) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendPushLen(nil, length))
+	} else {
+		c.AddReplyArrayLen(length)
+	}
+}
+
+func (c *GodisClient) AddReplyDouble(f float64) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendDouble(nil, f))
+	} else {
+		c.AddReplyBulk(resp.FormatDouble(f))
+	}
+}
+
+func (c *GodisClient) AddReplyBool(b bool) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendBool(nil, b))
+	} else {
+		c.AddReplyInt(int64(boolToInt(b)))
+	}
+}
+
+// 超出int64范围的整数，RESP2 中为bulk
+func (c *GodisClient) AddReplyBigNum(num string) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendBigNumber(nil, num))
+	} else {
+		c.AddReplyBulk(num)
+	}
+}
+
+// 带格式的文本，ext 为3个字符，如 txt
+func (c *GodisClient) AddReplyVerbatim(s string, ext string) {
+	if c.resp >= 3 {
+		c.addReplyBytes(resp.AppendVerbatim(nil, ext, s))
+	} else {
+		c.AddReplyBulk(s)
+	}
+}
+
+// 长度未知的回复，先占位，之后通过 SetDeferredXXXLen 设置长度
+type deferredLen struct {
+	o *obj.Gobj
+}
+
+func (c *GodisClient) AddReplyDeferredLen() deferredLen {
+	o := obj.CreateObject(obj.GSTR, "")
+	c.AddReply(o)
+	o.DecrRefCount()
+	return deferredLen{o}
+}
+
+// 占位对象只被本client的 reply（Raft模式下为 raftReplies）引用
+// 长度须在本次命令结束前设置，此时回复尚未写出，也未等到日志提交，因此可以直接修改
+func (c *GodisClient) setDeferredLen(d deferredLen, proto []byte) {
+	d.o.Val_ = string(proto)
+}
+
+func (c *GodisClient) SetDeferredArrayLen(d deferredLen, length int) {
+	c.setDeferredLen(d, resp.AppendArrayLen(nil, length))
+}
+
+func (c *GodisClient) SetDeferredMapLen(d deferredLen, length int) {
+	if c.resp >= 3 {
+		c.setDeferredLen(d, resp.AppendMapLen(nil, length))
+	} else {
+		c.setDeferredLen(d, resp.AppendArrayLen(nil, length*2))
+	}
+}
+
+func (c *GodisClient) SetDeferredSetLen(d deferredLen, length int) {
+	if c.resp >= 3 {
+		c.setDeferredLen(d, resp.AppendSetLen(nil, length))
+	} else {
+		c.setDeferredLen(d, resp.AppendArrayLen(nil, length))
+	}
+}
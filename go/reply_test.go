@@ -0,0 +1,71 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyTypes(t *testing.T) {
+	var config conf.Config
+	assert.Nil(t, initServer(&config))
+	c2, c3 := CreateClient(server.fd), CreateClient(server.fd)
+	c3.resp = 3
+	reply := func(c *GodisClient, add func(c *GodisClient)) string {
+		add(c)
+		return ExecQuery(c, "")
+	}
+	cases := []struct {
+		add          func(c *GodisClient)
+		resp2, resp3 string
+	}{
+		{func(c *GodisClient) { c.AddReplyStatus("OK") }, "+OK\r\n", "+OK\r\n"},
+		{func(c *GodisClient) { c.AddReplyError("ERR: bad\r\nvalue") }, "-ERR: bad  value\r\n", "-ERR: bad  value\r\n"},
+		{func(c *GodisClient) { c.AddReplyErrorFormat("ERR: %v %d", "a", 1) }, "-ERR: a 1\r\n", "-ERR: a 1\r\n"},
+		{func(c *GodisClient) { c.AddReplyInt(-12) }, ":-12\r\n", ":-12\r\n"},
+		{func(c *GodisClient) { c.AddReplyBulk("a\r\nb") }, "$4\r\na\r\nb\r\n", "$4\r\na\r\nb\r\n"},
+		{func(c *GodisClient) { c.AddReplyBulkObj(nil) }, "$-1\r\n", "_\r\n"},
+		{func(c *GodisClient) { c.AddReplyStrings([]string{"a", ""}) }, "*2\r\n$1\r\na\r\n$0\r\n\r\n", "*2\r\n$1\r\na\r\n$0\r\n\r\n"},
+		{func(c *GodisClient) { c.AddReplyNull() }, "$-1\r\n", "_\r\n"},
+		{func(c *GodisClient) { c.AddReplyNullArray() }, "*-1\r\n", "_\r\n"},
+		{func(c *GodisClient) { c.AddReplyMapLen(2) }, "*4\r\n", "%2\r\n"},
+		{func(c *GodisClient) { c.AddReplySetLen(2) }, "*2\r\n", "~2\r\n"},
+		{func(c *GodisClient) { c.AddReplyPushLen(2) }, "*2\r\n", ">2\r\n"},
+		{func(c *GodisClient) { c.AddReplyDouble(3.25) }, "$4\r\n3.25\r\n", ",3.25\r\n"},
+		{func(c *GodisClient) { c.AddReplyDouble(math.Inf(-1)) }, "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{func(c *GodisClient) { c.AddReplyBool(true) }, ":1\r\n", "#t\r\n"},
+		{func(c *GodisClient) { c.AddReplyBool(false) }, ":0\r\n", "#f\r\n"},
+		{func(c *GodisClient) { c.AddReplyBigNum("12345678901234567890") }, "$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{func(c *GodisClient) { c.AddReplyVerbatim("hello", "txt") }, "$5\r\nhello\r\n", "=9\r\ntxt:hello\r\n"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.resp2, reply(c2, tc.add))
+		assert.Equal(t, tc.resp3, reply(c3, tc.add))
+	}
+}
+
+func TestDeferredLen(t *testing.T) {
+	var config conf.Config
+	assert.Nil(t, initServer(&config))
+	c2, c3 := CreateClient(server.fd), CreateClient(server.fd)
+	c3.resp = 3
+	for _, c := range []*GodisClient{c2, c3} {
+		arr := c.AddReplyDeferredLen()
+		m := c.AddReplyDeferredLen()
+		c.AddReplyBulk("k")
+		c.AddReplyInt(1)
+		c.SetDeferredMapLen(m, 1)
+		s := c.AddReplyDeferredLen()
+		c.SetDeferredSetLen(s, 0)
+		c.SetDeferredArrayLen(arr, 2)
+	}
+	assert.Equal(t, "*2\r\n*2\r\n$1\r\nk\r\n:1\r\n*0\r\n", ExecQuery(c2, ""))
+	assert.Equal(t, "*2\r\n%1\r\n$1\r\nk\r\n:1\r\n~0\r\n", ExecQuery(c3, ""))
+
+	ExecQuery(c2, "mset a 1 b 2\r\n")
+	keys := ExecQuery(c2, "keys *\r\n")
+	assert.True(t, keys == "*2\r\n$1\r\na\r\n$1\r\nb\r\n" || keys == "*2\r\n$1\r\nb\r\n$1\r\na\r\n")
+	assert.Equal(t, "*0\r\n", ExecQuery(c2, "keys x*\r\n"))
+}
@@ -0,0 +1,462 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	DEFAULT_MAX_INLINE = 64 * 1024
+	DEFAULT_MAX_BULK   = 512 * 1024 * 1024
+	// 一条命令的最大参数个数
+	MAX_MULTIBULK = 1024 * 1024
+	// 嵌套的最大层数，避免恶意数据导致栈溢出
+	MAX_DEPTH = 128
+	readSize  = 16 * 1024
+)
+
+var crlf = []byte("\r\n")
+
+// 读取一行，不包含CRLF，不完整时返回的长度为0
+func parseLine(buf []byte) ([]byte, int) {
+	idx := bytes.Index(buf, crlf)
+	if idx < 0 {
+		return nil, 0
+	}
+	return buf[:idx], idx + 2
+}
+
+// 解析一个完整的值，数据不完整时返回的长度为0
+func Parse(buf []byte) (Value, int, error) {
+	return parseValue(buf, 0)
+}
+
+func parseValue(buf []byte, depth int) (Value, int, error) {
+	var v Value
+	if depth > MAX_DEPTH {
+		return v, 0, FORMAT_ERR
+	}
+	line, n := parseLine(buf)
+	if n == 0 {
+		return v, 0, nil
+	}
+	if len(line) == 0 {
+		return v, 0, FORMAT_ERR
+	}
+	v.Type = Type(line[0])
+	line = line[1:]
+	switch v.Type {
+	case SimpleString, Error, BigNumber:
+		v.Str = line
+		return v, n, nil
+	case Integer:
+		i, ok := parseInt(line)
+		if !ok {
+			return v, 0, FORMAT_ERR
+		}
+		v.Int = i
+		return v, n, nil
+	case Null:
+		if len(line) != 0 {
+			return v, 0, FORMAT_ERR
+		}
+		return v, n, nil
+	case Boolean:
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			return v, 0, FORMAT_ERR
+		}
+		v.Bool = line[0] == 't'
+		return v, n, nil
+	case Double:
+		f, ok := parseDouble(line)
+		if !ok {
+			return v, 0, FORMAT_ERR
+		}
+		v.Double = f
+		return v, n, nil
+	case BulkString, BulkError, Verbatim:
+		length, ok := parseInt(line)
+		if !ok || length < -1 || (length == -1 && v.Type != BulkString) {
+			return v, 0, FORMAT_ERR
+		}
+		if length == -1 {
+			v.Nil = true
+			return v, n, nil
+		}
+		if int64(len(buf)-n) < length+2 {
+			return v, 0, nil
+		}
+		end := n + int(length)
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return v, 0, FORMAT_ERR
+		}
+		v.Str = buf[n:end]
+		if v.Type == Verbatim {
+			if len(v.Str) < 4 || v.Str[3] != ':' {
+				return v, 0, FORMAT_ERR
+			}
+			v.Format = string(v.Str[:3])
+			v.Str = v.Str[4:]
+		}
+		return v, end + 2, nil
+	case Array, Map, Set, Attribute, Push:
+		count, ok := parseInt(line)
+		if !ok || count < -1 || (count == -1 && v.Type != Array) {
+			return v, 0, FORMAT_ERR
+		}
+		if count == -1 {
+			v.Nil = true
+			return v, n, nil
+		}
+		// 每个元素至少3个字节，长度明显不足时数据必然不完整，不预先分配
+		if count > int64(len(buf)-n)/3 {
+			return v, 0, nil
+		}
+		if v.Type == Map || v.Type == Attribute {
+			count *= 2
+		}
+		v.Elems = make([]Value, count)
+		m, err := parseElemsInto(buf[n:], depth, v.Elems)
+		if err != nil || m < 0 {
+			return v, 0, err
+		}
+		return v, n + m, nil
+	}
+	return v, 0, FORMAT_ERR
+}
+
+// 解析 len(elems) 个元素，不完整时返回-1
+func parseElemsInto(buf []byte, depth int, elems []Value) (int, error) {
+	pos := 0
+	for i := range elems {
+		e, m, err := parseValue(buf[pos:], depth+1)
+		if err != nil {
+			return 0, err
+		}
+		if m == 0 {
+			return -1, nil
+		}
+		elems[i] = e
+		pos += m
+	}
+	return pos, nil
+}
+
+// 只解析bulk的长度行，返回长度及长度行的字节数，之后的数据由调用方读取
+// 用于结尾没有CRLF的数据，如复制时发送的快照
+func ParseBulkLen(buf []byte) (int64, int, error) {
+	line, n := parseLine(buf)
+	if n == 0 {
+		return 0, 0, nil
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return 0, 0, FORMAT_ERR
+	}
+	length, ok := parseInt(line[1:])
+	if !ok || length < 0 {
+		return 0, 0, FORMAT_ERR
+	}
+	return length, n, nil
+}
+
+// 解析客户端命令，以*开头为multibulk，否则为inline
+// max 为0时使用默认限制
+func ParseCommand(buf []byte, maxInline, maxBulk int) ([][]byte, int, error) {
+	if len(buf) > 0 && buf[0] == '*' {
+		return ParseMultiBulk(buf, maxBulk)
+	}
+	return ParseInline(buf, maxInline)
+}
+
+// "set key val\r\n"，参数以空白分隔
+func ParseInline(buf []byte, maxInline int) ([][]byte, int, error) {
+	if maxInline <= 0 {
+		maxInline = DEFAULT_MAX_INLINE
+	}
+	line, n := parseLine(buf)
+	if n == 0 {
+		if len(buf) > maxInline {
+			return nil, 0, TOO_BIG_INLINE_ERR
+		}
+		return nil, 0, nil
+	}
+	if len(line) > maxInline {
+		return nil, 0, TOO_BIG_INLINE_ERR
+	}
+	return bytes.Fields(line), n, nil
+}
+
+// "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$3\r\nval\r\n"，参数只能是bulk
+func ParseMultiBulk(buf []byte, maxBulk int) ([][]byte, int, error) {
+	if maxBulk <= 0 {
+		maxBulk = DEFAULT_MAX_BULK
+	}
+	line, n := parseLine(buf)
+	if n == 0 {
+		return nil, 0, nil
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, 0, FORMAT_ERR
+	}
+	count, ok := parseInt(line[1:])
+	if !ok || count > MAX_MULTIBULK {
+		return nil, 0, FORMAT_ERR
+	}
+	if count <= 0 {
+		return nil, n, nil
+	}
+	// 参数个数可能很大，逐个追加而不预先分配
+	var args [][]byte
+	if count <= 64 {
+		args = make([][]byte, 0, count)
+	}
+	pos := n
+	for i := int64(0); i < count; i++ {
+		line, m := parseLine(buf[pos:])
+		if m == 0 {
+			return nil, 0, nil
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, 0, FORMAT_ERR
+		}
+		length, ok := parseInt(line[1:])
+		if !ok || length < 0 {
+			return nil, 0, FORMAT_ERR
+		}
+		if length > int64(maxBulk) {
+			return nil, 0, TOO_BIG_BULK_ERR
+		}
+		pos += m
+		if int64(len(buf)-pos) < length+2 {
+			return nil, 0, nil
+		}
+		end := pos + int(length)
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return nil, 0, FORMAT_ERR
+		}
+		args = append(args, buf[pos:end])
+		pos = end + 2
+	}
+	return args, pos, nil
+}
+
+// 带缓冲区的增量解析
+// src 为nil时只解析通过 Feed 追加的数据，否则 ReadValue/ReadCommand 在数据不足时从 src 读取
+// 返回的值引用内部缓冲区，在下一次 Feed 或读取前有效
+type Reader struct {
+	src       io.Reader
+	buf       []byte
+	pos       int   // buf[pos:] 为未解析的数据
+	offset    int64 // 已解析的字节数
+	MaxInline int
+	MaxBulk   int
+	// 只接受multibulk格式的命令，如AOF文件
+	MultiBulkOnly bool
+	// 未完成的multibulk命令，数据分多次到达时从上次的位置继续解析
+	// 位置都相对于 pos，compact 时不变
+	cmdPos  int      // 已解析到的位置，0表示没有未完成的命令
+	cmdLeft int64    // 剩余的参数个数
+	bulkLen int64    // 当前参数的长度，-1表示还未读取长度
+	argPos  [][2]int // 已解析参数的起止位置
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src}
+}
+
+// 已解析的数据超过一半时移到开头，之前返回的值失效
+func (r *Reader) compact() {
+	if r.pos > 0 && r.pos >= len(r.buf)/2 {
+		n := copy(r.buf, r.buf[r.pos:])
+		r.buf = r.buf[:n]
+		r.pos = 0
+	}
+}
+
+func (r *Reader) Feed(p []byte) {
+	r.compact()
+	r.buf = append(r.buf, p...)
+}
+
+// 未解析的数据，包括未完成的命令
+func (r *Reader) Buffered() []byte {
+	return r.buf[r.pos:]
+}
+
+// 已解析的值或命令的总字节数，不包括未完成的部分
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+func (r *Reader) Reset() {
+	r.buf = r.buf[:0]
+	r.pos = 0
+	r.offset = 0
+	r.cmdPos = 0
+}
+
+func (r *Reader) advance(n int) {
+	r.pos += n
+	r.offset += int64(n)
+}
+
+// 解析已有数据中的一个值，不完整时返回false
+func (r *Reader) Next() (Value, bool, error) {
+	v, n, err := Parse(r.buf[r.pos:])
+	if err != nil || n == 0 {
+		return v, false, err
+	}
+	r.advance(n)
+	return v, true, nil
+}
+
+// 解析已有数据中的一条命令，不完整时返回false，空行返回长度为0的命令
+// 与 ParseCommand 格式相同，multibulk 不完整时保留已解析的参数，不重复解析
+func (r *Reader) NextCommand() ([][]byte, bool, error) {
+	buf := r.buf[r.pos:]
+	// 与inline相同，未结束的 *<count> 及 $<len> 行不能超过 MaxInline
+	maxInline := r.MaxInline
+	if maxInline <= 0 {
+		maxInline = DEFAULT_MAX_INLINE
+	}
+	if r.cmdPos == 0 {
+		if len(buf) == 0 {
+			return nil, false, nil
+		}
+		if buf[0] != '*' {
+			if r.MultiBulkOnly {
+				return nil, false, FORMAT_ERR
+			}
+			args, n, err := ParseInline(buf, r.MaxInline)
+			if err != nil || n == 0 {
+				return nil, false, err
+			}
+			r.advance(n)
+			return args, true, nil
+		}
+		line, n := parseLine(buf)
+		if n == 0 {
+			if len(buf) > maxInline {
+				return nil, false, TOO_BIG_INLINE_ERR
+			}
+			return nil, false, nil
+		}
+		count, ok := parseInt(line[1:])
+		if !ok || count > MAX_MULTIBULK {
+			return nil, false, FORMAT_ERR
+		}
+		if count <= 0 {
+			r.advance(n)
+			return nil, true, nil
+		}
+		r.cmdPos, r.cmdLeft, r.bulkLen = n, count, -1
+		r.argPos = r.argPos[:0]
+	}
+	maxBulk := r.MaxBulk
+	if maxBulk <= 0 {
+		maxBulk = DEFAULT_MAX_BULK
+	}
+	for r.cmdLeft > 0 {
+		if r.bulkLen < 0 {
+			line, m := parseLine(buf[r.cmdPos:])
+			if m == 0 {
+				if len(buf)-r.cmdPos > maxInline {
+					r.cmdPos = 0
+					return nil, false, TOO_BIG_INLINE_ERR
+				}
+				return nil, false, nil
+			}
+			if len(line) == 0 || line[0] != '$' {
+				r.cmdPos = 0
+				return nil, false, FORMAT_ERR
+			}
+			length, ok := parseInt(line[1:])
+			if !ok || length < 0 {
+				r.cmdPos = 0
+				return nil, false, FORMAT_ERR
+			}
+			if length > int64(maxBulk) {
+				r.cmdPos = 0
+				return nil, false, TOO_BIG_BULK_ERR
+			}
+			r.bulkLen = length
+			r.cmdPos += m
+		}
+		if int64(len(buf)-r.cmdPos) < r.bulkLen+2 {
+			return nil, false, nil
+		}
+		end := r.cmdPos + int(r.bulkLen)
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			r.cmdPos = 0
+			return nil, false, FORMAT_ERR
+		}
+		r.argPos = append(r.argPos, [2]int{r.cmdPos, end})
+		r.cmdPos = end + 2
+		r.cmdLeft--
+		r.bulkLen = -1
+	}
+	args := make([][]byte, len(r.argPos))
+	for i, p := range r.argPos {
+		args[i] = buf[p[0]:p[1]]
+	}
+	r.advance(r.cmdPos)
+	r.cmdPos = 0
+	return args, true, nil
+}
+
+// 从 src 读取一次追加到缓冲区，不解析
+// 用于事件循环通知可读的非阻塞连接，之后通过 NextCommand 解析
+func (r *Reader) ReadMore() (int, error) {
+	if r.src == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.compact()
+	if cap(r.buf)-len(r.buf) < readSize {
+		buf := make([]byte, len(r.buf), 2*cap(r.buf)+readSize)
+		copy(buf, r.buf)
+		r.buf = buf
+	}
+	n, err := r.src.Read(r.buf[len(r.buf):cap(r.buf)])
+	r.buf = r.buf[:len(r.buf)+n]
+	return n, err
+}
+
+// 从 src 读取更多数据
+func (r *Reader) fill() error {
+	n, err := r.ReadMore()
+	if n > 0 {
+		return nil
+	}
+	if err == io.EOF && r.pos < len(r.buf) {
+		return io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// 读取一个值，没有数据时返回 io.EOF，数据不完整时返回 io.ErrUnexpectedEOF
+func (r *Reader) ReadValue() (Value, error) {
+	for {
+		v, ok, err := r.Next()
+		if err != nil || ok {
+			return v, err
+		}
+		if err := r.fill(); err != nil {
+			return v, err
+		}
+	}
+}
+
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		args, ok, err := r.NextCommand()
+		if err != nil || ok {
+			return args, err
+		}
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
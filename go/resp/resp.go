@@ -0,0 +1,136 @@
+// Package resp 实现 Redis 序列化协议 RESP2/RESP3 的解析与编码
+// 解析: Parse 解析一个完整的值，ParseCommand 解析客户端命令(inline或multibulk)，数据不完整时返回的长度为0
+// Reader 在此基础上维护缓冲区，可以分段追加数据(Feed)或从 io.Reader 读取
+// 编码: AppendXXX 追加到 []byte，Writer 在此基础上写入 io.Writer
+package resp
+
+import (
+	"errors"
+	"strconv"
+)
+
+type Type byte
+
+const (
+	SimpleString Type = '+'
+	Error        Type = '-'
+	Integer      Type = ':'
+	BulkString   Type = '$'
+	Array        Type = '*'
+	// RESP3
+	Null      Type = '_'
+	Boolean   Type = '#'
+	Double    Type = ','
+	BigNumber Type = '('
+	BulkError Type = '!'
+	Verbatim  Type = '='
+	Map       Type = '%'
+	Set       Type = '~'
+	Attribute Type = '|'
+	Push      Type = '>'
+)
+
+var (
+	FORMAT_ERR         = errors.New("resp: bad format")
+	TOO_BIG_INLINE_ERR = errors.New("resp: too big inline cmd")
+	TOO_BIG_BULK_ERR   = errors.New("resp: too big bulk")
+)
+
+// 解析得到的值
+// Str 引用解析时的缓冲区，缓冲区被修改后失效，需要保留时复制
+type Value struct {
+	Type Type
+	// 简单字符串、错误、bulk、大整数及verbatim的内容
+	Str []byte
+	// verbatim 的格式，如 txt
+	Format string
+	Int    int64
+	Double float64
+	Bool   bool
+	// 数组、set、push的元素，map及attribute为 key value 交替
+	Elems []Value
+	// RESP2 的 $-1 及 *-1
+	Nil bool
+}
+
+func (v Value) IsNull() bool {
+	return v.Type == Null || v.Nil
+}
+
+func (v Value) Text() string {
+	return string(v.Str)
+}
+
+// 错误类型的值转为error，其他类型返回nil
+func (v Value) Err() error {
+	if v.Type == Error || v.Type == BulkError {
+		return errors.New(string(v.Str))
+	}
+	return nil
+}
+
+// 元素均为字符串的数组，如客户端命令
+func (v Value) Strings() ([]string, bool) {
+	if v.Type != Array || v.Nil {
+		return nil, false
+	}
+	strs := make([]string, len(v.Elems))
+	for i, e := range v.Elems {
+		if (e.Type != BulkString && e.Type != SimpleString) || e.Nil {
+			return nil, false
+		}
+		strs[i] = string(e.Str)
+	}
+	return strs, true
+}
+
+// 元素均为整数的数组
+func (v Value) Ints() ([]int64, bool) {
+	if v.Type != Array || v.Nil {
+		return nil, false
+	}
+	ints := make([]int64, len(v.Elems))
+	for i, e := range v.Elems {
+		if e.Type != Integer {
+			return nil, false
+		}
+		ints[i] = e.Int
+	}
+	return ints, true
+}
+
+// 长度及整数不经过string转换
+func parseInt(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	neg := b[0] == '-'
+	if neg || b[0] == '+' {
+		b = b[1:]
+		if len(b) == 0 {
+			return 0, false
+		}
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + uint64(c-'0')
+		if n > 1<<63 {
+			return 0, false
+		}
+	}
+	if neg {
+		return -int64(n), true
+	}
+	if n > 1<<63-1 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+func parseDouble(b []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(string(b), 64)
+	return f, err == nil
+}
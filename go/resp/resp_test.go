@@ -0,0 +1,380 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	v, n, err := Parse([]byte("+OK\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, SimpleString, v.Type)
+	assert.Equal(t, "OK", v.Text())
+
+	v, _, _ = Parse([]byte("-ERR bad\r\n"))
+	assert.Equal(t, "ERR bad", v.Err().Error())
+	v, _, _ = Parse([]byte(":-42\r\n"))
+	assert.Equal(t, int64(-42), v.Int)
+	v, _, _ = Parse([]byte("$5\r\na\r\nbc\r\n"))
+	assert.Equal(t, "a\r\nbc", v.Text())
+	v, _, _ = Parse([]byte("$0\r\n\r\n"))
+	assert.Equal(t, "", v.Text())
+	assert.False(t, v.IsNull())
+	v, _, _ = Parse([]byte("$-1\r\n"))
+	assert.True(t, v.IsNull())
+	v, _, _ = Parse([]byte("*-1\r\n"))
+	assert.True(t, v.IsNull())
+	v, _, _ = Parse([]byte("_\r\n"))
+	assert.True(t, v.IsNull())
+	v, _, _ = Parse([]byte("#t\r\n"))
+	assert.True(t, v.Bool)
+	v, _, _ = Parse([]byte(",1.5\r\n"))
+	assert.Equal(t, 1.5, v.Double)
+	v, _, _ = Parse([]byte(",-inf\r\n"))
+	assert.True(t, math.IsInf(v.Double, -1))
+	v, _, _ = Parse([]byte("(3492890328409238509324850943850943825024385\r\n"))
+	assert.Equal(t, BigNumber, v.Type)
+	assert.Equal(t, "3492890328409238509324850943850943825024385", v.Text())
+	v, _, _ = Parse([]byte("!9\r\nERR: oops\r\n"))
+	assert.Equal(t, "ERR: oops", v.Err().Error())
+	v, _, _ = Parse([]byte("=7\r\ntxt:abc\r\n"))
+	assert.Equal(t, "txt", v.Format)
+	assert.Equal(t, "abc", v.Text())
+
+	v, n, _ = Parse([]byte("%2\r\n+a\r\n:1\r\n+b\r\n~1\r\n_\r\nrest"))
+	assert.Equal(t, 23, n)
+	assert.Equal(t, Map, v.Type)
+	assert.Equal(t, 4, len(v.Elems))
+	assert.Equal(t, Set, v.Elems[3].Type)
+	v, _, _ = Parse([]byte(">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n"))
+	assert.Equal(t, Push, v.Type)
+	v, _, _ = Parse([]byte("|1\r\n+ttl\r\n:3\r\n"))
+	assert.Equal(t, Attribute, v.Type)
+	assert.Equal(t, 2, len(v.Elems))
+
+	v, _, _ = Parse([]byte("*3\r\n$3\r\nset\r\n+k\r\n$1\r\nv\r\n"))
+	strs, ok := v.Strings()
+	assert.True(t, ok)
+	assert.Equal(t, []string{"set", "k", "v"}, strs)
+	v, _, _ = Parse([]byte("*2\r\n:1\r\n:-1\r\n"))
+	ints, ok := v.Ints()
+	assert.True(t, ok)
+	assert.Equal(t, []int64{1, -1}, ints)
+	_, ok = v.Strings()
+	assert.False(t, ok)
+
+	// 不完整
+	for _, s := range []string{"", "+OK", "+OK\r", "$3\r\nab", "$3\r\nabc\r", "*2\r\n:1\r\n", "%1\r\n+a\r\n", "*1000000\r\n"} {
+		_, n, err = Parse([]byte(s))
+		assert.Nil(t, err, s)
+		assert.Equal(t, 0, n, s)
+	}
+	// 格式错误
+	for _, s := range []string{"\r\n", "?\r\n", ":a\r\n", "$-2\r\n", "$3\r\nabcd\r\n", "#x\r\n", ",x\r\n", "_1\r\n", "=2\r\nab\r\n", "%-1\r\n", "*1\r\n:x\r\n"} {
+		_, _, err = Parse([]byte(s))
+		assert.Equal(t, FORMAT_ERR, err, s)
+	}
+	_, _, err = Parse([]byte(strings.Repeat("*1\r\n", MAX_DEPTH+2) + ":1\r\n"))
+	assert.Equal(t, FORMAT_ERR, err)
+}
+
+func TestParseCommand(t *testing.T) {
+	args, n, err := ParseCommand([]byte("set  key val\r\nget"), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 14, n)
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("key"), []byte("val")}, args)
+	args, n, _ = ParseCommand([]byte("\r\n"), 0, 0)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 0, len(args))
+	_, n, err = ParseCommand([]byte("get"), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	_, _, err = ParseCommand([]byte("get aaaa"), 4, 0)
+	assert.Equal(t, TOO_BIG_INLINE_ERR, err)
+
+	buf := []byte("*2\r\n$3\r\nget\r\n$3\r\na b\r\n")
+	args, n, err = ParseCommand(buf, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, [][]byte{[]byte("get"), []byte("a b")}, args)
+	for i := 0; i < len(buf); i++ {
+		_, n, err = ParseCommand(buf[:i], 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+	}
+	_, n, _ = ParseCommand([]byte("*0\r\n"), 0, 0)
+	assert.Equal(t, 4, n)
+	_, _, err = ParseCommand([]byte("*1\r\n$5\r\n"), 0, 4)
+	assert.Equal(t, TOO_BIG_BULK_ERR, err)
+	_, n, _ = ParseCommand([]byte("*5000\r\n"), 0, 4)
+	assert.Equal(t, 0, n)
+	for _, s := range []string{"*x\r\n", "*1048577\r\n", "*1\r\n:1\r\n", "*1\r\n$-1\r\n", "*1\r\n$1\r\nab\r\n"} {
+		_, _, err = ParseCommand([]byte(s), 0, 0)
+		assert.Equal(t, FORMAT_ERR, err, s)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.WriteSimpleString("OK\r\n")
+	w.WriteError("ERR bad")
+	w.WriteInt(-1)
+	w.WriteBulkString("ab")
+	w.WriteNullBulk()
+	w.WriteNullArray()
+	w.WriteNull()
+	w.WriteBool(false)
+	w.WriteDouble(math.Inf(1))
+	w.WriteDouble(0.25)
+	w.WriteBigNumber("123")
+	w.WriteBulkError("ERR x")
+	w.WriteVerbatim("txt", "hi")
+	w.WriteMapLen(1)
+	w.WriteSetLen(0)
+	w.WritePushLen(0)
+	w.WriteAttributeLen(0)
+	w.WriteCommand([]string{"get", "k"})
+	w.WriteBulkLen(3)
+	assert.Equal(t, 0, out.Len())
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, "+OK  \r\n-ERR bad\r\n:-1\r\n$2\r\nab\r\n$-1\r\n*-1\r\n_\r\n#f\r\n,inf\r\n,0.25\r\n(123\r\n!5\r\nERR x\r\n=6\r\ntxt:hi\r\n"+
+		"%1\r\n~0\r\n>0\r\n|0\r\n*2\r\n$3\r\nget\r\n$1\r\nk\r\n$3\r\n", out.String())
+	assert.Equal(t, 0, len(w.Bytes()))
+}
+
+// 编码后重新解析得到相同的数据
+func TestRoundTrip(t *testing.T) {
+	data := "+OK\r\n-ERR\r\n:7\r\n$0\r\n\r\n$-1\r\n*-1\r\n_\r\n#t\r\n,1.5\r\n(12\r\n!1\r\nE\r\n=5\r\nmkd:x\r\n" +
+		"*2\r\n%1\r\n$1\r\nk\r\n~2\r\n:1\r\n:2\r\n|1\r\n+a\r\n+b\r\n>1\r\n+c\r\n"
+	r := NewReader(nil)
+	r.Feed([]byte(data))
+	var out []byte
+	for {
+		v, ok, err := r.Next()
+		assert.Nil(t, err)
+		if !ok {
+			break
+		}
+		out = AppendValue(out, v)
+	}
+	assert.Equal(t, data, string(out))
+	assert.Equal(t, 0, len(r.Buffered()))
+}
+
+func TestReaderFeed(t *testing.T) {
+	data := []byte("*2\r\n$3\r\nfoo\r\n:1\r\nset a b\r\n*1\r\n$4\r\nping\r\n")
+	r := NewReader(nil)
+	var vals []string
+	// 逐字节追加
+	for i := 0; i < 18; i++ {
+		r.Feed(data[i : i+1])
+		v, ok, err := r.Next()
+		assert.Nil(t, err)
+		if ok {
+			vals = append(vals, string(AppendValue(nil, v)))
+		}
+	}
+	assert.Equal(t, []string{"*2\r\n$3\r\nfoo\r\n:1\r\n"}, vals)
+
+	var cmds [][]string
+	for i := 18; i < len(data); i++ {
+		r.Feed(data[i : i+1])
+		args, ok, err := r.NextCommand()
+		assert.Nil(t, err)
+		if ok {
+			var cmd []string
+			for _, a := range args {
+				cmd = append(cmd, string(a))
+			}
+			cmds = append(cmds, cmd)
+		}
+	}
+	assert.Equal(t, [][]string{{"set", "a", "b"}, {"ping"}}, cmds)
+	_, ok, err := r.Next()
+	assert.False(t, ok)
+	assert.Nil(t, err)
+}
+
+// 命令分多次到达时从上次解析的位置继续
+func TestReaderResume(t *testing.T) {
+	r := NewReader(nil)
+	r.Feed([]byte("ping\r\n"))
+	args, ok, err := r.NextCommand()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(args[0]))
+
+	cmd := make([]string, 1000)
+	for i := range cmd {
+		cmd[i] = strconv.Itoa(i)
+	}
+	data := AppendCommand(nil, cmd)
+	for i := 0; i < len(data); i += 100 {
+		end := i + 100
+		if end > len(data) {
+			end = len(data)
+		}
+		r.Feed(data[i:end])
+		args, ok, err = r.NextCommand()
+		assert.Nil(t, err)
+		if end < len(data) {
+			assert.False(t, ok)
+			// 已解析的参数不再重复解析
+			assert.Equal(t, int64(len(cmd)-len(r.argPos)), r.cmdLeft)
+		}
+	}
+	assert.True(t, ok)
+	assert.Equal(t, len(cmd), len(args))
+	assert.Equal(t, "999", string(args[999]))
+	assert.Equal(t, 0, len(r.Buffered()))
+	assert.Equal(t, int64(6+len(data)), r.Offset())
+
+	// 格式错误后不保留未完成的命令
+	r.Feed([]byte("*2\r\n$3\r\nget\r\n"))
+	_, ok, _ = r.NextCommand()
+	assert.False(t, ok)
+	r.Feed([]byte(":1\r\n"))
+	_, _, err = r.NextCommand()
+	assert.Equal(t, FORMAT_ERR, err)
+	assert.Equal(t, 0, r.cmdPos)
+}
+
+// 没有CRLF的 *<count> 及 $<len> 行超过 MaxInline 时返回错误，避免无限缓存
+func TestReaderLongHeader(t *testing.T) {
+	for _, prefix := range []string{"*", "*1\r\n$"} {
+		r := NewReader(nil)
+		r.MaxInline = 16
+		r.Feed([]byte(prefix + "1111"))
+		_, ok, err := r.NextCommand()
+		assert.False(t, ok)
+		assert.Nil(t, err)
+		r.Feed([]byte(strings.Repeat("1", 16)))
+		_, _, err = r.NextCommand()
+		assert.Equal(t, TOO_BIG_INLINE_ERR, err, prefix)
+		assert.Equal(t, 0, r.cmdPos)
+	}
+}
+
+func TestParseBulkLen(t *testing.T) {
+	n, m, err := ParseBulkLen([]byte("$5\r\nREDIS"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, 4, m)
+	_, m, err = ParseBulkLen([]byte("$5"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, m)
+	_, _, err = ParseBulkLen([]byte("+OK\r\n"))
+	assert.Equal(t, FORMAT_ERR, err)
+	_, _, err = ParseBulkLen([]byte("$-1\r\n"))
+	assert.Equal(t, FORMAT_ERR, err)
+}
+
+// 每次只返回一个字节
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
+
+func TestReaderSrc(t *testing.T) {
+	r := NewReader(&oneByteReader{[]byte("$3\r\nabc\r\n*1\r\n$4\r\nping\r\n:1")})
+	v, err := r.ReadValue()
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", v.Text())
+	args, err := r.ReadCommand()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("ping")}, args)
+	_, err = r.ReadValue()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	r = NewReader(strings.NewReader(":1\r\n"))
+	v, err = r.ReadValue()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), v.Int)
+	_, err = r.ReadValue()
+	assert.Equal(t, io.EOF, err)
+
+	r = NewReader(strings.NewReader("?\r\n"))
+	_, err = r.ReadValue()
+	assert.Equal(t, FORMAT_ERR, err)
+}
+
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{"+OK\r\n", "$3\r\nabc\r\n", "*2\r\n:1\r\n$-1\r\n", "%1\r\n+a\r\n,1.5\r\n", "=5\r\ntxt:a\r\n", ">1\r\n#t\r\n", "|1\r\n+a\r\n_\r\n"} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, n, err := Parse(data)
+		if err != nil || n == 0 {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("consumed %d of %d", n, len(data))
+		}
+		// 重新编码后解析出相同的值
+		enc := AppendValue(nil, v)
+		v2, n2, err := Parse(enc)
+		if err != nil || n2 != len(enc) {
+			t.Fatalf("reparse %q: %d %v", enc, n2, err)
+		}
+		if enc2 := AppendValue(nil, v2); !bytes.Equal(enc, enc2) {
+			t.Fatalf("%q != %q", enc, enc2)
+		}
+		// 分段追加与一次解析结果一致
+		r := NewReader(nil)
+		for i := 0; i < n; i++ {
+			r.Feed(data[i : i+1])
+			v3, ok, err := r.Next()
+			if err != nil || ok != (i == n-1) {
+				t.Fatalf("feed %d: %v %v", i, ok, err)
+			}
+			if ok && !bytes.Equal(enc, AppendValue(nil, v3)) {
+				t.Fatalf("feed mismatch %q", data[:n])
+			}
+		}
+	})
+}
+
+func FuzzParseCommand(f *testing.F) {
+	for _, s := range []string{"set a b\r\n", "*2\r\n$3\r\nget\r\n$1\r\na\r\n", "*0\r\n", "\r\n"} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		args, n, err := ParseCommand(data, 1024, 1024)
+		if err != nil || n == 0 {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("consumed %d of %d", n, len(data))
+		}
+		strs := make([]string, len(args))
+		for i, a := range args {
+			strs[i] = string(a)
+		}
+		enc := AppendCommand(nil, strs)
+		args2, n2, err := ParseCommand(enc, 0, 0)
+		if err != nil || n2 != len(enc) || len(args2) != len(args) {
+			t.Fatalf("reparse %q: %d %v", enc, n2, err)
+		}
+		for i := range args {
+			if !bytes.Equal(args[i], args2[i]) {
+				t.Fatalf("arg %d: %q != %q", i, args[i], args2[i])
+			}
+		}
+	})
+}
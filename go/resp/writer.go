@@ -0,0 +1,248 @@
+package resp
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 简单字符串及错误不能包含换行
+func sanitizeLine(s string) string {
+	if strings.ContainsAny(s, "\r\n") {
+		return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	}
+	return s
+}
+
+func appendLine(dst []byte, t Type, s string) []byte {
+	dst = append(dst, byte(t))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+func appendLen(dst []byte, t Type, n int) []byte {
+	dst = append(dst, byte(t))
+	dst = strconv.AppendInt(dst, int64(n), 10)
+	return append(dst, '\r', '\n')
+}
+
+func appendBlob(dst []byte, t Type, s string) []byte {
+	dst = appendLen(dst, t, len(s))
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// +OK
+func AppendSimpleString(dst []byte, s string) []byte {
+	return appendLine(dst, SimpleString, sanitizeLine(s))
+}
+
+// msg 包含错误码，如 "ERR syntax error"
+func AppendError(dst []byte, msg string) []byte {
+	return appendLine(dst, Error, sanitizeLine(msg))
+}
+
+func AppendInt(dst []byte, n int64) []byte {
+	dst = append(dst, byte(Integer))
+	dst = strconv.AppendInt(dst, n, 10)
+	return append(dst, '\r', '\n')
+}
+
+func AppendBulk(dst []byte, b []byte) []byte {
+	dst = appendLen(dst, BulkString, len(b))
+	dst = append(dst, b...)
+	return append(dst, '\r', '\n')
+}
+
+func AppendBulkString(dst []byte, s string) []byte {
+	return appendBlob(dst, BulkString, s)
+}
+
+// 只有bulk的长度，之后的n字节数据由调用方写入，如复制时发送的快照（结尾没有CRLF）
+func AppendBulkLen(dst []byte, n int) []byte {
+	return appendLen(dst, BulkString, n)
+}
+
+// 之后为 n 个值
+func AppendArrayLen(dst []byte, n int) []byte {
+	return appendLen(dst, Array, n)
+}
+
+// 之后为 n 个 key value 对
+func AppendMapLen(dst []byte, n int) []byte {
+	return appendLen(dst, Map, n)
+}
+
+func AppendSetLen(dst []byte, n int) []byte {
+	return appendLen(dst, Set, n)
+}
+
+func AppendPushLen(dst []byte, n int) []byte {
+	return appendLen(dst, Push, n)
+}
+
+// 之后为 n 个 key value 对，再之后为被描述的值
+func AppendAttributeLen(dst []byte, n int) []byte {
+	return appendLen(dst, Attribute, n)
+}
+
+// RESP3 的null
+func AppendNull(dst []byte) []byte {
+	return append(dst, "_\r\n"...)
+}
+
+// RESP2 的 $-1
+func AppendNullBulk(dst []byte) []byte {
+	return append(dst, "$-1\r\n"...)
+}
+
+// RESP2 的 *-1
+func AppendNullArray(dst []byte) []byte {
+	return append(dst, "*-1\r\n"...)
+}
+
+func AppendBool(dst []byte, b bool) []byte {
+	if b {
+		return append(dst, "#t\r\n"...)
+	}
+	return append(dst, "#f\r\n"...)
+}
+
+// 与 zset 的分数格式一致，无穷为 inf/-inf
+func FormatDouble(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	} else if math.IsNaN(f) {
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func AppendDouble(dst []byte, f float64) []byte {
+	return appendLine(dst, Double, FormatDouble(f))
+}
+
+// 超出int64范围的整数
+func AppendBigNumber(dst []byte, num string) []byte {
+	return appendLine(dst, BigNumber, sanitizeLine(num))
+}
+
+func AppendBulkError(dst []byte, msg string) []byte {
+	return appendBlob(dst, BulkError, msg)
+}
+
+// 带格式的文本，format 为3个字符，如 txt
+func AppendVerbatim(dst []byte, format string, s string) []byte {
+	dst = appendLen(dst, Verbatim, len(s)+4)
+	dst = append(dst, format...)
+	dst = append(dst, ':')
+	dst = append(dst, s...)
+	return append(dst, '\r', '\n')
+}
+
+// 命令以bulk数组发送，同时也是AOF及复制的格式
+func AppendCommand(dst []byte, args []string) []byte {
+	dst = AppendArrayLen(dst, len(args))
+	for _, arg := range args {
+		dst = AppendBulkString(dst, arg)
+	}
+	return dst
+}
+
+// 按值的类型重新编码，Parse 的结果编码后与原数据等价
+func AppendValue(dst []byte, v Value) []byte {
+	switch v.Type {
+	case SimpleString, Error, BigNumber:
+		dst = append(dst, byte(v.Type))
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case Integer:
+		return AppendInt(dst, v.Int)
+	case Null:
+		return AppendNull(dst)
+	case Boolean:
+		return AppendBool(dst, v.Bool)
+	case Double:
+		return AppendDouble(dst, v.Double)
+	case BulkString, BulkError:
+		if v.Nil {
+			return AppendNullBulk(dst)
+		}
+		dst = appendLen(dst, v.Type, len(v.Str))
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case Verbatim:
+		dst = appendLen(dst, Verbatim, len(v.Str)+4)
+		dst = append(dst, v.Format...)
+		dst = append(dst, ':')
+		dst = append(dst, v.Str...)
+		return append(dst, '\r', '\n')
+	case Array, Map, Set, Attribute, Push:
+		if v.Nil {
+			return AppendNullArray(dst)
+		}
+		n := len(v.Elems)
+		if v.Type == Map || v.Type == Attribute {
+			n /= 2
+		}
+		dst = appendLen(dst, v.Type, n)
+		for _, e := range v.Elems {
+			dst = AppendValue(dst, e)
+		}
+		return dst
+	}
+	return dst
+}
+
+// 编码到缓冲区，Flush 时写入 w
+// w 为nil时只在缓冲区中编码，通过 Bytes 获取
+type Writer struct {
+	w   io.Writer
+	buf []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+func (w *Writer) Reset() {
+	w.buf = w.buf[:0]
+}
+
+func (w *Writer) Flush() error {
+	if w.w == nil || len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *Writer) WriteSimpleString(s string)     { w.buf = AppendSimpleString(w.buf, s) }
+func (w *Writer) WriteError(msg string)          { w.buf = AppendError(w.buf, msg) }
+func (w *Writer) WriteInt(n int64)               { w.buf = AppendInt(w.buf, n) }
+func (w *Writer) WriteBulk(b []byte)             { w.buf = AppendBulk(w.buf, b) }
+func (w *Writer) WriteBulkString(s string)       { w.buf = AppendBulkString(w.buf, s) }
+func (w *Writer) WriteBulkLen(n int)             { w.buf = AppendBulkLen(w.buf, n) }
+func (w *Writer) WriteArrayLen(n int)            { w.buf = AppendArrayLen(w.buf, n) }
+func (w *Writer) WriteMapLen(n int)              { w.buf = AppendMapLen(w.buf, n) }
+func (w *Writer) WriteSetLen(n int)              { w.buf = AppendSetLen(w.buf, n) }
+func (w *Writer) WritePushLen(n int)             { w.buf = AppendPushLen(w.buf, n) }
+func (w *Writer) WriteAttributeLen(n int)        { w.buf = AppendAttributeLen(w.buf, n) }
+func (w *Writer) WriteNull()                     { w.buf = AppendNull(w.buf) }
+func (w *Writer) WriteNullBulk()                 { w.buf = AppendNullBulk(w.buf) }
+func (w *Writer) WriteNullArray()                { w.buf = AppendNullArray(w.buf) }
+func (w *Writer) WriteBool(b bool)               { w.buf = AppendBool(w.buf, b) }
+func (w *Writer) WriteDouble(f float64)          { w.buf = AppendDouble(w.buf, f) }
+func (w *Writer) WriteBigNumber(num string)      { w.buf = AppendBigNumber(w.buf, num) }
+func (w *Writer) WriteBulkError(msg string)      { w.buf = AppendBulkError(w.buf, msg) }
+func (w *Writer) WriteVerbatim(format, s string) { w.buf = AppendVerbatim(w.buf, format, s) }
+func (w *Writer) WriteCommand(args []string)     { w.buf = AppendCommand(w.buf, args) }
+func (w *Writer) WriteValue(v Value)             { w.buf = AppendValue(w.buf, v) }
@@ -0,0 +1,994 @@
+package main
+
+import (
+	"akt-redis/ae"
+	"akt-redis/conf"
+	"akt-redis/net"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	gonet "net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sentinel 模式
+// 使用 --sentinel 参数或配置 sentinel 启动，不保存数据，只监控配置的主节点及其replica
+// 定期向每个节点发送 PING 和 INFO replication，从主节点的INFO中发现replica
+// 主节点超过 down_after_milliseconds 没有有效回复时为主观下线(sdown)，
+// 通过 SENTINEL is-master-down-by-addr 询问其他sentinel，达到quorum时为客观下线(odown)
+// 客观下线后增加epoch并请求其他sentinel投票，获得过半且不少于quorum的票后成为leader执行failover：
+// 选择复制偏移量最大的replica发送 REPLICAOF NO ONE，确认提升后让其他replica复制新的主节点
+// sentinel 之间定期发送 sentinel.hello 交换自身地址及主节点配置，configEpoch 大的配置生效
+
+const (
+	SENTINEL_PING_PERIOD              = 1000 // ms
+	SENTINEL_INFO_PERIOD              = 1000
+	SENTINEL_HELLO_PERIOD             = 2000
+	SENTINEL_ASK_PERIOD               = 1000
+	SENTINEL_DOWN_REPLY_VALIDITY      = 5000 // 其他sentinel的下线判断的有效时间
+	SENTINEL_MAX_DESYNC               = 1000 // 开始failover的随机延迟，避免同时发起选举
+	SENTINEL_ELECTION_TIMEOUT         = 10000
+	SENTINEL_FIX_REPLICA_PERIOD       = 10000
+	DEFAULT_SENTINEL_DOWN_AFTER       = 30000
+	DEFAULT_SENTINEL_FAILOVER_TIMEOUT = 180000
+	DEFAULT_SENTINEL_ANNOUNCE_IP      = "127.0.0.1"
+)
+
+// 实例类型
+const (
+	SENTINEL_MASTER = iota
+	SENTINEL_REPLICA
+	SENTINEL_PEER // 其他sentinel
+)
+
+// 等待回复的请求
+const (
+	SENTINEL_REQ_PING = iota
+	SENTINEL_REQ_INFO
+	SENTINEL_REQ_ASK
+	SENTINEL_REQ_HELLO
+	SENTINEL_REQ_REPLICAOF
+	SENTINEL_REQ_AUTH
+)
+
+// failover 状态
+const (
+	SENTINEL_FAILOVER_NONE = iota
+	SENTINEL_FAILOVER_WAIT_START
+	SENTINEL_FAILOVER_SELECT_REPLICA
+	SENTINEL_FAILOVER_WAIT_PROMOTION
+	SENTINEL_FAILOVER_RECONF_REPLICAS
+)
+
+var failoverStateNames = []string{"none", "wait_start", "select_slave", "wait_promotion", "reconf_slaves"}
+
+var (
+	SENTINEL_CONFIG_ERR = errors.New("sentinel mode can't be used with appendonly, replicaof, raft or cluster")
+	SENTINEL_MASTER_ERR = errors.New("bad sentinel_monitor config")
+	SENTINEL_PEER_ERR   = errors.New("bad sentinel_peers address")
+	REPLY_FORMAT_ERR    = errors.New("bad reply format")
+)
+
+type sentinelInstance struct {
+	typ            int
+	ip             string
+	port           int
+	runid          string // 只用于sentinel
+	master         *sentinelMaster
+	fd             int // -1 表示未连接
+	buf            []byte
+	pending        []int // 等待回复的请求，按发送顺序
+	lastConnectTry int64
+	pingSent       int64 // 等待回复的PING的发送时间，0表示没有
+	lastPing       int64
+	lastAvail      int64 // 上次收到有效PING回复的时间
+	lastInfo       int64
+	infoRefresh    int64 // 上次收到INFO回复的时间
+	lastHello      int64
+	lastAsk        int64
+	lastReconf     int64 // 上次发送REPLICAOF的时间
+	// INFO 中的复制信息
+	role         string
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	// 其他sentinel对主节点的判断及投票
+	masterDown     bool
+	masterDownTime int64
+	leader         string
+	leaderEpoch    int64
+}
+
+type sentinelMaster struct {
+	name            string
+	inst            *sentinelInstance
+	quorum          int
+	downAfter       int64
+	failoverTimeout int64
+	authPass        string
+	configEpoch     int64
+	replicas        map[string]*sentinelInstance // ip:port
+	sentinels       map[string]*sentinelInstance // ip:port
+	sdown           bool
+	odown           bool
+	leader          string // 本sentinel在 leaderEpoch 的投票
+	leaderEpoch     int64
+	failoverState   int
+	failoverEpoch   int64
+	failoverStart   int64
+	failoverChange  int64 // 状态变化的时间
+	promoted        *sentinelInstance
+}
+
+type sentinelState struct {
+	myid         string
+	ip           string
+	currentEpoch int64
+	masters      []*sentinelMaster
+}
+
+var sentinelCmdTable = []GodisCommand{
+	{"ping", pingCommand, -1, 0},
+	{"hello", helloCommand, -1, 0},
+	{"auth", authCommand, -2, 0},
+	{"client", clientCommand, -2, 0},
+	{"info", sentinelInfoCommand, -1, 0},
+	{"sentinel", sentinelCommand, -2, 0},
+	{"sentinel.hello", sentinelHelloCommand, 9, 0},
+}
+
+func lookupSentinelCommand(cmdStr string) *GodisCommand {
+	for i := range sentinelCmdTable {
+		if sentinelCmdTable[i].name == cmdStr {
+			return &sentinelCmdTable[i]
+		}
+	}
+	return nil
+}
+
+func initSentinel(config *conf.Config) error {
+	if config.AppendOnly || config.ReplicaOf != "" || config.Raft || config.ClusterEnabled {
+		return SENTINEL_CONFIG_ERR
+	}
+	closeRaft()
+	closeCluster()
+	if err := initReplication(config); err != nil {
+		return err
+	}
+	st := &sentinelState{myid: genReplId(), ip: config.SentinelAnnounceIp}
+	if st.ip == "" {
+		st.ip = DEFAULT_SENTINEL_ANNOUNCE_IP
+	}
+	var peers [][2]string
+	for _, addr := range config.SentinelPeers {
+		host, port, err := gonet.SplitHostPort(addr)
+		if err != nil {
+			return SENTINEL_PEER_ERR
+		}
+		peers = append(peers, [2]string{host, port})
+	}
+	var err error
+	if server.aeLoop, err = ae.AeLoopCreate(); err != nil {
+		return err
+	}
+	server.sentinel = st
+	for _, mc := range config.SentinelMonitor {
+		if mc.Name == "" || mc.Quorum <= 0 {
+			server.sentinel = nil
+			return SENTINEL_MASTER_ERR
+		}
+		m := &sentinelMaster{
+			name:            mc.Name,
+			quorum:          mc.Quorum,
+			downAfter:       mc.DownAfter,
+			failoverTimeout: mc.FailoverTimeout,
+			authPass:        mc.AuthPass,
+			replicas:        make(map[string]*sentinelInstance),
+			sentinels:       make(map[string]*sentinelInstance),
+		}
+		if m.downAfter <= 0 {
+			m.downAfter = DEFAULT_SENTINEL_DOWN_AFTER
+		}
+		if m.failoverTimeout <= 0 {
+			m.failoverTimeout = DEFAULT_SENTINEL_FAILOVER_TIMEOUT
+		}
+		m.inst = createSentinelInstance(m, SENTINEL_MASTER, mc.Host, mc.Port)
+		for _, p := range peers {
+			port, err := strconv.Atoi(p[1])
+			if err != nil {
+				server.sentinel = nil
+				return SENTINEL_PEER_ERR
+			}
+			sentinelAddPeer(m, p[0], port)
+		}
+		st.masters = append(st.masters, m)
+	}
+	server.fd, err = net.TcpServer(server.port)
+	return err
+}
+
+func closeSentinel() {
+	st := server.sentinel
+	if st == nil {
+		return
+	}
+	for _, m := range st.masters {
+		for _, inst := range sentinelInstances(m) {
+			sentinelCloseLink(inst)
+		}
+	}
+	server.sentinel = nil
+}
+
+func createSentinelInstance(m *sentinelMaster, typ int, ip string, port int) *sentinelInstance {
+	return &sentinelInstance{typ: typ, ip: ip, port: port, master: m, fd: -1, lastAvail: utils.GetMsTime()}
+}
+
+func instanceAddr(inst *sentinelInstance) string {
+	return fmt.Sprintf("%v:%d", inst.ip, inst.port)
+}
+
+// 添加其他sentinel，忽略自己
+func sentinelAddPeer(m *sentinelMaster, ip string, port int) *sentinelInstance {
+	addr := fmt.Sprintf("%v:%d", ip, port)
+	if addr == fmt.Sprintf("%v:%d", server.sentinel.ip, server.port) {
+		return nil
+	}
+	p := m.sentinels[addr]
+	if p == nil {
+		p = createSentinelInstance(m, SENTINEL_PEER, ip, port)
+		m.sentinels[addr] = p
+		log.Printf("+sentinel %v %v\n", m.name, addr)
+	}
+	return p
+}
+
+// 主节点、replica及其他sentinel
+func sentinelInstances(m *sentinelMaster) []*sentinelInstance {
+	insts := []*sentinelInstance{m.inst}
+	for _, r := range m.replicas {
+		insts = append(insts, r)
+	}
+	for _, p := range m.sentinels {
+		insts = append(insts, p)
+	}
+	return insts
+}
+
+func sentinelLookupMaster(name string) *sentinelMaster {
+	for _, m := range server.sentinel.masters {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func sentinelCloseLink(inst *sentinelInstance) {
+	if inst.fd >= 0 {
+		server.aeLoop.RemoveFileEvent(inst.fd, ae.AE_READABLE)
+		net.Close(inst.fd)
+	}
+	inst.fd = -1
+	inst.buf = nil
+	inst.pending = nil
+	inst.pingSent = 0
+}
+
+func sentinelConnect(inst *sentinelInstance) bool {
+	now := utils.GetMsTime()
+	if now-inst.lastConnectTry < SENTINEL_PING_PERIOD {
+		return false
+	}
+	inst.lastConnectTry = now
+	ip, err := resolveHost(inst.ip)
+	if err != nil {
+		log.Printf("can't resolve %v: %v\n", inst.ip, err)
+		return false
+	}
+	fd, err := net.Connect(ip, inst.port)
+	if err != nil {
+		return false
+	}
+	inst.fd = fd
+	server.aeLoop.AddFileEvent(fd, ae.AE_READABLE, sentinelReadHandler, inst)
+	// 其他sentinel使用相同的 requirepass
+	pass := inst.master.authPass
+	if inst.typ == SENTINEL_PEER {
+		pass = server.requirePass
+	}
+	if pass != "" {
+		return sentinelSend(inst, SENTINEL_REQ_AUTH, "auth", pass)
+	}
+	return true
+}
+
+func sentinelSend(inst *sentinelInstance, req int, args ...string) bool {
+	if inst.fd < 0 {
+		return false
+	}
+	if _, err := net.Write(inst.fd, resp.AppendCommand(nil, args)); err != nil {
+		log.Printf("send to %v err: %v\n", instanceAddr(inst), err)
+		sentinelCloseLink(inst)
+		return false
+	}
+	inst.pending = append(inst.pending, req)
+	return true
+}
+
+func sentinelReadHandler(loop *ae.AeLoop, fd int, extra interface{}) {
+	inst := extra.(*sentinelInstance)
+	buf := make([]byte, utils.GODIS_IO_BUF)
+	n, err := net.Read(fd, buf)
+	if err != nil || n == 0 {
+		sentinelCloseLink(inst)
+		return
+	}
+	inst.buf = append(inst.buf, buf[:n]...)
+	for inst.fd == fd && len(inst.buf) > 0 {
+		reply, consumed, err := resp.Parse(inst.buf)
+		if err == nil && consumed > 0 && len(inst.pending) == 0 {
+			err = REPLY_FORMAT_ERR
+		}
+		if err != nil {
+			log.Printf("%v reply err: %v\n", instanceAddr(inst), err)
+			sentinelCloseLink(inst)
+			return
+		}
+		if consumed == 0 {
+			return
+		}
+		req := inst.pending[0]
+		inst.pending = inst.pending[1:]
+		sentinelHandleReply(inst, req, reply)
+		// reply 引用 inst.buf，处理完后再移除
+		inst.buf = inst.buf[consumed:]
+	}
+}
+
+func sentinelHandleReply(inst *sentinelInstance, req int, reply resp.Value) {
+	now := utils.GetMsTime()
+	switch req {
+	case SENTINEL_REQ_PING:
+		inst.pingSent = 0
+		// 加载数据中等状态也认为可用
+		if reply.Type == resp.SimpleString && reply.Text() == "PONG" {
+			inst.lastAvail = now
+		} else if err := reply.Err(); err != nil && strings.HasPrefix(err.Error(), "LOADING") {
+			inst.lastAvail = now
+		}
+	case SENTINEL_REQ_INFO:
+		if (reply.Type == resp.BulkString || reply.Type == resp.Verbatim) && !reply.IsNull() {
+			inst.infoRefresh = now
+			sentinelRefreshInstanceInfo(inst, reply.Text())
+		}
+	case SENTINEL_REQ_ASK:
+		vals := reply.Elems
+		if reply.Type != resp.Array || len(vals) != 3 || vals[0].Type != resp.Integer ||
+			vals[1].Type != resp.BulkString || vals[1].IsNull() || vals[2].Type != resp.Integer {
+			return
+		}
+		leader := vals[1].Text()
+		inst.masterDown = vals[0].Int == 1
+		inst.masterDownTime = now
+		if leader != "*" {
+			inst.leader = leader
+			inst.leaderEpoch = vals[2].Int
+		}
+	case SENTINEL_REQ_REPLICAOF, SENTINEL_REQ_AUTH:
+		if err := reply.Err(); err != nil {
+			log.Printf("%v reply err: %v\n", instanceAddr(inst), err)
+		}
+	}
+}
+
+// 解析 INFO replication
+func sentinelRefreshInstanceInfo(inst *sentinelInstance, info string) {
+	m := inst.master
+	for _, line := range strings.Split(info, "\r\n") {
+		key, val, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch {
+		case key == "role":
+			if inst.role != "" && inst.role != val {
+				log.Printf("%v %v role changed to %v\n", m.name, instanceAddr(inst), val)
+			}
+			inst.role = val
+		case key == "master_host":
+			inst.masterHost = val
+		case key == "master_port":
+			inst.masterPort, _ = strconv.Atoi(val)
+		case key == "master_link_status":
+			inst.masterLinkUp = val == "up"
+		case key == "slave_repl_offset":
+			inst.replOffset, _ = strconv.ParseInt(val, 10, 64)
+		case strings.HasPrefix(key, "slave") && inst == m.inst:
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=100
+			var ip string
+			var port int
+			for _, kv := range strings.Split(val, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				if k == "ip" {
+					ip = v
+				} else if k == "port" {
+					port, _ = strconv.Atoi(v)
+				}
+			}
+			if ip == "" || port <= 0 {
+				continue
+			}
+			addr := fmt.Sprintf("%v:%d", ip, port)
+			if m.replicas[addr] == nil {
+				m.replicas[addr] = createSentinelInstance(m, SENTINEL_REPLICA, ip, port)
+				log.Printf("+slave %v %v\n", m.name, addr)
+			}
+		}
+	}
+	if inst.role == "master" {
+		inst.masterHost = ""
+		inst.masterPort = 0
+		inst.replOffset = 0
+	}
+}
+
+// 连接并按周期发送 PING / INFO / sentinel.hello
+func sentinelSendPeriodicCommands(inst *sentinelInstance) {
+	m := inst.master
+	if inst.fd < 0 && !sentinelConnect(inst) {
+		return
+	}
+	now := utils.GetMsTime()
+	if inst.pingSent != 0 && now-inst.pingSent > m.downAfter/2 {
+		// 连接可能已失效，重新连接
+		sentinelCloseLink(inst)
+		return
+	}
+	if inst.pingSent == 0 && now-inst.lastPing >= SENTINEL_PING_PERIOD && sentinelSend(inst, SENTINEL_REQ_PING, "ping") {
+		inst.pingSent = now
+		inst.lastPing = now
+	}
+	if inst.typ == SENTINEL_PEER {
+		if now-inst.lastHello >= SENTINEL_HELLO_PERIOD {
+			st := server.sentinel
+			sentinelSend(inst, SENTINEL_REQ_HELLO, "sentinel.hello", st.ip, strconv.Itoa(server.port), st.myid, formatInt(st.currentEpoch),
+				m.name, m.inst.ip, strconv.Itoa(m.inst.port), formatInt(m.configEpoch))
+			inst.lastHello = now
+		}
+	} else if now-inst.lastInfo >= SENTINEL_INFO_PERIOD {
+		sentinelSend(inst, SENTINEL_REQ_INFO, "info", "replication")
+		inst.lastInfo = now
+	}
+}
+
+func instanceIsDown(inst *sentinelInstance) bool {
+	return utils.GetMsTime()-inst.lastAvail > inst.master.downAfter
+}
+
+func sentinelCheckSubjectivelyDown(m *sentinelMaster) {
+	down := instanceIsDown(m.inst)
+	if down && !m.sdown {
+		m.sdown = true
+		log.Printf("+sdown master %v %v\n", m.name, instanceAddr(m.inst))
+	} else if !down && m.sdown {
+		m.sdown = false
+		log.Printf("-sdown master %v %v\n", m.name, instanceAddr(m.inst))
+	}
+}
+
+func sentinelCheckObjectivelyDown(m *sentinelMaster) {
+	odown := false
+	if m.sdown {
+		votes := 1
+		now := utils.GetMsTime()
+		for _, p := range m.sentinels {
+			if p.masterDown && now-p.masterDownTime < SENTINEL_DOWN_REPLY_VALIDITY {
+				votes++
+			}
+		}
+		odown = votes >= m.quorum
+	}
+	if odown && !m.odown {
+		log.Printf("+odown master %v %v #quorum %d\n", m.name, instanceAddr(m.inst), m.quorum)
+	} else if !odown && m.odown {
+		log.Printf("-odown master %v %v\n", m.name, instanceAddr(m.inst))
+	}
+	m.odown = odown
+}
+
+// 询问其他sentinel主节点是否下线，failover开始后同时请求投票
+func sentinelAskMasterStateToOtherSentinels(m *sentinelMaster, force bool) {
+	if !m.sdown {
+		return
+	}
+	st := server.sentinel
+	now := utils.GetMsTime()
+	runid := "*"
+	if m.failoverState > SENTINEL_FAILOVER_NONE {
+		runid = st.myid
+	}
+	for _, p := range m.sentinels {
+		if now-p.masterDownTime > SENTINEL_DOWN_REPLY_VALIDITY {
+			p.masterDown = false
+			p.leader = ""
+		}
+		if p.fd < 0 || (!force && now-p.lastAsk < SENTINEL_ASK_PERIOD) {
+			continue
+		}
+		if sentinelSend(p, SENTINEL_REQ_ASK, "sentinel", "is-master-down-by-addr", m.inst.ip, strconv.Itoa(m.inst.port), formatInt(st.currentEpoch), runid) {
+			p.lastAsk = now
+		}
+	}
+}
+
+// 在 epoch 投票给 runid，每个epoch只投一次，返回本sentinel的投票
+func sentinelVoteLeader(m *sentinelMaster, epoch int64, runid string) (string, int64) {
+	st := server.sentinel
+	if epoch > st.currentEpoch {
+		st.currentEpoch = epoch
+		log.Printf("+new-epoch %d\n", epoch)
+	}
+	if m.leaderEpoch < epoch && st.currentEpoch <= epoch {
+		m.leader = runid
+		m.leaderEpoch = st.currentEpoch
+		log.Printf("+vote-for-leader %v %d\n", runid, epoch)
+		// 投票给其他sentinel后一段时间内不发起failover
+		if runid != st.myid {
+			m.failoverStart = utils.GetMsTime() + rand.Int63n(SENTINEL_MAX_DESYNC)
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// 统计 epoch 的投票，得票过半且不少于quorum时返回leader
+func sentinelGetLeader(m *sentinelMaster, epoch int64) string {
+	st := server.sentinel
+	votes := make(map[string]int)
+	for _, p := range m.sentinels {
+		if p.leader != "" && p.leaderEpoch == epoch {
+			votes[p.leader]++
+		}
+	}
+	winner, max := "", 0
+	for runid, n := range votes {
+		if n > max || (n == max && runid < winner) {
+			winner, max = runid, n
+		}
+	}
+	// 投票给当前得票最多的sentinel，没有时投给自己
+	if winner == "" {
+		winner = st.myid
+	}
+	if leader, leaderEpoch := sentinelVoteLeader(m, epoch, winner); leaderEpoch == epoch {
+		votes[leader]++
+		if votes[leader] > max || (votes[leader] == max && leader < winner) {
+			winner, max = leader, votes[leader]
+		}
+	}
+	voters := len(m.sentinels) + 1
+	if max < voters/2+1 || max < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+func sentinelStartFailoverIfNeeded(m *sentinelMaster) {
+	if !m.odown || m.failoverState != SENTINEL_FAILOVER_NONE {
+		return
+	}
+	now := utils.GetMsTime()
+	if now < m.failoverStart+2*m.failoverTimeout {
+		return
+	}
+	st := server.sentinel
+	st.currentEpoch++
+	m.failoverEpoch = st.currentEpoch
+	m.failoverState = SENTINEL_FAILOVER_WAIT_START
+	m.failoverStart = now + rand.Int63n(SENTINEL_MAX_DESYNC)
+	m.failoverChange = now
+	log.Printf("+try-failover master %v %v epoch %d\n", m.name, instanceAddr(m.inst), m.failoverEpoch)
+	sentinelAskMasterStateToOtherSentinels(m, true)
+}
+
+func sentinelAbortFailover(m *sentinelMaster, reason string) {
+	log.Printf("-failover-abort-%v master %v %v\n", reason, m.name, instanceAddr(m.inst))
+	m.failoverState = SENTINEL_FAILOVER_NONE
+	m.failoverChange = utils.GetMsTime()
+	m.promoted = nil
+}
+
+// 选择可用且复制偏移量最大的replica
+func sentinelSelectReplica(m *sentinelMaster) *sentinelInstance {
+	now := utils.GetMsTime()
+	var candidates []*sentinelInstance
+	for _, r := range m.replicas {
+		if r.fd < 0 || instanceIsDown(r) || r.role != "slave" || now-r.infoRefresh > 3*SENTINEL_INFO_PERIOD {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+		return instanceAddr(candidates[i]) < instanceAddr(candidates[j])
+	})
+	return candidates[0]
+}
+
+func sentinelFailoverStateMachine(m *sentinelMaster) {
+	st := server.sentinel
+	now := utils.GetMsTime()
+	switch m.failoverState {
+	case SENTINEL_FAILOVER_WAIT_START:
+		if now < m.failoverStart {
+			return
+		}
+		if sentinelGetLeader(m, m.failoverEpoch) != st.myid {
+			timeout := m.failoverTimeout
+			if timeout > SENTINEL_ELECTION_TIMEOUT {
+				timeout = SENTINEL_ELECTION_TIMEOUT
+			}
+			if now-m.failoverStart > timeout {
+				sentinelAbortFailover(m, "not-elected")
+			}
+			return
+		}
+		log.Printf("+elected-leader master %v epoch %d\n", m.name, m.failoverEpoch)
+		m.failoverState = SENTINEL_FAILOVER_SELECT_REPLICA
+		m.failoverChange = now
+		fallthrough
+	case SENTINEL_FAILOVER_SELECT_REPLICA:
+		r := sentinelSelectReplica(m)
+		if r == nil {
+			sentinelAbortFailover(m, "no-good-slave")
+			return
+		}
+		log.Printf("+selected-slave %v\n", instanceAddr(r))
+		sentinelSend(r, SENTINEL_REQ_REPLICAOF, "replicaof", "no", "one")
+		m.promoted = r
+		m.failoverState = SENTINEL_FAILOVER_WAIT_PROMOTION
+		m.failoverChange = now
+	case SENTINEL_FAILOVER_WAIT_PROMOTION:
+		if m.promoted.role != "master" {
+			if now-m.failoverChange > m.failoverTimeout {
+				sentinelAbortFailover(m, "slave-timeout")
+			}
+			return
+		}
+		log.Printf("+promoted-slave %v\n", instanceAddr(m.promoted))
+		m.configEpoch = m.failoverEpoch
+		m.failoverState = SENTINEL_FAILOVER_RECONF_REPLICAS
+		m.failoverChange = now
+		fallthrough
+	case SENTINEL_FAILOVER_RECONF_REPLICAS:
+		p := m.promoted
+		for _, r := range m.replicas {
+			if r != p && sentinelSend(r, SENTINEL_REQ_REPLICAOF, "replicaof", p.ip, strconv.Itoa(p.port)) {
+				r.lastReconf = now
+			}
+		}
+		sentinelSwitchMaster(m, p.ip, p.port)
+	}
+}
+
+// 主节点地址变化，原主节点作为replica，之后重新上线时将被配置为新主节点的replica
+func sentinelSwitchMaster(m *sentinelMaster, ip string, port int) {
+	old := m.inst
+	addr := fmt.Sprintf("%v:%d", ip, port)
+	log.Printf("+switch-master %v %v %v\n", m.name, instanceAddr(old), addr)
+	inst := m.replicas[addr]
+	if inst == nil {
+		inst = createSentinelInstance(m, SENTINEL_MASTER, ip, port)
+	}
+	delete(m.replicas, addr)
+	inst.typ = SENTINEL_MASTER
+	inst.lastAvail = utils.GetMsTime()
+	old.typ = SENTINEL_REPLICA
+	m.replicas[instanceAddr(old)] = old
+	m.inst = inst
+	m.sdown = false
+	m.odown = false
+	m.failoverState = SENTINEL_FAILOVER_NONE
+	m.promoted = nil
+}
+
+// 复制配置不正确的replica重新指向主节点，如重新上线的原主节点
+func sentinelFixReplicasConfig(m *sentinelMaster) {
+	if m.failoverState != SENTINEL_FAILOVER_NONE || m.sdown {
+		return
+	}
+	now := utils.GetMsTime()
+	for _, r := range m.replicas {
+		if r.infoRefresh == 0 || instanceIsDown(r) || now-r.lastReconf < SENTINEL_FIX_REPLICA_PERIOD {
+			continue
+		}
+		if r.role == "slave" && r.masterHost == m.inst.ip && r.masterPort == m.inst.port {
+			continue
+		}
+		if sentinelSend(r, SENTINEL_REQ_REPLICAOF, "replicaof", m.inst.ip, strconv.Itoa(m.inst.port)) {
+			log.Printf("+fix-slave-config %v %v\n", m.name, instanceAddr(r))
+			r.lastReconf = now
+		}
+	}
+}
+
+// 每100ms执行
+func sentinelCron() {
+	for _, m := range server.sentinel.masters {
+		for _, inst := range sentinelInstances(m) {
+			sentinelSendPeriodicCommands(inst)
+		}
+		sentinelCheckSubjectivelyDown(m)
+		sentinelCheckObjectivelyDown(m)
+		sentinelStartFailoverIfNeeded(m)
+		sentinelFailoverStateMachine(m)
+		sentinelAskMasterStateToOtherSentinels(m, false)
+		sentinelFixReplicasConfig(m)
+	}
+}
+
+func instanceFlags(inst *sentinelInstance) string {
+	flags := []string{"master"}
+	switch inst.typ {
+	case SENTINEL_REPLICA:
+		flags[0] = "slave"
+	case SENTINEL_PEER:
+		flags[0] = "sentinel"
+	}
+	m := inst.master
+	if inst.typ == SENTINEL_MASTER {
+		if m.sdown {
+			flags = append(flags, "s_down")
+		}
+		if m.odown {
+			flags = append(flags, "o_down")
+		}
+		if m.failoverState != SENTINEL_FAILOVER_NONE {
+			flags = append(flags, "failover_in_progress")
+		}
+	} else if instanceIsDown(inst) {
+		flags = append(flags, "s_down")
+	}
+	if inst == m.promoted {
+		flags = append(flags, "promoted")
+	}
+	if inst.fd < 0 {
+		flags = append(flags, "disconnected")
+	}
+	return strings.Join(flags, ",")
+}
+
+// 实例信息，按 key value 顺序排列
+func instanceFields(inst *sentinelInstance) []string {
+	m := inst.master
+	now := utils.GetMsTime()
+	fields := []string{
+		"name", instanceAddr(inst),
+		"ip", inst.ip,
+		"port", strconv.Itoa(inst.port),
+		"runid", inst.runid,
+		"flags", instanceFlags(inst),
+		"last-ok-ping-reply", formatInt(now - inst.lastAvail),
+		"down-after-milliseconds", formatInt(m.downAfter),
+	}
+	switch inst.typ {
+	case SENTINEL_MASTER:
+		fields[1] = m.name
+		fields = append(fields,
+			"role-reported", inst.role,
+			"config-epoch", formatInt(m.configEpoch),
+			"num-slaves", strconv.Itoa(len(m.replicas)),
+			"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+			"quorum", strconv.Itoa(m.quorum),
+			"failover-timeout", formatInt(m.failoverTimeout),
+			"failover-state", failoverStateNames[m.failoverState])
+	case SENTINEL_REPLICA:
+		linkStatus := "err"
+		if inst.masterLinkUp {
+			linkStatus = "ok"
+		}
+		fields = append(fields,
+			"role-reported", inst.role,
+			"master-host", inst.masterHost,
+			"master-port", strconv.Itoa(inst.masterPort),
+			"master-link-status", linkStatus,
+			"slave-repl-offset", formatInt(inst.replOffset))
+	case SENTINEL_PEER:
+		fields = append(fields,
+			"voted-leader", inst.leader,
+			"voted-leader-epoch", formatInt(inst.leaderEpoch))
+	}
+	return fields
+}
+
+// RESP3 中为map
+func addReplyInstance(c *GodisClient, inst *sentinelInstance) {
+	fields := instanceFields(inst)
+	c.AddReplyMapLen(len(fields) / 2)
+	for _, f := range fields {
+		c.AddReplyBulk(f)
+	}
+}
+
+func addReplyInstances(c *GodisClient, insts []*sentinelInstance) {
+	sort.Slice(insts, func(i, j int) bool { return instanceAddr(insts[i]) < instanceAddr(insts[j]) })
+	c.AddReplyArrayLen(len(insts))
+	for _, inst := range insts {
+		addReplyInstance(c, inst)
+	}
+}
+
+func getSentinelMasterFromArg(c *GodisClient, i int) *sentinelMaster {
+	m := sentinelLookupMaster(c.args[i].StrVal())
+	if m == nil {
+		c.AddReplyError("ERR: No such master with that name")
+	}
+	return m
+}
+
+// SENTINEL subcommand [args ...]
+func sentinelCommand(c *GodisClient) {
+	st := server.sentinel
+	sub := strings.ToLower(c.args[1].StrVal())
+	argc := len(c.args)
+	switch {
+	case sub == "myid" && argc == 2:
+		c.AddReplyBulk(st.myid)
+	case sub == "masters" && argc == 2:
+		var insts []*sentinelInstance
+		for _, m := range st.masters {
+			insts = append(insts, m.inst)
+		}
+		addReplyInstances(c, insts)
+	case sub == "master" && argc == 3:
+		if m := getSentinelMasterFromArg(c, 2); m != nil {
+			addReplyInstance(c, m.inst)
+		}
+	case (sub == "replicas" || sub == "slaves") && argc == 3:
+		if m := getSentinelMasterFromArg(c, 2); m != nil {
+			var insts []*sentinelInstance
+			for _, r := range m.replicas {
+				insts = append(insts, r)
+			}
+			addReplyInstances(c, insts)
+		}
+	case sub == "sentinels" && argc == 3:
+		if m := getSentinelMasterFromArg(c, 2); m != nil {
+			var insts []*sentinelInstance
+			for _, p := range m.sentinels {
+				insts = append(insts, p)
+			}
+			addReplyInstances(c, insts)
+		}
+	case sub == "get-master-addr-by-name" && argc == 3:
+		m := sentinelLookupMaster(c.args[2].StrVal())
+		if m == nil {
+			c.AddReplyNullArray()
+			return
+		}
+		c.AddReplyStrings([]string{m.inst.ip, strconv.Itoa(m.inst.port)})
+	case sub == "is-master-down-by-addr" && argc == 6:
+		sentinelIsMasterDownCommand(c)
+	default:
+		c.AddReplyError("ERR: unknown subcommand or wrong number of arguments")
+	}
+}
+
+// SENTINEL IS-MASTER-DOWN-BY-ADDR ip port current-epoch runid
+// runid 为 * 时只回复是否下线，否则同时投票，回复 down leader leader-epoch
+func sentinelIsMasterDownCommand(c *GodisClient) {
+	port, err := strconv.Atoi(c.args[3].StrVal())
+	if err != nil {
+		c.AddReplyError("ERR: invalid port")
+		return
+	}
+	epoch, ok := getIntFromArg(c, c.args[4])
+	if !ok {
+		return
+	}
+	ip, runid := c.args[2].StrVal(), c.args[5].StrVal()
+	var m *sentinelMaster
+	for _, sm := range server.sentinel.masters {
+		if sm.inst.ip == ip && sm.inst.port == port {
+			m = sm
+		}
+	}
+	down := m != nil && m.sdown
+	leader, leaderEpoch := "*", int64(0)
+	if m != nil && runid != "*" {
+		leader, leaderEpoch = sentinelVoteLeader(m, epoch, runid)
+	}
+	c.AddReplyArrayLen(3)
+	c.AddReplyInt(int64(boolToInt(down)))
+	c.AddReplyBulk(leader)
+	c.AddReplyInt(leaderEpoch)
+}
+
+// SENTINEL.HELLO ip port runid current-epoch master-name master-ip master-port master-config-epoch
+func sentinelHelloCommand(c *GodisClient) {
+	st := server.sentinel
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.StrVal()
+	}
+	port, err1 := strconv.Atoi(args[2])
+	epoch, err2 := strconv.ParseInt(args[4], 10, 64)
+	masterPort, err3 := strconv.Atoi(args[7])
+	configEpoch, err4 := strconv.ParseInt(args[8], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	m := sentinelLookupMaster(args[5])
+	if m == nil {
+		c.AddReplyError("ERR: No such master with that name")
+		return
+	}
+	if p := sentinelAddPeer(m, args[1], port); p != nil {
+		p.runid = args[3]
+	}
+	if epoch > st.currentEpoch {
+		st.currentEpoch = epoch
+		log.Printf("+new-epoch %d\n", epoch)
+	}
+	// 其他sentinel完成了failover
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if m.inst.ip != args[6] || m.inst.port != masterPort {
+			sentinelSwitchMaster(m, args[6], masterPort)
+		}
+	}
+	c.AddReplyStatus("OK")
+}
+
+func genSentinelInfo(sb *strings.Builder) {
+	st := server.sentinel
+	fmt.Fprintf(sb, "sentinel_masters:%d\r\n", len(st.masters))
+	for i, m := range st.masters {
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.sdown {
+			status = "sdown"
+		}
+		fmt.Fprintf(sb, "master%d:name=%v,status=%v,address=%v,slaves=%d,sentinels=%d\r\n",
+			i, m.name, status, instanceAddr(m.inst), len(m.replicas), len(m.sentinels)+1)
+	}
+}
+
+// INFO [section]，sentinel模式只有 server 和 sentinel
+func sentinelInfoCommand(c *GodisClient) {
+	if len(c.args) > 2 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	section := "default"
+	if len(c.args) == 2 {
+		section = strings.ToLower(c.args[1].StrVal())
+	}
+	var sb strings.Builder
+	all := section == "all" || section == "default" || section == "everything"
+	if all || section == "server" {
+		sb.WriteString("# Server\r\n")
+		genServerInfo(&sb)
+	}
+	if all || section == "sentinel" {
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# Sentinel\r\n")
+		genSentinelInfo(&sb)
+	}
+	c.AddReplyVerbatim(sb.String(), "txt")
+}
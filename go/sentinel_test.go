@@ -0,0 +1,188 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/utils"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initTestSentinel(t *testing.T) *conf.Config {
+	var config conf.Config
+	config.Dir = t.TempDir()
+	config.Sentinel = true
+	config.SentinelMonitor = []conf.SentinelMonitor{{Name: "mymaster", Host: "127.0.0.1", Port: 1, Quorum: 2, DownAfter: 1000, FailoverTimeout: 1000}}
+	config.SentinelPeers = []string{"127.0.0.1:2", "127.0.0.1:3"}
+	assert.Nil(t, initServer(&config))
+	return &config
+}
+
+func TestSentinelCommands(t *testing.T) {
+	config := initTestSentinel(t)
+	client := CreateClient(server.fd)
+	st := server.sentinel
+	assert.Equal(t, "+PONG\r\n", ExecQuery(client, "ping\r\n"))
+	assert.Equal(t, "-ERR: unknow command\r\n", ExecQuery(client, "set a b\r\n"))
+	assert.Equal(t, fmt.Sprintf("$40\r\n%v\r\n", st.myid), ExecQuery(client, "sentinel myid\r\n"))
+	assert.Equal(t, "*2\r\n$9\r\n127.0.0.1\r\n$1\r\n1\r\n", ExecQuery(client, "sentinel get-master-addr-by-name mymaster\r\n"))
+	assert.Equal(t, "*-1\r\n", ExecQuery(client, "sentinel get-master-addr-by-name nomaster\r\n"))
+	assert.Equal(t, "-ERR: No such master with that name\r\n", ExecQuery(client, "sentinel master nomaster\r\n"))
+	assert.Contains(t, ExecQuery(client, "sentinel master mymaster\r\n"), "$6\r\nquorum\r\n$1\r\n2\r\n")
+	assert.Contains(t, ExecQuery(client, "sentinel masters\r\n"), "*1\r\n")
+	assert.Equal(t, "*0\r\n", ExecQuery(client, "sentinel replicas mymaster\r\n"))
+	assert.Contains(t, ExecQuery(client, "sentinel sentinels mymaster\r\n"), "127.0.0.1:3")
+	assert.Contains(t, ExecQuery(client, "info\r\n"), "master0:name=mymaster,status=ok,address=127.0.0.1:1,slaves=0,sentinels=3")
+
+	// 从主节点的INFO中发现replica
+	m := st.masters[0]
+	sentinelRefreshInstanceInfo(m.inst, "# Replication\r\nrole:master\r\nconnected_slaves:1\r\nslave0:ip=127.0.0.1,port=4,state=online,offset=10\r\n")
+	assert.Equal(t, "master", m.inst.role)
+	assert.Contains(t, ExecQuery(client, "sentinel slaves mymaster\r\n"), "127.0.0.1:4")
+	r := m.replicas["127.0.0.1:4"]
+	sentinelRefreshInstanceInfo(r, "role:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:1\r\nmaster_link_status:up\r\nslave_repl_offset:10\r\n")
+	assert.Equal(t, "127.0.0.1", r.masterHost)
+	assert.Equal(t, 1, r.masterPort)
+	assert.True(t, r.masterLinkUp)
+	assert.Equal(t, int64(10), r.replOffset)
+
+	// 其他sentinel完成failover后通过hello更新主节点
+	hello := func(configEpoch int64, port int) string {
+		return ExecQuery(client, fmt.Sprintf("sentinel.hello 127.0.0.1 5 %v 3 mymaster 127.0.0.1 %d %d\r\n", strings.Repeat("a", 40), port, configEpoch))
+	}
+	assert.Equal(t, "+OK\r\n", hello(0, 4))
+	assert.Equal(t, 3, len(m.sentinels))
+	assert.Equal(t, int64(3), st.currentEpoch)
+	assert.Equal(t, 1, m.inst.port)
+	assert.Equal(t, "+OK\r\n", hello(3, 4))
+	assert.Equal(t, "*2\r\n$9\r\n127.0.0.1\r\n$1\r\n4\r\n", ExecQuery(client, "sentinel get-master-addr-by-name mymaster\r\n"))
+	assert.NotNil(t, m.replicas["127.0.0.1:1"])
+	assert.Nil(t, m.replicas["127.0.0.1:4"])
+	assert.Equal(t, int64(3), m.configEpoch)
+
+	// 其他配置不能与sentinel同时开启
+	config.AppendOnly = true
+	assert.Equal(t, SENTINEL_CONFIG_ERR, initServer(config))
+	config.AppendOnly = false
+	config.Sentinel = false
+	assert.Nil(t, initServer(config))
+	assert.Nil(t, server.sentinel)
+}
+
+func TestSentinelVote(t *testing.T) {
+	initTestSentinel(t)
+	client := CreateClient(server.fd)
+	st := server.sentinel
+	m := st.masters[0]
+	ask := func(epoch int, runid string) string {
+		return ExecQuery(client, fmt.Sprintf("sentinel is-master-down-by-addr 127.0.0.1 1 %d %v\r\n", epoch, runid))
+	}
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	assert.Equal(t, "*3\r\n:0\r\n$1\r\n*\r\n:0\r\n", ask(0, "*"))
+
+	// 超过 down_after 没有回复为主观下线
+	m.inst.lastAvail = utils.GetMsTime() - 2000
+	sentinelCheckSubjectivelyDown(m)
+	assert.True(t, m.sdown)
+	assert.Equal(t, "*3\r\n:1\r\n$1\r\n*\r\n:0\r\n", ask(0, "*"))
+
+	// 每个epoch只投票一次
+	assert.Equal(t, fmt.Sprintf("*3\r\n:1\r\n$40\r\n%v\r\n:1\r\n", a), ask(1, a))
+	assert.Equal(t, fmt.Sprintf("*3\r\n:1\r\n$40\r\n%v\r\n:1\r\n", a), ask(1, b))
+	assert.Equal(t, int64(1), st.currentEpoch)
+	assert.Equal(t, fmt.Sprintf("*3\r\n:1\r\n$40\r\n%v\r\n:2\r\n", b), ask(2, b))
+	// 投票给其他sentinel后推迟自己的failover
+	assert.Greater(t, m.failoverStart, utils.GetMsTime()-1000)
+
+	// 达到quorum为客观下线
+	sentinelCheckObjectivelyDown(m)
+	assert.False(t, m.odown)
+	now := utils.GetMsTime()
+	p2, p3 := m.sentinels["127.0.0.1:2"], m.sentinels["127.0.0.1:3"]
+	p2.masterDown, p2.masterDownTime = true, now
+	sentinelCheckObjectivelyDown(m)
+	assert.True(t, m.odown)
+	assert.Contains(t, ExecQuery(client, "sentinel master mymaster\r\n"), "master,s_down,o_down")
+
+	// 得票过半且不少于quorum才能成为leader
+	st.currentEpoch = 5
+	p2.leader, p2.leaderEpoch = st.myid, 5
+	assert.Equal(t, st.myid, sentinelGetLeader(m, 5))
+	p2.leader = b
+	p3.leader, p3.leaderEpoch = b, 5
+	assert.Equal(t, b, sentinelGetLeader(m, 5))
+	p3.leader = a
+	assert.Equal(t, "", sentinelGetLeader(m, 5))
+
+	// 没有可用的replica时放弃failover
+	m.failoverStart = 0
+	sentinelStartFailoverIfNeeded(m)
+	assert.Equal(t, SENTINEL_FAILOVER_WAIT_START, m.failoverState)
+	assert.Equal(t, int64(6), m.failoverEpoch)
+	p2.leader, p2.leaderEpoch = st.myid, 6
+	m.failoverStart = 0
+	sentinelFailoverStateMachine(m)
+	assert.Equal(t, SENTINEL_FAILOVER_NONE, m.failoverState)
+}
+
+func TestSentinelProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip process test in short mode")
+	}
+	bin := buildGodis(t)
+	ports := make([]int, 6)
+	for i := range ports {
+		ports[i] = freePort(t)
+	}
+	master := startGodis(t, bin, &conf.Config{Port: ports[0]})
+	for _, port := range ports[1:3] {
+		startGodis(t, bin, &conf.Config{Port: port, ReplicaOf: fmt.Sprintf("127.0.0.1 %d", ports[0])})
+	}
+	for i, port := range ports[3:] {
+		config := conf.Config{Port: port, Sentinel: true}
+		config.SentinelMonitor = []conf.SentinelMonitor{{Name: "mymaster", Host: "127.0.0.1", Port: ports[0], Quorum: 2, DownAfter: 1000, FailoverTimeout: 3000}}
+		// 只配置一个sentinel，其他通过hello发现
+		config.SentinelPeers = []string{fmt.Sprintf("127.0.0.1:%d", ports[3+(i+1)%3])}
+		startGodis(t, bin, &config)
+	}
+	c0 := dialGodis(t, ports[0])
+	assert.Equal(t, "+OK\r\n", c0.do(t, "set", "foo", "bar"))
+	s := dialGodis(t, ports[3])
+	waitReply(t, s, "slaves=2,sentinels=3", "info", "sentinel")
+	for _, port := range ports[4:] {
+		waitReply(t, dialGodis(t, port), "slaves=2,sentinels=3", "info", "sentinel")
+	}
+	for _, port := range ports[1:3] {
+		waitReply(t, dialGodis(t, port), "$3\r\nbar\r\n", "get", "foo")
+	}
+
+	// 主节点下线后提升一个replica
+	master.Process.Kill()
+	master.Wait()
+	addr := fmt.Sprintf("$%d\r\n%d\r\n", len(strconv.Itoa(ports[0])), ports[0])
+	deadline := time.Now().Add(30 * time.Second)
+	for strings.Contains(s.do(t, "sentinel", "get-master-addr-by-name", "mymaster"), addr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("failover timeout")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	reply := s.do(t, "sentinel", "get-master-addr-by-name", "mymaster")
+	newPort, other := ports[1], ports[2]
+	if strings.Contains(reply, strconv.Itoa(ports[2])) {
+		newPort, other = ports[2], ports[1]
+	}
+	assert.Contains(t, reply, strconv.Itoa(newPort))
+	newMaster := dialGodis(t, newPort)
+	waitReply(t, newMaster, "role:master", "info", "replication")
+	assert.Equal(t, "+OK\r\n", newMaster.do(t, "set", "foo", "baz"))
+	waitReplyFor(t, dialGodis(t, other), "$3\r\nbaz\r\n", 10*time.Second, "get", "foo")
+	// 其他sentinel也切换到新的主节点
+	for _, port := range ports[4:] {
+		waitReplyFor(t, dialGodis(t, port), strconv.Itoa(newPort), 10*time.Second, "sentinel", "get-master-addr-by-name", "mymaster")
+	}
+}
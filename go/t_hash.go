@@ -0,0 +1,311 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"math"
+	"strconv"
+)
+
+func createHashObject() *obj.Gobj {
+	return obj.CreateObject(obj.GDICT, dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual}))
+}
+
+// 获取hash，不存在时创建，类型不符时返回nil并回复错误
+func hashLookupWriteOrCreate(c *GodisClient, key *obj.Gobj) *dict.Dict {
+	hobj := findKeyWrite(c.db, key)
+	if hobj == nil {
+		hobj = createHashObject()
+		dbAdd(c.db, key, hobj)
+		hobj.DecrRefCount()
+	} else if checkType(c, hobj, obj.GDICT) {
+		return nil
+	}
+	return hobj.Val_.(*dict.Dict)
+}
+
+// 获取hash，不存在或类型不符时返回nil，类型不符时回复错误
+func hashLookupRead(c *GodisClient, key *obj.Gobj) (*dict.Dict, bool) {
+	hobj := findKeyRead(c.db, key)
+	if hobj == nil {
+		return nil, true
+	}
+	if checkType(c, hobj, obj.GDICT) {
+		return nil, false
+	}
+	return hobj.Val_.(*dict.Dict), true
+}
+
+// 获取hash用于修改，不存在或类型不符时返回nil，类型不符时回复错误
+func hashLookupWrite(c *GodisClient, key *obj.Gobj) (*dict.Dict, bool) {
+	hobj := findKeyWrite(c.db, key)
+	if hobj == nil {
+		return nil, true
+	}
+	if checkType(c, hobj, obj.GDICT) {
+		return nil, false
+	}
+	return hobj.Val_.(*dict.Dict), true
+}
+
+// HSET key field value [field value ...]
+func hsetCommand(c *GodisClient) {
+	if len(c.args)%2 != 0 {
+		c.AddReplyError("ERR: wrong number of args")
+		return
+	}
+	h := hashLookupWriteOrCreate(c, c.args[1])
+	if h == nil {
+		return
+	}
+	created := 0
+	for i := 2; i < len(c.args); i += 2 {
+		if h.Find(c.args[i]) == nil {
+			created++
+		}
+		h.Set(c.args[i], c.args[i+1])
+	}
+	server.dirty += int64(len(c.args)/2 - 1)
+	c.AddReplyInt(int64(created))
+}
+
+// HSETNX key field value
+func hsetnxCommand(c *GodisClient) {
+	h := hashLookupWriteOrCreate(c, c.args[1])
+	if h == nil {
+		return
+	}
+	if err := h.Add(c.args[2], c.args[3]); err != nil {
+		c.AddReplyInt(0)
+		return
+	}
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+// HGET key field
+func hgetCommand(c *GodisClient) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if h == nil {
+		c.AddReplyNull()
+		return
+	}
+	val := h.Get(c.args[2])
+	if val == nil {
+		c.AddReplyNull()
+		return
+	}
+	c.AddReplyBulk(val.StrVal())
+}
+
+// HMGET key field [field ...]
+func hmgetCommand(c *GodisClient) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyArrayLen(len(c.args) - 2)
+	for _, field := range c.args[2:] {
+		var val *obj.Gobj
+		if h != nil {
+			val = h.Get(field)
+		}
+		if val == nil {
+			c.AddReplyNull()
+		} else {
+			c.AddReplyBulk(val.StrVal())
+		}
+	}
+}
+
+// HDEL key field [field ...]
+func hdelCommand(c *GodisClient) {
+	h, ok := hashLookupWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	deleted := 0
+	if h != nil {
+		for _, field := range c.args[2:] {
+			if h.Delete(field) == nil {
+				deleted++
+			}
+		}
+		if h.Size() == 0 {
+			dbDelete(c.db, c.args[1])
+		}
+	}
+	server.dirty += int64(deleted)
+	c.AddReplyInt(int64(deleted))
+}
+
+// HEXISTS key field
+func hexistsCommand(c *GodisClient) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if h != nil && h.Find(c.args[2]) != nil {
+		c.AddReplyInt(1)
+	} else {
+		c.AddReplyInt(0)
+	}
+}
+
+// HLEN key
+func hlenCommand(c *GodisClient) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var size int64
+	if h != nil {
+		size = h.Size()
+	}
+	c.AddReplyInt(size)
+}
+
+// HSTRLEN key field
+func hstrlenCommand(c *GodisClient) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var val *obj.Gobj
+	if h != nil {
+		val = h.Get(c.args[2])
+	}
+	if val == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	c.AddReplyInt(int64(len(val.StrVal())))
+}
+
+const (
+	HASH_KEY = 1 << iota
+	HASH_VAL
+)
+
+// HKEYS/HVALS/HGETALL
+func hgetallGenericCommand(c *GodisClient, flags int) {
+	h, ok := hashLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	length := 0
+	if h != nil {
+		length = int(h.Size())
+	}
+	// HGETALL 在RESP3中为map
+	if flags&HASH_KEY != 0 && flags&HASH_VAL != 0 {
+		c.AddReplyMapLen(length)
+	} else {
+		c.AddReplyArrayLen(length)
+	}
+	if h == nil {
+		return
+	}
+	h.ForEach(func(e *dict.Entry) {
+		if flags&HASH_KEY != 0 {
+			c.AddReplyBulk(e.Key.StrVal())
+		}
+		if flags&HASH_VAL != 0 {
+			c.AddReplyBulk(e.Val.StrVal())
+		}
+	})
+}
+
+func hkeysCommand(c *GodisClient) {
+	hgetallGenericCommand(c, HASH_KEY)
+}
+
+func hvalsCommand(c *GodisClient) {
+	hgetallGenericCommand(c, HASH_VAL)
+}
+
+func hgetallCommand(c *GodisClient) {
+	hgetallGenericCommand(c, HASH_KEY|HASH_VAL)
+}
+
+// HINCRBY key field increment
+func hincrbyCommand(c *GodisClient) {
+	incr, ok := getIntFromArg(c, c.args[3])
+	if !ok {
+		return
+	}
+	h := hashLookupWriteOrCreate(c, c.args[1])
+	if h == nil {
+		return
+	}
+	var val int64
+	if cur := h.Get(c.args[2]); cur != nil {
+		v, err := strconv.ParseInt(cur.StrVal(), 10, 64)
+		if err != nil {
+			c.AddReplyError("ERR: hash value is not an integer")
+			return
+		}
+		val = v
+	}
+	if (incr < 0 && val < 0 && incr < math.MinInt64-val) ||
+		(incr > 0 && val > 0 && incr > math.MaxInt64-val) {
+		c.AddReplyError("ERR: increment or decrement would overflow")
+		return
+	}
+	val += incr
+	newObj := obj.CreateFromInt(val)
+	h.Set(c.args[2], newObj)
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyInt(val)
+}
+
+// HINCRBYFLOAT key field increment
+func hincrbyfloatCommand(c *GodisClient) {
+	incr, err := strconv.ParseFloat(c.args[3].StrVal(), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		c.AddReplyError("ERR: value is not a valid float")
+		return
+	}
+	h := hashLookupWriteOrCreate(c, c.args[1])
+	if h == nil {
+		return
+	}
+	var val float64
+	if cur := h.Get(c.args[2]); cur != nil {
+		v, err := strconv.ParseFloat(cur.StrVal(), 64)
+		if err != nil {
+			c.AddReplyError("ERR: hash value is not a float")
+			return
+		}
+		val = v
+	}
+	val += incr
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		c.AddReplyError("ERR: increment would produce NaN or Infinity")
+		return
+	}
+	str := strconv.FormatFloat(val, 'f', -1, 64)
+	newObj := obj.CreateObject(obj.GSTR, str)
+	h.Set(c.args[2], newObj)
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyBulk(str)
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func hscanCommand(c *GodisClient) {
+	cursor, ok := parseScanCursor(c, c.args[2])
+	if !ok {
+		return
+	}
+	o := scanLookupRead(c, obj.GDICT)
+	if o == nil {
+		return
+	}
+	scanGenericCommand(c, o, cursor, 2)
+}
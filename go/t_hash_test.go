@@ -0,0 +1,40 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "hset h f1 v1 f2 v2\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "hset h f1 v3\r\n"))
+	assert.Equal(t, "-ERR: wrong number of args\r\n", ExecQuery(client, "hset h f1 v1 f2\r\n"))
+	assert.Equal(t, "$2\r\nv3\r\n", ExecQuery(client, "hget h f1\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "hget h nope\r\n"))
+	assert.Equal(t, "*3\r\n$2\r\nv3\r\n$-1\r\n$2\r\nv2\r\n", ExecQuery(client, "hmget h f1 nope f2\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "hsetnx h f1 v4\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "hsetnx h f3 v4\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "hlen h\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "hexists h f3\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "hstrlen h f3\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "hdel h f2 f3 nope\r\n"))
+	assert.Equal(t, "*2\r\n$2\r\nf1\r\n$2\r\nv3\r\n", ExecQuery(client, "hgetall h\r\n"))
+	assert.Equal(t, "*1\r\n$2\r\nf1\r\n", ExecQuery(client, "hkeys h\r\n"))
+	assert.Equal(t, "*1\r\n$2\r\nv3\r\n", ExecQuery(client, "hvals h\r\n"))
+
+	assert.Equal(t, ":5\r\n", ExecQuery(client, "hincrby h n 5\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "hincrby h n -3\r\n"))
+	assert.Equal(t, "-ERR: hash value is not an integer\r\n", ExecQuery(client, "hincrby h f1 1\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "hincrbyfloat h n 0.5\r\n"))
+
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "hdel h f1 n nope\r\n"))
+	assert.Equal(t, "*0\r\n", ExecQuery(client, "hgetall h\r\n"))
+	ExecQuery(client, "set str val\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "hget str f\r\n"))
+}
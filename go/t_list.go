@@ -0,0 +1,610 @@
+package main
+
+import (
+	"akt-redis/list"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	LIST_HEAD int = 0
+	LIST_TAIL int = 1
+)
+
+func createListObject() *obj.Gobj {
+	return obj.CreateObject(obj.GLIST, list.ListCreate(list.ListType{EqualFunc: utils.GStrEqual}))
+}
+
+func listPush(l *list.List, val *obj.Gobj, where int) {
+	val.IncrRefCount()
+	if where == LIST_HEAD {
+		l.LPush(val)
+	} else {
+		l.Append(val)
+	}
+}
+
+// 弹出元素，调用方负责DecrRefCount
+func listPop(l *list.List, where int) *obj.Gobj {
+	var n *list.Node
+	if where == LIST_HEAD {
+		n = l.First()
+	} else {
+		n = l.Last()
+	}
+	if n == nil {
+		return nil
+	}
+	l.DelNode(n)
+	return n.Val
+}
+
+// 解析 LEFT|RIGHT
+func getListPosition(s string) (int, bool) {
+	switch strings.ToLower(s) {
+	case "left":
+		return LIST_HEAD, true
+	case "right":
+		return LIST_TAIL, true
+	}
+	return 0, false
+}
+
+// LPUSH/RPUSH key element [element ...]
+func pushGenericCommand(c *GodisClient, where int) {
+	key := c.args[1]
+	lobj := findKeyWrite(c.db, key)
+	if lobj != nil && checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	if lobj == nil {
+		lobj = createListObject()
+		dbAdd(c.db, key, lobj)
+		lobj.DecrRefCount()
+	}
+	l := lobj.Val_.(*list.List)
+	for _, val := range c.args[2:] {
+		listPush(l, val, where)
+	}
+	server.dirty += int64(len(c.args) - 2)
+	c.AddReplyInt(int64(l.Length()))
+}
+
+func lpushCommand(c *GodisClient) {
+	pushGenericCommand(c, LIST_HEAD)
+}
+
+func rpushCommand(c *GodisClient) {
+	pushGenericCommand(c, LIST_TAIL)
+}
+
+// LPOP/RPOP key [count]
+func popGenericCommand(c *GodisClient, where int) {
+	if len(c.args) > 3 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	hasCount := len(c.args) == 3
+	var count int64 = 1
+	if hasCount {
+		var ok bool
+		if count, ok = getIntFromArg(c, c.args[2]); !ok {
+			return
+		}
+		if count < 0 {
+			c.AddReplyError("ERR: value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := c.args[1]
+	lobj := findKeyWrite(c.db, key)
+	if lobj == nil {
+		if hasCount {
+			c.AddReplyNullArray()
+		} else {
+			c.AddReplyNull()
+		}
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	l := lobj.Val_.(*list.List)
+	if hasCount {
+		if count > int64(l.Length()) {
+			count = int64(l.Length())
+		}
+		c.AddReplyArrayLen(int(count))
+	}
+	for i := int64(0); i < count; i++ {
+		val := listPop(l, where)
+		c.AddReplyBulk(val.StrVal())
+		val.DecrRefCount()
+	}
+	if l.Length() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += count
+}
+
+func lpopCommand(c *GodisClient) {
+	popGenericCommand(c, LIST_HEAD)
+}
+
+func rpopCommand(c *GodisClient) {
+	popGenericCommand(c, LIST_TAIL)
+}
+
+// LLEN key
+func llenCommand(c *GodisClient) {
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	c.AddReplyInt(int64(lobj.Val_.(*list.List).Length()))
+}
+
+// 将 start/end 转换为 [0, length) 内的区间，区间为空时返回false
+func listRange(start, end int64, length int) (int64, int64, bool) {
+	llen := int64(length)
+	if start < 0 {
+		start += llen
+	}
+	if end < 0 {
+		end += llen
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= llen {
+		return 0, 0, false
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	return start, end, true
+}
+
+// LRANGE key start stop
+func lrangeCommand(c *GodisClient) {
+	start, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	end, ok := getIntFromArg(c, c.args[3])
+	if !ok {
+		return
+	}
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	l := lobj.Val_.(*list.List)
+	start, end, ok = listRange(start, end, l.Length())
+	if !ok {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	c.AddReplyArrayLen(int(end - start + 1))
+	n := l.Index(int(start))
+	for i := start; i <= end; i++ {
+		c.AddReplyBulk(n.Val.StrVal())
+		n = n.Next()
+	}
+}
+
+// LINDEX key index
+func lindexCommand(c *GodisClient) {
+	index, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyNull()
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	n := lobj.Val_.(*list.List).Index(int(index))
+	if n == nil {
+		c.AddReplyNull()
+		return
+	}
+	c.AddReplyBulk(n.Val.StrVal())
+}
+
+// LSET key index element
+func lsetCommand(c *GodisClient) {
+	index, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	lobj := findKeyWrite(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyError("ERR: no such key")
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	n := lobj.Val_.(*list.List).Index(int(index))
+	if n == nil {
+		c.AddReplyError("ERR: index out of range")
+		return
+	}
+	val := c.args[3]
+	val.IncrRefCount()
+	n.Val.DecrRefCount()
+	n.Val = val
+	server.dirty++
+	c.AddReplyStatus("OK")
+}
+
+// LREM key count element
+// count > 0: 从头开始删除count个; count < 0: 从尾开始删除-count个; count = 0: 全部删除
+func lremCommand(c *GodisClient) {
+	count, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	key := c.args[1]
+	lobj := findKeyWrite(c.db, key)
+	if lobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	l := lobj.Val_.(*list.List)
+	val := c.args[3]
+	removed := int64(0)
+	fromTail := count < 0
+	n := l.First()
+	if fromTail {
+		count = -count
+		n = l.Last()
+	}
+	for n != nil {
+		next := n.Next()
+		if fromTail {
+			next = n.Prev()
+		}
+		if l.EqualFunc(n.Val, val) {
+			l.DelNode(n)
+			n.Val.DecrRefCount()
+			removed++
+			if count != 0 && removed == count {
+				break
+			}
+		}
+		n = next
+	}
+	if l.Length() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += removed
+	c.AddReplyInt(removed)
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func linsertCommand(c *GodisClient) {
+	var after bool
+	switch strings.ToLower(c.args[2].StrVal()) {
+	case "before":
+		after = false
+	case "after":
+		after = true
+	default:
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	lobj := findKeyWrite(c.db, c.args[1])
+	if lobj == nil {
+		c.AddReplyInt(0)
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	l := lobj.Val_.(*list.List)
+	pivot := l.Find(c.args[3])
+	if pivot == nil {
+		c.AddReplyInt(-1)
+		return
+	}
+	val := c.args[4]
+	val.IncrRefCount()
+	if after {
+		l.InsertAfter(pivot, val)
+	} else {
+		l.InsertBefore(pivot, val)
+	}
+	server.dirty++
+	c.AddReplyInt(int64(l.Length()))
+}
+
+// LTRIM key start stop
+func ltrimCommand(c *GodisClient) {
+	start, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	end, ok := getIntFromArg(c, c.args[3])
+	if !ok {
+		return
+	}
+	key := c.args[1]
+	lobj := findKeyWrite(c.db, key)
+	if lobj == nil {
+		c.AddReplyStatus("OK")
+		return
+	}
+	if checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	l := lobj.Val_.(*list.List)
+	var ltrim, rtrim int64
+	start, end, ok = listRange(start, end, l.Length())
+	if !ok {
+		ltrim = int64(l.Length())
+	} else {
+		ltrim = start
+		rtrim = int64(l.Length()) - end - 1
+	}
+	for i := int64(0); i < ltrim; i++ {
+		listPop(l, LIST_HEAD).DecrRefCount()
+	}
+	for i := int64(0); i < rtrim; i++ {
+		listPop(l, LIST_TAIL).DecrRefCount()
+	}
+	if l.Length() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += ltrim + rtrim
+	c.AddReplyStatus("OK")
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lposCommand(c *GodisClient) {
+	var rank, count, maxlen int64 = 1, -1, 0
+	for i := 3; i < len(c.args); i += 2 {
+		if i+1 >= len(c.args) {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+		val, ok := getIntFromArg(c, c.args[i+1])
+		if !ok {
+			return
+		}
+		switch strings.ToLower(c.args[i].StrVal()) {
+		case "rank":
+			if val == 0 {
+				c.AddReplyError("ERR: RANK can't be zero")
+				return
+			}
+			rank = val
+		case "count":
+			if val < 0 {
+				c.AddReplyError("ERR: COUNT can't be negative")
+				return
+			}
+			count = val
+		case "maxlen":
+			if val < 0 {
+				c.AddReplyError("ERR: MAXLEN can't be negative")
+				return
+			}
+			maxlen = val
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+
+	lobj := findKeyRead(c.db, c.args[1])
+	if lobj != nil && checkType(c, lobj, obj.GLIST) {
+		return
+	}
+	var matches []int64
+	if lobj != nil {
+		l := lobj.Val_.(*list.List)
+		n, index, step := l.First(), int64(0), int64(1)
+		if rank < 0 {
+			rank = -rank
+			n, index, step = l.Last(), int64(l.Length()-1), -1
+		}
+		for checked := int64(0); n != nil && (maxlen == 0 || checked < maxlen); checked++ {
+			if l.EqualFunc(n.Val, c.args[2]) {
+				if rank > 1 {
+					rank--
+				} else {
+					matches = append(matches, index)
+					// 未指定COUNT时只需第一个，COUNT 0 表示全部
+					if count == -1 || int64(len(matches)) == count {
+						break
+					}
+				}
+			}
+			if step > 0 {
+				n = n.Next()
+			} else {
+				n = n.Prev()
+			}
+			index += step
+		}
+	}
+
+	if count == -1 {
+		if len(matches) == 0 {
+			c.AddReplyNull()
+		} else {
+			c.AddReplyInt(matches[0])
+		}
+		return
+	}
+	c.AddReplyArrayLen(len(matches))
+	for _, m := range matches {
+		c.AddReplyInt(m)
+	}
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmoveCommand(c *GodisClient) {
+	from, ok := getListPosition(c.args[3].StrVal())
+	if !ok {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	to, ok := getListPosition(c.args[4].StrVal())
+	if !ok {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	lmoveGenericCommand(c, c.args[1], c.args[2], from, to)
+}
+
+func lmoveGenericCommand(c *GodisClient, srcKey, dstKey *obj.Gobj, from, to int) bool {
+	sobj := findKeyWrite(c.db, srcKey)
+	if sobj == nil {
+		c.AddReplyNull()
+		return false
+	}
+	if checkType(c, sobj, obj.GLIST) {
+		return false
+	}
+	dobj := findKeyWrite(c.db, dstKey)
+	if dobj != nil && checkType(c, dobj, obj.GLIST) {
+		return false
+	}
+	if dobj == nil {
+		dobj = createListObject()
+		dbAdd(c.db, dstKey, dobj)
+		dobj.DecrRefCount()
+	}
+	src := sobj.Val_.(*list.List)
+	val := listPop(src, from)
+	listPush(dobj.Val_.(*list.List), val, to)
+	c.AddReplyBulk(val.StrVal())
+	val.DecrRefCount()
+	if src.Length() == 0 {
+		dbDelete(c.db, srcKey)
+	}
+	server.dirty++
+	return true
+}
+
+// 阻塞命令以对应的非阻塞命令传播
+func popCommandName(where int) string {
+	if where == LIST_HEAD {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+func listPositionName(where int) string {
+	if where == LIST_HEAD {
+		return "left"
+	}
+	return "right"
+}
+
+// 解析阻塞超时时间(秒，可为小数)，返回毫秒
+func getTimeoutFromArg(c *GodisClient, o *obj.Gobj) (int64, bool) {
+	timeout, err := strconv.ParseFloat(o.StrVal(), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		c.AddReplyError("ERR: timeout is not a float or out of range")
+		return 0, false
+	}
+	if timeout < 0 {
+		c.AddReplyError("ERR: timeout is negative")
+		return 0, false
+	}
+	ms := int64(timeout * 1000)
+	// 小于1ms 的超时不应变为永久阻塞
+	if timeout > 0 && ms == 0 {
+		ms = 1
+	}
+	return ms, true
+}
+
+// BLPOP/BRPOP key [key ...] timeout
+func blockingPopGenericCommand(c *GodisClient, where int) {
+	timeout, ok := getTimeoutFromArg(c, c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+	keys := c.args[1 : len(c.args)-1]
+	for _, key := range keys {
+		lobj := findKeyWrite(c.db, key)
+		if lobj == nil {
+			continue
+		}
+		if checkType(c, lobj, obj.GLIST) {
+			return
+		}
+		// 存在非空list时与LPOP/RPOP相同
+		l := lobj.Val_.(*list.List)
+		val := listPop(l, where)
+		c.AddReplyArrayLen(2)
+		c.AddReplyBulk(key.StrVal())
+		c.AddReplyBulk(val.StrVal())
+		val.DecrRefCount()
+		if l.Length() == 0 {
+			dbDelete(c.db, key)
+		}
+		server.dirty++
+		rewriteClientCommandArgs(c, popCommandName(where), key.StrVal())
+		return
+	}
+	blockForKeys(c, keys, timeout, nil, where, where)
+	preventCommandPropagation(c)
+}
+
+func blpopCommand(c *GodisClient) {
+	blockingPopGenericCommand(c, LIST_HEAD)
+}
+
+func brpopCommand(c *GodisClient) {
+	blockingPopGenericCommand(c, LIST_TAIL)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func blmoveCommand(c *GodisClient) {
+	from, ok := getListPosition(c.args[3].StrVal())
+	if !ok {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	to, ok := getListPosition(c.args[4].StrVal())
+	if !ok {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	timeout, ok := getTimeoutFromArg(c, c.args[5])
+	if !ok {
+		return
+	}
+	sobj := findKeyWrite(c.db, c.args[1])
+	if sobj == nil {
+		blockForKeys(c, c.args[1:2], timeout, c.args[2], from, to)
+		preventCommandPropagation(c)
+		return
+	}
+	lmoveGenericCommand(c, c.args[1], c.args[2], from, to)
+	rewriteClientCommandArgs(c, "lmove", c.args[1].StrVal(), c.args[2].StrVal(), c.args[3].StrVal(), c.args[4].StrVal())
+}
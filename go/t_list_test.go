@@ -0,0 +1,96 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "rpush list a b c\r\n"))
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "lpush list z\r\n"))
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "llen list\r\n"))
+	assert.Equal(t, "*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "lrange list 0 -1\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "lrange list -2 100\r\n"))
+	assert.Equal(t, "$1\r\nc\r\n", ExecQuery(client, "lindex list -1\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "lindex list 10\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "lset list 1 x\r\n"))
+	assert.Equal(t, "-ERR: index out of range\r\n", ExecQuery(client, "lset list 10 x\r\n"))
+	assert.Equal(t, ":5\r\n", ExecQuery(client, "linsert list after x a\r\n"))
+	assert.Equal(t, ":-1\r\n", ExecQuery(client, "linsert list before nope a\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "lpos list a\r\n"))
+	assert.Equal(t, "*1\r\n:2\r\n", ExecQuery(client, "lpos list a rank -1 count 0\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "lrem list 1 x\r\n"))
+	assert.Equal(t, "$1\r\nz\r\n", ExecQuery(client, "lpop list\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n", ExecQuery(client, "rpop list 2\r\n"))
+	assert.Equal(t, "$1\r\na\r\n", ExecQuery(client, "lmove list other right left\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "llen list\r\n"))
+	assert.Equal(t, "*-1\r\n", ExecQuery(client, "lpop list 1\r\n"))
+
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "rpush other b c d\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "ltrim other 1 -2\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "lrange other 0 -1\r\n"))
+
+	ExecQuery(client, "set str val\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "lpush str a\r\n"))
+	assert.Equal(t, "-ERR: value is not an integer or out of range\r\n", ExecQuery(client, "lrange other a 1\r\n"))
+}
+
+func TestBlockingListCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	c1 := CreateClient(server.fd)
+	c2 := CreateClient(server.fd)
+	c3 := CreateClient(server.fd)
+
+	// list非空时直接返回
+	ExecQuery(c1, "rpush list a\r\n")
+	assert.Equal(t, "*2\r\n$4\r\nlist\r\n$1\r\na\r\n", ExecQuery(c1, "blpop nope list 0\r\n"))
+
+	// 按阻塞顺序唤醒
+	assert.Equal(t, "", ExecQuery(c1, "blpop l1 l2 0\r\n"))
+	assert.Equal(t, "", ExecQuery(c2, "brpop l2 0\r\n"))
+	assert.Equal(t, true, c1.blocked)
+	assert.Equal(t, 2, len(c1.db.blockingKeys["l2"]))
+	assert.Equal(t, ":3\r\n", ExecQuery(c3, "rpush l2 x y z\r\n"))
+	assert.Equal(t, "*2\r\n$2\r\nl2\r\n$1\r\nx\r\n", ExecQuery(c1, ""))
+	assert.Equal(t, "*2\r\n$2\r\nl2\r\n$1\r\nz\r\n", ExecQuery(c2, ""))
+	assert.Equal(t, false, c1.blocked)
+	assert.Equal(t, 0, len(c1.db.blockingKeys))
+	assert.Equal(t, ":1\r\n", ExecQuery(c3, "llen l2\r\n"))
+
+	// BLMOVE 唤醒后推入的key可继续唤醒其他client
+	assert.Equal(t, "", ExecQuery(c1, "blmove src dst right left 0\r\n"))
+	assert.Equal(t, "", ExecQuery(c2, "blpop dst 0\r\n"))
+	ExecQuery(c3, "lpush src v\r\n")
+	assert.Equal(t, "$1\r\nv\r\n", ExecQuery(c1, ""))
+	assert.Equal(t, "*2\r\n$3\r\ndst\r\n$1\r\nv\r\n", ExecQuery(c2, ""))
+	assert.Equal(t, ":0\r\n", ExecQuery(c3, "llen dst\r\n"))
+
+	// 超时
+	assert.Equal(t, "", ExecQuery(c1, "blpop l3 0.01\r\n"))
+	assert.NotEqual(t, 0, c1.bpop.timeoutId)
+	blockedClientTimeout(server.aeLoop, c1.bpop.timeoutId, c1)
+	assert.Equal(t, "*-1\r\n", ExecQuery(c1, ""))
+	assert.Equal(t, false, c1.blocked)
+	assert.Equal(t, "-ERR: timeout is negative\r\n", ExecQuery(c1, "blpop l3 -1\r\n"))
+
+	// 阻塞期间收到的后续命令在解除阻塞后执行
+	assert.Equal(t, "", ExecQuery(c1, "blpop l4 0\r\nping\r\n"))
+	assert.Equal(t, "", ExecQuery(c1, "llen l4\r\n"))
+	ExecQuery(c3, "rpush l4 a\r\n")
+	beforeSleep(server.aeLoop)
+	assert.Equal(t, "*2\r\n$2\r\nl4\r\n$1\r\na\r\n+PONG\r\n:0\r\n", ExecQuery(c1, ""))
+	assert.Equal(t, 0, len(server.unblockedClients))
+
+	// 超时后同样继续执行
+	assert.Equal(t, "", ExecQuery(c1, "blpop l4 0.01\r\nping\r\n"))
+	blockedClientTimeout(server.aeLoop, c1.bpop.timeoutId, c1)
+	beforeSleep(server.aeLoop)
+	assert.Equal(t, "*-1\r\n+PONG\r\n", ExecQuery(c1, ""))
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"sort"
+)
+
+// set 使用dict实现，只使用key，val为nil
+func setCreate() *dict.Dict {
+	return dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual})
+}
+
+func createSetObject() *obj.Gobj {
+	return obj.CreateObject(obj.GSET, setCreate())
+}
+
+func setAdd(s *dict.Dict, member *obj.Gobj) bool {
+	return s.AddRaw(member) != nil
+}
+
+func setIsMember(s *dict.Dict, member *obj.Gobj) bool {
+	return s.Find(member) != nil
+}
+
+// 获取set，类型不符时回复错误并返回false
+func setLookupRead(c *GodisClient, key *obj.Gobj) (*dict.Dict, bool) {
+	sobj := findKeyRead(c.db, key)
+	if sobj == nil {
+		return nil, true
+	}
+	if checkType(c, sobj, obj.GSET) {
+		return nil, false
+	}
+	return sobj.Val_.(*dict.Dict), true
+}
+
+// 获取set用于修改，类型不符时回复错误并返回false
+func setLookupWrite(c *GodisClient, key *obj.Gobj) (*dict.Dict, bool) {
+	sobj := findKeyWrite(c.db, key)
+	if sobj == nil {
+		return nil, true
+	}
+	if checkType(c, sobj, obj.GSET) {
+		return nil, false
+	}
+	return sobj.Val_.(*dict.Dict), true
+}
+
+// RESP3 中为set
+func addReplySetMembers(c *GodisClient, s *dict.Dict) {
+	c.AddReplySetLen(int(s.Size()))
+	s.ForEach(func(e *dict.Entry) {
+		c.AddReplyBulk(e.Key.StrVal())
+	})
+}
+
+// SADD key member [member ...]
+func saddCommand(c *GodisClient) {
+	key := c.args[1]
+	sobj := findKeyWrite(c.db, key)
+	if sobj == nil {
+		sobj = createSetObject()
+		dbAdd(c.db, key, sobj)
+		sobj.DecrRefCount()
+	} else if checkType(c, sobj, obj.GSET) {
+		return
+	}
+	s := sobj.Val_.(*dict.Dict)
+	added := 0
+	for _, member := range c.args[2:] {
+		if setAdd(s, member) {
+			added++
+		}
+	}
+	server.dirty += int64(added)
+	c.AddReplyInt(int64(added))
+}
+
+// SREM key member [member ...]
+func sremCommand(c *GodisClient) {
+	s, ok := setLookupWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	removed := 0
+	if s != nil {
+		for _, member := range c.args[2:] {
+			if s.Delete(member) == nil {
+				removed++
+			}
+		}
+		if s.Size() == 0 {
+			dbDelete(c.db, c.args[1])
+		}
+	}
+	server.dirty += int64(removed)
+	c.AddReplyInt(int64(removed))
+}
+
+// SISMEMBER key member
+func sismemberCommand(c *GodisClient) {
+	s, ok := setLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if s != nil && setIsMember(s, c.args[2]) {
+		c.AddReplyInt(1)
+	} else {
+		c.AddReplyInt(0)
+	}
+}
+
+// SMISMEMBER key member [member ...]
+func smismemberCommand(c *GodisClient) {
+	s, ok := setLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyArrayLen(len(c.args) - 2)
+	for _, member := range c.args[2:] {
+		if s != nil && setIsMember(s, member) {
+			c.AddReplyInt(1)
+		} else {
+			c.AddReplyInt(0)
+		}
+	}
+}
+
+// SMEMBERS key
+func smembersCommand(c *GodisClient) {
+	s, ok := setLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if s == nil {
+		c.AddReplySetLen(0)
+		return
+	}
+	addReplySetMembers(c, s)
+}
+
+// SCARD key
+func scardCommand(c *GodisClient) {
+	s, ok := setLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var size int64
+	if s != nil {
+		size = s.Size()
+	}
+	c.AddReplyInt(size)
+}
+
+// 随机获取一个元素，RandomGet在稀疏table中可能返回nil，需重试
+func setRandomMember(s *dict.Dict) *dict.Entry {
+	for {
+		if e := s.RandomGet(); e != nil {
+			return e
+		}
+	}
+}
+
+// SPOP key [count]
+func spopCommand(c *GodisClient) {
+	if len(c.args) > 3 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	hasCount := len(c.args) == 3
+	var count int64 = 1
+	if hasCount {
+		var ok bool
+		if count, ok = getIntFromArg(c, c.args[2]); !ok {
+			return
+		}
+		if count < 0 {
+			c.AddReplyError("ERR: value is out of range, must be positive")
+			return
+		}
+	}
+	key := c.args[1]
+	s, ok := setLookupWrite(c, key)
+	if !ok {
+		return
+	}
+	if s == nil {
+		if hasCount {
+			c.AddReplyArrayLen(0)
+		} else {
+			c.AddReplyNull()
+		}
+		return
+	}
+	if count > s.Size() {
+		count = s.Size()
+	}
+	if hasCount {
+		c.AddReplyArrayLen(int(count))
+	}
+	// 随机结果以SREM传播
+	propagated := []string{"srem", key.StrVal()}
+	for i := int64(0); i < count; i++ {
+		e := setRandomMember(s)
+		member := e.Key.StrVal()
+		c.AddReplyBulk(member)
+		propagated = append(propagated, member)
+		s.Delete(e.Key)
+	}
+	if s.Size() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += count
+	rewriteClientCommandArgs(c, propagated...)
+}
+
+// count < 0 时回复整个在内存中生成，限制其大小
+const SRANDMEMBER_MAX_COUNT = 1024 * 1024
+
+// SRANDMEMBER key [count]
+// count > 0 返回不重复的元素，count < 0 元素可重复
+func srandmemberCommand(c *GodisClient) {
+	if len(c.args) > 3 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	hasCount := len(c.args) == 3
+	var count int64 = 1
+	if hasCount {
+		var ok bool
+		if count, ok = getIntFromArg(c, c.args[2]); !ok {
+			return
+		}
+		if count < -SRANDMEMBER_MAX_COUNT {
+			c.AddReplyError("ERR: value is out of range")
+			return
+		}
+	}
+	s, ok := setLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if s == nil {
+		if hasCount {
+			c.AddReplyArrayLen(0)
+		} else {
+			c.AddReplyNull()
+		}
+		return
+	}
+	if !hasCount {
+		c.AddReplyBulk(setRandomMember(s).Key.StrVal())
+		return
+	}
+	if count < 0 {
+		c.AddReplyArrayLen(int(-count))
+		for i := int64(0); i < -count; i++ {
+			c.AddReplyBulk(setRandomMember(s).Key.StrVal())
+		}
+		return
+	}
+	if count >= s.Size() {
+		c.AddReplyArrayLen(int(s.Size()))
+		s.ForEach(func(e *dict.Entry) {
+			c.AddReplyBulk(e.Key.StrVal())
+		})
+		return
+	}
+	picked := make(map[string]struct{}, count)
+	for int64(len(picked)) < count {
+		picked[setRandomMember(s).Key.StrVal()] = struct{}{}
+	}
+	c.AddReplyArrayLen(int(count))
+	for member := range picked {
+		c.AddReplyBulk(member)
+	}
+}
+
+// SMOVE source destination member
+func smoveCommand(c *GodisClient) {
+	srcKey, dstKey, member := c.args[1], c.args[2], c.args[3]
+	src, ok := setLookupWrite(c, srcKey)
+	if !ok {
+		return
+	}
+	dobj := findKeyWrite(c.db, dstKey)
+	if dobj != nil && checkType(c, dobj, obj.GSET) {
+		return
+	}
+	if src == nil || !setIsMember(src, member) {
+		c.AddReplyInt(0)
+		return
+	}
+	if utils.GStrEqual(srcKey, dstKey) {
+		c.AddReplyInt(1)
+		return
+	}
+	if dobj == nil {
+		dobj = createSetObject()
+		dbAdd(c.db, dstKey, dobj)
+		dobj.DecrRefCount()
+	}
+	src.Delete(member)
+	if src.Size() == 0 {
+		dbDelete(c.db, srcKey)
+	}
+	setAdd(dobj.Val_.(*dict.Dict), member)
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+const (
+	SET_OP_UNION = iota
+	SET_OP_DIFF
+	SET_OP_INTER
+)
+
+// 计算多个set的交集/并集/差集，类型不符时回复错误并返回false
+func setOperation(c *GodisClient, keys []*obj.Gobj, op int) (*dict.Dict, bool) {
+	sets := make([]*dict.Dict, len(keys))
+	for i, key := range keys {
+		s, ok := setLookupRead(c, key)
+		if !ok {
+			return nil, false
+		}
+		sets[i] = s
+	}
+	dst := setCreate()
+	switch op {
+	case SET_OP_INTER:
+		for _, s := range sets {
+			if s == nil {
+				return dst, true
+			}
+		}
+		// 从最小的set开始遍历
+		sort.Slice(sets, func(i, j int) bool {
+			return sets[i].Size() < sets[j].Size()
+		})
+		sets[0].ForEach(func(e *dict.Entry) {
+			for _, s := range sets[1:] {
+				if !setIsMember(s, e.Key) {
+					return
+				}
+			}
+			setAdd(dst, e.Key)
+		})
+	case SET_OP_UNION:
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			s.ForEach(func(e *dict.Entry) {
+				setAdd(dst, e.Key)
+			})
+		}
+	case SET_OP_DIFF:
+		if sets[0] == nil {
+			return dst, true
+		}
+		sets[0].ForEach(func(e *dict.Entry) {
+			for _, s := range sets[1:] {
+				if s != nil && setIsMember(s, e.Key) {
+					return
+				}
+			}
+			setAdd(dst, e.Key)
+		})
+	}
+	return dst, true
+}
+
+func setOperationCommand(c *GodisClient, keys []*obj.Gobj, op int) {
+	result, ok := setOperation(c, keys, op)
+	if !ok {
+		return
+	}
+	addReplySetMembers(c, result)
+}
+
+func setOperationStoreCommand(c *GodisClient, dstKey *obj.Gobj, keys []*obj.Gobj, op int) {
+	result, ok := setOperation(c, keys, op)
+	if !ok {
+		return
+	}
+	// 结果为空且目标原本不存在时没有修改
+	deleted := dbDelete(c.db, dstKey)
+	if result.Size() > 0 {
+		o := obj.CreateObject(obj.GSET, result)
+		dbAdd(c.db, dstKey, o)
+		o.DecrRefCount()
+		server.dirty++
+	} else if deleted {
+		server.dirty++
+	}
+	c.AddReplyInt(result.Size())
+}
+
+func sinterCommand(c *GodisClient) {
+	setOperationCommand(c, c.args[1:], SET_OP_INTER)
+}
+
+func sinterstoreCommand(c *GodisClient) {
+	setOperationStoreCommand(c, c.args[1], c.args[2:], SET_OP_INTER)
+}
+
+func sunionCommand(c *GodisClient) {
+	setOperationCommand(c, c.args[1:], SET_OP_UNION)
+}
+
+func sunionstoreCommand(c *GodisClient) {
+	setOperationStoreCommand(c, c.args[1], c.args[2:], SET_OP_UNION)
+}
+
+func sdiffCommand(c *GodisClient) {
+	setOperationCommand(c, c.args[1:], SET_OP_DIFF)
+}
+
+func sdiffstoreCommand(c *GodisClient) {
+	setOperationStoreCommand(c, c.args[1], c.args[2:], SET_OP_DIFF)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func sscanCommand(c *GodisClient) {
+	cursor, ok := parseScanCursor(c, c.args[2])
+	if !ok {
+		return
+	}
+	o := scanLookupRead(c, obj.GSET)
+	if o == nil {
+		return
+	}
+	scanGenericCommand(c, o, cursor, 2)
+}
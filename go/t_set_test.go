@@ -0,0 +1,49 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "sadd s1 a b c\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "sadd s1 a d\r\n"))
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "scard s1\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "sismember s1 a\r\n"))
+	assert.Equal(t, "*2\r\n:1\r\n:0\r\n", ExecQuery(client, "smismember s1 b x\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "srem s1 d x\r\n"))
+
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "sadd s2 b c\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "sinterstore dst s1 s2\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "sismember dst a\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", ExecQuery(client, "sdiff s1 s2\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "sunionstore dst s1 s2 nope\r\n"))
+	assert.Equal(t, "*0\r\n", ExecQuery(client, "sinter s1 nope\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "sdiffstore dst nope s1\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "scard dst\r\n"))
+
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "smove s1 s3 a\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "smove s1 s3 a\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", ExecQuery(client, "smembers s3\r\n"))
+	assert.Equal(t, "$1\r\na\r\n", ExecQuery(client, "srandmember s3\r\n"))
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\na\r\n$1\r\na\r\n", ExecQuery(client, "srandmember s3 -3\r\n"))
+	assert.Equal(t, "-ERR: value is out of range\r\n", ExecQuery(client, "srandmember s3 -9223372036854775808\r\n"))
+	assert.Equal(t, "-ERR: value is out of range\r\n", ExecQuery(client, "srandmember nope -2000000\r\n"))
+	assert.Equal(t, "$1\r\na\r\n", ExecQuery(client, "spop s3\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "scard s3\r\n"))
+
+	reply := ExecQuery(client, "srandmember s1 1\r\n")
+	assert.Contains(t, []string{"*1\r\n$1\r\nb\r\n", "*1\r\n$1\r\nc\r\n"}, reply)
+	assert.Contains(t, []string{"*2\r\n$1\r\nb\r\n$1\r\nc\r\n", "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"}, ExecQuery(client, "spop s1 5\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "scard s1\r\n"))
+
+	ExecQuery(client, "set str val\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "sadd str a\r\n"))
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "sunion s2 str\r\n"))
+}
@@ -0,0 +1,652 @@
+package main
+
+import (
+	"akt-redis/obj"
+	"akt-redis/utils"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// string 最大长度 512MB
+const STRING_MAX_SIZE int64 = 512 * 1024 * 1024
+
+// LCS 动态规划表的最大单元数
+const LCS_MAX_CELLS int64 = 64 * 1024 * 1024
+
+// 获取string，不存在时返回nil，类型不符时回复错误
+func stringLookupRead(c *GodisClient, key *obj.Gobj) (*obj.Gobj, bool) {
+	sobj := findKeyRead(c.db, key)
+	if sobj == nil {
+		return nil, true
+	}
+	if checkType(c, sobj, obj.GSTR) {
+		return nil, false
+	}
+	return sobj, true
+}
+
+// 覆盖已有key的值，保留过期时间
+func dbOverwrite(db *GodisDB, key *obj.Gobj, val *obj.Gobj) {
+	db.data.Set(key, val)
+}
+
+// GET key
+func getCommand(c *GodisClient) {
+	val, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyBulkObj(val)
+}
+
+const (
+	OBJ_SET_NX = 1 << iota
+	OBJ_SET_XX
+	OBJ_SET_GET
+	OBJ_KEEPTTL
+	OBJ_PERSIST
+	OBJ_EX
+	OBJ_PX
+	OBJ_EXAT
+	OBJ_PXAT
+)
+
+const OBJ_EXPIRE = OBJ_EX | OBJ_PX | OBJ_EXAT | OBJ_PXAT
+
+const (
+	COMMAND_SET = iota
+	COMMAND_GET
+)
+
+// 解析 SET/GETEX 的可选参数，返回flags及毫秒过期时间戳，失败时回复错误
+func parseExtendedStringArgs(c *GodisClient, start int, cmdType int) (int, int64, bool) {
+	flags := 0
+	var when int64 = -1
+	for i := start; i < len(c.args); i++ {
+		opt := strings.ToLower(c.args[i].StrVal())
+		hasNext := i+1 < len(c.args)
+		switch {
+		case opt == "nx" && cmdType == COMMAND_SET && flags&OBJ_SET_XX == 0:
+			flags |= OBJ_SET_NX
+		case opt == "xx" && cmdType == COMMAND_SET && flags&OBJ_SET_NX == 0:
+			flags |= OBJ_SET_XX
+		case opt == "get" && cmdType == COMMAND_SET:
+			flags |= OBJ_SET_GET
+		case opt == "keepttl" && cmdType == COMMAND_SET && flags&(OBJ_PERSIST|OBJ_EXPIRE) == 0:
+			flags |= OBJ_KEEPTTL
+		case opt == "persist" && cmdType == COMMAND_GET && flags&(OBJ_KEEPTTL|OBJ_EXPIRE) == 0:
+			flags |= OBJ_PERSIST
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") &&
+			flags&(OBJ_KEEPTTL|OBJ_PERSIST|OBJ_EXPIRE) == 0 && hasNext:
+			val, ok := getIntFromArg(c, c.args[i+1])
+			if !ok {
+				return 0, 0, false
+			}
+			if val <= 0 || (opt != "px" && opt != "pxat" && val > math.MaxInt64/1000) {
+				c.AddReplyErrorFormat("ERR: invalid expire time in '%v' command", c.args[0].StrVal())
+				return 0, 0, false
+			}
+			switch opt {
+			case "ex":
+				flags |= OBJ_EX
+				when = utils.GetMsTime() + val*1000
+			case "px":
+				flags |= OBJ_PX
+				when = utils.GetMsTime() + val
+			case "exat":
+				flags |= OBJ_EXAT
+				when = val * 1000
+			case "pxat":
+				flags |= OBJ_PXAT
+				when = val
+			}
+			i++
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return 0, 0, false
+		}
+	}
+	return flags, when, true
+}
+
+// 将EX/PX替换为PXAT，使传播的命令与执行时间无关
+func rewriteRelativeExpire(c *GodisClient, start int, flags int, when int64) {
+	if flags&(OBJ_EX|OBJ_PX) == 0 {
+		return
+	}
+	for i := start; i < len(c.args)-1; i++ {
+		opt := strings.ToLower(c.args[i].StrVal())
+		if opt == "ex" || opt == "px" {
+			replaceClientCommandArg(c, i, "pxat")
+			replaceClientCommandArg(c, i+1, strconv.FormatInt(when, 10))
+			return
+		}
+	}
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|KEEPTTL]
+func setCommand(c *GodisClient) {
+	flags, when, ok := parseExtendedStringArgs(c, 3, COMMAND_SET)
+	if !ok {
+		return
+	}
+	rewriteRelativeExpire(c, 3, flags, when)
+	key := c.args[1]
+	old := findKeyWrite(c.db, key)
+	if flags&OBJ_SET_GET != 0 {
+		if old != nil && checkType(c, old, obj.GSTR) {
+			return
+		}
+		c.AddReplyBulkObj(old)
+	}
+	if (flags&OBJ_SET_NX != 0 && old != nil) || (flags&OBJ_SET_XX != 0 && old == nil) {
+		if flags&OBJ_SET_GET == 0 {
+			c.AddReplyNull()
+		}
+		return
+	}
+	// 新key通过setKey加入slot索引
+	if flags&OBJ_KEEPTTL != 0 && old != nil {
+		dbOverwrite(c.db, key, c.args[2])
+	} else {
+		setKey(c.db, key, c.args[2])
+	}
+	if when >= 0 {
+		setExpire(c.db, key, when)
+	}
+	server.dirty++
+	if flags&OBJ_SET_GET == 0 {
+		c.AddReplyStatus("OK")
+	}
+}
+
+// SETNX key value
+func setnxCommand(c *GodisClient) {
+	if findKeyWrite(c.db, c.args[1]) != nil {
+		c.AddReplyInt(0)
+		return
+	}
+	setKey(c.db, c.args[1], c.args[2])
+	server.dirty++
+	c.AddReplyInt(1)
+}
+
+// GETSET key value
+func getsetCommand(c *GodisClient) {
+	old, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyBulkObj(old)
+	setKey(c.db, c.args[1], c.args[2])
+	server.dirty++
+}
+
+// GETDEL key
+func getdelCommand(c *GodisClient) {
+	val, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyBulkObj(val)
+	if val != nil {
+		dbDelete(c.db, c.args[1])
+		server.dirty++
+	}
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|PERSIST]
+func getexCommand(c *GodisClient) {
+	flags, when, ok := parseExtendedStringArgs(c, 2, COMMAND_GET)
+	if !ok {
+		return
+	}
+	rewriteRelativeExpire(c, 2, flags, when)
+	val, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.AddReplyBulkObj(val)
+	if val == nil {
+		return
+	}
+	if flags&OBJ_PERSIST != 0 {
+		if c.db.expire.Delete(c.args[1]) == nil {
+			server.dirty++
+		}
+	} else if when >= 0 {
+		setExpire(c.db, c.args[1], when)
+		server.dirty++
+	}
+}
+
+// MGET key [key ...]
+func mgetCommand(c *GodisClient) {
+	c.AddReplyArrayLen(len(c.args) - 1)
+	for _, key := range c.args[1:] {
+		val := findKeyRead(c.db, key)
+		if val == nil || val.Type_ != obj.GSTR {
+			c.AddReplyNull()
+		} else {
+			c.AddReplyBulk(val.StrVal())
+		}
+	}
+}
+
+// MSET/MSETNX key value [key value ...]
+func msetGenericCommand(c *GodisClient, nx bool) {
+	if len(c.args)%2 == 0 {
+		c.AddReplyError("ERR: wrong number of args")
+		return
+	}
+	if nx {
+		for i := 1; i < len(c.args); i += 2 {
+			if findKeyWrite(c.db, c.args[i]) != nil {
+				c.AddReplyInt(0)
+				return
+			}
+		}
+	}
+	for i := 1; i < len(c.args); i += 2 {
+		setKey(c.db, c.args[i], c.args[i+1])
+	}
+	server.dirty += int64(len(c.args) / 2)
+	if nx {
+		c.AddReplyInt(1)
+	} else {
+		c.AddReplyStatus("OK")
+	}
+}
+
+func msetCommand(c *GodisClient) {
+	msetGenericCommand(c, false)
+}
+
+func msetnxCommand(c *GodisClient) {
+	msetGenericCommand(c, true)
+}
+
+// INCR/DECR/INCRBY/DECRBY
+func incrDecrCommand(c *GodisClient, incr int64) {
+	key := c.args[1]
+	cur, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	var val int64
+	if cur != nil {
+		v, err := cur.IntVal()
+		if err != nil {
+			c.AddReplyErrorFormat("ERR: %v", err)
+			return
+		}
+		val = v
+	}
+	if (incr < 0 && val < 0 && incr < math.MinInt64-val) ||
+		(incr > 0 && val > 0 && incr > math.MaxInt64-val) {
+		c.AddReplyError("ERR: increment or decrement would overflow")
+		return
+	}
+	val += incr
+	newObj := obj.CreateFromInt(val)
+	if cur == nil {
+		dbAdd(c.db, key, newObj)
+	} else {
+		dbOverwrite(c.db, key, newObj)
+	}
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyInt(val)
+}
+
+func incrCommand(c *GodisClient) {
+	incrDecrCommand(c, 1)
+}
+
+func decrCommand(c *GodisClient) {
+	incrDecrCommand(c, -1)
+}
+
+func incrbyCommand(c *GodisClient) {
+	incr, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	incrDecrCommand(c, incr)
+}
+
+func decrbyCommand(c *GodisClient) {
+	decr, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	if decr == math.MinInt64 {
+		c.AddReplyError("ERR: decrement would overflow")
+		return
+	}
+	incrDecrCommand(c, -decr)
+}
+
+// INCRBYFLOAT key increment
+func incrbyfloatCommand(c *GodisClient) {
+	incr, ok := getFloatFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	key := c.args[1]
+	cur, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	var val float64
+	if cur != nil {
+		v, err := cur.FloatVal()
+		if err != nil {
+			c.AddReplyErrorFormat("ERR: %v", err)
+			return
+		}
+		val = v
+	}
+	val += incr
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		c.AddReplyError("ERR: increment would produce NaN or Infinity")
+		return
+	}
+	str := strconv.FormatFloat(val, 'f', -1, 64)
+	newObj := obj.CreateObject(obj.GSTR, str)
+	if cur == nil {
+		dbAdd(c.db, key, newObj)
+	} else {
+		dbOverwrite(c.db, key, newObj)
+	}
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyBulk(str)
+}
+
+// APPEND key value
+func appendCommand(c *GodisClient) {
+	key := c.args[1]
+	cur, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if cur == nil {
+		dbAdd(c.db, key, c.args[2])
+		server.dirty++
+		c.AddReplyInt(int64(len(c.args[2].StrVal())))
+		return
+	}
+	if int64(len(cur.StrVal())+len(c.args[2].StrVal())) > STRING_MAX_SIZE {
+		c.AddReplyError("ERR: string exceeds maximum allowed size (512MB)")
+		return
+	}
+	str := cur.StrVal() + c.args[2].StrVal()
+	newObj := obj.CreateObject(obj.GSTR, str)
+	dbOverwrite(c.db, key, newObj)
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyInt(int64(len(str)))
+}
+
+// STRLEN key
+func strlenCommand(c *GodisClient) {
+	val, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	length := 0
+	if val != nil {
+		length = len(val.StrVal())
+	}
+	c.AddReplyInt(int64(length))
+}
+
+// GETRANGE key start end
+func getrangeCommand(c *GodisClient) {
+	start, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	end, ok := getIntFromArg(c, c.args[3])
+	if !ok {
+		return
+	}
+	val, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if val == nil {
+		c.AddReplyBulk("")
+		return
+	}
+	str := val.StrVal()
+	strLen := int64(len(str))
+	if start < 0 && end < 0 && start > end {
+		c.AddReplyBulk("")
+		return
+	}
+	if start < 0 {
+		start += strLen
+	}
+	if end < 0 {
+		end += strLen
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strLen {
+		end = strLen - 1
+	}
+	if start > end || strLen == 0 {
+		c.AddReplyBulk("")
+		return
+	}
+	c.AddReplyBulk(str[start : end+1])
+}
+
+// SETRANGE key offset value
+func setrangeCommand(c *GodisClient) {
+	offset, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	if offset < 0 {
+		c.AddReplyError("ERR: offset is out of range")
+		return
+	}
+	key := c.args[1]
+	cur, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	val := c.args[3].StrVal()
+	var str string
+	if cur != nil {
+		str = cur.StrVal()
+	}
+	// 空值不修改，不存在时也不创建key
+	if len(val) == 0 {
+		c.AddReplyInt(int64(len(str)))
+		return
+	}
+	if offset+int64(len(val)) > STRING_MAX_SIZE {
+		c.AddReplyError("ERR: string exceeds maximum allowed size (512MB)")
+		return
+	}
+	buf := []byte(str)
+	if need := int(offset) + len(val); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], val)
+	newObj := obj.CreateObject(obj.GSTR, string(buf))
+	if cur == nil {
+		dbAdd(c.db, key, newObj)
+	} else {
+		dbOverwrite(c.db, key, newObj)
+	}
+	newObj.DecrRefCount()
+	server.dirty++
+	c.AddReplyInt(int64(len(buf)))
+}
+
+// lcs 中一段连续匹配的区间，均为闭区间
+type lcsMatch struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len] [WITHMATCHLEN]
+func lcsCommand(c *GodisClient) {
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int64
+	for i := 3; i < len(c.args); i++ {
+		switch strings.ToLower(c.args[i].StrVal()) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 >= len(c.args) {
+				c.AddReplyError("ERR: syntax error")
+				return
+			}
+			val, ok := getIntFromArg(c, c.args[i+1])
+			if !ok {
+				return
+			}
+			if val > 0 {
+				minMatchLen = val
+			}
+			i++
+		default:
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+	if getLen && getIdx {
+		c.AddReplyError("ERR: if you want both the length and indexes, please just use IDX")
+		return
+	}
+	aobj, ok := stringLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	bobj, ok := stringLookupRead(c, c.args[2])
+	if !ok {
+		return
+	}
+	var a, b string
+	if aobj != nil {
+		a = aobj.StrVal()
+	}
+	if bobj != nil {
+		b = bobj.StrVal()
+	}
+
+	// 动态规划表过大时拒绝，避免耗尽内存及长时间阻塞
+	alen, blen := len(a), len(b)
+	if int64(alen+1) > LCS_MAX_CELLS/int64(blen+1) {
+		c.AddReplyError("ERR: string too long for LCS")
+		return
+	}
+	// 只需要长度时滚动使用两行
+	if getLen {
+		prev, row := make([]uint32, blen+1), make([]uint32, blen+1)
+		for i := 1; i <= alen; i++ {
+			for j := 1; j <= blen; j++ {
+				if a[i-1] == b[j-1] {
+					row[j] = prev[j-1] + 1
+				} else if prev[j] > row[j-1] {
+					row[j] = prev[j]
+				} else {
+					row[j] = row[j-1]
+				}
+			}
+			prev, row = row, prev
+		}
+		c.AddReplyInt(int64(prev[blen]))
+		return
+	}
+
+	// dp[i*w+j] 为 a[:i] 与 b[:j] 的最长公共子序列长度
+	w := blen + 1
+	dp := make([]uint32, (alen+1)*w)
+	for i := 1; i <= alen; i++ {
+		for j := 1; j <= blen; j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*w+j] = dp[(i-1)*w+j-1] + 1
+			} else if dp[(i-1)*w+j] > dp[i*w+j-1] {
+				dp[i*w+j] = dp[(i-1)*w+j]
+			} else {
+				dp[i*w+j] = dp[i*w+j-1]
+			}
+		}
+	}
+	lcsLen := int(dp[alen*w+blen])
+
+	// 从后向前回溯，得到结果及各段匹配区间
+	result := make([]byte, lcsLen)
+	var matches []lcsMatch
+	var cur *lcsMatch
+	emit := func() {
+		if cur != nil && int64(cur.aEnd-cur.aStart+1) >= minMatchLen {
+			matches = append(matches, *cur)
+		}
+		cur = nil
+	}
+	idx := lcsLen
+	for i, j := alen, blen; i > 0 && j > 0; {
+		if a[i-1] == b[j-1] {
+			idx--
+			result[idx] = a[i-1]
+			if cur == nil {
+				cur = &lcsMatch{aStart: i - 1, aEnd: i - 1, bStart: j - 1, bEnd: j - 1}
+			} else {
+				cur.aStart, cur.bStart = i-1, j-1
+			}
+			i--
+			j--
+			continue
+		}
+		emit()
+		if dp[(i-1)*w+j] > dp[i*w+j-1] {
+			i--
+		} else {
+			j--
+		}
+	}
+	emit()
+
+	if !getIdx {
+		c.AddReplyBulk(string(result))
+		return
+	}
+	c.AddReplyArrayLen(4)
+	c.AddReplyBulk("matches")
+	c.AddReplyArrayLen(len(matches))
+	for _, m := range matches {
+		if withMatchLen {
+			c.AddReplyArrayLen(3)
+		} else {
+			c.AddReplyArrayLen(2)
+		}
+		c.AddReplyArrayLen(2)
+		c.AddReplyInt(int64(m.aStart))
+		c.AddReplyInt(int64(m.aEnd))
+		c.AddReplyArrayLen(2)
+		c.AddReplyInt(int64(m.bStart))
+		c.AddReplyInt(int64(m.bEnd))
+		if withMatchLen {
+			c.AddReplyInt(int64(m.aEnd - m.aStart + 1))
+		}
+	}
+	c.AddReplyBulk("len")
+	c.AddReplyInt(int64(lcsLen))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"akt-redis/obj"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set s hello\r\n"))
+	assert.Equal(t, "$5\r\nhello\r\n", ExecQuery(client, "get s\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get nope\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "setnx s x\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "setnx s2 x\r\n"))
+	assert.Equal(t, "$1\r\nx\r\n", ExecQuery(client, "getset s2 y\r\n"))
+	assert.Equal(t, "$1\r\ny\r\n", ExecQuery(client, "getdel s2\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "get s2\r\n"))
+	assert.Equal(t, "$5\r\nhello\r\n", ExecQuery(client, "getex s px 100000\r\n"))
+	assert.NotNil(t, client.db.expire.Find(obj.CreateObject(obj.GSTR, "s")))
+	assert.Equal(t, "$5\r\nhello\r\n", ExecQuery(client, "getex s persist\r\n"))
+	assert.Nil(t, client.db.expire.Find(obj.CreateObject(obj.GSTR, "s")))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "getex s ex 1 persist\r\n"))
+
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "mset a 1 b 2\r\n"))
+	assert.Equal(t, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n", ExecQuery(client, "mget a nope b\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "msetnx c 3 a 4\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "msetnx c 3 d 4\r\n"))
+
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "incr a\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "decr a\r\n"))
+	assert.Equal(t, ":11\r\n", ExecQuery(client, "incrby a 10\r\n"))
+	assert.Equal(t, ":-4\r\n", ExecQuery(client, "decrby n 4\r\n"))
+	assert.Equal(t, "-ERR: value is not an integer or out of range\r\n", ExecQuery(client, "incr s\r\n"))
+	assert.Equal(t, "-ERR: value is not an integer or out of range\r\n", ExecQuery(client, "incrby a x\r\n"))
+	ExecQuery(client, "set big 9223372036854775807\r\n")
+	assert.Equal(t, "-ERR: increment or decrement would overflow\r\n", ExecQuery(client, "incr big\r\n"))
+	assert.Equal(t, "$4\r\n11.5\r\n", ExecQuery(client, "incrbyfloat a 0.5\r\n"))
+
+	ExecQuery(client, "set s2 hello\r\n")
+	assert.Equal(t, ":10\r\n", ExecQuery(client, "append s2 world\r\n"))
+	assert.Equal(t, ":10\r\n", ExecQuery(client, "strlen s2\r\n"))
+	assert.Equal(t, "$5\r\nworld\r\n", ExecQuery(client, "getrange s2 -5 -1\r\n"))
+	assert.Equal(t, "$3\r\nhel\r\n", ExecQuery(client, "getrange s2 0 2\r\n"))
+	assert.Equal(t, "$0\r\n\r\n", ExecQuery(client, "getrange s2 5 2\r\n"))
+	assert.Equal(t, ":10\r\n", ExecQuery(client, "setrange s2 5 WORLD\r\n"))
+	assert.Equal(t, "$10\r\nhelloWORLD\r\n", ExecQuery(client, "get s2\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "setrange pad 2 x\r\n"))
+	assert.Equal(t, "$3\r\n\x00\x00x\r\n", ExecQuery(client, "get pad\r\n"))
+
+	ExecQuery(client, "mset k1 ohmytext k2 mynewtext\r\n")
+	assert.Equal(t, "$6\r\nmytext\r\n", ExecQuery(client, "lcs k1 k2\r\n"))
+	assert.Equal(t, ":6\r\n", ExecQuery(client, "lcs k1 k2 len\r\n"))
+	assert.Equal(t, "*4\r\n$7\r\nmatches\r\n*2\r\n"+
+		"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n"+
+		"*3\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n:2\r\n"+
+		"$3\r\nlen\r\n:6\r\n", ExecQuery(client, "lcs k1 k2 idx withmatchlen\r\n"))
+	ExecQuery(client, "setrange big1 100000 x\r\n")
+	ExecQuery(client, "setrange big2 100000 x\r\n")
+	assert.Equal(t, "-ERR: string too long for LCS\r\n", ExecQuery(client, "lcs big1 big2 len\r\n"))
+
+	ExecQuery(client, "lpush list a\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "get list\r\n"))
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "incr list\r\n"))
+}
+
+func TestSetOptions(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+	key := obj.CreateObject(obj.GSTR, "k")
+
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "set k v1 xx\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set k v1 nx ex 100\r\n"))
+	assert.NotNil(t, client.db.expire.Find(key))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "set k v2 nx\r\n"))
+	assert.Equal(t, "$2\r\nv1\r\n", ExecQuery(client, "set k v2 xx get keepttl\r\n"))
+	assert.NotNil(t, client.db.expire.Find(key))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set k v3\r\n"))
+	assert.Nil(t, client.db.expire.Find(key))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set k v4 pxat 99999999999999\r\n"))
+	when, _ := client.db.expire.Get(key).IntVal()
+	assert.Equal(t, int64(99999999999999), when)
+	assert.Equal(t, "$2\r\nv4\r\n", ExecQuery(client, "set k v5 nx get\r\n"))
+	assert.Equal(t, "$2\r\nv4\r\n", ExecQuery(client, "get k\r\n"))
+
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "set k v nx xx\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "set k v ex 10 px 100\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "set k v keepttl ex 10\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "set k v ex\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "set k v persist\r\n"))
+	assert.Equal(t, "-ERR: invalid expire time in 'set' command\r\n", ExecQuery(client, "set k v ex 0\r\n"))
+	assert.Equal(t, "-ERR: value is not an integer or out of range\r\n", ExecQuery(client, "set k v px abc\r\n"))
+
+	ExecQuery(client, "lpush list a\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "set list v get\r\n"))
+	assert.Equal(t, "+OK\r\n", ExecQuery(client, "set list v\r\n"))
+}
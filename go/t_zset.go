@@ -0,0 +1,783 @@
+package main
+
+import (
+	"akt-redis/dict"
+	"akt-redis/obj"
+	"akt-redis/zset"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func createZsetObject() *obj.Gobj {
+	return obj.CreateObject(obj.GZSET, zset.ZSetCreate())
+}
+
+// 获取zset，类型不符时回复错误并返回false
+func zsetLookupRead(c *GodisClient, key *obj.Gobj) (*zset.ZSet, bool) {
+	zobj := findKeyRead(c.db, key)
+	if zobj == nil {
+		return nil, true
+	}
+	if checkType(c, zobj, obj.GZSET) {
+		return nil, false
+	}
+	return zobj.Val_.(*zset.ZSet), true
+}
+
+// 获取zset用于修改，类型不符时回复错误并返回false
+func zsetLookupWrite(c *GodisClient, key *obj.Gobj) (*zset.ZSet, bool) {
+	zobj := findKeyWrite(c.db, key)
+	if zobj == nil {
+		return nil, true
+	}
+	if checkType(c, zobj, obj.GZSET) {
+		return nil, false
+	}
+	return zobj.Val_.(*zset.ZSet), true
+}
+
+// 解析score参数，失败时回复错误
+func getScoreFromArg(c *GodisClient, o *obj.Gobj) (float64, bool) {
+	score, err := strconv.ParseFloat(o.StrVal(), 64)
+	if err != nil || math.IsNaN(score) {
+		c.AddReplyError("ERR: value is not a valid float")
+		return 0, false
+	}
+	return score, true
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func zaddCommand(c *GodisClient) {
+	zaddGenericCommand(c, 0)
+}
+
+// ZINCRBY key increment member
+func zincrbyCommand(c *GodisClient) {
+	zaddGenericCommand(c, zset.ZADD_IN_INCR)
+}
+
+func zaddGenericCommand(c *GodisClient, flags int) {
+	ch := false
+	idx := 2
+	for ; idx < len(c.args); idx++ {
+		opt := strings.ToLower(c.args[idx].StrVal())
+		if opt == "nx" {
+			flags |= zset.ZADD_IN_NX
+		} else if opt == "xx" {
+			flags |= zset.ZADD_IN_XX
+		} else if opt == "gt" {
+			flags |= zset.ZADD_IN_GT
+		} else if opt == "lt" {
+			flags |= zset.ZADD_IN_LT
+		} else if opt == "ch" {
+			ch = true
+		} else if opt == "incr" {
+			flags |= zset.ZADD_IN_INCR
+		} else {
+			break
+		}
+	}
+	incr := flags&zset.ZADD_IN_INCR != 0
+	nx := flags&zset.ZADD_IN_NX != 0
+	gt := flags&zset.ZADD_IN_GT != 0
+	lt := flags&zset.ZADD_IN_LT != 0
+
+	elements := len(c.args) - idx
+	if elements%2 != 0 || elements == 0 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	elements /= 2
+	if nx && flags&zset.ZADD_IN_XX != 0 {
+		c.AddReplyError("ERR: XX and NX options at the same time are not compatible")
+		return
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		c.AddReplyError("ERR: GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if incr && elements > 1 {
+		c.AddReplyError("ERR: INCR option supports a single increment-element pair")
+		return
+	}
+
+	scores := make([]float64, elements)
+	for i := 0; i < elements; i++ {
+		var ok bool
+		if scores[i], ok = getScoreFromArg(c, c.args[idx+i*2]); !ok {
+			return
+		}
+	}
+
+	key := c.args[1]
+	zobj := findKeyWrite(c.db, key)
+	if zobj != nil && checkType(c, zobj, obj.GZSET) {
+		return
+	}
+	if zobj == nil {
+		if flags&zset.ZADD_IN_XX != 0 {
+			if incr {
+				c.AddReplyNull()
+			} else {
+				c.AddReplyInt(0)
+			}
+			return
+		}
+		zobj = createZsetObject()
+		dbAdd(c.db, key, zobj)
+		zobj.DecrRefCount()
+	}
+	zs := zobj.Val_.(*zset.ZSet)
+
+	added, updated := 0, 0
+	var score float64
+	for i := 0; i < elements; i++ {
+		var out int
+		out, score = zs.Add(scores[i], c.args[idx+i*2+1], flags)
+		if out&zset.ZADD_OUT_NAN != 0 {
+			c.AddReplyError("ERR: resulting score is not a number (NaN)")
+			if zs.Len() == 0 {
+				dbDelete(c.db, key)
+			}
+			return
+		}
+		if out&zset.ZADD_OUT_ADDED != 0 {
+			added++
+		}
+		if out&zset.ZADD_OUT_UPDATED != 0 {
+			updated++
+		}
+		if incr && out&zset.ZADD_OUT_NOP != 0 {
+			c.AddReplyNull()
+			return
+		}
+	}
+	if zs.Len() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += int64(added + updated)
+	if incr {
+		c.AddReplyDouble(score)
+	} else if ch {
+		c.AddReplyInt(int64(added + updated))
+	} else {
+		c.AddReplyInt(int64(added))
+	}
+}
+
+// ZREM key member [member ...]
+func zremCommand(c *GodisClient) {
+	zs, ok := zsetLookupWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	deleted := 0
+	if zs != nil {
+		for _, member := range c.args[2:] {
+			if zs.Delete(member) {
+				deleted++
+			}
+		}
+		if zs.Len() == 0 {
+			dbDelete(c.db, c.args[1])
+		}
+	}
+	server.dirty += int64(deleted)
+	c.AddReplyInt(int64(deleted))
+}
+
+// ZSCORE key member
+func zscoreCommand(c *GodisClient) {
+	zs, ok := zsetLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zs == nil {
+		c.AddReplyNull()
+		return
+	}
+	score, ok := zs.Score(c.args[2])
+	if !ok {
+		c.AddReplyNull()
+		return
+	}
+	c.AddReplyDouble(score)
+}
+
+// ZCARD key
+func zcardCommand(c *GodisClient) {
+	zs, ok := zsetLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var length int64
+	if zs != nil {
+		length = zs.Len()
+	}
+	c.AddReplyInt(length)
+}
+
+// ZRANK/ZREVRANK key member
+func zrankGenericCommand(c *GodisClient, reverse bool) {
+	zs, ok := zsetLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zs == nil {
+		c.AddReplyNull()
+		return
+	}
+	rank, ok := zs.Rank(c.args[2], reverse)
+	if !ok {
+		c.AddReplyNull()
+		return
+	}
+	c.AddReplyInt(rank)
+}
+
+func zrankCommand(c *GodisClient) {
+	zrankGenericCommand(c, false)
+}
+
+func zrevrankCommand(c *GodisClient) {
+	zrankGenericCommand(c, true)
+}
+
+const (
+	ZRANGE_RANK = iota
+	ZRANGE_SCORE
+	ZRANGE_LEX
+)
+
+func addReplyZsetNodes(c *GodisClient, nodes []*zset.Node, withScores bool) {
+	if withScores {
+		c.AddReplyArrayLen(len(nodes) * 2)
+	} else {
+		c.AddReplyArrayLen(len(nodes))
+	}
+	for _, n := range nodes {
+		c.AddReplyBulk(n.Member.StrVal())
+		if withScores {
+			c.AddReplyDouble(n.Score)
+		}
+	}
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func zrangeCommand(c *GodisClient) {
+	rangeType := ZRANGE_RANK
+	reverse, withScores, hasLimit := false, false, false
+	var offset, limit int64 = 0, -1
+	for i := 4; i < len(c.args); i++ {
+		opt := strings.ToLower(c.args[i].StrVal())
+		if opt == "withscores" {
+			withScores = true
+		} else if opt == "rev" {
+			reverse = true
+		} else if opt == "byscore" && rangeType == ZRANGE_RANK {
+			rangeType = ZRANGE_SCORE
+		} else if opt == "bylex" && rangeType == ZRANGE_RANK {
+			rangeType = ZRANGE_LEX
+		} else if opt == "limit" && i+2 < len(c.args) {
+			var ok bool
+			if offset, ok = getIntFromArg(c, c.args[i+1]); !ok {
+				return
+			}
+			if limit, ok = getIntFromArg(c, c.args[i+2]); !ok {
+				return
+			}
+			hasLimit = true
+			i += 2
+		} else {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+	if hasLimit && rangeType == ZRANGE_RANK {
+		c.AddReplyError("ERR: syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		return
+	}
+	if withScores && rangeType == ZRANGE_LEX {
+		c.AddReplyError("ERR: syntax error, WITHSCORES not supported in combination with BYLEX")
+		return
+	}
+
+	minArg, maxArg := c.args[2], c.args[3]
+	if reverse && rangeType != ZRANGE_RANK {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	var nodes []*zset.Node
+	switch rangeType {
+	case ZRANGE_RANK:
+		start, ok := getIntFromArg(c, minArg)
+		if !ok {
+			return
+		}
+		end, ok := getIntFromArg(c, maxArg)
+		if !ok {
+			return
+		}
+		zs, ok := zsetLookupRead(c, c.args[1])
+		if !ok {
+			return
+		}
+		if zs != nil {
+			nodes = zsetRangeByRank(zs, start, end, reverse)
+		}
+	case ZRANGE_SCORE:
+		r, err := zset.ParseRange(minArg.StrVal(), maxArg.StrVal())
+		if err != nil {
+			c.AddReplyErrorFormat("ERR: %v", err)
+			return
+		}
+		zs, ok := zsetLookupRead(c, c.args[1])
+		if !ok {
+			return
+		}
+		if zs != nil {
+			var first *zset.Node
+			if reverse {
+				first = zs.LastInRange(r)
+			} else {
+				first = zs.FirstInRange(r)
+			}
+			nodes = zsetRangeFrom(first, reverse, offset, limit, func(n *zset.Node) bool {
+				return r.Contains(n.Score)
+			})
+		}
+	case ZRANGE_LEX:
+		r, err := zset.ParseLexRange(minArg.StrVal(), maxArg.StrVal())
+		if err != nil {
+			c.AddReplyErrorFormat("ERR: %v", err)
+			return
+		}
+		zs, ok := zsetLookupRead(c, c.args[1])
+		if !ok {
+			return
+		}
+		if zs != nil {
+			var first *zset.Node
+			if reverse {
+				first = zs.LastInLexRange(r)
+			} else {
+				first = zs.FirstInLexRange(r)
+			}
+			nodes = zsetRangeFrom(first, reverse, offset, limit, func(n *zset.Node) bool {
+				return r.Contains(n.Member.StrVal())
+			})
+		}
+	}
+	addReplyZsetNodes(c, nodes, withScores)
+}
+
+func zsetRangeByRank(zs *zset.ZSet, start, end int64, reverse bool) []*zset.Node {
+	start, end, ok := listRange(start, end, int(zs.Len()))
+	if !ok {
+		return nil
+	}
+	var n *zset.Node
+	if reverse {
+		n = zs.ElementByRank(zs.Len() - 1 - start)
+	} else {
+		n = zs.ElementByRank(start)
+	}
+	nodes := make([]*zset.Node, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		nodes = append(nodes, n)
+		if reverse {
+			n = n.Prev()
+		} else {
+			n = n.Next()
+		}
+	}
+	return nodes
+}
+
+// 从first开始遍历，跳过offset个元素，最多返回limit个(limit < 0 不限制)
+func zsetRangeFrom(first *zset.Node, reverse bool, offset, limit int64, inRange func(n *zset.Node) bool) []*zset.Node {
+	var nodes []*zset.Node
+	if offset < 0 {
+		return nodes
+	}
+	n := first
+	for n != nil && inRange(n) && limit != 0 {
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, n)
+			limit--
+		}
+		if reverse {
+			n = n.Prev()
+		} else {
+			n = n.Next()
+		}
+	}
+	return nodes
+}
+
+// ZCOUNT key min max
+func zcountCommand(c *GodisClient) {
+	r, err := zset.ParseRange(c.args[2].StrVal(), c.args[3].StrVal())
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	zs, ok := zsetLookupRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var count int64
+	if zs != nil {
+		count = zs.Count(r)
+	}
+	c.AddReplyInt(count)
+}
+
+// ZREMRANGEBYSCORE key min max
+func zremrangebyscoreCommand(c *GodisClient) {
+	r, err := zset.ParseRange(c.args[2].StrVal(), c.args[3].StrVal())
+	if err != nil {
+		c.AddReplyErrorFormat("ERR: %v", err)
+		return
+	}
+	zs, ok := zsetLookupWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	var removed int64
+	if zs != nil {
+		removed = zs.DeleteRangeByScore(r)
+		if zs.Len() == 0 {
+			dbDelete(c.db, c.args[1])
+		}
+	}
+	server.dirty += removed
+	c.AddReplyInt(removed)
+}
+
+// ZREMRANGEBYRANK key start stop
+func zremrangebyrankCommand(c *GodisClient) {
+	start, ok := getIntFromArg(c, c.args[2])
+	if !ok {
+		return
+	}
+	end, ok := getIntFromArg(c, c.args[3])
+	if !ok {
+		return
+	}
+	zs, ok := zsetLookupWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	var removed int64
+	if zs != nil {
+		if start, end, ok = listRange(start, end, int(zs.Len())); ok {
+			removed = zs.DeleteRangeByRank(start, end)
+		}
+		if zs.Len() == 0 {
+			dbDelete(c.db, c.args[1])
+		}
+	}
+	server.dirty += removed
+	c.AddReplyInt(removed)
+}
+
+// ZPOPMIN/ZPOPMAX key [count]
+func zpopGenericCommand(c *GodisClient, max bool) {
+	if len(c.args) > 3 {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+	var count int64 = 1
+	if len(c.args) == 3 {
+		var ok bool
+		if count, ok = getIntFromArg(c, c.args[2]); !ok {
+			return
+		}
+		if count < 0 {
+			c.AddReplyError("ERR: value is out of range, must be positive")
+			return
+		}
+	}
+	key := c.args[1]
+	zs, ok := zsetLookupWrite(c, key)
+	if !ok {
+		return
+	}
+	if zs == nil {
+		c.AddReplyArrayLen(0)
+		return
+	}
+	if count > zs.Len() {
+		count = zs.Len()
+	}
+	c.AddReplyArrayLen(int(count * 2))
+	for i := int64(0); i < count; i++ {
+		var n *zset.Node
+		if max {
+			n = zs.Last()
+		} else {
+			n = zs.First()
+		}
+		c.AddReplyBulk(n.Member.StrVal())
+		c.AddReplyDouble(n.Score)
+		zs.Delete(n.Member)
+	}
+	if zs.Len() == 0 {
+		dbDelete(c.db, key)
+	}
+	server.dirty += count
+}
+
+func zpopminCommand(c *GodisClient) {
+	zpopGenericCommand(c, false)
+}
+
+func zpopmaxCommand(c *GodisClient) {
+	zpopGenericCommand(c, true)
+}
+
+const (
+	ZAGGREGATE_SUM = iota
+	ZAGGREGATE_MIN
+	ZAGGREGATE_MAX
+)
+
+// zset 集合运算的输入，普通set的score视为1
+type zsetopSrc struct {
+	zs     *zset.ZSet
+	set    *dict.Dict
+	weight float64
+}
+
+func (src *zsetopSrc) size() int64 {
+	if src.zs != nil {
+		return src.zs.Len()
+	} else if src.set != nil {
+		return src.set.Size()
+	}
+	return 0
+}
+
+func (src *zsetopSrc) score(member *obj.Gobj) (float64, bool) {
+	if src.zs != nil {
+		return src.zs.Score(member)
+	} else if src.set != nil && setIsMember(src.set, member) {
+		return 1, true
+	}
+	return 0, false
+}
+
+func (src *zsetopSrc) forEach(fn func(member *obj.Gobj, score float64)) {
+	if src.zs != nil {
+		for n := src.zs.First(); n != nil; n = n.Next() {
+			fn(n.Member, n.Score)
+		}
+	} else if src.set != nil {
+		src.set.ForEach(func(e *dict.Entry) {
+			fn(e.Key, 1)
+		})
+	}
+}
+
+// inf * 0 等情况会产生NaN，视为0
+func zunionInterWeight(score, weight float64) float64 {
+	score *= weight
+	if math.IsNaN(score) {
+		return 0
+	}
+	return score
+}
+
+func zunionInterAggregate(target, val float64, aggregate int) float64 {
+	switch aggregate {
+	case ZAGGREGATE_MIN:
+		return math.Min(target, val)
+	case ZAGGREGATE_MAX:
+		return math.Max(target, val)
+	}
+	target += val
+	// +inf + -inf
+	if math.IsNaN(target) {
+		return 0
+	}
+	return target
+}
+
+// ZUNION/ZINTER/ZDIFF 及 STORE 形式的公共实现
+// dstKey 为nil时直接返回结果，numkeysIdx 为numkeys参数下标
+func zunionInterDiffGenericCommand(c *GodisClient, dstKey *obj.Gobj, numkeysIdx int, op int) {
+	numkeys, ok := getIntFromArg(c, c.args[numkeysIdx])
+	if !ok {
+		return
+	}
+	if numkeys < 1 {
+		c.AddReplyErrorFormat("ERR: at least 1 input key is needed for '%v' command", c.args[0].StrVal())
+		return
+	}
+	if numkeys > int64(len(c.args)-numkeysIdx-1) {
+		c.AddReplyError("ERR: syntax error")
+		return
+	}
+
+	srcs := make([]*zsetopSrc, numkeys)
+	for i := range srcs {
+		key := c.args[numkeysIdx+1+i]
+		src := &zsetopSrc{weight: 1}
+		if o := findKeyRead(c.db, key); o != nil {
+			if o.Type_ == obj.GZSET {
+				src.zs = o.Val_.(*zset.ZSet)
+			} else if o.Type_ == obj.GSET {
+				src.set = o.Val_.(*dict.Dict)
+			} else {
+				c.AddReplyError("ERR: wrong type")
+				return
+			}
+		}
+		srcs[i] = src
+	}
+
+	aggregate := ZAGGREGATE_SUM
+	withScores := false
+	for i := numkeysIdx + 1 + int(numkeys); i < len(c.args); i++ {
+		remaining := len(c.args) - i - 1
+		opt := strings.ToLower(c.args[i].StrVal())
+		if op != SET_OP_DIFF && opt == "weights" && remaining >= int(numkeys) {
+			for j := range srcs {
+				i++
+				w, err := strconv.ParseFloat(c.args[i].StrVal(), 64)
+				if err != nil || math.IsNaN(w) {
+					c.AddReplyError("ERR: weight value is not a float")
+					return
+				}
+				srcs[j].weight = w
+			}
+		} else if op != SET_OP_DIFF && opt == "aggregate" && remaining >= 1 {
+			i++
+			switch strings.ToLower(c.args[i].StrVal()) {
+			case "sum":
+				aggregate = ZAGGREGATE_SUM
+			case "min":
+				aggregate = ZAGGREGATE_MIN
+			case "max":
+				aggregate = ZAGGREGATE_MAX
+			default:
+				c.AddReplyError("ERR: syntax error")
+				return
+			}
+		} else if dstKey == nil && opt == "withscores" {
+			withScores = true
+		} else {
+			c.AddReplyError("ERR: syntax error")
+			return
+		}
+	}
+
+	result := zset.ZSetCreate()
+	switch op {
+	case SET_OP_INTER:
+		// 从最小的输入开始遍历
+		sort.SliceStable(srcs, func(i, j int) bool {
+			return srcs[i].size() < srcs[j].size()
+		})
+		if srcs[0].size() == 0 {
+			break
+		}
+		srcs[0].forEach(func(member *obj.Gobj, score float64) {
+			score = zunionInterWeight(score, srcs[0].weight)
+			for _, other := range srcs[1:] {
+				s, ok := other.score(member)
+				if !ok {
+					return
+				}
+				score = zunionInterAggregate(score, zunionInterWeight(s, other.weight), aggregate)
+			}
+			result.Add(score, member, 0)
+		})
+	case SET_OP_UNION:
+		for _, src := range srcs {
+			src.forEach(func(member *obj.Gobj, score float64) {
+				score = zunionInterWeight(score, src.weight)
+				if cur, ok := result.Score(member); ok {
+					score = zunionInterAggregate(cur, score, aggregate)
+				}
+				result.Add(score, member, 0)
+			})
+		}
+	case SET_OP_DIFF:
+		srcs[0].forEach(func(member *obj.Gobj, score float64) {
+			for _, other := range srcs[1:] {
+				if _, ok := other.score(member); ok {
+					return
+				}
+			}
+			result.Add(score, member, 0)
+		})
+	}
+
+	if dstKey != nil {
+		deleted := dbDelete(c.db, dstKey)
+		if result.Len() > 0 {
+			o := obj.CreateObject(obj.GZSET, result)
+			dbAdd(c.db, dstKey, o)
+			o.DecrRefCount()
+			server.dirty++
+		} else if deleted {
+			server.dirty++
+		}
+		c.AddReplyInt(result.Len())
+		return
+	}
+	nodes := make([]*zset.Node, 0, result.Len())
+	for n := result.First(); n != nil; n = n.Next() {
+		nodes = append(nodes, n)
+	}
+	addReplyZsetNodes(c, nodes, withScores)
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zunionCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, nil, 1, SET_OP_UNION)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zunionstoreCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, c.args[1], 2, SET_OP_UNION)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func zinterCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, nil, 1, SET_OP_INTER)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func zinterstoreCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, c.args[1], 2, SET_OP_INTER)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func zdiffCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, nil, 1, SET_OP_DIFF)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func zdiffstoreCommand(c *GodisClient) {
+	zunionInterDiffGenericCommand(c, c.args[1], 2, SET_OP_DIFF)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func zscanCommand(c *GodisClient) {
+	cursor, ok := parseScanCursor(c, c.args[2])
+	if !ok {
+		return
+	}
+	o := scanLookupRead(c, obj.GZSET)
+	if o == nil {
+		return
+	}
+	scanGenericCommand(c, o, cursor, 2)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"akt-redis/conf"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZsetCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "zadd z 1 a 2 b 3 c\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "zadd z ch 1.5 a 4 d\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zadd z nx 10 a\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zadd z xx 10 nope\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "zadd z gt incr -1 a\r\n"))
+	assert.Equal(t, "-ERR: XX and NX options at the same time are not compatible\r\n", ExecQuery(client, "zadd z nx xx 1 a\r\n"))
+	assert.Equal(t, "-ERR: value is not a valid float\r\n", ExecQuery(client, "zadd z x a\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zincrby z 1 a\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zscore z a\r\n"))
+	// score 不变时回复当前score
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zincrby z 0 a\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zadd z incr 0 a\r\n"))
+	assert.Equal(t, "$3\r\n2.5\r\n", ExecQuery(client, "zadd z xx incr 0 a\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zadd z ch 2.5 a\r\n"))
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "zcard z\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "zrank z a\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "zrevrank z a\r\n"))
+	assert.Equal(t, "$-1\r\n", ExecQuery(client, "zrank z nope\r\n"))
+
+	// b:2 a:2.5 c:3 d:4
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\na\r\n", ExecQuery(client, "zrange z 0 1\r\n"))
+	assert.Equal(t, "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n", ExecQuery(client, "zrange z 0 1 rev withscores\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nc\r\n", ExecQuery(client, "zrange z (2 3 byscore\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\nc\r\n", ExecQuery(client, "zrange z +inf -inf byscore rev limit 1 1\r\n"))
+	assert.Equal(t, ":3\r\n", ExecQuery(client, "zcount z 2 (4\r\n"))
+
+	assert.Equal(t, "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\na\r\n$3\r\n2.5\r\n", ExecQuery(client, "zpopmin z 2\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nd\r\n$1\r\n4\r\n", ExecQuery(client, "zpopmax z\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "zrem z c nope\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zcard z\r\n"))
+
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "zadd lex 0 a 0 b 0 c 0 d\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n", ExecQuery(client, "zrange lex (a [c bylex\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n", ExecQuery(client, "zrange lex + - bylex rev limit 0 2\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "zremrangebyrank lex 0 1\r\n"))
+	assert.Equal(t, ":2\r\n", ExecQuery(client, "zremrangebyscore lex -inf 0\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zcard lex\r\n"))
+
+	ExecQuery(client, "set str val\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "zadd str 1 a\r\n"))
+}
+
+func TestZsetAggregateCommands(t *testing.T) {
+	var config conf.Config
+	initServer(&config)
+	client := CreateClient(server.fd)
+
+	ExecQuery(client, "zadd z1 1 a 2 b 3 c\r\n")
+	ExecQuery(client, "zadd z2 10 b 20 c 30 d\r\n")
+	ExecQuery(client, "sadd s c d\r\n")
+
+	assert.Equal(t, ":4\r\n", ExecQuery(client, "zunionstore out 2 z1 z2\r\n"))
+	assert.Equal(t, "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n23\r\n$1\r\nd\r\n$2\r\n30\r\n",
+		ExecQuery(client, "zrange out 0 -1 withscores\r\n"))
+	assert.Equal(t, "*4\r\n$1\r\nb\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n6\r\n",
+		ExecQuery(client, "zinter 2 z1 z2 weights 2 0.1 aggregate max withscores\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\n1\r\n",
+		ExecQuery(client, "zinter 2 z1 s aggregate min withscores\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", ExecQuery(client, "zdiff 2 z1 s\r\n"))
+	assert.Equal(t, ":1\r\n", ExecQuery(client, "zdiffstore out 3 z1 z2 s\r\n"))
+	assert.Equal(t, "*1\r\n$1\r\na\r\n", ExecQuery(client, "zrange out 0 -1\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zinterstore out 2 z1 nope\r\n"))
+	assert.Equal(t, ":0\r\n", ExecQuery(client, "zcard out\r\n"))
+	assert.Equal(t, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n", ExecQuery(client, "zunion 1 s\r\n"))
+
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "zunion 3 z1 z2\r\n"))
+	assert.Equal(t, "-ERR: syntax error\r\n", ExecQuery(client, "zdiff 1 z1 weights 2\r\n"))
+	assert.Equal(t, "-ERR: at least 1 input key is needed for 'zunion' command\r\n", ExecQuery(client, "zunion 0 z1\r\n"))
+	ExecQuery(client, "set str val\r\n")
+	assert.Equal(t, "-ERR: wrong type\r\n", ExecQuery(client, "zunion 2 z1 str\r\n"))
+}
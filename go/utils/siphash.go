@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+)
+
+// SipHash-2-4，使用随机seed防止hash碰撞攻击
+
+const HASH_SEED_LEN = 16
+
+var SEED_ERR = errors.New("hash seed must be 32 hex characters")
+
+var hashSeed [HASH_SEED_LEN]byte
+
+func init() {
+	if _, err := rand.Read(hashSeed[:]); err != nil {
+		panic(err)
+	}
+}
+
+// 设置hash seed，需在创建任何dict之前调用
+func SetHashSeed(seed [HASH_SEED_LEN]byte) {
+	hashSeed = seed
+}
+
+// 从16进制字符串设置hash seed
+func SetHashSeedHex(s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != HASH_SEED_LEN {
+		return SEED_ERR
+	}
+	var seed [HASH_SEED_LEN]byte
+	copy(seed[:], b)
+	SetHashSeed(seed)
+	return nil
+}
+
+func GetHashSeed() [HASH_SEED_LEN]byte {
+	return hashSeed
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+func SipHash(key [HASH_SEED_LEN]byte, data []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	length := len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	// 剩余字节与长度组成最后一个word
+	b := uint64(length) << 56
+	for i, c := range data {
+		b |= uint64(c) << (8 * i)
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -2,7 +2,7 @@ package utils
 
 import (
 	"akt-redis/obj"
-	"hash/fnv"
+	"bytes"
 	"time"
 )
 
@@ -14,9 +14,10 @@ const (
 	COMMAND_BULK    CmdType = 0x02
 )
 
+// bulk 上限与 Redis 的 proto-max-bulk-len 相同，RESTORE 及 Raft 快照的payload可能很大
 const (
 	GODIS_IO_BUF     int = 1024 * 16
-	GODIS_MAX_BULK   int = 1024 * 4
+	GODIS_MAX_BULK   int = 512 * 1024 * 1024
 	GODIS_MAX_INLINE int = 1024 * 4
 )
 
@@ -35,7 +36,97 @@ func GStrHash(key *obj.Gobj) int64 {
 	if key.Type_ != obj.GSTR {
 		return 0
 	}
-	hash := fnv.New64()
-	hash.Write([]byte(key.StrVal()))
-	return int64(hash.Sum64())
+	return int64(SipHash(hashSeed, []byte(key.StrVal())))
+}
+
+// glob 风格匹配，支持 * ? [abc] [^a-z] 及 \ 转义
+func StringMatch(pattern, str string, nocase bool) bool {
+	p, s := []byte(pattern), []byte(str)
+	if nocase {
+		p, s = bytes.ToLower(p), bytes.ToLower(s)
+	}
+	skipLonger := false
+	return stringMatch(p, s, &skipLonger)
+}
+
+// skipLonger 为true表示 * 之后的模式已尝试过当前字符串的所有后缀且都不匹配，
+// 外层的 * 再跳过更多字符也不会匹配，直接返回避免指数级回溯
+func stringMatch(p, s []byte, skipLonger *bool) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			// 合并连续的 *
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if stringMatch(p[1:], s[i:], skipLonger) {
+					return true
+				}
+				if *skipLonger {
+					return false
+				}
+			}
+			*skipLonger = true
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for len(p) > 0 && p[0] != ']' {
+				if p[0] == '\\' && len(p) >= 2 {
+					p = p[1:]
+					if p[0] == s[0] {
+						match = true
+					}
+				} else if len(p) >= 3 && p[1] == '-' && p[2] != ']' {
+					start, end := p[0], p[2]
+					if start > end {
+						start, end = end, start
+					}
+					if s[0] >= start && s[0] <= end {
+						match = true
+					}
+					p = p[2:]
+				} else if p[0] == s[0] {
+					match = true
+				}
+				p = p[1:]
+			}
+			// 缺少 ] 时视为到达结尾
+			if len(p) == 0 {
+				p = []byte{']'}
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
+	}
+	return len(s) == 0
 }
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"akt-redis/obj"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringMatch(t *testing.T) {
+	assert.True(t, StringMatch("*", "", false))
+	assert.True(t, StringMatch("h?llo", "hello", false))
+	assert.False(t, StringMatch("h?llo", "hllo", false))
+	assert.True(t, StringMatch("h*llo", "heeeello", false))
+	assert.True(t, StringMatch("h[ae]llo", "hallo", false))
+	assert.False(t, StringMatch("h[ae]llo", "hillo", false))
+	assert.True(t, StringMatch("h[^e]llo", "hallo", false))
+	assert.False(t, StringMatch("h[^e]llo", "hello", false))
+	assert.True(t, StringMatch("h[a-b]llo", "hbllo", false))
+	assert.True(t, StringMatch("user:*:name", "user:1000:name", false))
+	assert.False(t, StringMatch("user:*:name", "user:1000:age", false))
+	assert.True(t, StringMatch("a\\*b", "a*b", false))
+	assert.False(t, StringMatch("a\\*b", "axb", false))
+	assert.True(t, StringMatch("HELLO", "hello", true))
+	assert.True(t, StringMatch("*a*b*c", "xaybzc", false))
+	// 多个 * 不会指数级回溯
+	long := strings.Repeat("a", 10000)
+	assert.False(t, StringMatch("*a*a*a*a*a*a*a*a*a*b", long, false))
+	assert.True(t, StringMatch("*a*a*a*a*a*a*a*a*a*b", long+"b", false))
+}
+
+func TestSipHash(t *testing.T) {
+	var key [HASH_SEED_LEN]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	// 参考实现的测试向量
+	assert.Equal(t, uint64(0x726fdb47dd0e0e31), SipHash(key, []byte{}))
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	assert.Equal(t, uint64(0xa129ca6149be45e5), SipHash(key, msg))
+
+	old := GetHashSeed()
+	defer SetHashSeed(old)
+	assert.Equal(t, SEED_ERR, SetHashSeedHex("xyz"))
+	assert.Nil(t, SetHashSeedHex("000102030405060708090a0b0c0d0e0f"))
+	assert.Equal(t, SipHash(key, []byte("hello")), uint64(GStrHash(obj.CreateObject(obj.GSTR, "hello"))))
+}
+
+func TestCrc16(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), Crc16([]byte("123456789")))
+	assert.Equal(t, uint16(0), Crc16(nil))
+}
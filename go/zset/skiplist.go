@@ -0,0 +1,308 @@
+package zset
+
+import (
+	"akt-redis/obj"
+	"math/rand"
+)
+
+// 跳表，按score从小到大排列，score相同时按member字典序排列
+
+const (
+	ZSKIPLIST_MAXLEVEL int     = 32
+	ZSKIPLIST_P        float64 = 0.25
+)
+
+type skiplistLevel struct {
+	forward *Node
+	span    int64 // 到下一个节点跨越的节点数，用于计算rank
+}
+
+type Node struct {
+	Member   *obj.Gobj
+	Score    float64
+	backward *Node
+	level    []skiplistLevel
+}
+
+type skiplist struct {
+	header *Node
+	tail   *Node
+	length int64
+	level  int
+}
+
+func createNode(level int, score float64, member *obj.Gobj) *Node {
+	return &Node{
+		Member: member,
+		Score:  score,
+		level:  make([]skiplistLevel, level),
+	}
+}
+
+func skiplistCreate() *skiplist {
+	return &skiplist{
+		header: createNode(ZSKIPLIST_MAXLEVEL, 0, nil),
+		level:  1,
+	}
+}
+
+func (n *Node) Next() *Node {
+	return n.level[0].forward
+}
+
+func (n *Node) Prev() *Node {
+	return n.backward
+}
+
+// 随机层数，每升一层概率为ZSKIPLIST_P
+func randomLevel() int {
+	level := 1
+	for level < ZSKIPLIST_MAXLEVEL && rand.Float64() < ZSKIPLIST_P {
+		level++
+	}
+	return level
+}
+
+// 节点 (score, member) 是否排在 x 之后
+func nodeLess(x *Node, score float64, member *obj.Gobj) bool {
+	return x.Score < score || (x.Score == score && x.Member.StrVal() < member.StrVal())
+}
+
+// 插入节点，调用方需保证member不存在
+func (zsl *skiplist) insert(score float64, member *obj.Gobj) *Node {
+	var update [ZSKIPLIST_MAXLEVEL]*Node
+	var rank [ZSKIPLIST_MAXLEVEL]int64
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && nodeLess(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = createNode(level, score, member)
+	member.IncrRefCount()
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+	// 更高层跨越了新节点
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *skiplist) deleteNode(x *Node, update []*Node) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span -= 1
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// 查找 (score, member) 前驱节点
+func (zsl *skiplist) findUpdate(score float64, member *obj.Gobj) []*Node {
+	update := make([]*Node, ZSKIPLIST_MAXLEVEL)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && nodeLess(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	return update
+}
+
+func (zsl *skiplist) delete(score float64, member *obj.Gobj) bool {
+	update := zsl.findUpdate(score, member)
+	x := update[0].level[0].forward
+	if x != nil && x.Score == score && x.Member.StrVal() == member.StrVal() {
+		zsl.deleteNode(x, update)
+		x.Member.DecrRefCount()
+		return true
+	}
+	return false
+}
+
+// 更新score，位置不变时原地修改
+func (zsl *skiplist) updateScore(curScore float64, member *obj.Gobj, newScore float64) *Node {
+	update := zsl.findUpdate(curScore, member)
+	x := update[0].level[0].forward
+	if (x.backward == nil || x.backward.Score < newScore) &&
+		(x.level[0].forward == nil || x.level[0].forward.Score > newScore) {
+		x.Score = newScore
+		return x
+	}
+	zsl.deleteNode(x, update)
+	newNode := zsl.insert(newScore, x.Member)
+	x.Member.DecrRefCount()
+	return newNode
+}
+
+// 获取排名，从1开始，不存在时返回0
+func (zsl *skiplist) getRank(score float64, member *obj.Gobj) int64 {
+	var rank int64
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (nodeLess(x.level[i].forward, score, member) ||
+			(x.level[i].forward.Score == score && x.level[i].forward.Member.StrVal() == member.StrVal())) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x.Member != nil && x.Member.StrVal() == member.StrVal() {
+			return rank
+		}
+	}
+	return 0
+}
+
+// 根据排名获取节点，从1开始
+func (zsl *skiplist) getElementByRank(rank int64) *Node {
+	var traversed int64
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func (zsl *skiplist) isInRange(r *RangeSpec) bool {
+	if r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx)) {
+		return false
+	}
+	x := zsl.tail
+	if x == nil || !r.gteMin(x.Score) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !r.lteMax(x.Score) {
+		return false
+	}
+	return true
+}
+
+func (zsl *skiplist) firstInRange(r *RangeSpec) *Node {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.Score) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.Score) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skiplist) lastInRange(r *RangeSpec) *Node {
+	if !zsl.isInRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.Score) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.Score) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skiplist) isInLexRange(r *LexRangeSpec) bool {
+	if r.MinInf == 1 || r.MaxInf == -1 {
+		return false
+	}
+	if r.MinInf == 0 && r.MaxInf == 0 && (r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))) {
+		return false
+	}
+	x := zsl.tail
+	if x == nil || !r.gteMin(x.Member.StrVal()) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !r.lteMax(x.Member.StrVal()) {
+		return false
+	}
+	return true
+}
+
+func (zsl *skiplist) firstInLexRange(r *LexRangeSpec) *Node {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.gteMin(x.level[i].forward.Member.StrVal()) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.lteMax(x.Member.StrVal()) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skiplist) lastInLexRange(r *LexRangeSpec) *Node {
+	if !zsl.isInLexRange(r) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.lteMax(x.level[i].forward.Member.StrVal()) {
+			x = x.level[i].forward
+		}
+	}
+	if !r.gteMin(x.Member.StrVal()) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,344 @@
+package zset
+
+import (
+	"akt-redis/dict"
+	"akt-redis/obj"
+	"akt-redis/resp"
+	"akt-redis/utils"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// 有序集合：dict 保存 member -> score，跳表按score排序
+
+// ZSet.Add 输入flags
+const (
+	ZADD_IN_INCR = 1 << iota
+	ZADD_IN_NX
+	ZADD_IN_XX
+	ZADD_IN_GT
+	ZADD_IN_LT
+)
+
+// ZSet.Add 输出flags，NOP 表示因 NX/XX/GT/LT 未执行
+// score 不变时视为已更新，不返回任何flag
+const (
+	ZADD_OUT_NOP = 1 << iota
+	ZADD_OUT_NAN
+	ZADD_OUT_ADDED
+	ZADD_OUT_UPDATED
+)
+
+var (
+	MIN_MAX_ERR     = errors.New("min or max is not a float")
+	LEX_MIN_MAX_ERR = errors.New("min or max not valid string range item")
+)
+
+type ZSet struct {
+	dict *dict.Dict
+	zsl  *skiplist
+}
+
+// score 区间
+type RangeSpec struct {
+	Min, Max     float64
+	MinEx, MaxEx bool // 是否为开区间
+}
+
+// 字典序区间，MinInf/MaxInf 为 -1 表示 "-"，1 表示 "+"
+type LexRangeSpec struct {
+	Min, Max       string
+	MinEx, MaxEx   bool
+	MinInf, MaxInf int
+}
+
+func ZSetCreate() *ZSet {
+	return &ZSet{
+		dict: dict.DictCreate(dict.DictType{HashFunc: utils.GStrHash, EqualFunc: utils.GStrEqual}),
+		zsl:  skiplistCreate(),
+	}
+}
+
+func (r *RangeSpec) gteMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *RangeSpec) lteMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+func (r *RangeSpec) Contains(score float64) bool {
+	return r.gteMin(score) && r.lteMax(score)
+}
+
+func (r *LexRangeSpec) gteMin(s string) bool {
+	switch r.MinInf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+	if r.MinEx {
+		return s > r.Min
+	}
+	return s >= r.Min
+}
+
+func (r *LexRangeSpec) lteMax(s string) bool {
+	switch r.MaxInf {
+	case -1:
+		return false
+	case 1:
+		return true
+	}
+	if r.MaxEx {
+		return s < r.Max
+	}
+	return s <= r.Max
+}
+
+func (r *LexRangeSpec) Contains(s string) bool {
+	return r.gteMin(s) && r.lteMax(s)
+}
+
+func parseRangeItem(s string) (float64, bool, error) {
+	ex := false
+	if len(s) > 0 && s[0] == '(' {
+		ex = true
+		s = s[1:]
+	}
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(val) {
+		return 0, false, MIN_MAX_ERR
+	}
+	return val, ex, nil
+}
+
+// 解析 score 区间，如 "(1" "5" "-inf" "+inf"
+func ParseRange(min, max string) (*RangeSpec, error) {
+	var r RangeSpec
+	var err error
+	if r.Min, r.MinEx, err = parseRangeItem(min); err != nil {
+		return nil, err
+	}
+	if r.Max, r.MaxEx, err = parseRangeItem(max); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func parseLexRangeItem(s string) (string, bool, int, error) {
+	if len(s) == 0 {
+		return "", false, 0, LEX_MIN_MAX_ERR
+	}
+	switch s[0] {
+	case '+':
+		if len(s) == 1 {
+			return "", false, 1, nil
+		}
+	case '-':
+		if len(s) == 1 {
+			return "", false, -1, nil
+		}
+	case '(':
+		return s[1:], true, 0, nil
+	case '[':
+		return s[1:], false, 0, nil
+	}
+	return "", false, 0, LEX_MIN_MAX_ERR
+}
+
+// 解析字典序区间，如 "[a" "(b" "-" "+"
+func ParseLexRange(min, max string) (*LexRangeSpec, error) {
+	var r LexRangeSpec
+	var err error
+	if r.Min, r.MinEx, r.MinInf, err = parseLexRangeItem(min); err != nil {
+		return nil, err
+	}
+	if r.Max, r.MaxEx, r.MaxInf, err = parseLexRangeItem(max); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func FormatScore(score float64) string {
+	return resp.FormatDouble(score)
+}
+
+func createScoreObject(score float64) *obj.Gobj {
+	return obj.CreateObject(obj.GSTR, FormatScore(score))
+}
+
+func scoreOf(e *dict.Entry) float64 {
+	score, _ := strconv.ParseFloat(e.Val.StrVal(), 64)
+	return score
+}
+
+// 见 dict.PauseRehash，暂停期间跳表及dict都只读
+func (zs *ZSet) PauseRehash() {
+	zs.dict.PauseRehash()
+}
+
+func (zs *ZSet) ResumeRehash() {
+	zs.dict.ResumeRehash()
+}
+
+func (zs *ZSet) Len() int64 {
+	return zs.zsl.length
+}
+
+func (zs *ZSet) Score(member *obj.Gobj) (float64, bool) {
+	e := zs.dict.Find(member)
+	if e == nil {
+		return 0, false
+	}
+	return scoreOf(e), true
+}
+
+// 添加或更新member，返回输出flags及最终score
+func (zs *ZSet) Add(score float64, member *obj.Gobj, inFlags int) (int, float64) {
+	incr := inFlags&ZADD_IN_INCR != 0
+	nx := inFlags&ZADD_IN_NX != 0
+	xx := inFlags&ZADD_IN_XX != 0
+	gt := inFlags&ZADD_IN_GT != 0
+	lt := inFlags&ZADD_IN_LT != 0
+
+	if math.IsNaN(score) {
+		return ZADD_OUT_NAN, 0
+	}
+
+	if e := zs.dict.Find(member); e != nil {
+		curScore := scoreOf(e)
+		if nx {
+			return ZADD_OUT_NOP, curScore
+		}
+		if incr {
+			score += curScore
+			if math.IsNaN(score) {
+				return ZADD_OUT_NAN, 0
+			}
+		}
+		if (lt && score >= curScore) || (gt && score <= curScore) {
+			return ZADD_OUT_NOP, curScore
+		}
+		if score == curScore {
+			return 0, score
+		}
+		zs.zsl.updateScore(curScore, e.Key, score)
+		e.Val.DecrRefCount()
+		e.Val = createScoreObject(score)
+		return ZADD_OUT_UPDATED, score
+	} else if !xx {
+		zs.zsl.insert(score, member)
+		scoreObj := createScoreObject(score)
+		zs.dict.Add(member, scoreObj)
+		scoreObj.DecrRefCount()
+		return ZADD_OUT_ADDED, score
+	}
+	return ZADD_OUT_NOP, 0
+}
+
+func (zs *ZSet) Delete(member *obj.Gobj) bool {
+	e := zs.dict.Find(member)
+	if e == nil {
+		return false
+	}
+	zs.zsl.delete(scoreOf(e), member)
+	zs.dict.Delete(member)
+	return true
+}
+
+// 获取排名，从0开始
+func (zs *ZSet) Rank(member *obj.Gobj, reverse bool) (int64, bool) {
+	e := zs.dict.Find(member)
+	if e == nil {
+		return 0, false
+	}
+	rank := zs.zsl.getRank(scoreOf(e), member)
+	if reverse {
+		return zs.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// 根据排名获取节点，从0开始
+func (zs *ZSet) ElementByRank(rank int64) *Node {
+	if rank < 0 || rank >= zs.zsl.length {
+		return nil
+	}
+	return zs.zsl.getElementByRank(rank + 1)
+}
+
+// 游标遍历，见 dict.Scan
+func (zs *ZSet) Scan(cursor uint64, fn func(member *obj.Gobj, score float64)) uint64 {
+	return zs.dict.Scan(cursor, func(e *dict.Entry) {
+		fn(e.Key, scoreOf(e))
+	})
+}
+
+func (zs *ZSet) First() *Node {
+	return zs.zsl.header.level[0].forward
+}
+
+func (zs *ZSet) Last() *Node {
+	return zs.zsl.tail
+}
+
+func (zs *ZSet) FirstInRange(r *RangeSpec) *Node {
+	return zs.zsl.firstInRange(r)
+}
+
+func (zs *ZSet) LastInRange(r *RangeSpec) *Node {
+	return zs.zsl.lastInRange(r)
+}
+
+func (zs *ZSet) FirstInLexRange(r *LexRangeSpec) *Node {
+	return zs.zsl.firstInLexRange(r)
+}
+
+func (zs *ZSet) LastInLexRange(r *LexRangeSpec) *Node {
+	return zs.zsl.lastInLexRange(r)
+}
+
+// 统计score区间内元素个数
+func (zs *ZSet) Count(r *RangeSpec) int64 {
+	first := zs.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := zs.zsl.lastInRange(r)
+	return zs.zsl.getRank(last.Score, last.Member) - zs.zsl.getRank(first.Score, first.Member) + 1
+}
+
+// 删除score区间内元素，返回删除个数
+func (zs *ZSet) DeleteRangeByScore(r *RangeSpec) int64 {
+	var removed int64
+	x := zs.zsl.firstInRange(r)
+	for x != nil && r.lteMax(x.Score) {
+		next := x.Next()
+		zs.Delete(x.Member)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// 删除排名区间 [start, end] 内元素，从0开始，返回删除个数
+func (zs *ZSet) DeleteRangeByRank(start, end int64) int64 {
+	var removed int64
+	x := zs.ElementByRank(start)
+	for i := start; x != nil && i <= end; i++ {
+		next := x.Next()
+		zs.Delete(x.Member)
+		removed++
+		x = next
+	}
+	return removed
+}
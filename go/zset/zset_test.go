@@ -0,0 +1,108 @@
+package zset
+
+import (
+	"akt-redis/obj"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkiplist(t *testing.T) {
+	zsl := skiplistCreate()
+	for i := 0; i < 100; i++ {
+		zsl.insert(float64(i%10), obj.CreateObject(obj.GSTR, fmt.Sprintf("m%02d", i)))
+	}
+	assert.Equal(t, int64(100), zsl.length)
+
+	// 按score排序，score相同时按member排序
+	var prev *Node
+	for x := zsl.header.level[0].forward; x != nil; x = x.Next() {
+		if prev != nil {
+			assert.True(t, nodeLess(prev, x.Score, x.Member))
+		}
+		assert.Equal(t, prev, x.Prev())
+		prev = x
+	}
+	assert.Equal(t, prev, zsl.tail)
+
+	for rank := int64(1); rank <= 100; rank++ {
+		x := zsl.getElementByRank(rank)
+		assert.Equal(t, rank, zsl.getRank(x.Score, x.Member))
+	}
+
+	assert.True(t, zsl.delete(5, obj.CreateObject(obj.GSTR, "m05")))
+	assert.False(t, zsl.delete(5, obj.CreateObject(obj.GSTR, "m05")))
+	assert.Equal(t, int64(99), zsl.length)
+
+	x := zsl.updateScore(0, obj.CreateObject(obj.GSTR, "m00"), 100)
+	assert.Equal(t, x, zsl.tail)
+	assert.Equal(t, int64(99), zsl.getRank(100, x.Member))
+}
+
+func TestZSet(t *testing.T) {
+	zs := ZSetCreate()
+	a := obj.CreateObject(obj.GSTR, "a")
+	b := obj.CreateObject(obj.GSTR, "b")
+	c := obj.CreateObject(obj.GSTR, "c")
+
+	out, _ := zs.Add(1, a, 0)
+	assert.Equal(t, ZADD_OUT_ADDED, out)
+	zs.Add(2, b, 0)
+	zs.Add(3, c, 0)
+	out, _ = zs.Add(10, a, ZADD_IN_NX)
+	assert.Equal(t, ZADD_OUT_NOP, out)
+	out, _ = zs.Add(0, a, ZADD_IN_GT)
+	assert.Equal(t, ZADD_OUT_NOP, out)
+	out, score := zs.Add(5, a, ZADD_IN_INCR)
+	assert.Equal(t, ZADD_OUT_UPDATED, out)
+	assert.Equal(t, float64(6), score)
+	out, score = zs.Add(0, a, ZADD_IN_INCR)
+	assert.Equal(t, 0, out)
+	assert.Equal(t, float64(6), score)
+	out, _ = zs.Add(1, obj.CreateObject(obj.GSTR, "d"), ZADD_IN_XX)
+	assert.Equal(t, ZADD_OUT_NOP, out)
+	assert.Equal(t, int64(3), zs.Len())
+
+	rank, ok := zs.Rank(a, false)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), rank)
+	rank, _ = zs.Rank(a, true)
+	assert.Equal(t, int64(0), rank)
+	assert.Equal(t, "b", zs.ElementByRank(0).Member.StrVal())
+
+	r, err := ParseRange("(2", "+inf")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), zs.Count(r))
+	assert.Equal(t, "c", zs.FirstInRange(r).Member.StrVal())
+	assert.Equal(t, "a", zs.LastInRange(r).Member.StrVal())
+	_, err = ParseRange("x", "1")
+	assert.Equal(t, MIN_MAX_ERR, err)
+
+	assert.Equal(t, int64(2), zs.DeleteRangeByScore(r))
+	assert.Equal(t, int64(1), zs.Len())
+	assert.Equal(t, int64(1), zs.DeleteRangeByRank(0, 0))
+	assert.Equal(t, int64(0), zs.Len())
+	assert.Nil(t, zs.First())
+	assert.Nil(t, zs.Last())
+}
+
+func TestLexRange(t *testing.T) {
+	zs := ZSetCreate()
+	for _, m := range []string{"a", "b", "c", "d"} {
+		zs.Add(0, obj.CreateObject(obj.GSTR, m), 0)
+	}
+	r, err := ParseLexRange("(a", "[c")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", zs.FirstInLexRange(r).Member.StrVal())
+	assert.Equal(t, "c", zs.LastInLexRange(r).Member.StrVal())
+
+	r, _ = ParseLexRange("-", "+")
+	assert.Equal(t, "a", zs.FirstInLexRange(r).Member.StrVal())
+	assert.Equal(t, "d", zs.LastInLexRange(r).Member.StrVal())
+
+	r, _ = ParseLexRange("+", "-")
+	assert.Nil(t, zs.FirstInLexRange(r))
+	_, err = ParseLexRange("a", "+")
+	assert.Equal(t, LEX_MIN_MAX_ERR, err)
+}